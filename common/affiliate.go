@@ -0,0 +1,42 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// AffCommissionMaxLevels 返佣最多支持的邀请层级
+const AffCommissionMaxLevels = 3
+
+// AffCommissionRates 各级邀请人的返佣比例（百分比），下标 0 为直接邀请人
+var AffCommissionRates = []float64{10}
+
+func AffCommissionRates2JSONString() string {
+	jsonBytes, err := json.Marshal(AffCommissionRates)
+	if err != nil {
+		SysError("error marshalling aff commission rates: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateAffCommissionRatesByJSONString(jsonStr string) error {
+	var rates []float64
+	if err := json.Unmarshal([]byte(jsonStr), &rates); err != nil {
+		return err
+	}
+	if len(rates) > AffCommissionMaxLevels {
+		return errors.New("返佣层级过多")
+	}
+	total := 0.0
+	for _, rate := range rates {
+		if rate < 0 || rate > 100 {
+			return errors.New("返佣比例需在 0 到 100 之间")
+		}
+		total += rate
+	}
+	if total > 100 {
+		return errors.New("各级返佣比例之和不能超过 100")
+	}
+	AffCommissionRates = rates
+	return nil
+}
@@ -24,12 +24,20 @@ var DrawingEnabled = true
 var DataExportEnabled = true
 var DataExportInterval = 5         // unit: minute
 var DataExportDefaultTime = "hour" // unit: minute
+
+// 数据看板保留策略：小时数据超过天数后合并为天数据，天数据超过天数后合并为月数据，月数据超过月数后删除（0 表示永久保留）
+var DataExportHourRetentionDays = 30
+var DataExportDayRetentionDays = 365
+var DataExportMonthRetentionMonths = 0
 var DefaultCollapseSidebar = false // default value of collapse sidebar
 
-// Any options with "Secret", "Token" in its key won't be return by GetOptions
+// Any options with "Secret", "Token", "Key", "Password" in its key won't be return by GetOptions
 
 var SessionSecret = uuid.New().String()
 
+// SessionIdKey cookie 会话中保存服务端会话 ID 的键
+const SessionIdKey = "session_id"
+
 var OptionMap map[string]string
 var OptionMapRWMutex sync.RWMutex
 
@@ -42,7 +50,20 @@ var EmailVerificationEnabled = false
 var GitHubOAuthEnabled = false
 var WeChatAuthEnabled = false
 var TelegramOAuthEnabled = false
+var OIDCEnabled = false
+var LDAPLoginEnabled = false
 var TurnstileCheckEnabled = false
+var TwoFAAdminEnforcementEnabled = false // 是否强制管理员启用两步验证
+var TwoFARecentSeconds = 300             // 敏感操作要求的两步验证有效期（秒）
+var LoginLockoutEnabled = true           // 是否启用登录失败锁定
+var LoginMaxFailures = 5                 // 同一账户在统计窗口内允许的连续失败次数，超过后锁定
+var LoginIPMaxFailures = 20              // 同一 IP 在统计窗口内允许的失败次数，超过后锁定
+var LoginFailureWindowSeconds = 900      // 登录失败次数的统计窗口（秒）
+var LoginLockoutSeconds = 900            // 锁定时长（秒）
+var PasswordMinLength = 8                // 密码最小长度，不低于 8
+var PasswordRequiredCharClasses = 0      // 密码需包含的字符类别数（小写、大写、数字、符号），0 表示不限制
+var PasswordBlockCommonEnabled = false   // 是否禁止使用常见弱密码
+var PasswordHistoryCount = 0             // 禁止重复使用最近几次的密码，0 表示不限制
 var RegisterEnabled = true
 
 var EmailDomainRestrictionEnabled = false // 是否启用邮箱域名限制
@@ -74,6 +95,29 @@ var SMTPToken = ""
 var GitHubClientId = ""
 var GitHubClientSecret = ""
 
+// OpenID Connect 单点登录配置，OIDCIssuer 为签发者地址，
+// 通过 {issuer}/.well-known/openid-configuration 自动发现各端点
+var OIDCIssuer = ""
+var OIDCClientId = ""
+var OIDCClientSecret = ""
+var OIDCScopes = "openid profile email"
+var OIDCUsernameClaim = "preferred_username"
+var OIDCEmailClaim = "email"
+var OIDCGroupClaim = "" // 为空时新用户使用默认分组
+
+// LDAP 目录登录配置，LDAPUserFilter 中的 %s 会被替换为转义后的用户名
+var LDAPServerURL = "" // ldap://host:389 或 ldaps://host:636
+var LDAPStartTLSEnabled = false
+var LDAPSkipTLSVerifyEnabled = false
+var LDAPBindDN = ""
+var LDAPBindPassword = ""
+var LDAPBaseDN = ""
+var LDAPUserFilter = "(uid=%s)"
+var LDAPUsernameAttribute = "uid"
+var LDAPDisplayNameAttribute = "cn"
+var LDAPEmailAttribute = "mail"
+var LDAPGroupAttribute = "memberOf"
+
 var WeChatServerAddress = ""
 var WeChatServerToken = ""
 var WeChatAccountQRCodeImageURL = ""
@@ -87,6 +131,11 @@ var TelegramBotName = ""
 var QuotaForNewUser = 0
 var QuotaForInviter = 0
 var QuotaForInvitee = 0
+var AffCommissionEnabled = false           // 是否按被邀请人充值金额向邀请人返佣
+var AffCommissionRedemptionEnabled = false // 兑换码充值是否参与返佣
+var AffCommissionDays = 0                  // 被邀请人注册后多少天内的充值参与返佣，0 表示不限制
+var AffCommissionCap = 0                   // 每个邀请人累计可获得的返佣额度上限，0 表示不限制
+var GroupPromotionEnabled = false          // 是否按累计充值金额自动调整用户分组
 var ChannelDisableThreshold = 5.0
 var AutomaticDisableChannelEnabled = false
 var AutomaticEnableChannelEnabled = false
@@ -99,11 +148,28 @@ var RootUserEmail = ""
 
 var IsMasterNode = os.Getenv("NODE_TYPE") != "slave"
 
+// LedgerReconcileFrequency 额度流水对账间隔（秒），0 表示关闭
+var LedgerReconcileFrequency = GetOrDefault("LEDGER_RECONCILE_FREQUENCY", 3600)
+
+// LogPartitionEnabled 在 PostgreSQL / MySQL 上按月对日志表分区
+var LogPartitionEnabled = os.Getenv("LOG_PARTITION_ENABLED") == "true"
+
+// LogBatchWriteEnabled 消费日志放入队列后异步批量写入日志库
+var LogBatchWriteEnabled = os.Getenv("LOG_BATCH_WRITE_ENABLED") == "true"
+var LogBatchWriteSize = GetOrDefault("LOG_BATCH_WRITE_SIZE", 200)
+var LogBatchWriteInterval = GetOrDefault("LOG_BATCH_WRITE_INTERVAL", 1000) // unit is millisecond
+
+// ShutdownTimeout 退出时等待进行中请求结束的最长秒数
+var ShutdownTimeout = GetOrDefault("SHUTDOWN_TIMEOUT", 30)
+
 var requestInterval, _ = strconv.Atoi(os.Getenv("POLLING_INTERVAL"))
 var RequestInterval = time.Duration(requestInterval) * time.Second
 
 var SyncFrequency = GetOrDefault("SYNC_FREQUENCY", 60) // unit is second
 
+// ChannelStatUpdateFrequency 渠道统计从内存写入数据库的间隔
+var ChannelStatUpdateFrequency = GetOrDefault("CHANNEL_STAT_UPDATE_FREQUENCY", 60) // unit is second
+
 var BatchUpdateEnabled = false
 var BatchUpdateInterval = GetOrDefault("BATCH_UPDATE_INTERVAL", 5)
 
@@ -116,10 +182,11 @@ const (
 )
 
 const (
-	RoleGuestUser  = 0
-	RoleCommonUser = 1
-	RoleAdminUser  = 10
-	RoleRootUser   = 100
+	RoleGuestUser    = 0
+	RoleCommonUser   = 1
+	RoleResellerUser = 5
+	RoleAdminUser    = 10
+	RoleRootUser     = 100
 )
 
 var (
@@ -146,6 +213,9 @@ var (
 
 	CriticalRateLimitNum            = 20
 	CriticalRateLimitDuration int64 = 20 * 60
+
+	TokenizeRateLimitNum            = GetOrDefault("TOKENIZE_RATE_LIMIT", 60)
+	TokenizeRateLimitDuration int64 = 60
 )
 
 var RateLimitKeyExpirationDuration = 20 * time.Minute
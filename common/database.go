@@ -4,4 +4,9 @@ var UsingSQLite = false
 var UsingPostgreSQL = false
 var UsingMySQL = false
 
+// 日志库的数据库类型，未单独配置日志库时与主库一致
+var LogUsingSQLite = false
+var LogUsingPostgreSQL = false
+var LogUsingMySQL = false
+
 var SQLitePath = "one-api.db?_busy_timeout=5000"
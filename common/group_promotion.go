@@ -0,0 +1,50 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// GroupPromotionRule 按累计充值金额自动调整分组的规则
+type GroupPromotionRule struct {
+	Group        string  `json:"group"`
+	MinAmount    float64 `json:"min_amount"`    // 累计充值金额达到该值时升级到该分组
+	InactiveDays int     `json:"inactive_days"` // 连续多少天没有充值后不再保留该分组，0 表示不降级
+}
+
+// GroupPromotionRules 按 MinAmount 从低到高排列
+var GroupPromotionRules = []GroupPromotionRule{}
+
+func GroupPromotionRules2JSONString() string {
+	jsonBytes, err := json.Marshal(GroupPromotionRules)
+	if err != nil {
+		SysError("error marshalling group promotion rules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupPromotionRulesByJSONString(jsonStr string) error {
+	var rules []GroupPromotionRule
+	if err := json.Unmarshal([]byte(jsonStr), &rules); err != nil {
+		return err
+	}
+	groups := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Group == "" || rule.Group == "default" {
+			return errors.New("自动升级的分组不能为空或 default")
+		}
+		if groups[rule.Group] {
+			return errors.New("自动升级的分组不能重复：" + rule.Group)
+		}
+		groups[rule.Group] = true
+		if rule.MinAmount <= 0 || rule.InactiveDays < 0 {
+			return errors.New("累计充值金额需大于 0，降级天数不能为负数")
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].MinAmount < rules[j].MinAmount
+	})
+	GroupPromotionRules = rules
+	return nil
+}
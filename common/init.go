@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -22,7 +23,20 @@ func printHelp() {
 	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
 }
 
+// isTestBinary go test 运行的测试程序会带上 -test.* 参数，这些参数由 testing 包在初始化之后注册
+func isTestBinary() bool {
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "-test.") {
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
+	if isTestBinary() {
+		return
+	}
 	flag.Parse()
 
 	if *PrintVersion {
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LDAPGroupRule 目录组对应的系统分组与权限等级，Role 为 0 时不改变权限等级
+type LDAPGroupRule struct {
+	Group string `json:"group"`
+	Role  int    `json:"role"`
+}
+
+// LDAPGroupMapping 键为目录组的 DN 或 CN（不区分大小写）
+var LDAPGroupMapping = map[string]LDAPGroupRule{}
+
+func LDAPGroupMapping2JSONString() string {
+	jsonBytes, err := json.Marshal(LDAPGroupMapping)
+	if err != nil {
+		SysError("error marshalling ldap group mapping: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateLDAPGroupMappingByJSONString(jsonStr string) error {
+	mapping := make(map[string]LDAPGroupRule)
+	if err := json.Unmarshal([]byte(jsonStr), &mapping); err != nil {
+		return err
+	}
+	for name, rule := range mapping {
+		// 目录组最多映射为管理员，超级管理员不能通过目录授予
+		if rule.Role != 0 && rule.Role != RoleCommonUser && rule.Role != RoleResellerUser && rule.Role != RoleAdminUser {
+			return fmt.Errorf("目录组 %s 的权限等级无效", name)
+		}
+	}
+	LDAPGroupMapping = mapping
+	return nil
+}
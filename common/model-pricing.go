@@ -0,0 +1,224 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// PriceTier 按提示词长度分档计价，提示词 token 数达到 MinPromptTokens 时使用该档位的倍率
+type PriceTier struct {
+	MinPromptTokens int     `json:"min_prompt_tokens"`
+	ModelRatio      float64 `json:"model_ratio"`
+	// CompletionRatio 为 0 时沿用模型的补全倍率
+	CompletionRatio float64 `json:"completion_ratio,omitempty"`
+}
+
+// ModelPricingSchema 模型的多维度价格，所有倍率均相对于提示词价格（即 ModelRatio），为 0 表示未配置
+type ModelPricingSchema struct {
+	// ModelRatio 与 CompletionRatio 为 0 时使用 ModelRatio 与 CompletionRatio 配置
+	ModelRatio      float64 `json:"model_ratio,omitempty"`
+	CompletionRatio float64 `json:"completion_ratio,omitempty"`
+	// CacheRatio 命中缓存的提示词 token 倍率，如 0.5 表示五折
+	CacheRatio float64 `json:"cache_ratio,omitempty"`
+	// AudioInputRatio 音频输入 token 倍率，未配置时按普通提示词计费
+	AudioInputRatio float64 `json:"audio_input_ratio,omitempty"`
+	// AudioOutputRatio 音频输出 token 倍率，未配置时按补全倍率计费
+	AudioOutputRatio float64 `json:"audio_output_ratio,omitempty"`
+	// ReasoningRatio 推理 token 倍率，未配置时按补全倍率计费
+	ReasoningRatio float64 `json:"reasoning_ratio,omitempty"`
+	// Tiers 上下文长度分档，按 MinPromptTokens 升序匹配
+	Tiers []PriceTier `json:"tiers,omitempty"`
+	// ImageSizeRatio 与 ImageQualityRatio 覆盖绘图模型内置的尺寸、品质倍率
+	ImageSizeRatio    map[string]float64 `json:"image_size_ratio,omitempty"`
+	ImageQualityRatio map[string]float64 `json:"image_quality_ratio,omitempty"`
+}
+
+var modelPricingSchemas = map[string]ModelPricingSchema{}
+
+func ModelPricing2JSONString() string {
+	jsonBytes, err := json.Marshal(modelPricingSchemas)
+	if err != nil {
+		SysError("error marshalling model pricing: " + err.Error())
+		return "{}"
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelPricingByJSONString(jsonStr string) error {
+	schemas := make(map[string]ModelPricingSchema)
+	if strings.TrimSpace(jsonStr) != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &schemas); err != nil {
+			return err
+		}
+	}
+	for name, schema := range schemas {
+		if schema.ModelRatio < 0 || schema.CompletionRatio < 0 || schema.CacheRatio < 0 ||
+			schema.AudioInputRatio < 0 || schema.AudioOutputRatio < 0 || schema.ReasoningRatio < 0 {
+			return fmt.Errorf("model %s has negative ratio", name)
+		}
+		for _, tier := range schema.Tiers {
+			if tier.MinPromptTokens < 0 || tier.ModelRatio <= 0 || tier.CompletionRatio < 0 {
+				return fmt.Errorf("model %s has invalid tier", name)
+			}
+		}
+		sort.SliceStable(schema.Tiers, func(i, j int) bool {
+			return schema.Tiers[i].MinPromptTokens < schema.Tiers[j].MinPromptTokens
+		})
+		schemas[name] = schema
+	}
+	modelPricingSchemas = schemas
+	return nil
+}
+
+// GetModelPricingSchema 返回模型的多维度价格配置
+func GetModelPricingSchema(name string) (ModelPricingSchema, bool) {
+	if strings.HasPrefix(name, "gpt-4-gizmo") {
+		name = "gpt-4-gizmo-*"
+	}
+	schema, ok := modelPricingSchemas[name]
+	return schema, ok
+}
+
+func GetModelPricingSchemaMap() map[string]ModelPricingSchema {
+	return modelPricingSchemas
+}
+
+// TokenUsage 参与计费的 token 明细，PromptTokens 与 CompletionTokens 包含各项明细
+type TokenUsage struct {
+	PromptTokens      int
+	CompletionTokens  int
+	CachedTokens      int
+	AudioInputTokens  int
+	AudioOutputTokens int
+	ReasoningTokens   int
+}
+
+// TokenPrice 计费时实际生效的倍率
+type TokenPrice struct {
+	ModelRatio       float64 `json:"model_ratio"`
+	CompletionRatio  float64 `json:"completion_ratio"`
+	CacheRatio       float64 `json:"cache_ratio"`
+	AudioInputRatio  float64 `json:"audio_input_ratio"`
+	AudioOutputRatio float64 `json:"audio_output_ratio"`
+	ReasoningRatio   float64 `json:"reasoning_ratio"`
+	// Tier 命中的档位下标，-1 表示未分档
+	Tier int `json:"tier"`
+}
+
+// GetTokenPrice 根据提示词长度解析模型生效的倍率，未配置价格结构时与 ModelRatio、CompletionRatio 一致
+func GetTokenPrice(name string, promptTokens int) TokenPrice {
+	price := TokenPrice{
+		ModelRatio:      GetModelRatio(name),
+		CompletionRatio: GetCompletionRatio(name),
+		CacheRatio:      1,
+		AudioInputRatio: 1,
+		Tier:            -1,
+	}
+	schema, ok := GetModelPricingSchema(name)
+	if ok {
+		if schema.ModelRatio > 0 {
+			price.ModelRatio = schema.ModelRatio
+		}
+		if schema.CompletionRatio > 0 {
+			price.CompletionRatio = schema.CompletionRatio
+		}
+		for i, tier := range schema.Tiers {
+			if promptTokens < tier.MinPromptTokens {
+				break
+			}
+			price.Tier = i
+			if tier.ModelRatio > 0 {
+				price.ModelRatio = tier.ModelRatio
+			}
+			if tier.CompletionRatio > 0 {
+				price.CompletionRatio = tier.CompletionRatio
+			}
+		}
+		if schema.CacheRatio > 0 {
+			price.CacheRatio = schema.CacheRatio
+		}
+		if schema.AudioInputRatio > 0 {
+			price.AudioInputRatio = schema.AudioInputRatio
+		}
+	}
+	price.AudioOutputRatio = price.CompletionRatio
+	price.ReasoningRatio = price.CompletionRatio
+	if ok && schema.AudioOutputRatio > 0 {
+		price.AudioOutputRatio = schema.AudioOutputRatio
+	}
+	if ok && schema.ReasoningRatio > 0 {
+		price.ReasoningRatio = schema.ReasoningRatio
+	}
+	return price
+}
+
+// WeightedTokens 按各维度倍率折算为等价的提示词 token 数
+func (p TokenPrice) WeightedTokens(usage TokenUsage) (int, error) {
+	plainPrompt := usage.PromptTokens - usage.CachedTokens - usage.AudioInputTokens
+	plainCompletion := usage.CompletionTokens - usage.ReasoningTokens - usage.AudioOutputTokens
+	if plainPrompt < 0 || plainCompletion < 0 {
+		return 0, errors.New("token details exceed total tokens")
+	}
+	tokens := float64(plainPrompt) +
+		float64(usage.CachedTokens)*p.CacheRatio +
+		float64(usage.AudioInputTokens)*p.AudioInputRatio +
+		float64(plainCompletion)*p.CompletionRatio +
+		float64(usage.ReasoningTokens)*p.ReasoningRatio +
+		float64(usage.AudioOutputTokens)*p.AudioOutputRatio
+	return int(math.Round(tokens)), nil
+}
+
+// CalculateTokenQuota 计算按量计费模型的额度，明细不合法时退化为仅按提示词与补全计费
+func CalculateTokenQuota(name string, usage TokenUsage, groupRatio float64) (int, TokenPrice) {
+	price := GetTokenPrice(name, usage.PromptTokens)
+	tokens, err := price.WeightedTokens(usage)
+	if err != nil {
+		SysError(fmt.Sprintf("invalid token usage for model %s: %s", name, err.Error()))
+		tokens = usage.PromptTokens + int(math.Round(float64(usage.CompletionTokens)*price.CompletionRatio))
+	}
+	ratio := price.ModelRatio * groupRatio
+	quota := int(math.Round(float64(tokens) * ratio))
+	if ratio != 0 && quota <= 0 {
+		quota = 1
+	}
+	return quota, price
+}
+
+// GetImagePriceRatio 返回绘图模型的尺寸与品质倍率，价格结构中未配置的尺寸、品质使用内置规则
+func GetImagePriceRatio(name string, size string, quality string) (sizeRatio float64, qualityRatio float64) {
+	sizeRatio, qualityRatio = defaultImagePriceRatio(name, size, quality)
+	schema, ok := GetModelPricingSchema(name)
+	if !ok {
+		return sizeRatio, qualityRatio
+	}
+	if ratio, exists := schema.ImageSizeRatio[size]; exists {
+		sizeRatio = ratio
+	}
+	if ratio, exists := schema.ImageQualityRatio[quality]; exists {
+		qualityRatio = ratio
+	}
+	return sizeRatio, qualityRatio
+}
+
+func defaultImagePriceRatio(name string, size string, quality string) (sizeRatio float64, qualityRatio float64) {
+	sizeRatio, qualityRatio = 1, 1
+	switch size {
+	case "256x256":
+		sizeRatio = 0.4
+	case "512x512":
+		sizeRatio = 0.45
+	case "1024x1792", "1792x1024":
+		sizeRatio = 2
+	}
+	if name == "dall-e-3" && quality == "hd" {
+		qualityRatio = 2.0
+		if size == "1024x1792" || size == "1792x1024" {
+			qualityRatio = 1.5
+		}
+	}
+	return sizeRatio, qualityRatio
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestCalculateTokenQuotaTiers(t *testing.T) {
+	err := UpdateModelPricingByJSONString(`{
+		"tiered-model": {
+			"model_ratio": 1,
+			"completion_ratio": 2,
+			"cache_ratio": 0.5,
+			"reasoning_ratio": 4,
+			"tiers": [
+				{"min_prompt_tokens": 200000, "model_ratio": 3, "completion_ratio": 1.5},
+				{"min_prompt_tokens": 100000, "model_ratio": 2}
+			]
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = UpdateModelPricingByJSONString("") }()
+
+	tests := []struct {
+		name  string
+		usage TokenUsage
+		group float64
+		quota int
+		tier  int
+	}{
+		{
+			name:  "below first tier",
+			usage: TokenUsage{PromptTokens: 1000, CompletionTokens: 100},
+			group: 1,
+			quota: 1000 + 100*2,
+			tier:  -1,
+		},
+		{
+			name:  "first tier keeps completion ratio",
+			usage: TokenUsage{PromptTokens: 100000, CompletionTokens: 100},
+			group: 1,
+			quota: (100000 + 100*2) * 2,
+			tier:  0,
+		},
+		{
+			name:  "second tier overrides completion ratio",
+			usage: TokenUsage{PromptTokens: 200000, CompletionTokens: 100},
+			group: 0.5,
+			quota: int((200000 + 100*1.5) * 3 * 0.5),
+			tier:  1,
+		},
+		{
+			name:  "cached and reasoning tokens",
+			usage: TokenUsage{PromptTokens: 1000, CompletionTokens: 300, CachedTokens: 400, ReasoningTokens: 100},
+			group: 1,
+			quota: 600 + 400/2 + 200*2 + 100*4,
+			tier:  -1,
+		},
+		{
+			name:  "invalid details fall back to prompt and completion",
+			usage: TokenUsage{PromptTokens: 100, CompletionTokens: 10, CachedTokens: 200},
+			group: 1,
+			quota: 100 + 10*2,
+			tier:  -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quota, price := CalculateTokenQuota("tiered-model", tt.usage, tt.group)
+			if quota != tt.quota {
+				t.Fatalf("expected quota %d, got %d", tt.quota, quota)
+			}
+			if price.Tier != tt.tier {
+				t.Fatalf("expected tier %d, got %d", tt.tier, price.Tier)
+			}
+		})
+	}
+}
+
+func TestCalculateTokenQuotaMinimum(t *testing.T) {
+	if quota, _ := CalculateTokenQuota("gpt-3.5-turbo", TokenUsage{}, 1); quota != 1 {
+		t.Fatalf("expected a priced request to cost at least 1, got %d", quota)
+	}
+	if quota, _ := CalculateTokenQuota("gpt-3.5-turbo", TokenUsage{PromptTokens: 100}, 0); quota != 0 {
+		t.Fatalf("expected a free group to cost nothing, got %d", quota)
+	}
+}
+
+func TestUpdateModelPricingRejectsInvalidTier(t *testing.T) {
+	err := UpdateModelPricingByJSONString(`{"bad-model": {"tiers": [{"min_prompt_tokens": 1000, "model_ratio": 0}]}}`)
+	if err == nil {
+		t.Fatal("expected a tier without model ratio to be rejected")
+	}
+}
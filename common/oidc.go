@@ -0,0 +1,23 @@
+package common
+
+import "encoding/json"
+
+// OIDCGroupMapping 将分组声明的取值映射为系统分组，未配置映射时声明值与分组同名即可生效
+var OIDCGroupMapping = map[string]string{}
+
+func OIDCGroupMapping2JSONString() string {
+	jsonBytes, err := json.Marshal(OIDCGroupMapping)
+	if err != nil {
+		SysError("error marshalling oidc group mapping: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateOIDCGroupMappingByJSONString(jsonStr string) error {
+	mapping := make(map[string]string)
+	if err := json.Unmarshal([]byte(jsonStr), &mapping); err != nil {
+		return err
+	}
+	OIDCGroupMapping = mapping
+	return nil
+}
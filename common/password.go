@@ -0,0 +1,109 @@
+package common
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords 常见弱密码，比较时忽略大小写
+var commonPasswords = map[string]struct{}{}
+
+func init() {
+	for _, password := range []string{
+		"12345678", "123456789", "1234567890", "0123456789", "87654321", "11111111", "00000000",
+		"88888888", "66666666", "12341234", "11223344", "123123123", "1q2w3e4r", "1qaz2wsx",
+		"qwertyui", "qwertyuiop", "asdfghjk", "asdfghjkl", "zxcvbnm1", "password", "password1",
+		"password123", "passw0rd", "p@ssw0rd", "p@ssword", "iloveyou", "sunshine", "princess",
+		"football", "baseball", "welcome1", "welcome123", "admin123", "administrator", "abc12345",
+		"abcd1234", "a1234567", "qwe12345", "qwerty123", "letmein1", "trustno1", "superman",
+		"whatever", "changeme", "12qwaszx", "q1w2e3r4", "aa123456", "woaini1314", "5201314520",
+	} {
+		commonPasswords[password] = struct{}{}
+	}
+}
+
+func passwordCharClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	return classes
+}
+
+// CheckPasswordPolicy 按系统设置的密码策略校验密码，历史密码由调用方另行校验
+func CheckPasswordPolicy(password string, username string) error {
+	if len(password) < PasswordMinLength {
+		return fmt.Errorf("密码长度不能少于 %d 位", PasswordMinLength)
+	}
+	if len(password) > 30 {
+		return errors.New("密码长度不能超过 30 位")
+	}
+	if PasswordRequiredCharClasses > 0 && passwordCharClasses(password) < PasswordRequiredCharClasses {
+		return fmt.Errorf("密码需至少包含小写字母、大写字母、数字、符号中的 %d 类", PasswordRequiredCharClasses)
+	}
+	if PasswordBlockCommonEnabled {
+		lower := strings.ToLower(password)
+		if _, ok := commonPasswords[lower]; ok {
+			return errors.New("密码过于常见，请更换")
+		}
+		if username != "" && strings.Contains(lower, strings.ToLower(username)) {
+			return errors.New("密码不能包含用户名")
+		}
+	}
+	return nil
+}
+
+// GenerateRandomPassword 生成包含全部四类字符的随机密码，满足任意密码策略
+func GenerateRandomPassword(length int) string {
+	charsets := []string{
+		"abcdefghijkmnpqrstuvwxyz",
+		"ABCDEFGHJKLMNPQRSTUVWXYZ",
+		"23456789",
+		"!@#$%^&*-_",
+	}
+	if length < len(charsets) {
+		length = len(charsets)
+	}
+	all := strings.Join(charsets, "")
+	password := make([]byte, length)
+	for i := range password {
+		charset := all
+		if i < len(charsets) {
+			charset = charsets[i]
+		}
+		password[i] = charset[randomInt(len(charset))]
+	}
+	// 打乱顺序，避免固定位置出现固定类别的字符
+	for i := len(password) - 1; i > 0; i-- {
+		j := randomInt(i + 1)
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password)
+}
+
+func randomInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(v.Int64())
+}
@@ -0,0 +1,173 @@
+package common
+
+import "strings"
+
+// 权限名称，按 资源.操作 命名，角色通过权限名称的集合授予能力
+const (
+	PermissionAll = "*"
+
+	PermissionStatusTest = "status.test"
+
+	PermissionUserRead          = "user.read"
+	PermissionUserReadAll       = "user.read.all"
+	PermissionUserWrite         = "user.write"
+	PermissionUserQuotaAdjust   = "user.quota.adjust"
+	PermissionUserQuotaAllocate = "user.quota.allocate"
+
+	PermissionResellerMarkup = "reseller.markup"
+
+	PermissionChannelRead  = "channel.read"
+	PermissionChannelWrite = "channel.write"
+
+	PermissionRedemptionRead  = "redemption.read"
+	PermissionRedemptionWrite = "redemption.write"
+
+	PermissionLogRead         = "log.read"
+	PermissionLogDelete       = "log.delete"
+	PermissionLogExport       = "log.export"
+	PermissionLogArchiveRead  = "log.archive.read"
+	PermissionLogArchiveWrite = "log.archive.write"
+
+	PermissionDataRead   = "data.read"
+	PermissionDataExport = "data.export"
+
+	PermissionLedgerRead      = "ledger.read"
+	PermissionLedgerReconcile = "ledger.reconcile"
+
+	PermissionStatementRead  = "statement.read"
+	PermissionStatementWrite = "statement.write"
+
+	PermissionOrgRead  = "org.read"
+	PermissionOrgWrite = "org.write"
+
+	PermissionGroupRead      = "group.read"
+	PermissionMidjourneyRead = "midjourney.read"
+
+	PermissionOptionRead  = "option.read"
+	PermissionOptionWrite = "option.write"
+
+	PermissionRoleRead  = "role.read"
+	PermissionRoleWrite = "role.write"
+
+	PermissionAuditRead = "audit.read"
+
+	PermissionSubscriptionRead  = "subscription.read"
+	PermissionSubscriptionWrite = "subscription.write"
+)
+
+// AllPermissions 所有可分配的权限及其说明
+var AllPermissions = []struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}{
+	{PermissionStatusTest, "测试系统状态"},
+	{PermissionUserRead, "查看用户"},
+	{PermissionUserReadAll, "访问全部用户及其日志、使用数据，否则只能访问自己的下级"},
+	{PermissionUserWrite, "创建、编辑、禁用与删除用户"},
+	{PermissionUserQuotaAdjust, "直接调整用户额度"},
+	{PermissionUserQuotaAllocate, "从自己的余额向下级分配额度"},
+	{PermissionResellerMarkup, "设置下级调用时的加价倍率"},
+	{PermissionChannelRead, "查看渠道与渠道统计"},
+	{PermissionChannelWrite, "创建、编辑、测试与删除渠道"},
+	{PermissionRedemptionRead, "查看兑换码"},
+	{PermissionRedemptionWrite, "创建、编辑与删除兑换码"},
+	{PermissionLogRead, "查看日志"},
+	{PermissionLogDelete, "删除历史日志"},
+	{PermissionLogExport, "导出日志"},
+	{PermissionLogArchiveRead, "查看日志归档"},
+	{PermissionLogArchiveWrite, "执行日志归档"},
+	{PermissionDataRead, "查看使用数据"},
+	{PermissionDataExport, "导出使用数据"},
+	{PermissionLedgerRead, "查看额度流水与对账报告"},
+	{PermissionLedgerReconcile, "执行额度对账"},
+	{PermissionStatementRead, "查看账单"},
+	{PermissionStatementWrite, "生成账单"},
+	{PermissionOrgRead, "查看组织"},
+	{PermissionOrgWrite, "修改组织的分组、状态与额度"},
+	{PermissionGroupRead, "查看分组"},
+	{PermissionMidjourneyRead, "查看所有绘图任务"},
+	{PermissionOptionRead, "查看系统设置"},
+	{PermissionOptionWrite, "修改系统设置"},
+	{PermissionRoleRead, "查看角色"},
+	{PermissionRoleWrite, "创建、编辑与分配角色"},
+	{PermissionAuditRead, "查看与导出审计记录"},
+	{PermissionSubscriptionRead, "查看订阅套餐与用户订阅"},
+	{PermissionSubscriptionWrite, "管理订阅套餐，开通与终止用户订阅"},
+}
+
+// BuiltInRolePermissions 内置角色的权限，与原有的权限等级一一对应
+var BuiltInRolePermissions = map[int][]string{
+	RoleCommonUser: {},
+	RoleResellerUser: {
+		PermissionUserRead,
+		PermissionUserWrite,
+		PermissionUserQuotaAllocate,
+		PermissionResellerMarkup,
+		PermissionLogRead,
+	},
+	RoleAdminUser: {
+		PermissionStatusTest,
+		PermissionUserRead,
+		PermissionUserReadAll,
+		PermissionUserWrite,
+		PermissionUserQuotaAdjust,
+		PermissionUserQuotaAllocate,
+		PermissionResellerMarkup,
+		PermissionChannelRead,
+		PermissionChannelWrite,
+		PermissionRedemptionRead,
+		PermissionRedemptionWrite,
+		PermissionLogRead,
+		PermissionLogDelete,
+		PermissionLogExport,
+		PermissionLogArchiveRead,
+		PermissionDataRead,
+		PermissionDataExport,
+		PermissionLedgerRead,
+		PermissionStatementRead,
+		PermissionStatementWrite,
+		PermissionOrgRead,
+		PermissionOrgWrite,
+		PermissionGroupRead,
+		PermissionMidjourneyRead,
+		PermissionRoleRead,
+		PermissionAuditRead,
+		PermissionSubscriptionRead,
+		PermissionSubscriptionWrite,
+	},
+	RoleRootUser: {PermissionAll},
+}
+
+func IsValidPermission(permission string) bool {
+	if permission == PermissionAll {
+		return true
+	}
+	wildcard := strings.HasSuffix(permission, ".*")
+	for _, p := range AllPermissions {
+		if p.Name == permission || (wildcard && strings.HasPrefix(p.Name, strings.TrimSuffix(permission, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission 判断权限集合是否包含 permission，支持 * 与 channel.* 形式的通配
+func HasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == PermissionAll || p == permission {
+			return true
+		}
+		if strings.HasSuffix(p, ".*") && strings.HasPrefix(permission, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeSelf 管理令牌的作用域之一，允许访问个人信息、令牌、日志等自身数据的接口。
+// 其余作用域与权限名称相同，令牌最终可用的权限为作用域与所属用户权限的交集
+const ScopeSelf = "self"
+
+func IsValidScope(scope string) bool {
+	return scope == ScopeSelf || IsValidPermission(scope)
+}
@@ -0,0 +1,29 @@
+package constant
+
+// LogArchiveEnabled 是否定时将过期日志归档为压缩的 JSONL 文件并从数据库中移除
+var LogArchiveEnabled = false
+
+// LogArchiveDays 日志在数据库中保留的天数，超过后归档
+var LogArchiveDays = 90
+
+const (
+	LogArchiveStorageLocal = "local"
+	LogArchiveStorageS3    = "s3"
+)
+
+// LogArchiveStorage 归档存储位置，local 或 s3
+var LogArchiveStorage = LogArchiveStorageLocal
+
+// LogArchiveDir 本地归档目录
+var LogArchiveDir = "./log_archives"
+
+// S3 兼容对象存储配置
+var LogArchiveS3Endpoint = ""
+var LogArchiveS3Region = "us-east-1"
+var LogArchiveS3Bucket = ""
+var LogArchiveS3Prefix = "log_archives"
+var LogArchiveS3AccessKey = ""
+var LogArchiveS3SecretKey = ""
+
+// LogArchiveS3PathStyleEnabled 使用路径风格访问存储桶，MinIO 等自建存储通常需要开启
+var LogArchiveS3PathStyleEnabled = true
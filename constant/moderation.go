@@ -0,0 +1,107 @@
+package constant
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ModerationEnabled 是否在转发前调用审核模型检查提示词
+var ModerationEnabled = false
+
+// ModerationModel 使用本网关 /v1/moderations 渠道时调用的审核模型
+var ModerationModel = "text-moderation-latest"
+
+// ModerationEndpoint 自定义审核接口地址（OpenAI moderation 格式），为空时使用本网关渠道
+var ModerationEndpoint = ""
+var ModerationKey = ""
+
+// ModerationAction 超过阈值时的处理方式：block 拦截请求，flag 仅在日志中标记
+var ModerationAction = "block"
+
+// ModerationFailOpenEnabled 审核服务不可用时是否放行请求，默认拦截；flag 模式下始终放行
+var ModerationFailOpenEnabled = false
+
+// ModerationCacheSeconds 审核结果按内容哈希缓存的时间，0 表示不缓存
+var ModerationCacheSeconds = 3600
+
+// ModerationThresholds 各类别的分数阈值，为空时使用上游返回的 flagged 结果
+var ModerationThresholds = map[string]float64{}
+
+// ModerationGroups 按分组覆盖审核设置，未配置的分组使用全局设置
+var ModerationGroups = map[string]ModerationGroupSetting{}
+
+const (
+	ModerationActionBlock = "block"
+	ModerationActionFlag  = "flag"
+)
+
+type ModerationGroupSetting struct {
+	Enabled    bool               `json:"enabled"`
+	Action     string             `json:"action,omitempty"`
+	FailOpen   *bool              `json:"fail_open,omitempty"` // 为空时使用全局设置
+	Thresholds map[string]float64 `json:"thresholds,omitempty"`
+}
+
+// ShouldFailOpen 审核服务不可用时是否放行请求
+func (setting ModerationGroupSetting) ShouldFailOpen() bool {
+	if setting.Action == ModerationActionFlag {
+		return true
+	}
+	return setting.FailOpen != nil && *setting.FailOpen
+}
+
+func ModerationThresholds2JSONString() string {
+	jsonBytes, err := json.Marshal(ModerationThresholds)
+	if err != nil {
+		return "{}"
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModerationThresholdsByJSONString(jsonStr string) error {
+	ModerationThresholds = make(map[string]float64)
+	if strings.TrimSpace(jsonStr) == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(jsonStr), &ModerationThresholds)
+}
+
+func ModerationGroups2JSONString() string {
+	jsonBytes, err := json.Marshal(ModerationGroups)
+	if err != nil {
+		return "{}"
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModerationGroupsByJSONString(jsonStr string) error {
+	ModerationGroups = make(map[string]ModerationGroupSetting)
+	if strings.TrimSpace(jsonStr) == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(jsonStr), &ModerationGroups)
+}
+
+// GetModerationSetting 返回分组生效的审核设置，第二个返回值表示是否需要审核
+func GetModerationSetting(group string) (ModerationGroupSetting, bool) {
+	failOpen := ModerationFailOpenEnabled
+	setting := ModerationGroupSetting{
+		Enabled:    ModerationEnabled,
+		Action:     ModerationAction,
+		FailOpen:   &failOpen,
+		Thresholds: ModerationThresholds,
+	}
+	if groupSetting, ok := ModerationGroups[group]; ok {
+		setting.Enabled = groupSetting.Enabled
+		if groupSetting.Action != "" {
+			setting.Action = groupSetting.Action
+		}
+		if groupSetting.FailOpen != nil {
+			setting.FailOpen = groupSetting.FailOpen
+		}
+		if len(groupSetting.Thresholds) > 0 {
+			setting.Thresholds = groupSetting.Thresholds
+		}
+	}
+	return setting, setting.Enabled
+}
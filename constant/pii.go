@@ -0,0 +1,115 @@
+package constant
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PIIRedactionEnabled 是否在转发上游前检测提示词中的个人敏感信息
+var PIIRedactionEnabled = false
+
+// PIIRedactionMode 检测到敏感信息后的处理方式：mask 打码，reject 拒绝请求，placeholder 替换为占位符并在响应中还原
+var PIIRedactionMode = PIIRedactionModeMask
+
+// PIIRedactionDetectors 启用的内置检测器
+var PIIRedactionDetectors = []string{"api_key", "email", "id_card", "phone"}
+
+// PIICustomDetectors 管理员自定义的检测器，名称 -> 正则表达式
+var PIICustomDetectors = map[string]string{}
+
+// PIIRedactionGroups 按分组开关，未配置的分组使用全局开关
+var PIIRedactionGroups = map[string]bool{}
+
+// PIIRedactionChannels 按渠道 ID 开关，优先级高于分组，可用于豁免自建的 Ollama 等渠道
+var PIIRedactionChannels = map[string]bool{}
+
+const (
+	PIIRedactionModeMask        = "mask"
+	PIIRedactionModeReject      = "reject"
+	PIIRedactionModePlaceholder = "placeholder"
+)
+
+func PIIRedactionDetectorsToString() string {
+	return strings.Join(PIIRedactionDetectors, ",")
+}
+
+func PIIRedactionDetectorsFromString(s string) {
+	PIIRedactionDetectors = []string{}
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(d)
+		if d != "" {
+			PIIRedactionDetectors = append(PIIRedactionDetectors, d)
+		}
+	}
+}
+
+func PIICustomDetectors2JSONString() string {
+	jsonBytes, err := json.Marshal(PIICustomDetectors)
+	if err != nil {
+		return "{}"
+	}
+	return string(jsonBytes)
+}
+
+func UpdatePIICustomDetectorsByJSONString(jsonStr string) error {
+	detectors := make(map[string]string)
+	if strings.TrimSpace(jsonStr) != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &detectors); err != nil {
+			return err
+		}
+	}
+	for name, pattern := range detectors {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid pattern for detector %s: %s", name, err.Error())
+		}
+	}
+	PIICustomDetectors = detectors
+	return nil
+}
+
+func PIIRedactionGroups2JSONString() string {
+	jsonBytes, err := json.Marshal(PIIRedactionGroups)
+	if err != nil {
+		return "{}"
+	}
+	return string(jsonBytes)
+}
+
+func UpdatePIIRedactionGroupsByJSONString(jsonStr string) error {
+	PIIRedactionGroups = make(map[string]bool)
+	if strings.TrimSpace(jsonStr) == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(jsonStr), &PIIRedactionGroups)
+}
+
+func PIIRedactionChannels2JSONString() string {
+	jsonBytes, err := json.Marshal(PIIRedactionChannels)
+	if err != nil {
+		return "{}"
+	}
+	return string(jsonBytes)
+}
+
+func UpdatePIIRedactionChannelsByJSONString(jsonStr string) error {
+	PIIRedactionChannels = make(map[string]bool)
+	if strings.TrimSpace(jsonStr) == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(jsonStr), &PIIRedactionChannels)
+}
+
+// ShouldRedactPII 判断当前分组和渠道是否需要脱敏，渠道设置优先于分组设置
+func ShouldRedactPII(group string, channelId int) bool {
+	enabled := PIIRedactionEnabled
+	if groupEnabled, ok := PIIRedactionGroups[group]; ok {
+		enabled = groupEnabled
+	}
+	if channelEnabled, ok := PIIRedactionChannels[strconv.Itoa(channelId)]; ok {
+		enabled = channelEnabled
+	}
+	return enabled
+}
@@ -0,0 +1,35 @@
+package constant
+
+// StatementEnabled 是否在每月结束后自动生成上月账单
+var StatementEnabled = false
+
+// StatementEmailEnabled 生成账单后是否通过邮件发送给用户
+var StatementEmailEnabled = false
+
+// StatementCompany 账单中展示的公司信息
+type StatementCompany struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	TaxId   string `json:"tax_id"`
+	Email   string `json:"email"`
+	Phone   string `json:"phone"`
+	Note    string `json:"note"`
+}
+
+var StatementCompanyName = ""
+var StatementCompanyAddress = ""
+var StatementCompanyTaxId = ""
+var StatementCompanyEmail = ""
+var StatementCompanyPhone = ""
+var StatementNote = ""
+
+func GetStatementCompany() StatementCompany {
+	return StatementCompany{
+		Name:    StatementCompanyName,
+		Address: StatementCompanyAddress,
+		TaxId:   StatementCompanyTaxId,
+		Email:   StatementCompanyEmail,
+		Phone:   StatementCompanyPhone,
+		Note:    StatementNote,
+	}
+}
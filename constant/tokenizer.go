@@ -0,0 +1,75 @@
+package constant
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// TokenizerTypeEncoding tiktoken 内置编码，如 cl100k_base、o200k_base
+	TokenizerTypeEncoding = "encoding"
+	// TokenizerTypeTiktoken tiktoken 格式的词表文件（每行 base64 token 与 rank），如 Qwen、GLM-4
+	TokenizerTypeTiktoken = "tiktoken"
+	// TokenizerTypeHuggingFace HuggingFace tokenizer.json 格式的 BPE 词表，如 Claude
+	TokenizerTypeHuggingFace = "huggingface"
+)
+
+type TokenizerMapping struct {
+	// Pattern 匹配模型名称的正则表达式
+	Pattern string `json:"pattern"`
+	Type    string `json:"type"`
+	// Encoding 内置编码名称，仅 encoding 类型使用
+	Encoding string `json:"encoding,omitempty"`
+	// File 词表文件路径，相对路径基于 TOKENIZER_DIR
+	File string `json:"file,omitempty"`
+	// SplitPattern 预分词正则，为空时使用 cl100k_base 的规则
+	SplitPattern string `json:"split_pattern,omitempty"`
+}
+
+// TokenizerMappings 管理员注册的模型分词器映射，按顺序匹配，优先于内置映射
+var TokenizerMappings = []TokenizerMapping{}
+
+// DefaultTokenizerMappings 内置映射，词表文件不存在时回退到 gpt-3.5 的编码
+var DefaultTokenizerMappings = []TokenizerMapping{
+	{Pattern: `^claude`, Type: TokenizerTypeHuggingFace, File: "claude.json"},
+	{Pattern: `(?i)^qwen`, Type: TokenizerTypeTiktoken, File: "qwen.tiktoken", SplitPattern: `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+	{Pattern: `(?i)^(glm-4|glm4|chatglm)`, Type: TokenizerTypeTiktoken, File: "glm4.tiktoken"},
+}
+
+func TokenizerMappings2JSONString() string {
+	jsonBytes, err := json.Marshal(TokenizerMappings)
+	if err != nil {
+		return "[]"
+	}
+	return string(jsonBytes)
+}
+
+func UpdateTokenizerMappingsByJSONString(jsonStr string) error {
+	mappings := make([]TokenizerMapping, 0)
+	if strings.TrimSpace(jsonStr) != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &mappings); err != nil {
+			return err
+		}
+	}
+	for _, mapping := range mappings {
+		if _, err := regexp.Compile(mapping.Pattern); err != nil {
+			return fmt.Errorf("invalid tokenizer pattern %s: %s", mapping.Pattern, err.Error())
+		}
+		switch mapping.Type {
+		case TokenizerTypeEncoding:
+			if mapping.Encoding == "" {
+				return fmt.Errorf("tokenizer mapping %s requires encoding", mapping.Pattern)
+			}
+		case TokenizerTypeTiktoken, TokenizerTypeHuggingFace:
+			if mapping.File == "" {
+				return fmt.Errorf("tokenizer mapping %s requires file", mapping.Pattern)
+			}
+		default:
+			return fmt.Errorf("unknown tokenizer type: %s", mapping.Type)
+		}
+	}
+	TokenizerMappings = mappings
+	return nil
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAccessTokenName 兼容旧版单一访问令牌的默认管理令牌名称
+const defaultAccessTokenName = "default"
+
+// rotateDefaultAccessToken 删除旧的默认管理令牌并生成拥有全部作用域的新令牌
+func rotateDefaultAccessToken(userId int) (string, error) {
+	if err := model.DeleteAccessTokensByName(userId, defaultAccessTokenName); err != nil {
+		return "", err
+	}
+	token := &model.AccessToken{
+		UserId:      userId,
+		Name:        defaultAccessTokenName,
+		ExpiredTime: -1,
+	}
+	if err := token.SetScopes([]string{common.PermissionAll}); err != nil {
+		return "", err
+	}
+	return token.Insert()
+}
+
+// checkGrantableScopes 作用域不能超出当前用户的权限；通过管理令牌创建时也不能超出该令牌的作用域
+func checkGrantableScopes(c *gin.Context, scopes []string) error {
+	current, fromToken := c.Get("access_token_scopes")
+	for _, scope := range scopes {
+		if fromToken && !common.HasPermission(current.([]string), scope) {
+			return fmt.Errorf("无法授予当前令牌不具备的作用域：%s", scope)
+		}
+		if scope == common.ScopeSelf || scope == common.PermissionAll {
+			continue
+		}
+		if !hasPermission(c, scope) {
+			return fmt.Errorf("无法授予自己不具备的权限：%s", scope)
+		}
+	}
+	return nil
+}
+
+func GetAccessTokens(c *gin.Context) {
+	tokens, err := model.GetUserAccessTokens(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tokens,
+	})
+}
+
+type AccessTokenRequest struct {
+	Name        string   `json:"name"`
+	Scopes      []string `json:"scopes"`
+	ExpiredTime int64    `json:"expired_time"`
+}
+
+// CreateAccessToken 创建管理令牌，明文令牌仅在本次响应中返回
+func CreateAccessToken(c *gin.Context) {
+	var req AccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" || len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.ExpiredTime == 0 {
+		req.ExpiredTime = -1
+	}
+	token := &model.AccessToken{
+		UserId:      c.GetInt("id"),
+		Name:        req.Name,
+		ExpiredTime: req.ExpiredTime,
+	}
+	err := token.SetScopes(req.Scopes)
+	if err == nil {
+		err = checkGrantableScopes(c, req.Scopes)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	key, err := token.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordSecurityLog(c, token.UserId, "创建了管理令牌 "+token.Name)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"token": token,
+			"key":   key,
+		},
+	})
+}
+
+func DeleteAccessToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err == nil {
+		err = model.DeleteAccessTokenById(id, c.GetInt("id"))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordSecurityLog(c, c.GetInt("id"), "删除了管理令牌 #"+strconv.Itoa(id))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAffCommissions 邀请人查看自己的返佣明细，可按被邀请人筛选
+func GetAffCommissions(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 0 {
+		p = 0
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	inviteeId, _ := strconv.Atoi(c.Query("invitee_id"))
+	commissions, total, err := model.GetAffCommissions(c.GetInt("id"), inviteeId, p*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    commissions,
+		"total":   total,
+	})
+}
+
+// GetAffCommissionSummaries 邀请人按被邀请人查看返佣汇总
+func GetAffCommissionSummaries(c *gin.Context) {
+	summaries, err := model.GetAffCommissionSummaries(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    summaries,
+	})
+}
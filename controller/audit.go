@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordAudit 记录一次管理操作，before 为 nil 表示创建，after 为 nil 表示删除。
+// 审计记录写入失败不影响操作本身，仅记录系统日志
+func recordAudit(c *gin.Context, action string, targetType string, targetId any, before any, after any) {
+	audit := &model.AuditLog{
+		ActorId:    c.GetInt("id"),
+		ActorName:  c.GetString("username"),
+		Ip:         c.ClientIP(),
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   fmt.Sprintf("%v", targetId),
+		Diff:       model.BuildAuditDiff(before, after),
+	}
+	if err := model.RecordAuditLog(audit); err != nil {
+		common.SysError(fmt.Sprintf("failed to record audit log %s %s:%s: %s", action, targetType, audit.TargetId, err.Error()))
+	}
+}
+
+func getAuditLogFilter(c *gin.Context) model.AuditLogFilter {
+	actorId, _ := strconv.Atoi(c.Query("actor_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return model.AuditLogFilter{
+		ActorId:        actorId,
+		ActorName:      c.Query("actor_name"),
+		Action:         c.Query("action"),
+		TargetType:     c.Query("target_type"),
+		TargetId:       c.Query("target_id"),
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+	}
+}
+
+func GetAuditLogs(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 0 {
+		p = 0
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	audits, total, err := model.SearchAuditLogs(getAuditLogFilter(c), p*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    audits,
+		"total":   total,
+	})
+}
+
+var auditExportHeader = []string{"id", "created_at", "actor_id", "actor_name", "ip", "action", "target_type", "target_id", "diff"}
+
+func ExportAuditLogs(c *gin.Context) {
+	writer, ok := newExportWriter(c, "audit", auditExportHeader)
+	if !ok {
+		return
+	}
+	err := model.IterateAuditLogs(getAuditLogFilter(c), exportBatchSize, func(audits []*model.AuditLog) error {
+		for _, audit := range audits {
+			row := []string{
+				strconv.Itoa(audit.Id),
+				strconv.FormatInt(audit.CreatedAt, 10),
+				strconv.Itoa(audit.ActorId),
+				audit.ActorName,
+				audit.Ip,
+				audit.Action,
+				audit.TargetType,
+				audit.TargetId,
+				audit.Diff,
+			}
+			if err := writer.write(audit, row); err != nil {
+				return err
+			}
+		}
+		return writer.flush()
+	})
+	if err != nil {
+		common.SysError("failed to export audit logs: " + err.Error())
+		return
+	}
+	_ = writer.flush()
+}
@@ -133,6 +133,7 @@ func FixChannelsAbilities(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, "channel.fix_abilities", model.AuditTargetChannel, "*", nil, gin.H{"fixed": count})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -186,6 +187,32 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+// GetChannelKey 查看渠道密钥，需要近期完成两步验证，每次查看都会记录审计
+func GetChannelKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "channel.view_key", model.AuditTargetChannel, id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    channel.Key,
+	})
+}
+
 func AddChannel(c *gin.Context) {
 	channel := model.Channel{}
 	err := c.ShouldBindJSON(&channel)
@@ -215,6 +242,9 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	for i := range channels {
+		recordAudit(c, "channel.create", model.AuditTargetChannel, channels[i].Id, nil, channels[i])
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -224,6 +254,7 @@ func AddChannel(c *gin.Context) {
 
 func DeleteChannel(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	before, _ := model.GetChannelById(id, true)
 	channel := model.Channel{Id: id}
 	err := channel.Delete()
 	if err != nil {
@@ -233,6 +264,7 @@ func DeleteChannel(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, "channel.delete", model.AuditTargetChannel, id, before, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -249,6 +281,7 @@ func DeleteDisabledChannel(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, "channel.delete_disabled", model.AuditTargetChannel, "*", nil, gin.H{"deleted": rows})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -271,6 +304,12 @@ func DeleteChannelBatch(c *gin.Context) {
 		})
 		return
 	}
+	befores := make([]*model.Channel, 0, len(channelBatch.Ids))
+	for _, id := range channelBatch.Ids {
+		if before, err := model.GetChannelById(id, true); err == nil {
+			befores = append(befores, before)
+		}
+	}
 	err = model.BatchDeleteChannels(channelBatch.Ids)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -279,6 +318,9 @@ func DeleteChannelBatch(c *gin.Context) {
 		})
 		return
 	}
+	for _, before := range befores {
+		recordAudit(c, "channel.delete", model.AuditTargetChannel, before.Id, before, nil)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -297,6 +339,7 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	before, _ := model.GetChannelById(channel.Id, true)
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -305,6 +348,8 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	after, _ := model.GetChannelById(channel.Id, true)
+	recordAudit(c, "channel.update", model.AuditTargetChannel, channel.Id, before, after)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
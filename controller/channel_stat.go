@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelStats 渠道表现统计，group_by 可选 channel（默认）、model（渠道 + 模型）或 hour（按小时的时间序列）
+func GetChannelStats(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	groupBy := c.DefaultQuery("group_by", "channel")
+	if groupBy != "channel" && groupBy != "model" && groupBy != "hour" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "group_by 只能为 channel、model 或 hour",
+		})
+		return
+	}
+	summaries, err := model.GetChannelStatSummaries(model.ChannelStatQuery{
+		ChannelId:      channelId,
+		ModelName:      c.Query("model_name"),
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		GroupBy:        groupBy,
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    summaries,
+	})
+}
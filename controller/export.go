@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const exportBatchSize = 1000
+
+// exportWriter 将记录以 CSV 或 JSONL 格式流式写出
+type exportWriter struct {
+	c       *gin.Context
+	csv     *csv.Writer
+	encoder *json.Encoder
+}
+
+func newExportWriter(c *gin.Context, name string, header []string) (*exportWriter, bool) {
+	format := c.DefaultQuery("format", "csv")
+	filename := fmt.Sprintf("%s-%s", name, time.Now().Format("20060102150405"))
+	writer := &exportWriter{c: c}
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", filename))
+		writer.csv = csv.NewWriter(c.Writer)
+		c.Status(http.StatusOK)
+		if err := writer.csv.Write(header); err != nil {
+			return nil, false
+		}
+	case "jsonl":
+		c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.jsonl", filename))
+		writer.encoder = json.NewEncoder(c.Writer)
+		c.Status(http.StatusOK)
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "导出格式只能为 csv 或 jsonl",
+		})
+		return nil, false
+	}
+	return writer, true
+}
+
+func (w *exportWriter) write(record any, row []string) error {
+	if w.csv != nil {
+		return w.csv.Write(row)
+	}
+	return w.encoder.Encode(record)
+}
+
+func (w *exportWriter) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	w.c.Writer.Flush()
+	// 客户端断开后停止读取数据库
+	return w.c.Request.Context().Err()
+}
+
+var logExportHeader = []string{"id", "created_at", "type", "username", "token_name", "model_name", "quota", "prompt_tokens", "completion_tokens", "use_time", "is_stream", "channel", "token_id", "content", "other"}
+
+func exportLogs(c *gin.Context, filter model.LogFilter, isAdmin bool) {
+	writer, ok := newExportWriter(c, "logs", logExportHeader)
+	if !ok {
+		return
+	}
+	writeLog := func(log *model.Log) error {
+		if filter.UserIds != nil {
+			clearResellerLogs([]*model.Log{log})
+		} else if !isAdmin {
+			otherMap := common.StrToMap(log.Other)
+			if otherMap != nil {
+				delete(otherMap, "admin_info")
+			}
+			log.Other = common.MapToJsonStr(otherMap)
+		}
+		row := []string{
+			strconv.Itoa(log.Id),
+			strconv.FormatInt(log.CreatedAt, 10),
+			strconv.Itoa(log.Type),
+			log.Username,
+			log.TokenName,
+			log.ModelName,
+			strconv.Itoa(log.Quota),
+			strconv.Itoa(log.PromptTokens),
+			strconv.Itoa(log.CompletionTokens),
+			strconv.Itoa(log.UseTime),
+			strconv.FormatBool(log.IsStream),
+			strconv.Itoa(log.ChannelId),
+			strconv.Itoa(log.TokenId),
+			log.Content,
+			log.Other,
+		}
+		return writer.write(log, row)
+	}
+	// 归档中的日志早于数据库中的日志，先输出归档部分
+	if c.Query("include_archived") == "true" {
+		if err := exportArchivedLogs(filter, writer, writeLog); err != nil {
+			common.SysError("failed to export archived logs: " + err.Error())
+			return
+		}
+	}
+	err := model.IterateLogs(filter, exportBatchSize, func(logs []*model.Log) error {
+		for _, log := range logs {
+			if err := writeLog(log); err != nil {
+				return err
+			}
+		}
+		return writer.flush()
+	})
+	if err != nil {
+		common.SysError("failed to export logs: " + err.Error())
+		return
+	}
+	_ = writer.flush()
+}
+
+func exportArchivedLogs(filter model.LogFilter, writer *exportWriter, writeLog func(log *model.Log) error) error {
+	archives, err := model.GetLogArchivesInRange(filter.StartTimestamp, filter.EndTimestamp)
+	if err != nil {
+		return err
+	}
+	for _, archive := range archives {
+		count := 0
+		err = service.ReadLogArchive(archive, func(log *model.Log) error {
+			if !filter.Match(log) {
+				return nil
+			}
+			if err := writeLog(log); err != nil {
+				return err
+			}
+			count++
+			if count%exportBatchSize == 0 {
+				return writer.flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err = writer.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseLogFilter(c *gin.Context) model.LogFilter {
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	return model.LogFilter{
+		LogType:        logType,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      c.Query("model_name"),
+		Username:       c.Query("username"),
+		TokenName:      c.Query("token_name"),
+		Channel:        channel,
+	}
+}
+
+func ExportAllLogs(c *gin.Context) {
+	filter := parseLogFilter(c)
+	userIds, err := getResellerScope(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	filter.UserIds = userIds
+	exportLogs(c, filter, userIds == nil)
+}
+
+func ExportUserLogs(c *gin.Context) {
+	filter := parseLogFilter(c)
+	filter.UserId = c.GetInt("id")
+	filter.Username = ""
+	exportLogs(c, filter, false)
+}
+
+var quotaDataExportHeader = []string{"id", "user_id", "username", "model_name", "token_id", "token_name", "channel_id", "granularity", "created_at", "token_used", "count", "quota"}
+
+func exportQuotaData(c *gin.Context, filter model.QuotaDataFilter) {
+	writer, ok := newExportWriter(c, "quota-data", quotaDataExportHeader)
+	if !ok {
+		return
+	}
+	err := model.IterateQuotaData(filter, exportBatchSize, func(quotaData []*model.QuotaData) error {
+		for _, data := range quotaData {
+			if filter.UserIds != nil {
+				data.ChannelId = 0
+			}
+			row := []string{
+				strconv.Itoa(data.Id),
+				strconv.Itoa(data.UserID),
+				data.Username,
+				data.ModelName,
+				strconv.Itoa(data.TokenId),
+				data.TokenName,
+				strconv.Itoa(data.ChannelId),
+				data.Granularity,
+				strconv.FormatInt(data.CreatedAt, 10),
+				strconv.Itoa(data.TokenUsed),
+				strconv.Itoa(data.Count),
+				strconv.Itoa(data.Quota),
+			}
+			if err := writer.write(data, row); err != nil {
+				return err
+			}
+		}
+		return writer.flush()
+	})
+	if err != nil {
+		common.SysError("failed to export quota data: " + err.Error())
+		return
+	}
+	_ = writer.flush()
+}
+
+func parseQuotaDataFilter(c *gin.Context) model.QuotaDataFilter {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	return model.QuotaDataFilter{
+		Username:       c.Query("username"),
+		ModelName:      c.Query("model_name"),
+		TokenName:      c.Query("token_name"),
+		ChannelId:      channel,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+	}
+}
+
+func ExportAllQuotaData(c *gin.Context) {
+	filter := parseQuotaDataFilter(c)
+	userIds, err := getResellerScope(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	filter.UserIds = userIds
+	if userIds != nil {
+		filter.ChannelId = 0
+	}
+	exportQuotaData(c, filter)
+}
+
+func ExportUserQuotaData(c *gin.Context) {
+	filter := parseQuotaDataFilter(c)
+	filter.UserId = c.GetInt("id")
+	filter.Username = ""
+	exportQuotaData(c, filter)
+}
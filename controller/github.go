@@ -87,8 +87,7 @@ func GitHubOAuth(c *gin.Context) {
 		})
 		return
 	}
-	username := session.Get("username")
-	if username != nil {
+	if _, loggedIn := sessionUserId(c); loggedIn {
 		GitHubBind(c)
 		return
 	}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LDAPLogin 使用目录账号登录，目录用户首次登录时即时创建账户，不受 RegisterEnabled 限制
+func LDAPLogin(c *gin.Context) {
+	if !common.LDAPLoginEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "管理员未开启 LDAP 登录",
+			"success": false,
+		})
+		return
+	}
+	var loginRequest LoginRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&loginRequest)
+	if err != nil || loginRequest.Username == "" || loginRequest.Password == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无效的参数",
+			"success": false,
+		})
+		return
+	}
+	if !checkLoginAllowed(c, loginRequest.Username) {
+		return
+	}
+	ldapUser, err := service.AuthenticateLDAPUser(loginRequest.Username, loginRequest.Password)
+	if errors.Is(err, service.ErrLDAPInvalidCredentials) {
+		failedUser := model.User{LdapId: loginRequest.Username}
+		if model.IsLdapIdAlreadyTaken(failedUser.LdapId) {
+			_ = failedUser.FillUserByLdapId()
+		}
+		recordLoginFailure(c, loginRequest.Username, failedUser.Id, "LDAP 用户名或密码错误")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	rule, matched := service.ResolveLDAPGroupRule(ldapUser.Groups)
+	user := model.User{
+		LdapId: ldapUser.Id,
+	}
+	if model.IsLdapIdAlreadyTaken(user.LdapId) {
+		if err = user.FillUserByLdapId(); err == nil {
+			err = syncLDAPUser(&user, ldapUser, rule, matched)
+		}
+	} else {
+		user.Username = getProvisionUsername(ldapUser.Id, "ldap_")
+		user.DisplayName = ldapUser.DisplayName
+		if user.DisplayName == "" || len(user.DisplayName) > 20 {
+			user.DisplayName = user.Username
+		}
+		if len(ldapUser.Email) <= 50 {
+			user.Email = ldapUser.Email
+		}
+		user.Group = rule.Group
+		user.Role = common.RoleCommonUser
+		if rule.Role > 0 {
+			user.Role = rule.Role
+		}
+		user.Status = common.UserStatusEnabled
+		err = user.Insert(0)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	model.ClearLoginFailures(loginRequest.Username)
+	setupLogin(&user, c)
+}
+
+// syncLDAPUser 每次登录时以目录为准同步显示名称、邮箱、分组与权限等级。
+// 目录组映射中配置了权限等级时，不再属于任何授权目录组的用户会降为普通用户；超级管理员不受影响
+func syncLDAPUser(user *model.User, ldapUser *service.LDAPUser, rule common.LDAPGroupRule, matched bool) error {
+	origin := *user
+	if ldapUser.DisplayName != "" && len(ldapUser.DisplayName) <= 20 {
+		user.DisplayName = ldapUser.DisplayName
+	}
+	if ldapUser.Email != "" && len(ldapUser.Email) <= 50 {
+		user.Email = ldapUser.Email
+	}
+	if matched && rule.Group != "" {
+		user.Group = rule.Group
+	}
+	if user.Role != common.RoleRootUser && ldapRoleMappingEnabled() {
+		user.Role = common.RoleCommonUser
+		if rule.Role > 0 {
+			user.Role = rule.Role
+		}
+	}
+	if user.DisplayName == origin.DisplayName && user.Email == origin.Email &&
+		user.Group == origin.Group && user.Role == origin.Role {
+		return nil
+	}
+	return user.Update(false)
+}
+
+func ldapRoleMappingEnabled() bool {
+	for _, rule := range common.LDAPGroupMapping {
+		if rule.Role > 0 {
+			return true
+		}
+	}
+	return false
+}
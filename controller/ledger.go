@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getLedgers(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 0 {
+		p = 0
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	reason := c.Query("reason")
+	reference := c.Query("reference")
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	ledgers, total, err := model.GetQuotaLedgers(userId, reason, reference, startTimestamp, endTimestamp, p*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    ledgers,
+		"total":   total,
+	})
+}
+
+func GetUserLedgers(c *gin.Context) {
+	getLedgers(c, c.GetInt("id"))
+}
+
+func GetAllLedgers(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	getLedgers(c, userId)
+}
+
+func GetLedgerReconcileReport(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetLastLedgerReconcileReport(),
+	})
+}
+
+func ReconcileLedger(c *gin.Context) {
+	report, err := model.ReconcileQuotaLedger()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    report,
+	})
+}
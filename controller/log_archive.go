@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetLogArchives(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	archives, total, err := model.GetLogArchives(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":       archives,
+			"total":       total,
+			"partitioned": model.IsLogPartitioned(),
+		},
+	})
+}
+
+// ArchiveLogs 立即执行一次日志归档
+func ArchiveLogs(c *gin.Context) {
+	count, err := service.ArchiveLogs()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    count,
+	})
+}
+
+// AutomaticallyArchiveLogs 定时维护日志分区并归档过期日志
+func AutomaticallyArchiveLogs() {
+	for {
+		if err := model.EnsureLogPartitions(); err != nil {
+			common.SysError("failed to create log partitions: " + err.Error())
+		}
+		if constant.LogArchiveEnabled {
+			count, err := service.ArchiveLogs()
+			if err != nil {
+				common.SysError("failed to archive logs: " + err.Error())
+			}
+			if count > 0 {
+				common.SysLog(fmt.Sprintf("created %d log archives", count))
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
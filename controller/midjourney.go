@@ -156,7 +156,11 @@ func UpdateMidjourneyTaskBulk() {
 					} else {
 						quota := task.Quota
 						if quota != 0 {
-							err = model.IncreaseUserQuota(task.UserId, quota)
+							if task.OrgId != 0 {
+								err = model.RefundOrganizationQuota(task.OrgId, task.UserId, quota, task.MjId)
+							} else {
+								err = model.IncreaseUserQuota(task.UserId, quota, model.LedgerReasonRefund, task.MjId)
+							}
 							if err != nil {
 								common.LogError(ctx, "fail to increase user quota: "+err.Error())
 							}
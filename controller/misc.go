@@ -38,6 +38,8 @@ func GetStatus(c *gin.Context) {
 			"email_verification":       common.EmailVerificationEnabled,
 			"github_oauth":             common.GitHubOAuthEnabled,
 			"github_client_id":         common.GitHubClientId,
+			"oidc_login":               common.OIDCEnabled,
+			"ldap_login":               common.LDAPLoginEnabled,
 			"telegram_oauth":           common.TelegramOAuthEnabled,
 			"telegram_bot_name":        common.TelegramBotName,
 			"system_name":              common.SystemName,
@@ -246,7 +248,12 @@ func ResetPassword(c *gin.Context) {
 		})
 		return
 	}
-	password := common.GenerateVerificationCode(12)
+	// 随机密码包含全部字符类别，满足任意密码策略
+	length := 16
+	if common.PasswordMinLength > length {
+		length = common.PasswordMinLength
+	}
+	password := common.GenerateRandomPassword(length)
 	err = model.ResetUserPasswordByEmail(req.Email, password)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -256,6 +263,10 @@ func ResetPassword(c *gin.Context) {
 		return
 	}
 	common.DeleteKey(req.Email, common.PasswordResetPurpose)
+	user := model.User{Email: req.Email}
+	if err = user.FillUserByEmail(); err == nil && user.Id != 0 {
+		recordSecurityLog(c, user.Id, "通过邮箱重置了登录密码")
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
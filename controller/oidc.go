@@ -0,0 +1,439 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCDiscovery 身份提供方的发现文档中用到的字段
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type OIDCTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IdToken          string `json:"id_token"`
+	TokenType        string `json:"token_type"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+const oidcDiscoveryCacheSeconds = 3600
+
+var oidcDiscoveryCache struct {
+	sync.Mutex
+	issuer    string
+	fetchedAt int64
+	discovery *OIDCDiscovery
+}
+
+var oidcClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// getOIDCDiscovery 读取并缓存发现文档，签发者地址变更后重新获取
+func getOIDCDiscovery() (*OIDCDiscovery, error) {
+	issuer := common.OIDCIssuer
+	if issuer == "" {
+		return nil, errors.New("管理员未配置 OIDC 签发者地址")
+	}
+	oidcDiscoveryCache.Lock()
+	defer oidcDiscoveryCache.Unlock()
+	if oidcDiscoveryCache.discovery != nil && oidcDiscoveryCache.issuer == issuer &&
+		common.GetTimestamp()-oidcDiscoveryCache.fetchedAt < oidcDiscoveryCacheSeconds {
+		return oidcDiscoveryCache.discovery, nil
+	}
+	res, err := oidcClient.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		common.SysLog(err.Error())
+		return nil, errors.New("无法连接至 OIDC 服务器，请稍后重试！")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取 OIDC 发现文档失败：%s", res.Status)
+	}
+	var discovery OIDCDiscovery
+	if err = json.NewDecoder(res.Body).Decode(&discovery); err != nil {
+		return nil, err
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" {
+		return nil, errors.New("OIDC 发现文档缺少授权或令牌端点")
+	}
+	if discovery.Issuer != "" && strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, errors.New("OIDC 发现文档的签发者与配置不一致")
+	}
+	oidcDiscoveryCache.issuer = issuer
+	oidcDiscoveryCache.fetchedAt = common.GetTimestamp()
+	oidcDiscoveryCache.discovery = &discovery
+	return &discovery, nil
+}
+
+func getOIDCRedirectURI() string {
+	return fmt.Sprintf("%s/oauth/oidc", constant.ServerAddress)
+}
+
+// OIDCAuthorize 生成 state 与 nonce 后跳转至身份提供方的授权页面，登录与绑定共用此入口
+func OIDCAuthorize(c *gin.Context) {
+	if !common.OIDCEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员未开启通过 OIDC 登录以及注册",
+		})
+		return
+	}
+	discovery, err := getOIDCDiscovery()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	state := common.GetRandomString(16)
+	nonce := common.GetRandomString(16)
+	session := sessions.Default(c)
+	session.Set("oidc_state", state)
+	session.Set("oidc_nonce", nonce)
+	if err = session.Save(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", common.OIDCClientId)
+	values.Set("redirect_uri", getOIDCRedirectURI())
+	values.Set("scope", common.OIDCScopes)
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, discovery.AuthorizationEndpoint+separator+values.Encode())
+}
+
+func getOIDCClaimsByCode(code string, nonce string) (map[string]any, error) {
+	if code == "" {
+		return nil, errors.New("无效的参数")
+	}
+	discovery, err := getOIDCDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", getOIDCRedirectURI())
+	form.Set("client_id", common.OIDCClientId)
+	form.Set("client_secret", common.OIDCClientSecret)
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := oidcClient.Do(req)
+	if err != nil {
+		common.SysLog(err.Error())
+		return nil, errors.New("无法连接至 OIDC 服务器，请稍后重试！")
+	}
+	defer res.Body.Close()
+	var tokenResponse OIDCTokenResponse
+	if err = json.NewDecoder(res.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+	if tokenResponse.Error != "" {
+		return nil, fmt.Errorf("OIDC 授权失败：%s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	claims := make(map[string]any)
+	if tokenResponse.IdToken != "" {
+		claims, err = parseOIDCIdToken(tokenResponse.IdToken, discovery, nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if discovery.UserinfoEndpoint != "" && tokenResponse.AccessToken != "" {
+		userinfo, err := getOIDCUserinfo(discovery.UserinfoEndpoint, tokenResponse.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		if sub, ok := claims["sub"]; ok && sub != userinfo["sub"] {
+			return nil, errors.New("OIDC 用户信息与 ID Token 的用户标识不一致")
+		}
+		for k, v := range userinfo {
+			claims[k] = v
+		}
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("返回值非法，用户标识为空，请稍后重试！")
+	}
+	return claims, nil
+}
+
+// parseOIDCIdToken 解析 ID Token 的声明。ID Token 由服务端通过 TLS 直接从令牌端点获取，
+// 按 OIDC Core 3.1.3.7 可以不校验签名，但仍校验签发者、受众、有效期与 nonce
+func parseOIDCIdToken(idToken string, discovery *OIDCDiscovery, nonce string) (map[string]any, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID Token 格式错误")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("ID Token 格式错误")
+	}
+	var claims map[string]any
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("ID Token 格式错误")
+	}
+	issuer := discovery.Issuer
+	if issuer == "" {
+		issuer = common.OIDCIssuer
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, errors.New("ID Token 签发者不匹配")
+	}
+	if !oidcAudienceContains(claims["aud"], common.OIDCClientId) {
+		return nil, errors.New("ID Token 受众不匹配")
+	}
+	if exp, ok := claims["exp"].(float64); ok && int64(exp) < common.GetTimestamp() {
+		return nil, errors.New("ID Token 已过期")
+	}
+	if tokenNonce, ok := claims["nonce"].(string); !ok || tokenNonce != nonce {
+		return nil, errors.New("ID Token nonce 不匹配")
+	}
+	return claims, nil
+}
+
+func oidcAudienceContains(aud any, clientId string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientId
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getOIDCUserinfo(endpoint string, accessToken string) (map[string]any, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Accept", "application/json")
+	res, err := oidcClient.Do(req)
+	if err != nil {
+		common.SysLog(err.Error())
+		return nil, errors.New("无法连接至 OIDC 服务器，请稍后重试！")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取 OIDC 用户信息失败：%s", res.Status)
+	}
+	var userinfo map[string]any
+	if err = json.NewDecoder(res.Body).Decode(&userinfo); err != nil {
+		return nil, err
+	}
+	return userinfo, nil
+}
+
+// getOIDCClaim 读取声明，支持以点号分隔的嵌套路径，如 realm_access.roles
+func getOIDCClaim(claims map[string]any, path string) any {
+	if path == "" {
+		return nil
+	}
+	var current any = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = m[key]
+	}
+	return current
+}
+
+func getOIDCClaimString(claims map[string]any, path string) string {
+	switch v := getOIDCClaim(claims, path).(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// resolveOIDCGroup 根据分组声明确定新用户的分组，声明可以是字符串或字符串数组，取第一个匹配的分组
+func resolveOIDCGroup(claims map[string]any) string {
+	var values []string
+	switch v := getOIDCClaim(claims, common.OIDCGroupClaim).(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	for _, value := range values {
+		group := value
+		if len(common.OIDCGroupMapping) > 0 {
+			mapped, ok := common.OIDCGroupMapping[value]
+			if !ok {
+				continue
+			}
+			group = mapped
+		}
+		if _, ok := common.GroupRatio[group]; ok {
+			return group
+		}
+	}
+	return ""
+}
+
+func OIDCOAuth(c *gin.Context) {
+	session := sessions.Default(c)
+	state := c.Query("state")
+	expectedState, _ := session.Get("oidc_state").(string)
+	if state == "" || expectedState == "" || state != expectedState {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "state is empty or not same",
+		})
+		return
+	}
+	nonce, _ := session.Get("oidc_nonce").(string)
+	// state 只能使用一次
+	session.Delete("oidc_state")
+	session.Delete("oidc_nonce")
+	_ = session.Save()
+	if !common.OIDCEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员未开启通过 OIDC 登录以及注册",
+		})
+		return
+	}
+	claims, err := getOIDCClaimsByCode(c.Query("code"), nonce)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	oidcId := claims["sub"].(string)
+	if _, loggedIn := sessionUserId(c); loggedIn {
+		OIDCBind(c, oidcId)
+		return
+	}
+	user := model.User{
+		OidcId: oidcId,
+	}
+	if model.IsOidcIdAlreadyTaken(user.OidcId) {
+		err := user.FillUserByOidcId()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	} else {
+		if !common.RegisterEnabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "管理员关闭了新用户注册",
+			})
+			return
+		}
+		user.Username = getProvisionUsername(getOIDCClaimString(claims, common.OIDCUsernameClaim), "oidc_")
+		user.DisplayName = getOIDCClaimString(claims, "name")
+		if user.DisplayName == "" || len(user.DisplayName) > 20 {
+			user.DisplayName = user.Username
+		}
+		if email := getOIDCClaimString(claims, common.OIDCEmailClaim); len(email) <= 50 {
+			user.Email = email
+		}
+		user.Group = resolveOIDCGroup(claims)
+		user.Role = common.RoleCommonUser
+		user.Status = common.UserStatusEnabled
+		if err := user.Insert(0); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	setupLogin(&user, c)
+}
+
+// OIDCBind 已登录用户绑定 OIDC 账户
+func OIDCBind(c *gin.Context, oidcId string) {
+	if model.IsOidcIdAlreadyTaken(oidcId) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该 OIDC 账户已被绑定",
+		})
+		return
+	}
+	session := sessions.Default(c)
+	user := model.User{
+		Id: session.Get("id").(int),
+	}
+	err := user.FillUserById()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	user.OidcId = oidcId
+	err = user.Update(false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "bind",
+	})
+}
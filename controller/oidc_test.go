@@ -0,0 +1,342 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/model"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testOIDCClientId     = "one-api-test"
+	testOIDCClientSecret = "one-api-secret"
+	testOIDCCode         = "test-code"
+	testOIDCAccessToken  = "test-access-token"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "one-api-controller-test")
+	if err != nil {
+		panic(err)
+	}
+	common.SQLitePath = filepath.Join(dir, "one-api.db")
+	common.RedisEnabled = false
+	if err = model.InitDB(); err != nil {
+		panic(err)
+	}
+	if err = model.InitLogDB(); err != nil {
+		panic(err)
+	}
+	model.InitOptionMap()
+	gin.SetMode(gin.TestMode)
+	code := m.Run()
+	_ = model.CloseDB()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeOIDCProvider 模拟身份提供方的发现、令牌与用户信息端点
+type fakeOIDCProvider struct {
+	server          *httptest.Server
+	discoveryIssuer string
+	idTokenClaims   map[string]any
+	userinfo        map[string]any
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	provider := &fakeOIDCProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]any{
+			"issuer":                 provider.discoveryIssuer,
+			"authorization_endpoint": provider.server.URL + "/authorize",
+			"token_endpoint":         provider.server.URL + "/token",
+			"userinfo_endpoint":      provider.server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.ParseForm() != nil ||
+			r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("code") != testOIDCCode ||
+			r.PostForm.Get("client_id") != testOIDCClientId ||
+			r.PostForm.Get("client_secret") != testOIDCClientSecret ||
+			r.PostForm.Get("redirect_uri") != getOIDCRedirectURI() {
+			writeTestJSON(w, map[string]any{"error": "invalid_grant", "error_description": "bad code"})
+			return
+		}
+		writeTestJSON(w, map[string]any{
+			"access_token": testOIDCAccessToken,
+			"token_type":   "Bearer",
+			"id_token":     encodeTestIdToken(provider.idTokenClaims),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testOIDCAccessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeTestJSON(w, provider.userinfo)
+	})
+	provider.server = httptest.NewServer(mux)
+	provider.discoveryIssuer = provider.server.URL
+	t.Cleanup(provider.server.Close)
+
+	oidcDiscoveryCache.Lock()
+	oidcDiscoveryCache.discovery = nil
+	oidcDiscoveryCache.Unlock()
+	common.OIDCEnabled = true
+	common.OIDCIssuer = provider.server.URL
+	common.OIDCClientId = testOIDCClientId
+	common.OIDCClientSecret = testOIDCClientSecret
+	common.OIDCGroupClaim = "realm_access.roles"
+	common.OIDCGroupMapping = map[string]string{"idp-vip": "vip"}
+	t.Cleanup(func() {
+		common.OIDCEnabled = false
+		common.OIDCIssuer = ""
+		common.OIDCGroupClaim = ""
+		common.OIDCGroupMapping = map[string]string{}
+	})
+	return provider
+}
+
+func writeTestJSON(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func encodeTestIdToken(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
+
+func newTestOIDCEngine() *gin.Engine {
+	engine := gin.New()
+	engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("test-secret"))))
+	engine.GET("/api/oauth/oidc/authorize", OIDCAuthorize)
+	engine.GET("/api/oauth/oidc", OIDCOAuth)
+	engine.PUT("/api/option/", UpdateOption)
+	return engine
+}
+
+func serveTestRequest(engine *gin.Engine, method string, target string, cookies []*http.Cookie, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func decodeTestResponse(t *testing.T, w *httptest.ResponseRecorder) (bool, string) {
+	var response struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	return response.Success, response.Message
+}
+
+// startTestOIDCLogin 访问授权入口，返回会话 cookie 以及跳转地址中的 state 与 nonce
+func startTestOIDCLogin(t *testing.T, engine *gin.Engine, provider *fakeOIDCProvider) ([]*http.Cookie, string, string) {
+	w := serveTestRequest(engine, http.MethodGet, "/api/oauth/oidc/authorize", nil, "")
+	if w.Code != http.StatusFound {
+		t.Fatalf("authorize returned %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), provider.server.URL+"/authorize?") {
+		t.Fatalf("unexpected authorization endpoint %s", location)
+	}
+	query := location.Query()
+	if query.Get("client_id") != testOIDCClientId || query.Get("redirect_uri") != getOIDCRedirectURI() ||
+		query.Get("response_type") != "code" || query.Get("scope") != common.OIDCScopes {
+		t.Fatalf("unexpected authorization request %s", location)
+	}
+	if query.Get("state") == "" || query.Get("nonce") == "" {
+		t.Fatalf("state or nonce missing in %s", location)
+	}
+	return w.Result().Cookies(), query.Get("state"), query.Get("nonce")
+}
+
+func testIdTokenClaims(provider *fakeOIDCProvider, sub string, nonce string) map[string]any {
+	return map[string]any{
+		"iss":   provider.server.URL,
+		"aud":   []any{"other-client", testOIDCClientId},
+		"sub":   sub,
+		"nonce": nonce,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestOIDCLoginProvisionsUserWithMappedGroup(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	// 签发者带结尾斜杠时仍与配置的签发者地址一致
+	provider.discoveryIssuer = provider.server.URL + "/"
+	engine := newTestOIDCEngine()
+	cookies, state, nonce := startTestOIDCLogin(t, engine, provider)
+	provider.idTokenClaims = testIdTokenClaims(provider, "oidc-alice", nonce)
+	provider.idTokenClaims["iss"] = provider.server.URL + "/"
+	provider.userinfo = map[string]any{
+		"sub":                "oidc-alice",
+		"preferred_username": "alice",
+		"name":               "Alice",
+		"email":              "alice@example.com",
+		"realm_access":       map[string]any{"roles": []any{"offline_access", "idp-vip"}},
+	}
+
+	w := serveTestRequest(engine, http.MethodGet, "/api/oauth/oidc?code="+testOIDCCode+"&state="+state, cookies, "")
+	if success, message := decodeTestResponse(t, w); !success {
+		t.Fatalf("login failed: %s", message)
+	}
+	user := model.User{OidcId: "oidc-alice"}
+	if err := user.FillUserByOidcId(); err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.com" || user.Group != "vip" {
+		t.Fatalf("unexpected provisioned user %s %s %s", user.Username, user.Email, user.Group)
+	}
+
+	// state 只能使用一次
+	w = serveTestRequest(engine, http.MethodGet, "/api/oauth/oidc?code="+testOIDCCode+"&state="+state, w.Result().Cookies(), "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("reused state returned %d", w.Code)
+	}
+}
+
+func TestOIDCLoginRejectsMismatchedState(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	engine := newTestOIDCEngine()
+	cookies, _, _ := startTestOIDCLogin(t, engine, provider)
+	w := serveTestRequest(engine, http.MethodGet, "/api/oauth/oidc?code="+testOIDCCode+"&state=forged", cookies, "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("forged state returned %d", w.Code)
+	}
+	w = serveTestRequest(engine, http.MethodGet, "/api/oauth/oidc?code="+testOIDCCode+"&state=forged", nil, "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("state without session returned %d", w.Code)
+	}
+}
+
+func TestOIDCLoginRejectsInvalidIdToken(t *testing.T) {
+	cases := []struct {
+		name    string
+		code    string
+		modify  func(provider *fakeOIDCProvider, claims map[string]any)
+		message string
+	}{
+		{
+			name:    "code",
+			code:    "forged-code",
+			message: "OIDC 授权失败",
+		},
+		{
+			name:    "nonce",
+			modify:  func(provider *fakeOIDCProvider, claims map[string]any) { claims["nonce"] = "forged" },
+			message: "nonce 不匹配",
+		},
+		{
+			name:    "missing nonce",
+			modify:  func(provider *fakeOIDCProvider, claims map[string]any) { delete(claims, "nonce") },
+			message: "nonce 不匹配",
+		},
+		{
+			name:    "audience",
+			modify:  func(provider *fakeOIDCProvider, claims map[string]any) { claims["aud"] = "other-client" },
+			message: "受众不匹配",
+		},
+		{
+			name:    "issuer",
+			modify:  func(provider *fakeOIDCProvider, claims map[string]any) { claims["iss"] = "https://evil.example.com" },
+			message: "签发者不匹配",
+		},
+		{
+			name: "expired",
+			modify: func(provider *fakeOIDCProvider, claims map[string]any) {
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			},
+			message: "已过期",
+		},
+		{
+			name: "userinfo subject",
+			modify: func(provider *fakeOIDCProvider, claims map[string]any) {
+				provider.userinfo["sub"] = "oidc-other"
+			},
+			message: "用户标识不一致",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := newFakeOIDCProvider(t)
+			engine := newTestOIDCEngine()
+			cookies, state, nonce := startTestOIDCLogin(t, engine, provider)
+			provider.idTokenClaims = testIdTokenClaims(provider, "oidc-rejected", nonce)
+			provider.userinfo = map[string]any{"sub": "oidc-rejected", "preferred_username": "rejected"}
+			if tc.modify != nil {
+				tc.modify(provider, provider.idTokenClaims)
+			}
+			code := tc.code
+			if code == "" {
+				code = testOIDCCode
+			}
+			w := serveTestRequest(engine, http.MethodGet, "/api/oauth/oidc?code="+code+"&state="+state, cookies, "")
+			success, message := decodeTestResponse(t, w)
+			if success || !strings.Contains(message, tc.message) {
+				t.Fatalf("expected rejection containing %q, got %v %q", tc.message, success, message)
+			}
+			if model.IsOidcIdAlreadyTaken("oidc-rejected") {
+				t.Fatal("rejected login provisioned a user")
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryRejectsMismatchedIssuer(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	provider.discoveryIssuer = "https://evil.example.com"
+	engine := newTestOIDCEngine()
+	w := serveTestRequest(engine, http.MethodGet, "/api/oauth/oidc/authorize", nil, "")
+	success, message := decodeTestResponse(t, w)
+	if success || !strings.Contains(message, "签发者与配置不一致") {
+		t.Fatalf("expected issuer mismatch, got %v %q", success, message)
+	}
+}
+
+func TestUpdateOptionTrimsOIDCIssuer(t *testing.T) {
+	engine := newTestOIDCEngine()
+	w := serveTestRequest(engine, http.MethodPut, "/api/option/", nil, `{"key":"OIDCIssuer","value":" https://idp.example.com/realms/one/ "}`)
+	if success, message := decodeTestResponse(t, w); !success {
+		t.Fatalf("update failed: %s", message)
+	}
+	t.Cleanup(func() {
+		_ = model.UpdateOption("OIDCIssuer", "")
+	})
+	expected := "https://idp.example.com/realms/one"
+	common.OptionMapRWMutex.RLock()
+	saved := common.OptionMap["OIDCIssuer"]
+	common.OptionMapRWMutex.RUnlock()
+	if common.OIDCIssuer != expected || saved != expected {
+		t.Fatalf("issuer not normalized: %q %q", common.OIDCIssuer, saved)
+	}
+}
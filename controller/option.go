@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/model"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -14,7 +16,7 @@ func GetOptions(c *gin.Context) {
 	var options []*model.Option
 	common.OptionMapRWMutex.Lock()
 	for k, v := range common.OptionMap {
-		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || strings.HasSuffix(k, "Key") {
+		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || strings.HasSuffix(k, "Key") || strings.HasSuffix(k, "Password") {
 			continue
 		}
 		options = append(options, &model.Option{
@@ -50,6 +52,67 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "OIDCEnabled":
+		if option.Value == "true" && (common.OIDCIssuer == "" || common.OIDCClientId == "") {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无法启用 OIDC 登录，请先填入 OIDC 签发者地址、Client Id 以及 Client Secret！",
+			})
+			return
+		}
+	case "OIDCIssuer":
+		// 发现文档地址与签发者校验都基于不带结尾斜杠的签发者地址
+		option.Value = strings.TrimSuffix(strings.TrimSpace(option.Value), "/")
+	case "LDAPLoginEnabled":
+		if option.Value == "true" && (common.LDAPServerURL == "" || common.LDAPBaseDN == "") {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无法启用 LDAP 登录，请先填入 LDAP 服务器地址以及搜索基准 DN！",
+			})
+			return
+		}
+	case "PasswordMinLength":
+		if length, err := strconv.Atoi(option.Value); err != nil || length < 8 || length > 30 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "密码最小长度需在 8 到 30 之间！",
+			})
+			return
+		}
+	case "PasswordRequiredCharClasses":
+		if classes, err := strconv.Atoi(option.Value); err != nil || classes < 0 || classes > 4 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "密码字符类别数需在 0 到 4 之间！",
+			})
+			return
+		}
+	case "LoginMaxFailures", "LoginIPMaxFailures", "LoginFailureWindowSeconds", "LoginLockoutSeconds", "PasswordHistoryCount":
+		if number, err := strconv.Atoi(option.Value); err != nil || number < 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "请输入非负整数！",
+			})
+			return
+		}
+	case "GroupPromotionRules":
+		var rules []common.GroupPromotionRule
+		if err := json.Unmarshal([]byte(option.Value), &rules); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "自动升级规则格式错误！",
+			})
+			return
+		}
+		for _, rule := range rules {
+			if _, ok := common.GroupRatio[rule.Group]; !ok {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "分组 " + rule.Group + " 不存在！",
+				})
+				return
+			}
+		}
 	case "EmailDomainRestrictionEnabled":
 		if option.Value == "true" && len(common.EmailDomainWhitelist) == 0 {
 			c.JSON(http.StatusOK, gin.H{
@@ -74,7 +137,24 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "ModerationAction":
+		if option.Value != constant.ModerationActionBlock && option.Value != constant.ModerationActionFlag {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "审核处理方式只能为 block 或 flag！",
+			})
+			return
+		}
+	case "PIIRedactionMode":
+		if option.Value != constant.PIIRedactionModeMask && option.Value != constant.PIIRedactionModeReject && option.Value != constant.PIIRedactionModePlaceholder {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "脱敏方式只能为 mask、reject 或 placeholder！",
+			})
+			return
+		}
 	}
+	before := getOptionValue(option.Key)
 	err = model.UpdateOption(option.Key, option.Value)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -83,9 +163,25 @@ func UpdateOption(c *gin.Context) {
 		})
 		return
 	}
+	recordOptionAudit(c, option.Key, before, option.Value)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
 	})
 	return
 }
+
+func getOptionValue(key string) string {
+	common.OptionMapRWMutex.RLock()
+	defer common.OptionMapRWMutex.RUnlock()
+	return common.Interface2String(common.OptionMap[key])
+}
+
+// recordOptionAudit 记录系统设置的修改，分组倍率相关的设置记为分组操作
+func recordOptionAudit(c *gin.Context, key string, before string, after string) {
+	targetType := model.AuditTargetOption
+	if key == "GroupRatio" || key == "TopupGroupRatio" {
+		targetType = model.AuditTargetGroup
+	}
+	recordAudit(c, "option.update", targetType, key, map[string]string{key: before}, map[string]string{key: after})
+}
@@ -0,0 +1,519 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOrganizationForMember 读取路径中的组织，并校验当前用户在组织中的角色不低于 role
+func getOrganizationForMember(c *gin.Context, role string) (*model.Organization, *model.OrganizationMember, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, nil, errors.New("无效的组织 ID")
+	}
+	org, err := model.GetOrganizationById(id)
+	if err != nil {
+		return nil, nil, errors.New("组织不存在")
+	}
+	member, err := model.GetOrganizationMember(org.Id, c.GetInt("id"))
+	if err != nil {
+		return nil, nil, errors.New("您不是该组织的成员")
+	}
+	if !member.HasRole(role) {
+		return nil, nil, errors.New("无权进行此操作")
+	}
+	return org, member, nil
+}
+
+func CreateOrganization(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称不能为空",
+		})
+		return
+	}
+	if len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称过长",
+		})
+		return
+	}
+	org, err := model.CreateOrganization(req.Name, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    org,
+	})
+}
+
+func GetSelfOrganizations(c *gin.Context) {
+	orgs, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    orgs,
+	})
+}
+
+func GetOrganization(c *gin.Context) {
+	org, member, err := getOrganizationForMember(c, model.OrgRoleMember)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": &model.UserOrganization{
+			Organization: *org,
+			Role:         member.Role,
+			SpendLimit:   member.SpendLimit,
+			MemberUsed:   member.UsedQuota,
+		},
+	})
+}
+
+// UpdateOrganization 组织管理员修改组织名称，分组与状态只能由系统管理员修改
+func UpdateOrganization(c *gin.Context) {
+	org, _, err := getOrganizationForMember(c, model.OrgRoleAdmin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err = c.ShouldBindJSON(&req); err != nil || req.Name == "" || len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的组织名称",
+		})
+		return
+	}
+	org.Name = req.Name
+	if err = org.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    org,
+	})
+}
+
+func DeleteOrganization(c *gin.Context) {
+	org, _, err := getOrganizationForMember(c, model.OrgRoleOwner)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if org.Quota > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织仍有剩余额度，无法删除",
+		})
+		return
+	}
+	if err = org.Delete(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	org, _, err := getOrganizationForMember(c, model.OrgRoleMember)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	members, err := model.GetOrganizationMembers(org.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    members,
+	})
+}
+
+// AddOrganizationMember 按用户名添加成员，只有所有者可以添加管理员
+func AddOrganizationMember(c *gin.Context) {
+	org, operator, err := getOrganizationForMember(c, model.OrgRoleAdmin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var req struct {
+		Username   string `json:"username"`
+		Role       string `json:"role"`
+		SpendLimit int    `json:"spend_limit"`
+	}
+	if err = c.ShouldBindJSON(&req); err != nil || req.Username == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrgRoleMember
+	}
+	if req.Role == model.OrgRoleAdmin && !operator.HasRole(model.OrgRoleOwner) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有组织所有者可以添加管理员",
+		})
+		return
+	}
+	if req.SpendLimit < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "消费上限不能为负数",
+		})
+		return
+	}
+	user := model.User{Username: req.Username}
+	_ = user.FillUserByUsername()
+	if user.Id == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "用户不存在",
+		})
+		return
+	}
+	member, err := model.AddOrganizationMember(org.Id, user.Id, req.Role, req.SpendLimit)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	member.Username = user.Username
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    member,
+	})
+}
+
+// UpdateOrganizationMember 修改成员角色与消费上限，所有者不可被修改，只有所有者可以变更管理员
+func UpdateOrganizationMember(c *gin.Context) {
+	org, operator, err := getOrganizationForMember(c, model.OrgRoleAdmin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	member, err := model.GetOrganizationMember(org.Id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "成员不存在",
+		})
+		return
+	}
+	var req struct {
+		Role       string `json:"role"`
+		SpendLimit int    `json:"spend_limit"`
+	}
+	if err = c.ShouldBindJSON(&req); err != nil || req.SpendLimit < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Role == "" {
+		req.Role = member.Role
+	}
+	if member.Role == model.OrgRoleOwner || req.Role == model.OrgRoleOwner || !model.IsValidOrgRole(req.Role) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无法修改组织所有者或设置无效的角色",
+		})
+		return
+	}
+	if (member.Role == model.OrgRoleAdmin || req.Role == model.OrgRoleAdmin) && !operator.HasRole(model.OrgRoleOwner) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有组织所有者可以变更管理员",
+		})
+		return
+	}
+	member.Role = req.Role
+	member.SpendLimit = req.SpendLimit
+	if err = member.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    member,
+	})
+}
+
+// RemoveOrganizationMember 移除成员，成员也可以主动退出，所有者不可被移除
+func RemoveOrganizationMember(c *gin.Context) {
+	org, operator, err := getOrganizationForMember(c, model.OrgRoleMember)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	member, err := model.GetOrganizationMember(org.Id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "成员不存在",
+		})
+		return
+	}
+	allowed := member.UserId == operator.UserId ||
+		(operator.HasRole(model.OrgRoleAdmin) && member.Role == model.OrgRoleMember) ||
+		operator.HasRole(model.OrgRoleOwner)
+	if member.Role == model.OrgRoleOwner || !allowed {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权移除该成员",
+		})
+		return
+	}
+	if err = member.Delete(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// TransferQuotaToOrganization 成员将自己的额度转入组织额度池
+func TransferQuotaToOrganization(c *gin.Context) {
+	org, _, err := getOrganizationForMember(c, model.OrgRoleMember)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var req struct {
+		Quota int `json:"quota"`
+	}
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	userId := c.GetInt("id")
+	if err = model.TransferQuotaToOrganization(org.Id, userId, req.Quota); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	_ = model.CacheUpdateUserQuota(userId)
+	model.RecordLog(userId, model.LogTypeManage, "向组织 "+org.Name+" 转入额度 "+common.LogQuota(req.Quota))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetOrganizationLogs 组织管理员可查看全部成员的日志，普通成员只能查看自己的日志
+func GetOrganizationLogs(c *gin.Context) {
+	org, member, err := getOrganizationForMember(c, model.OrgRoleMember)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId := 0
+	if !member.HasRole(model.OrgRoleAdmin) {
+		userId = member.UserId
+	} else if c.Query("user_id") != "" {
+		userId, _ = strconv.Atoi(c.Query("user_id"))
+	}
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	logs, err := model.GetOrganizationLogs(org.Id, userId, logType, startTimestamp, endTimestamp, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    logs,
+	})
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	orgs, err := model.GetAllOrganizations(c.Query("keyword"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    orgs,
+	})
+}
+
+// AdminUpdateOrganization 系统管理员修改组织的分组、状态与额度
+func AdminUpdateOrganization(c *gin.Context) {
+	var req struct {
+		Id     int    `json:"id"`
+		Name   string `json:"name"`
+		Group  string `json:"group"`
+		Status int    `json:"status"`
+		Quota  *int   `json:"quota"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	org, err := model.GetOrganizationById(req.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织不存在",
+		})
+		return
+	}
+	if req.Group != "" {
+		if _, ok := common.GroupRatio[req.Group]; !ok {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "分组不存在",
+			})
+			return
+		}
+	}
+	before := *org
+	if req.Name != "" {
+		org.Name = req.Name
+	}
+	org.Group = req.Group
+	if req.Status == model.OrgStatusEnabled || req.Status == model.OrgStatusDisabled {
+		org.Status = req.Status
+	}
+	if err = org.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Quota != nil && *req.Quota != org.Quota {
+		if err = model.AdjustOrganizationQuota(org.Id, *req.Quota); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		model.RecordLog(c.GetInt("id"), model.LogTypeManage, "管理员将组织 "+org.Name+" 的额度从 "+common.LogQuota(org.Quota)+" 修改为 "+common.LogQuota(*req.Quota))
+		org.Quota = *req.Quota
+	}
+	recordAudit(c, "organization.update", model.AuditTargetOrganization, org.Id, before, org)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    org,
+	})
+}
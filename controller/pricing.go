@@ -24,6 +24,7 @@ func GetPricing(c *gin.Context) {
 
 func ResetModelRatio(c *gin.Context) {
 	defaultStr := common.DefaultModelRatio2JSONString()
+	before := getOptionValue("ModelRatio")
 	err := model.UpdateOption("ModelRatio", defaultStr)
 	if err != nil {
 		c.JSON(200, gin.H{
@@ -40,6 +41,7 @@ func ResetModelRatio(c *gin.Context) {
 		})
 		return
 	}
+	recordOptionAudit(c, "ModelRatio", before, defaultStr)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "重置模型倍率成功",
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
@@ -8,6 +9,45 @@ import (
 	"strconv"
 )
 
+const (
+	redemptionPrefixMaxLength = 16
+	redemptionMaxGroupDays    = 3650
+	redemptionMaxUses         = 100000
+)
+
+// validateRedemptionRule 校验兑换码的前缀、额度、分组、使用次数与有效期设置
+func validateRedemptionRule(prefix string, quota int, group string, groupDays int, maxUses int, expiredTime int64) error {
+	if len(prefix) > redemptionPrefixMaxLength {
+		return errors.New("兑换码前缀长度不能超过 16")
+	}
+	for _, r := range prefix {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return errors.New("兑换码前缀只能包含字母、数字、- 和 _")
+		}
+	}
+	if quota < 0 {
+		return errors.New("额度不能为负数")
+	}
+	if quota == 0 && group == "" {
+		return errors.New("兑换码需要包含额度或分组")
+	}
+	if group != "" {
+		if _, ok := common.GroupRatio[group]; !ok {
+			return errors.New("分组不存在")
+		}
+	}
+	if groupDays < 0 || groupDays > redemptionMaxGroupDays {
+		return errors.New("分组有效天数必须在 0-3650 之间")
+	}
+	if maxUses < 1 || maxUses > redemptionMaxUses {
+		return errors.New("兑换次数必须在 1-100000 之间")
+	}
+	if expiredTime != 0 && expiredTime < common.GetTimestamp() {
+		return errors.New("过期时间不能早于当前时间")
+	}
+	return nil
+}
+
 func GetAllRedemptions(c *gin.Context) {
 	p, _ := strconv.Atoi(c.Query("p"))
 	if p < 0 {
@@ -103,15 +143,30 @@ func AddRedemption(c *gin.Context) {
 		})
 		return
 	}
+	if redemption.MaxUses == 0 {
+		redemption.MaxUses = 1
+	}
+	err = validateRedemptionRule(redemption.Prefix, redemption.Quota, redemption.Group, redemption.GroupDays, redemption.MaxUses, redemption.ExpiredTime)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	var keys []string
 	for i := 0; i < redemption.Count; i++ {
-		key := common.GetUUID()
+		key := model.GenerateRedemptionKey(redemption.Prefix)
 		cleanRedemption := model.Redemption{
 			UserId:      c.GetInt("id"),
 			Name:        redemption.Name,
 			Key:         key,
 			CreatedTime: common.GetTimestamp(),
 			Quota:       redemption.Quota,
+			ExpiredTime: redemption.ExpiredTime,
+			MaxUses:     redemption.MaxUses,
+			Group:       redemption.Group,
+			GroupDays:   redemption.GroupDays,
 		}
 		err = cleanRedemption.Insert()
 		if err != nil {
@@ -122,6 +177,7 @@ func AddRedemption(c *gin.Context) {
 			})
 			return
 		}
+		recordAudit(c, "redemption.create", model.AuditTargetRedemption, cleanRedemption.Id, nil, cleanRedemption)
 		keys = append(keys, key)
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -134,6 +190,7 @@ func AddRedemption(c *gin.Context) {
 
 func DeleteRedemption(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	before, _ := model.GetRedemptionById(id)
 	err := model.DeleteRedemptionById(id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -142,6 +199,7 @@ func DeleteRedemption(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, "redemption.delete", model.AuditTargetRedemption, id, before, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -168,12 +226,42 @@ func UpdateRedemption(c *gin.Context) {
 		})
 		return
 	}
+	before := *cleanRedemption
 	if statusOnly != "" {
 		cleanRedemption.Status = redemption.Status
 	} else {
+		if redemption.MaxUses == 0 {
+			redemption.MaxUses = 1
+		}
+		// 有效期未变化时允许保留已过期的时间
+		expiredTime := redemption.ExpiredTime
+		if expiredTime == cleanRedemption.ExpiredTime {
+			expiredTime = 0
+		}
+		err = validateRedemptionRule("", redemption.Quota, redemption.Group, redemption.GroupDays, redemption.MaxUses, expiredTime)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		maxUsesChanged := cleanRedemption.MaxUses != redemption.MaxUses
 		// If you add more fields, please also update redemption.Update()
 		cleanRedemption.Name = redemption.Name
 		cleanRedemption.Quota = redemption.Quota
+		cleanRedemption.ExpiredTime = redemption.ExpiredTime
+		cleanRedemption.MaxUses = redemption.MaxUses
+		cleanRedemption.Group = redemption.Group
+		cleanRedemption.GroupDays = redemption.GroupDays
+		// 调整兑换次数后同步兑换码是否已用完
+		if maxUsesChanged {
+			if cleanRedemption.Status == common.RedemptionCodeStatusUsed && cleanRedemption.UsedCount < cleanRedemption.MaxUses {
+				cleanRedemption.Status = common.RedemptionCodeStatusEnabled
+			} else if cleanRedemption.Status == common.RedemptionCodeStatusEnabled && cleanRedemption.UsedCount >= cleanRedemption.MaxUses {
+				cleanRedemption.Status = common.RedemptionCodeStatusUsed
+			}
+		}
 	}
 	err = cleanRedemption.Update()
 	if err != nil {
@@ -183,6 +271,7 @@ func UpdateRedemption(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, "redemption.update", model.AuditTargetRedemption, cleanRedemption.Id, before, cleanRedemption)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redemptionCampaignMaxCount 单次为活动生成兑换码的最大数量
+const redemptionCampaignMaxCount = 1000
+
+func validateRedemptionCampaignCount(count int) string {
+	if count <= 0 {
+		return "兑换码个数必须大于0"
+	}
+	if count > redemptionCampaignMaxCount {
+		return "一次兑换码批量生成的个数不能大于 1000"
+	}
+	return ""
+}
+
+func GetRedemptionCampaigns(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	campaigns, total, err := model.GetRedemptionCampaigns(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    campaigns,
+		"total":   total,
+	})
+}
+
+// GetRedemptionCampaign 返回活动详情与兑换统计
+func GetRedemptionCampaign(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	campaign, err := model.GetRedemptionCampaignById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	stats, err := model.GetRedemptionCampaignStats(campaign.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"campaign": campaign,
+			"stats":    stats,
+		},
+	})
+}
+
+func AddRedemptionCampaign(c *gin.Context) {
+	campaign := model.RedemptionCampaign{}
+	err := c.ShouldBindJSON(&campaign)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(campaign.Name) == 0 || len(campaign.Name) > 20 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "兑换码名称长度必须在1-20之间",
+		})
+		return
+	}
+	if message := validateRedemptionCampaignCount(campaign.Count); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	if campaign.MaxUses == 0 {
+		campaign.MaxUses = 1
+	}
+	err = validateRedemptionRule(campaign.Prefix, campaign.Quota, campaign.Group, campaign.GroupDays, campaign.MaxUses, campaign.ExpiredTime)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanCampaign := model.RedemptionCampaign{
+		Name:        campaign.Name,
+		Prefix:      campaign.Prefix,
+		Quota:       campaign.Quota,
+		Group:       campaign.Group,
+		GroupDays:   campaign.GroupDays,
+		MaxUses:     campaign.MaxUses,
+		OnePerUser:  campaign.OnePerUser,
+		ExpiredTime: campaign.ExpiredTime,
+		Status:      common.RedemptionCodeStatusEnabled,
+		CreatedBy:   c.GetInt("id"),
+		CreatedTime: common.GetTimestamp(),
+	}
+	keys, err := cleanCampaign.Insert(campaign.Count)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "redemption_campaign.create", model.AuditTargetCampaign, cleanCampaign.Id, nil, gin.H{
+		"campaign": cleanCampaign,
+		"count":    len(keys),
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"campaign": cleanCampaign,
+			"keys":     keys,
+		},
+	})
+}
+
+// GenerateRedemptionCampaignCodes 为已有活动追加生成兑换码
+func GenerateRedemptionCampaignCodes(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	req := struct {
+		Count int `json:"count"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if message := validateRedemptionCampaignCount(req.Count); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	campaign, err := model.GetRedemptionCampaignById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	keys, err := campaign.GenerateCodes(req.Count)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "redemption_campaign.generate", model.AuditTargetCampaign, campaign.Id, nil, gin.H{
+		"count": len(keys),
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    keys,
+	})
+}
+
+func UpdateRedemptionCampaign(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	campaign := model.RedemptionCampaign{}
+	err := c.ShouldBindJSON(&campaign)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanCampaign, err := model.GetRedemptionCampaignById(campaign.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	before := *cleanCampaign
+	if statusOnly != "" {
+		if campaign.Status != common.RedemptionCodeStatusEnabled && campaign.Status != common.RedemptionCodeStatusDisabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的状态",
+			})
+			return
+		}
+		cleanCampaign.Status = campaign.Status
+	} else {
+		if len(campaign.Name) == 0 || len(campaign.Name) > 20 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "兑换码名称长度必须在1-20之间",
+			})
+			return
+		}
+		if campaign.ExpiredTime != 0 && campaign.ExpiredTime != cleanCampaign.ExpiredTime && campaign.ExpiredTime < common.GetTimestamp() {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "过期时间不能早于当前时间",
+			})
+			return
+		}
+		// 额度、分组与兑换次数已复制到兑换码，创建后不再修改
+		cleanCampaign.Name = campaign.Name
+		cleanCampaign.OnePerUser = campaign.OnePerUser
+		cleanCampaign.ExpiredTime = campaign.ExpiredTime
+	}
+	if err = cleanCampaign.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "redemption_campaign.update", model.AuditTargetCampaign, cleanCampaign.Id, before, cleanCampaign)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanCampaign,
+	})
+}
+
+func DeleteRedemptionCampaign(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	before, err := model.GetRedemptionCampaignById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = model.DeleteRedemptionCampaignById(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "redemption_campaign.delete", model.AuditTargetCampaign, id, before, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+var redemptionExportHeader = []string{"id", "key", "name", "status", "quota", "group", "group_days", "max_uses", "used_count", "expired_time", "created_time", "redeemed_time", "used_user_id"}
+
+// ExportRedemptionCampaign 导出活动内的全部兑换码
+func ExportRedemptionCampaign(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	campaign, err := model.GetRedemptionCampaignById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writer, ok := newExportWriter(c, "redemptions-"+strconv.Itoa(campaign.Id), redemptionExportHeader)
+	if !ok {
+		return
+	}
+	recordAudit(c, "redemption_campaign.export", model.AuditTargetCampaign, campaign.Id, nil, nil)
+	err = model.IterateCampaignRedemptions(campaign.Id, exportBatchSize, func(redemptions []*model.Redemption) error {
+		for _, redemption := range redemptions {
+			row := []string{
+				strconv.Itoa(redemption.Id),
+				redemption.Key,
+				redemption.Name,
+				strconv.Itoa(redemption.Status),
+				strconv.Itoa(redemption.Quota),
+				redemption.Group,
+				strconv.Itoa(redemption.GroupDays),
+				strconv.Itoa(redemption.MaxUses),
+				strconv.Itoa(redemption.UsedCount),
+				strconv.FormatInt(redemption.ExpiredTime, 10),
+				strconv.FormatInt(redemption.CreatedTime, 10),
+				strconv.FormatInt(redemption.RedeemedTime, 10),
+				strconv.Itoa(redemption.UsedUserId),
+			}
+			if err := writer.write(redemption, row); err != nil {
+				return err
+			}
+		}
+		return writer.flush()
+	})
+	if err != nil {
+		common.SysError("failed to export redemption campaign: " + err.Error())
+		return
+	}
+	_ = writer.flush()
+}
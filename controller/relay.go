@@ -16,6 +16,7 @@ import (
 	relayconstant "one-api/relay/constant"
 	"one-api/service"
 	"strings"
+	"time"
 )
 
 func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
@@ -42,9 +43,11 @@ func Relay(c *gin.Context) {
 	channelId := c.GetInt("channel_id")
 	group := c.GetString("group")
 	originalModel := c.GetString("original_model")
+	startTime := time.Now()
 	openaiErr := relayHandler(c, relayMode)
 	c.Set("use_channel", []string{fmt.Sprintf("%d", channelId)})
 	if openaiErr != nil {
+		service.RecordChannelError(c, channelId, startTime, openaiErr)
 		go processChannelError(c, channelId, openaiErr)
 	} else {
 		retryTimes = 0
@@ -64,8 +67,11 @@ func Relay(c *gin.Context) {
 
 		requestBody, err := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		c.Set("relay_attempt", i+1)
+		startTime = time.Now()
 		openaiErr = relayHandler(c, relayMode)
 		if openaiErr != nil {
+			service.RecordChannelError(c, channelId, startTime, openaiErr)
 			go processChannelError(c, channelId, openaiErr)
 		}
 	}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isResellerOperator 当前操作者是否只能访问自己子树中的用户，拥有 user.read.all 权限时不受限制
+func isResellerOperator(c *gin.Context) bool {
+	return !hasPermission(c, common.PermissionUserReadAll)
+}
+
+// resellerCanManage 代理只能管理自己子树中权限低于管理员的用户
+func resellerCanManage(c *gin.Context, user *model.User) bool {
+	return user.Role < common.RoleAdminUser && model.IsUserInSubtree(c.GetInt("id"), user.Id)
+}
+
+// getResellerScope 返回操作者可见的用户 ID，返回 nil 表示不限制
+func getResellerScope(c *gin.Context) ([]int, error) {
+	if !isResellerOperator(c) {
+		return nil, nil
+	}
+	return model.GetSubtreeUserIds(c.GetInt("id"))
+}
+
+// clearResellerLogs 代理查看下级日志时隐藏渠道等管理员信息
+func clearResellerLogs(logs []*model.Log) {
+	model.ClearLogAdminInfo(logs)
+	for _, log := range logs {
+		log.ChannelId = 0
+	}
+}
+
+// getTokenOwnerId 返回令牌接口操作的用户，管理员与代理可以通过 user_id 参数管理下级用户的令牌。
+// write 为 true 时为修改或删除操作，代他人操作需要 user.write 权限，user.read.all 只允许查看
+func getTokenOwnerId(c *gin.Context, write bool) (int, error) {
+	userId := c.GetInt("id")
+	if c.Query("user_id") == "" {
+		return userId, nil
+	}
+	targetId, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		return 0, errors.New("无效的用户 ID")
+	}
+	if targetId == userId {
+		return userId, nil
+	}
+	if write && !hasPermission(c, common.PermissionUserWrite) {
+		return 0, errors.New("无权修改其他用户的令牌")
+	}
+	role := c.GetInt("role")
+	if !isResellerOperator(c) {
+		target, err := model.GetUserById(targetId, false)
+		if err != nil {
+			return 0, err
+		}
+		if role <= target.Role && role != common.RoleRootUser {
+			return 0, errors.New("无权管理同级或更高等级用户的令牌")
+		}
+		return targetId, nil
+	}
+	if !hasPermission(c, common.PermissionUserWrite) || !model.IsUserInSubtree(userId, targetId) {
+		return 0, errors.New("无权管理非下级用户的令牌")
+	}
+	return targetId, nil
+}
+
+type AllocateQuotaRequest struct {
+	UserId int `json:"user_id"`
+	Quota  int `json:"quota"`
+}
+
+// AllocateQuota 代理从自己的余额向下级分配额度，quota 为负时收回
+func AllocateQuota(c *gin.Context) {
+	var req AllocateQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserId == 0 || req.Quota == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	resellerId := c.GetInt("id")
+	if !model.IsUserInSubtree(resellerId, req.UserId) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只能向自己的下级用户分配额度",
+		})
+		return
+	}
+	if err := model.AllocateQuotaToChild(resellerId, req.UserId, req.Quota); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "user.allocate_quota", model.AuditTargetUser, req.UserId, nil, gin.H{"quota": req.Quota})
+	if req.Quota > 0 {
+		model.RecordLog(req.UserId, model.LogTypeManage, fmt.Sprintf("上级代理分配额度 %s", common.LogQuota(req.Quota)))
+	} else {
+		model.RecordLog(req.UserId, model.LogTypeManage, fmt.Sprintf("上级代理收回额度 %s", common.LogQuota(-req.Quota)))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+type UpdateMarkupRatioRequest struct {
+	MarkupRatio float64 `json:"markup_ratio"`
+}
+
+// UpdateMarkupRatio 代理设置对下级用户的加价倍率，在分组倍率的基础上生效
+func UpdateMarkupRatio(c *gin.Context) {
+	var req UpdateMarkupRatioRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	before, _ := model.GetUserById(c.GetInt("id"), false)
+	if err := model.UpdateUserMarkupRatio(c.GetInt("id"), req.MarkupRatio); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if before != nil {
+		recordAudit(c, "user.markup_ratio", model.AuditTargetUser, before.Id, gin.H{"markup_ratio": before.MarkupRatio}, gin.H{"markup_ratio": req.MarkupRatio})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hasPermission 判断当前用户是否拥有权限，通过管理令牌访问时还需令牌作用域包含该权限
+func hasPermission(c *gin.Context, permission string) bool {
+	permissions, ok := c.Get("permissions")
+	if !ok {
+		var err error
+		permissions, err = model.GetUserPermissions(c.GetInt("id"), c.GetInt("role"))
+		if err != nil {
+			return false
+		}
+	}
+	if !common.HasPermission(permissions.([]string), permission) {
+		return false
+	}
+	if scopes, ok := c.Get("access_token_scopes"); ok {
+		return common.HasPermission(scopes.([]string), permission)
+	}
+	return true
+}
+
+// checkGrantablePermissions 非超级管理员只能授予自己拥有的权限
+func checkGrantablePermissions(c *gin.Context, permissions []string) error {
+	for _, permission := range permissions {
+		if !hasPermission(c, permission) {
+			return fmt.Errorf("无法授予自己不具备的权限：%s", permission)
+		}
+	}
+	return nil
+}
+
+func GetAllRoles(c *gin.Context) {
+	roles, err := model.GetAllRoles()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    roles,
+	})
+}
+
+func GetAllPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    common.AllPermissions,
+	})
+}
+
+type RoleRequest struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func AddRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" || len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	role := &model.Role{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	err := checkGrantablePermissions(c, req.Permissions)
+	if err == nil {
+		err = role.SetPermissions(req.Permissions)
+	}
+	if err == nil {
+		err = role.Insert()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "role.create", model.AuditTargetRole, role.Id, nil, role)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    role,
+	})
+}
+
+func UpdateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id == 0 || req.Name == "" || len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	role, err := model.GetRoleById(req.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "角色不存在",
+		})
+		return
+	}
+	before := *role
+	role.Name = req.Name
+	role.Description = req.Description
+	err = checkGrantablePermissions(c, req.Permissions)
+	if err == nil {
+		err = role.SetPermissions(req.Permissions)
+	}
+	if err == nil {
+		err = role.Update()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "role.update", model.AuditTargetRole, role.Id, before, role)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    role,
+	})
+}
+
+func DeleteRole(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	role, err := model.GetRoleById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "角色不存在",
+		})
+		return
+	}
+	if err = role.Delete(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "role.delete", model.AuditTargetRole, role.Id, role, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+type UpdateUserRoleRequest struct {
+	UserId int `json:"user_id"`
+	RoleId int `json:"role_id"`
+}
+
+// UpdateUserRole 为用户分配角色，角色决定用户拥有哪些权限，权限等级仍决定可以管理哪些用户
+func UpdateUserRole(c *gin.Context) {
+	var req UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.UserId == c.GetInt("id") {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无法修改自己的角色",
+		})
+		return
+	}
+	user, err := model.GetUserById(req.UserId, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	myRole := c.GetInt("role")
+	if myRole <= user.Role && myRole != common.RoleRootUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权修改同权限等级或更高权限等级用户的角色",
+		})
+		return
+	}
+	if req.RoleId != 0 {
+		role, err := model.GetRoleById(req.RoleId)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "角色不存在",
+			})
+			return
+		}
+		if err = checkGrantablePermissions(c, role.GetPermissions()); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	if err = model.UpdateUserRoleId(user.Id, req.RoleId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "user.role", model.AuditTargetUser, user.Id, gin.H{"role_id": user.RoleId}, gin.H{"role_id": req.RoleId})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginMethodNames 按登录接口路径区分登录方式，用于安全日志
+var loginMethodNames = map[string]string{
+	"/api/user/login":           "密码",
+	"/api/user/login/2fa":       "密码 + 两步验证",
+	"/api/user/login/ldap":      "LDAP",
+	"/api/oauth/github":         "GitHub",
+	"/api/oauth/oidc":           "OIDC",
+	"/api/oauth/wechat":         "微信",
+	"/api/oauth/telegram/login": "Telegram",
+}
+
+func recordSecurityLog(c *gin.Context, userId int, content string) {
+	model.RecordSecurityLog(userId, c.ClientIP(), c.Request.UserAgent(), content)
+}
+
+func recordLoginSuccess(c *gin.Context, user *model.User) {
+	model.ClearLoginFailures(user.Username)
+	method, ok := loginMethodNames[c.FullPath()]
+	if !ok {
+		method = c.FullPath()
+	}
+	recordSecurityLog(c, user.Id, "登录成功，登录方式："+method)
+}
+
+// checkLoginAllowed 账户或 IP 处于锁定或重试等待期间时拒绝登录
+func checkLoginAllowed(c *gin.Context, username string) bool {
+	wait := model.CheckLoginAllowed(username, c.ClientIP())
+	if wait <= 0 {
+		return true
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": fmt.Sprintf("登录失败次数过多，请 %d 秒后再试", wait),
+	})
+	return false
+}
+
+// recordLoginFailure 记录登录失败，userId 不为 0 时同时写入该用户的安全日志
+func recordLoginFailure(c *gin.Context, username string, userId int, reason string) {
+	locked := model.RecordLoginFailure(username, c.ClientIP())
+	if userId == 0 {
+		return
+	}
+	recordSecurityLog(c, userId, "登录失败："+reason)
+	if locked {
+		recordSecurityLog(c, userId, fmt.Sprintf("连续登录失败，账户已被锁定 %d 秒", common.LoginLockoutSeconds))
+	}
+}
+
+// checkPasswordPolicy 校验密码策略与历史密码，userId 为 0 表示新用户
+func checkPasswordPolicy(userId int, username string, password string) error {
+	if err := common.CheckPasswordPolicy(password, username); err != nil {
+		return err
+	}
+	return model.CheckPasswordReuse(userId, password)
+}
+
+// GetLoginLockouts 列出当前被锁定的账户与 IP
+func GetLoginLockouts(c *gin.Context) {
+	if isResellerOperator(c) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权进行此操作",
+		})
+		return
+	}
+	failures, err := model.GetLockedLoginFailures()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    failures,
+	})
+}
+
+// UnlockLogin 管理员解除账户或 IP 的登录锁定，key 为 user:<用户名> 或 ip:<IP>
+func UnlockLogin(c *gin.Context) {
+	key := c.Query("key")
+	if isResellerOperator(c) || !(strings.HasPrefix(key, "user:") || strings.HasPrefix(key, "ip:")) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if err := model.UnlockLogin(key); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "user.unlock_login", model.AuditTargetUser, key, nil, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+type SessionResponse struct {
+	*model.UserSession
+	Current bool `json:"current"`
+}
+
+func currentSessionId(c *gin.Context) string {
+	sessionId, _ := sessions.Default(c).Get(common.SessionIdKey).(string)
+	return sessionId
+}
+
+// sessionUserId 返回当前登录会话的用户 ID，会话已被撤销或过期时返回 false
+func sessionUserId(c *gin.Context) (int, bool) {
+	id, ok := sessions.Default(c).Get("id").(int)
+	if !ok || !model.ValidateUserSession(currentSessionId(c), id) {
+		return 0, false
+	}
+	return id, true
+}
+
+// GetSessions 列出当前用户所有有效的登录会话，并标记当前会话
+func GetSessions(c *gin.Context) {
+	userSessions, err := model.GetUserSessions(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	current := currentSessionId(c)
+	data := make([]SessionResponse, 0, len(userSessions))
+	for _, userSession := range userSessions {
+		data = append(data, SessionResponse{
+			UserSession: userSession,
+			Current:     userSession.Id == current,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+func RevokeSession(c *gin.Context) {
+	found, err := model.RevokeUserSession(c.GetInt("id"), c.Param("id"))
+	if err == nil && !found {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "会话不存在",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// RevokeOtherSessions 退出当前会话以外的所有登录
+func RevokeOtherSessions(c *gin.Context) {
+	if err := model.RevokeUserSessions(c.GetInt("id"), currentSessionId(c)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listStatements(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	statements, err := model.GetStatements(userId, c.Query("period"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    statements,
+	})
+}
+
+// writeStatement 按 format 参数输出 CSV、HTML 或 JSON 格式的账单
+func writeStatement(c *gin.Context, statement *model.Statement) {
+	filename := fmt.Sprintf("statement-%d-%s", statement.UserId, statement.Period)
+	var err error
+	switch c.Query("format") {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", filename))
+		err = service.WriteStatementCSV(c.Writer, statement)
+	case "html":
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		err = service.WriteStatementHTML(c.Writer, statement)
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data": gin.H{
+				"statement": statement,
+				"items":     statement.GetItems(),
+				"company":   statement.GetCompany(),
+			},
+		})
+		return
+	}
+	if err != nil {
+		common.SysError("failed to write statement: " + err.Error())
+	}
+}
+
+func getStatement(c *gin.Context, userId int) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	statement, err := model.GetStatementById(id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writeStatement(c, statement)
+}
+
+func GetUserStatements(c *gin.Context) {
+	listStatements(c, c.GetInt("id"))
+}
+
+func GetUserStatement(c *gin.Context) {
+	getStatement(c, c.GetInt("id"))
+}
+
+// PreviewUserStatement 预览尚未结束周期的账单，不保存
+func PreviewUserStatement(c *gin.Context) {
+	period := c.Query("period")
+	if period == "" {
+		period = time.Now().Format(model.StatementPeriodLayout)
+	}
+	statement, err := model.BuildStatement(c.GetInt("id"), period)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writeStatement(c, statement)
+}
+
+func GetAllStatements(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	listStatements(c, userId)
+}
+
+func GetStatement(c *gin.Context) {
+	getStatement(c, 0)
+}
+
+type GenerateStatementRequest struct {
+	Period string `json:"period"`
+	UserId int    `json:"user_id"`
+	Email  bool   `json:"email"`
+}
+
+// GenerateStatements 手动生成账单，未指定用户时为周期内所有有变动的用户生成
+func GenerateStatements(c *gin.Context) {
+	var req GenerateStatementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Period == "" {
+		req.Period = model.LastStatementPeriod()
+	}
+	userIds := []int{req.UserId}
+	if req.UserId == 0 {
+		var err error
+		userIds, err = model.GetStatementUserIds(req.Period)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	count, err := generateStatements(req.Period, userIds, false, req.Email)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+			"data":    count,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    count,
+	})
+}
+
+// generateStatements 逐个生成账单，单个用户失败时记录错误并继续，最后返回汇总的错误
+func generateStatements(period string, userIds []int, skipExisting bool, sendEmail bool) (int, error) {
+	count := 0
+	var failedUserIds []string
+	for _, userId := range userIds {
+		if skipExisting && model.IsStatementGenerated(userId, period) {
+			continue
+		}
+		statement, err := model.GenerateStatement(userId, period)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to generate statement for user %d: %s", userId, err.Error()))
+			failedUserIds = append(failedUserIds, strconv.Itoa(userId))
+			continue
+		}
+		count++
+		if sendEmail && statement.EmailedAt == 0 {
+			if err = service.SendStatementEmail(statement); err != nil {
+				common.SysError(fmt.Sprintf("failed to send statement email to user %d: %s", userId, err.Error()))
+			}
+		}
+	}
+	if len(failedUserIds) > 0 {
+		return count, fmt.Errorf("failed to generate %s statements for %d users: %s", period, len(failedUserIds), strings.Join(failedUserIds, ","))
+	}
+	return count, nil
+}
+
+// AutomaticallyGenerateStatements 定时检查上一周期的账单是否已生成
+func AutomaticallyGenerateStatements() {
+	for {
+		if constant.StatementEnabled {
+			period := model.LastStatementPeriod()
+			userIds, err := model.GetStatementUserIds(period)
+			if err != nil {
+				common.SysError("failed to get statement users: " + err.Error())
+			} else {
+				count, err := generateStatements(period, userIds, true, constant.StatementEmailEnabled)
+				if err != nil {
+					common.SysError(err.Error())
+				}
+				if count > 0 {
+					common.SysLog(fmt.Sprintf("generated %d statements for %s", count, period))
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -61,13 +61,20 @@ func StripeWebhook(c *gin.Context) {
 				log.Printf("Stripe 回调更新订单失败: %v", topUp)
 				return
 			}
-			err = model.IncreaseUserQuota(topUp.UserId, topUp.Amount*int(common.QuotaPerUnit))
+			if topUp.PlanId != 0 {
+				fulfillSubscriptionOrder(topUp)
+				model.PromoteUserGroup(topUp.UserId)
+				break
+			}
+			err = model.IncreaseUserQuota(topUp.UserId, topUp.Amount*int(common.QuotaPerUnit), model.LedgerReasonTopUp, topUp.TradeNo)
 			if err != nil {
 				log.Printf("Stripe 回调更新用户失败: %v", topUp)
 				return
 			}
 			log.Printf("Stripe 回调更新用户成功 %v", topUp)
 			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用Stripe充值成功，充值金额: %v，支付金额：%f", common.LogQuota(topUp.Amount*int(common.QuotaPerUnit)), topUp.Money))
+			model.GrantAffCommission(topUp.UserId, topUp.Amount*int(common.QuotaPerUnit), model.AffCommissionSourceStripe, topUp.TradeNo)
+			model.PromoteUserGroup(topUp.UserId)
 		}
 	case "payment_intent.payment_failed":
 		log.Printf("支付失败: %v", event)
@@ -107,11 +114,13 @@ func IncreaseQuota(c *gin.Context) {
 		return
 	}
 
-	err = model.IncreaseUserQuota(userId, amount*int(common.QuotaPerUnit))
+	err = model.IncreaseUserQuota(userId, amount*int(common.QuotaPerUnit), model.LedgerReasonAdjust, strconv.Itoa(c.GetInt("id")))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	quota := amount * int(common.QuotaPerUnit)
+	recordAudit(c, "user.increase_quota", model.AuditTargetUser, userId, gin.H{"quota": users[0].Quota}, gin.H{"quota": users[0].Quota + quota})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Quota increased successfully"})
 }
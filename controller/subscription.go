@@ -0,0 +1,496 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// subscriptionMaxDays 套餐单次有效天数与重置周期的上限
+const subscriptionMaxDays = 3650
+
+// subscriptionRenewalOrderLead 余额不足以自动续费时，提前创建在线支付续费订单的时间
+const subscriptionRenewalOrderLead = 3 * 24 * 3600
+
+func validateSubscriptionPlan(plan *model.SubscriptionPlan) error {
+	if len(plan.Name) == 0 || len(plan.Name) > 64 {
+		return errors.New("套餐名称长度必须在1-64之间")
+	}
+	if len(plan.Description) > 255 {
+		return errors.New("套餐描述长度不能超过 255")
+	}
+	if plan.Price < 0 || plan.Quota < 0 {
+		return errors.New("价格与额度不能为负数")
+	}
+	if plan.DurationDays <= 0 || plan.DurationDays > subscriptionMaxDays {
+		return errors.New("有效天数必须在 1-3650 之间")
+	}
+	if plan.ResetDays < 0 || plan.ResetDays > plan.DurationDays {
+		return errors.New("重置周期不能超过有效天数")
+	}
+	if plan.Group != "" {
+		if _, ok := common.GroupRatio[plan.Group]; !ok {
+			return errors.New("分组不存在")
+		}
+	}
+	if plan.Status != model.SubscriptionPlanStatusEnabled && plan.Status != model.SubscriptionPlanStatusDisabled {
+		return errors.New("无效的状态")
+	}
+	return nil
+}
+
+// GetSubscriptionPlans 用户可购买的套餐
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+// GetSelfSubscription 返回当前用户生效中的订阅与当期剩余的套餐额度，没有订阅时 data 为空
+func GetSelfSubscription(c *gin.Context) {
+	subscription, err := model.GetActiveUserSubscription(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if subscription == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    nil,
+		})
+		return
+	}
+	plan, _ := model.GetSubscriptionPlanById(subscription.PlanId)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"subscription":      subscription,
+			"plan":              plan,
+			"plan_remain_quota": subscription.PlanRemainQuota(),
+		},
+	})
+}
+
+// UpdateSelfSubscription 开启或关闭自动续费，关闭后订阅在到期时结束
+func UpdateSelfSubscription(c *gin.Context) {
+	req := struct {
+		AutoRenew bool `json:"auto_renew"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if err := model.SetSubscriptionAutoRenew(c.GetInt("id"), req.AutoRenew); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+type subscriptionPurchaseRequest struct {
+	PlanId        int    `json:"plan_id"`
+	PaymentMethod string `json:"payment_method"` // balance 表示使用账户余额购买，其余为在线支付方式
+}
+
+// PurchaseSubscription 购买或续订套餐，使用余额时立即开通，在线支付时在支付回调中开通
+func PurchaseSubscription(c *gin.Context) {
+	var req subscriptionPurchaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	id := c.GetInt("id")
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || plan.Status != model.SubscriptionPlanStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "套餐不存在或已下架",
+		})
+		return
+	}
+	active, err := model.GetActiveUserSubscription(id)
+	if err == nil && active != nil && active.PlanId != plan.Id {
+		err = errors.New("已有其他生效中的订阅，请先取消当前订阅")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.PaymentMethod == "balance" {
+		cost := plan.BalanceQuota()
+		if plan.Price > 0 && cost <= 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "当前不支持使用余额购买",
+			})
+			return
+		}
+		subscription, err := model.Subscribe(id, plan.Id, cost, "plan:"+strconv.Itoa(plan.Id))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    subscription,
+		})
+		return
+	}
+	if plan.Price < 0.01 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "套餐价格过低，无法在线支付",
+		})
+		return
+	}
+	uri, params, tradeNo, err := requestEpayPurchase(req.PaymentMethod, plan.Price)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	topUp := &model.TopUp{
+		UserId:     id,
+		Money:      plan.Price,
+		TradeNo:    tradeNo,
+		CreateTime: time.Now().Unix(),
+		Status:     "pending",
+		PlanId:     plan.Id,
+	}
+	if err = topUp.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "创建订单失败",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    params,
+		"url":     uri,
+	})
+}
+
+// fulfillSubscriptionOrder 套餐订单支付成功后开通套餐。
+// 开通失败时按套餐的余额价格折算为额度充值到账户，避免用户付款后没有任何权益
+func fulfillSubscriptionOrder(topUp *model.TopUp) {
+	_, subscribeErr := model.Subscribe(topUp.UserId, topUp.PlanId, 0, topUp.TradeNo)
+	if subscribeErr == nil {
+		log.Printf("套餐订单开通成功 %v", topUp)
+		return
+	}
+	log.Printf("套餐订单开通失败: %v, %s", topUp, subscribeErr.Error())
+	plan, err := model.GetSubscriptionPlanById(topUp.PlanId)
+	if err != nil || plan.BalanceQuota() <= 0 {
+		model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("套餐订单 %s 开通失败：%s，请联系管理员", topUp.TradeNo, subscribeErr.Error()))
+		return
+	}
+	quota := plan.BalanceQuota()
+	if err = model.IncreaseUserQuota(topUp.UserId, quota, model.LedgerReasonTopUp, topUp.TradeNo); err != nil {
+		log.Printf("套餐订单折算充值失败: %v", topUp)
+		return
+	}
+	model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("套餐订单 %s 开通失败：%s，支付金额已折算为额度 %s", topUp.TradeNo, subscribeErr.Error(), common.LogQuota(quota)))
+}
+
+func GetAllSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func AddSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if plan.Status == 0 {
+		plan.Status = model.SubscriptionPlanStatusEnabled
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plan.Id = 0
+	plan.CreatedTime = common.GetTimestamp()
+	if err := plan.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "subscription.plan_create", model.AuditTargetSubscription, plan.Id, nil, plan)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func UpdateSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPlan, err := model.GetSubscriptionPlanById(plan.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateSubscriptionPlan(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	before := *cleanPlan
+	plan.CreatedTime = cleanPlan.CreatedTime
+	if err = plan.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "subscription.plan_update", model.AuditTargetSubscription, plan.Id, before, plan)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	before, err := model.GetSubscriptionPlanById(id)
+	if err == nil {
+		err = model.DeleteSubscriptionPlanById(id)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "subscription.plan_delete", model.AuditTargetSubscription, id, before, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetUserSubscriptions 分页列出用户订阅，可按 user_id 过滤
+func GetUserSubscriptions(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	subscriptions, total, err := model.GetUserSubscriptions(userId, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscriptions,
+		"total":   total,
+	})
+}
+
+// GrantUserSubscription 管理员为用户开通或续期套餐，不扣除余额
+func GrantUserSubscription(c *gin.Context) {
+	req := struct {
+		UserId int `json:"user_id"`
+		PlanId int `json:"plan_id"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if _, err := model.GetUserById(req.UserId, false); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "用户不存在",
+		})
+		return
+	}
+	subscription, err := model.Subscribe(req.UserId, req.PlanId, 0, "admin:"+strconv.Itoa(c.GetInt("id")))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "subscription.grant", model.AuditTargetSubscription, subscription.Id, nil, subscription)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+	})
+}
+
+// CancelUserSubscription 立即终止用户订阅，收回剩余的套餐额度并恢复分组
+func CancelUserSubscription(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	subscription, err := model.CancelUserSubscription(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordAudit(c, "subscription.cancel", model.AuditTargetSubscription, id, subscription, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// createSubscriptionRenewalOrder 为余额不足以自动续费的订阅创建易支付续费订单，并将支付链接发送到用户邮箱。
+// 订单与在线购买套餐的订单相同，支付成功后由 fulfillSubscriptionOrder 顺延有效期
+func createSubscriptionRenewalOrder(subscription *model.UserSubscription, plan *model.SubscriptionPlan) error {
+	uri, params, tradeNo, err := requestEpayPurchase("", plan.Price)
+	if err != nil {
+		return err
+	}
+	ok, err := model.SetSubscriptionRenewalTradeNo(subscription.Id, tradeNo)
+	if err != nil || !ok {
+		return err
+	}
+	topUp := &model.TopUp{
+		UserId:     subscription.UserId,
+		Money:      plan.Price,
+		TradeNo:    tradeNo,
+		CreateTime: time.Now().Unix(),
+		Status:     "pending",
+		PlanId:     plan.Id,
+	}
+	if err = topUp.Insert(); err != nil {
+		return err
+	}
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	link := uri + "?" + query.Encode()
+	expireAt := time.Unix(subscription.EndTime, 0).Format("2006-01-02 15:04:05")
+	model.RecordLog(subscription.UserId, model.LogTypeTopup, fmt.Sprintf("余额不足以自动续费套餐 %s，已创建续费订单 %s", plan.Name, tradeNo))
+	email, err := model.GetUserEmail(subscription.UserId)
+	if err != nil || email == "" {
+		return err
+	}
+	subject := fmt.Sprintf("%s套餐续费提醒", common.SystemName)
+	content := fmt.Sprintf("<p>您好，您订阅的套餐 %s 将于 %s 到期，账户余额不足以自动续费。</p>"+
+		"<p>点击 <a href='%s'>此处</a> 支付 %.2f 元完成续费。</p>"+
+		"<p>如果链接无法点击，请将下面的链接复制到浏览器中打开：<br> %s </p>", plan.Name, expireAt, link, plan.Price, link)
+	return common.SendEmail(subject, email, content)
+}
+
+// AutomaticallyCreateSubscriptionRenewalOrders 定时为即将到期且余额不足以自动续费的订阅创建在线支付续费订单，
+// 余额充足的订阅仍在到期时由 model.ProcessSubscriptions 从余额中扣费续期
+func AutomaticallyCreateSubscriptionRenewalOrders() {
+	for {
+		if GetEpayClient() != nil {
+			subscriptions, err := model.GetSubscriptionsNeedRenewalOrder(common.GetTimestamp() + subscriptionRenewalOrderLead)
+			if err != nil {
+				common.SysError("failed to get subscriptions to renew: " + err.Error())
+			}
+			for _, subscription := range subscriptions {
+				plan, err := model.GetSubscriptionPlanById(subscription.PlanId)
+				if err != nil || plan.Status != model.SubscriptionPlanStatusEnabled || plan.Price < 0.01 {
+					continue
+				}
+				need, err := subscription.NeedRenewalOrder(plan)
+				if err == nil && need {
+					err = createSubscriptionRenewalOrder(subscription, plan)
+				}
+				if err != nil {
+					common.SysError(fmt.Sprintf("failed to create renewal order for subscription %d: %s", subscription.Id, err.Error()))
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
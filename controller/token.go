@@ -9,7 +9,14 @@ import (
 )
 
 func GetAllTokens(c *gin.Context) {
-	userId := c.GetInt("id")
+	userId, err := getTokenOwnerId(c, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	p, _ := strconv.Atoi(c.Query("p"))
 	size, _ := strconv.Atoi(c.Query("size"))
 	if p < 0 {
@@ -37,7 +44,14 @@ func GetAllTokens(c *gin.Context) {
 }
 
 func SearchTokens(c *gin.Context) {
-	userId := c.GetInt("id")
+	userId, err := getTokenOwnerId(c, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	keyword := c.Query("keyword")
 	token := c.Query("token")
 	tokens, err := model.SearchUserTokens(userId, keyword, token)
@@ -58,7 +72,14 @@ func SearchTokens(c *gin.Context) {
 
 func GetToken(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
-	userId := c.GetInt("id")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	userId, err := getTokenOwnerId(c, false)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -123,8 +144,18 @@ func AddToken(c *gin.Context) {
 		})  
 		return
 	}
+	if token.OrgId != 0 {
+		if _, err = model.ValidateOrganizationToken(token.OrgId, c.GetInt("id")); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	cleanToken := model.Token{
 		UserId:             c.GetInt("id"),
+		OrgId:              token.OrgId,
 		Name:               token.Name,
 		Key:                common.GenerateKey(),
 		CreatedTime:        common.GetTimestamp(),
@@ -152,8 +183,16 @@ func AddToken(c *gin.Context) {
 
 func DeleteToken(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	userId := c.GetInt("id")
-	err := model.DeleteTokenById(id, userId)
+	userId, err := getTokenOwnerId(c, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	before, _ := model.GetTokenByIds(id, userId)
+	err = model.DeleteTokenById(id, userId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -161,6 +200,10 @@ func DeleteToken(c *gin.Context) {
 		})
 		return
 	}
+	// 仅记录管理员或代理代他人进行的操作
+	if userId != c.GetInt("id") {
+		recordAudit(c, "token.delete", model.AuditTargetToken, id, before, nil)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -169,10 +212,17 @@ func DeleteToken(c *gin.Context) {
 }
 
 func UpdateToken(c *gin.Context) {
-	userId := c.GetInt("id")
+	userId, err := getTokenOwnerId(c, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	statusOnly := c.Query("status_only")
 	token := model.Token{}
-	err := c.ShouldBindJSON(&token)
+	err = c.ShouldBindJSON(&token)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -211,6 +261,7 @@ func UpdateToken(c *gin.Context) {
 			return
 		}
 	}
+	before := *cleanToken
 	if statusOnly != "" {
 		cleanToken.Status = token.Status
 	} else {
@@ -230,6 +281,9 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if userId != c.GetInt("id") {
+		recordAudit(c, "token.update", model.AuditTargetToken, cleanToken.Id, before, cleanToken)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
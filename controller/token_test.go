@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createTestUser(t *testing.T, username string, role int, parentId int) *model.User {
+	user := &model.User{
+		Username:    username,
+		Password:    "password123",
+		DisplayName: username,
+		Role:        role,
+		Status:      common.UserStatusEnabled,
+		ParentId:    parentId,
+		AccessToken: common.GetUUID(),
+		AffCode:     common.GetRandomString(4) + username,
+	}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newTokenOwnerContext 构造以 operator 身份、通过 user_id 参数访问 target 令牌的请求
+func newTokenOwnerContext(operator *model.User, target *model.User, permissions []string, scopes []string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/token/?user_id="+strconv.Itoa(target.Id), nil)
+	c.Set("id", operator.Id)
+	c.Set("role", operator.Role)
+	c.Set("permissions", permissions)
+	if scopes != nil {
+		c.Set("access_token_scopes", scopes)
+	}
+	return c
+}
+
+func TestGetTokenOwnerIdPermissions(t *testing.T) {
+	admin := createTestUser(t, "token_admin", common.RoleAdminUser, 0)
+	reseller := createTestUser(t, "token_reseller", common.RoleCommonUser, 0)
+	child := createTestUser(t, "token_child", common.RoleCommonUser, reseller.Id)
+	other := createTestUser(t, "token_other", common.RoleCommonUser, 0)
+
+	cases := []struct {
+		name        string
+		operator    *model.User
+		target      *model.User
+		permissions []string
+		scopes      []string
+		write       bool
+		allowed     bool
+	}{
+		{"read-only role reads", admin, other, []string{common.PermissionUserReadAll}, nil, false, true},
+		{"read-only role writes", admin, other, []string{common.PermissionUserReadAll}, nil, true, false},
+		{"admin writes", admin, other, []string{common.PermissionUserReadAll, common.PermissionUserWrite}, nil, true, true},
+		{"admin writes with self-scoped access token", admin, other, []string{common.PermissionAll}, []string{"self"}, true, false},
+		{"reseller writes child", reseller, child, []string{common.PermissionUserWrite}, nil, true, true},
+		{"reseller writes non-child", reseller, other, []string{common.PermissionUserWrite}, nil, true, false},
+		{"reseller without user.write reads child", reseller, child, []string{common.PermissionUserRead}, nil, false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTokenOwnerContext(tc.operator, tc.target, tc.permissions, tc.scopes)
+			userId, err := getTokenOwnerId(c, tc.write)
+			if tc.allowed && (err != nil || userId != tc.target.Id) {
+				t.Fatalf("expected access to user %d, got %d %v", tc.target.Id, userId, err)
+			}
+			if !tc.allowed && err == nil {
+				t.Fatalf("expected access to user %d to be denied", tc.target.Id)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tokenize 统计请求的输入 token 数量并估算费用，不转发上游，也不扣除额度
+func Tokenize(c *gin.Context) {
+	var request dto.TokenizeRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		tokenizeError(c, http.StatusBadRequest, "invalid_request", err)
+		return
+	}
+	if request.Model == "" {
+		tokenizeError(c, http.StatusBadRequest, "invalid_request", errors.New("model is required"))
+		return
+	}
+	if c.GetBool("token_model_limit_enabled") {
+		tokenModelLimit, _ := c.Get("token_model_limit")
+		if limit, ok := tokenModelLimit.(map[string]bool); !ok || !limit[request.Model] {
+			tokenizeError(c, http.StatusForbidden, "model_not_allowed", errors.New("该令牌无权访问模型 "+request.Model))
+			return
+		}
+	}
+	inputTokens, err := countTokenizeRequest(&request)
+	if err != nil {
+		tokenizeError(c, http.StatusInternalServerError, "count_token_messages_failed", err)
+		return
+	}
+
+	group, err := model.CacheGetUserGroup(c.GetInt("id"))
+	if err != nil {
+		group = "default"
+	}
+	groupRatio := relaycommon.GetGroupRatio(c, group)
+	var quota int
+	modelPrice, usePrice := common.GetModelPrice(request.Model, false)
+	if usePrice {
+		quota = int(modelPrice * common.QuotaPerUnit * groupRatio)
+	} else {
+		quota, _ = common.CalculateTokenQuota(request.Model, common.TokenUsage{
+			PromptTokens:     inputTokens,
+			CompletionTokens: int(request.MaxTokens),
+		}, groupRatio)
+	}
+	c.JSON(http.StatusOK, dto.TokenizeResponse{
+		Object:         "tokenize",
+		Model:          request.Model,
+		InputTokens:    inputTokens,
+		EstimatedQuota: quota,
+		EstimatedCost:  float64(quota) / common.QuotaPerUnit,
+	})
+}
+
+func countTokenizeRequest(request *dto.TokenizeRequest) (int, error) {
+	tokens := 0
+	if request.System != nil {
+		systemTokens, err := service.CountTokenInput(request.System, request.Model)
+		if err != nil {
+			return 0, err
+		}
+		tokens += systemTokens
+	}
+	if len(request.Messages) > 0 {
+		messageTokens, err := service.CountTokenChatRequest(request.GeneralOpenAIRequest, request.Model)
+		if err != nil {
+			return 0, err
+		}
+		return tokens + messageTokens, nil
+	}
+	var input any
+	if request.Prompt != nil {
+		input = request.Prompt
+	} else if request.Input != nil {
+		input = request.Input
+	} else {
+		return tokens, nil
+	}
+	inputTokens, err := service.CountTokenInput(input, request.Model)
+	if err != nil {
+		return 0, err
+	}
+	return tokens + inputTokens, nil
+}
+
+func tokenizeError(c *gin.Context, statusCode int, code string, err error) {
+	c.JSON(statusCode, gin.H{
+		"error": dto.OpenAIError{
+			Message: common.MessageWithRequestId(err.Error(), c.GetString(common.RequestIdKey)),
+			Type:    "new_api_error",
+			Code:    code,
+		},
+	})
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Calcium-Ion/go-epay/epay"
 	"github.com/gin-gonic/gin"
@@ -83,12 +84,38 @@ func RequestEpay(c *gin.Context) {
 		return
 	}
 
+	uri, params, tradeNo, err := requestEpayPurchase(req.PaymentMethod, payMoney)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": err.Error()})
+		return
+	}
+	amount := req.Amount
+	if !common.DisplayInCurrencyEnabled {
+		amount = amount / int(common.QuotaPerUnit)
+	}
+	topUp := &model.TopUp{
+		UserId:     id,
+		Amount:     amount,
+		Money:      payMoney,
+		TradeNo:    tradeNo,
+		CreateTime: time.Now().Unix(),
+		Status:     "pending",
+	}
+	err = topUp.Insert()
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
+}
+
+// requestEpayPurchase 向易支付发起支付，返回支付地址、参数与订单号
+func requestEpayPurchase(paymentMethod string, payMoney float64) (string, map[string]string, string, error) {
 	var payType epay.PurchaseType
-	if req.PaymentMethod == "zfb" {
+	if paymentMethod == "zfb" {
 		payType = epay.Alipay
 	}
-	if req.PaymentMethod == "wx" {
-		req.PaymentMethod = "wxpay"
+	if paymentMethod == "wx" {
 		payType = epay.WechatPay
 	}
 	callBackAddress := service.GetCallbackAddress()
@@ -97,8 +124,7 @@ func RequestEpay(c *gin.Context) {
 	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
 	client := GetEpayClient()
 	if client == nil {
-		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
-		return
+		return "", nil, "", errors.New("当前管理员未配置支付信息")
 	}
 	uri, params, err := client.Purchase(&epay.PurchaseArgs{
 		Type:           payType,
@@ -110,27 +136,9 @@ func RequestEpay(c *gin.Context) {
 		ReturnUrl:      returnUrl,
 	})
 	if err != nil {
-		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
-		return
-	}
-	amount := req.Amount
-	if !common.DisplayInCurrencyEnabled {
-		amount = amount / int(common.QuotaPerUnit)
+		return "", nil, "", errors.New("拉起支付失败")
 	}
-	topUp := &model.TopUp{
-		UserId:     id,
-		Amount:     amount,
-		Money:      payMoney,
-		TradeNo:    "A" + tradeNo,
-		CreateTime: time.Now().Unix(),
-		Status:     "pending",
-	}
-	err = topUp.Insert()
-	if err != nil {
-		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
-		return
-	}
-	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
+	return uri, params, "A" + tradeNo, nil
 }
 
 // tradeNo lock
@@ -205,15 +213,22 @@ func EpayNotify(c *gin.Context) {
 				log.Printf("易支付回调更新订单失败: %v", topUp)
 				return
 			}
+			if topUp.PlanId != 0 {
+				fulfillSubscriptionOrder(topUp)
+				model.PromoteUserGroup(topUp.UserId)
+				return
+			}
 			//user, _ := model.GetUserById(topUp.UserId, false)
 			//user.Quota += topUp.Amount * 500000
-			err = model.IncreaseUserQuota(topUp.UserId, topUp.Amount*int(common.QuotaPerUnit))
+			err = model.IncreaseUserQuota(topUp.UserId, topUp.Amount*int(common.QuotaPerUnit), model.LedgerReasonTopUp, topUp.TradeNo)
 			if err != nil {
 				log.Printf("易支付回调更新用户失败: %v", topUp)
 				return
 			}
 			log.Printf("易支付回调更新用户成功 %v", topUp)
 			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(topUp.Amount*int(common.QuotaPerUnit)), topUp.Money))
+			model.GrantAffCommission(topUp.UserId, topUp.Amount*int(common.QuotaPerUnit), model.AffCommissionSourceEpay, topUp.TradeNo)
+			model.PromoteUserGroup(topUp.UserId)
 		}
 	} else {
 		log.Printf("易支付异常回调: %v", verifyInfo)
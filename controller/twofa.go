@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	twoFAPendingIdKey   = "2fa_pending_id"
+	twoFAPendingTimeKey = "2fa_pending_time"
+	// twoFAPendingSeconds 密码验证通过后输入两步验证码的时限
+	twoFAPendingSeconds = 300
+)
+
+type TwoFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+func bindTwoFACode(c *gin.Context) (string, bool) {
+	var req TwoFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请输入验证码",
+		})
+		return "", false
+	}
+	return req.Code, true
+}
+
+// verifyTwoFACode 校验已登录用户的两步验证码，失败次数与登录时的两步验证共用锁定计数
+func verifyTwoFACode(c *gin.Context, userId int, code string) bool {
+	username := c.GetString("username")
+	if !checkLoginAllowed(c, username) {
+		return false
+	}
+	if err := model.VerifyUserTwoFA(userId, code); err != nil {
+		recordLoginFailure(c, username, userId, "两步验证码错误")
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// markTwoFAVerified 在会话中记录两步验证时间，供敏感操作校验
+func markTwoFAVerified(c *gin.Context) {
+	session := sessions.Default(c)
+	session.Set(common.TwoFAVerifiedAtSessionKey, common.GetTimestamp())
+	_ = session.Save()
+}
+
+func setupPendingTwoFALogin(user *model.User, c *gin.Context) {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(twoFAPendingIdKey, user.Id)
+	session.Set(twoFAPendingTimeKey, common.GetTimestamp())
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无法保存会话信息，请重试",
+			"success": false,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "请输入两步验证码",
+		"success": true,
+		"data": gin.H{
+			"require_2fa": true,
+		},
+	})
+}
+
+// LoginTwoFA 密码或第三方登录通过后，校验两步验证码并完成登录
+func LoginTwoFA(c *gin.Context) {
+	session := sessions.Default(c)
+	userId, ok := session.Get(twoFAPendingIdKey).(int)
+	pendingTime, _ := session.Get(twoFAPendingTimeKey).(int64)
+	if !ok || common.GetTimestamp()-pendingTime > twoFAPendingSeconds {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "登录已过期，请重新登录",
+		})
+		return
+	}
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !checkLoginAllowed(c, user.Username) {
+		return
+	}
+	if err := model.VerifyUserTwoFA(userId, code); err != nil {
+		// 两步验证码错误同样计入登录失败，防止暴力尝试验证码
+		recordLoginFailure(c, user.Username, user.Id, "两步验证码错误")
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "用户已被封禁",
+		})
+		return
+	}
+	setupSession(user, c, true)
+}
+
+func GetTwoFAStatus(c *gin.Context) {
+	userId := c.GetInt("id")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"enabled":                  model.IsTwoFAEnabled(userId),
+			"recovery_codes_remaining": model.GetRecoveryCodesRemaining(userId),
+			"enforced":                 common.TwoFAAdminEnforcementEnabled && c.GetInt("role") >= common.RoleAdminUser,
+		},
+	})
+}
+
+// SetupTwoFA 生成新的密钥，前端将 uri 渲染为二维码供验证器应用扫描
+func SetupTwoFA(c *gin.Context) {
+	secret, err := model.SetupUserTwoFA(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"secret": secret,
+			"uri":    common.TOTPProvisioningURI(c.GetString("username"), secret),
+		},
+	})
+}
+
+func EnableTwoFA(c *gin.Context) {
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	codes, err := model.EnableUserTwoFA(c.GetInt("id"), code)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	markTwoFAVerified(c)
+	recordSecurityLog(c, c.GetInt("id"), "启用了两步验证")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "两步验证已启用，请妥善保存恢复码，恢复码仅显示一次",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// VerifyTwoFA 重新进行两步验证，用于查看渠道密钥等敏感操作前
+func VerifyTwoFA(c *gin.Context) {
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	if !verifyTwoFACode(c, c.GetInt("id"), code) {
+		return
+	}
+	markTwoFAVerified(c)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func DisableTwoFA(c *gin.Context) {
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	userId := c.GetInt("id")
+	if common.TwoFAAdminEnforcementEnabled && c.GetInt("role") >= common.RoleAdminUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "系统要求管理员启用两步验证，无法关闭",
+		})
+		return
+	}
+	if !verifyTwoFACode(c, userId, code) {
+		return
+	}
+	if err := model.DisableUserTwoFA(userId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recordSecurityLog(c, userId, "关闭了两步验证")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func RegenerateRecoveryCodes(c *gin.Context) {
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	userId := c.GetInt("id")
+	if !verifyTwoFACode(c, userId, code) {
+		return
+	}
+	codes, err := model.RegenerateRecoveryCodes(userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
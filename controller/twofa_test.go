@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+func TestTwoFAFailuresLockOut(t *testing.T) {
+	user := createTestUser(t, "twofa_lockout", common.RoleCommonUser, 0)
+	hashes, _ := json.Marshal([]string{common.HashRecoveryCode("recovery-code")})
+	err := model.DB.Create(&model.UserTwoFA{
+		UserId:        user.Id,
+		Secret:        "JBSWY3DPEHPK3PXP",
+		Enabled:       true,
+		RecoveryCodes: string(hashes),
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := gin.New()
+	engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("test-secret"))))
+	engine.Use(func(c *gin.Context) {
+		c.Set("id", user.Id)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+	})
+	engine.POST("/api/user/self/2fa/verify", VerifyTwoFA)
+	engine.POST("/api/user/self/2fa/disable", DisableTwoFA)
+	engine.POST("/api/user/self/2fa/recovery_codes", RegenerateRecoveryCodes)
+
+	key := "user:" + user.Username
+	for _, target := range []string{
+		"/api/user/self/2fa/verify",
+		"/api/user/self/2fa/disable",
+		"/api/user/self/2fa/recovery_codes",
+	} {
+		// 失败次数距锁定阈值只差一次，且已过重试等待时间
+		err = model.DB.Create(&model.LoginFailure{
+			Key:             key,
+			Failures:        common.LoginMaxFailures - 1,
+			LastFailureTime: common.GetTimestamp() - 60,
+		}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := serveTestRequest(engine, http.MethodPost, target, nil, `{"code":"000000"}`)
+		if success, _ := decodeTestResponse(t, w); success {
+			t.Fatalf("%s: expected a wrong code to be rejected", target)
+		}
+		var failure model.LoginFailure
+		if err = model.DB.First(&failure, "failure_key = ?", key).Error; err != nil || failure.LockedUntil <= common.GetTimestamp() {
+			t.Fatalf("%s: expected the account to be locked, got %+v %v", target, failure, err)
+		}
+		w = serveTestRequest(engine, http.MethodPost, target, nil, `{"code":"recovery-code"}`)
+		success, message := decodeTestResponse(t, w)
+		if success || !strings.Contains(message, "失败次数过多") {
+			t.Fatalf("%s: expected a locked account to be refused, got %v %q", target, success, message)
+		}
+		if model.GetRecoveryCodesRemaining(user.Id) != 1 {
+			t.Fatalf("%s: expected the recovery code to be left unused", target)
+		}
+		if err = model.UnlockLogin(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := serveTestRequest(engine, http.MethodPost, "/api/user/self/2fa/verify", nil, `{"code":"recovery-code"}`)
+	if success, message := decodeTestResponse(t, w); !success {
+		t.Fatalf("expected the recovery code to verify after unlocking, got %q", message)
+	}
+}
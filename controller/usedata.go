@@ -1,17 +1,63 @@
 package controller
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/model"
 	"strconv"
 )
 
-func GetAllQuotaDates(c *gin.Context) {
+// parseQuotaDataQuery 解析数据看板的公共参数：group_by（逗号分隔）与 granularity（hour、day、month）
+func parseQuotaDataQuery(c *gin.Context, allowedGroupBy []string) (model.QuotaDataQuery, error) {
 	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
 	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
-	username := c.Query("username")
-	dates, err := model.GetAllQuotaDates(startTimestamp, endTimestamp, username)
+	tokenId, _ := strconv.Atoi(c.Query("token_id"))
+	groupBy, err := model.ParseQuotaDataGroupBy(c.Query("group_by"), allowedGroupBy)
+	if err != nil {
+		return model.QuotaDataQuery{}, err
+	}
+	granularity := c.DefaultQuery("granularity", model.QuotaDataGranularityHour)
+	if !model.IsValidQuotaDataGranularity(granularity) {
+		return model.QuotaDataQuery{}, errors.New("granularity 只能为 hour、day 或 month")
+	}
+	return model.QuotaDataQuery{
+		TokenId:     tokenId,
+		StartTime:   startTimestamp,
+		EndTime:     endTimestamp,
+		GroupBy:     groupBy,
+		Granularity: granularity,
+	}, nil
+}
+
+func GetAllQuotaDates(c *gin.Context) {
+	userIds, err := getResellerScope(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	groupByFields := model.QuotaDataGroupByFields
+	if userIds != nil {
+		// 代理查看下级数据时不按渠道统计
+		groupByFields = []string{"user", "model", "token"}
+	}
+	query, err := parseQuotaDataQuery(c, groupByFields)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数：" + err.Error(),
+		})
+		return
+	}
+	query.Username = c.Query("username")
+	query.UserIds = userIds
+	if userIds == nil {
+		query.ChannelId, _ = strconv.Atoi(c.Query("channel_id"))
+	}
+	dates, err := model.GetQuotaData(query)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -28,18 +74,31 @@ func GetAllQuotaDates(c *gin.Context) {
 }
 
 func GetUserQuotaDates(c *gin.Context) {
-	userId := c.GetInt("id")
-	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
-	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
-	// 判断时间跨度是否超过 1 个月
-	if endTimestamp-startTimestamp > 2592000 {
+	query, err := parseQuotaDataQuery(c, []string{"model", "token"})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数：" + err.Error(),
+		})
+		return
+	}
+	query.UserId = c.GetInt("id")
+	// 按小时查询时跨度不能超过 1 个月，按天或按月查询时不能超过 1 年
+	if query.Granularity == model.QuotaDataGranularityHour && query.EndTime-query.StartTime > 2592000 {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "时间跨度不能超过 1 个月",
 		})
 		return
 	}
-	dates, err := model.GetQuotaDataByUserId(userId, startTimestamp, endTimestamp)
+	if query.EndTime-query.StartTime > 31622400 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "时间跨度不能超过 1 年",
+		})
+		return
+	}
+	dates, err := model.GetQuotaData(query)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
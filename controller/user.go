@@ -8,7 +8,6 @@ import (
 	"one-api/model"
 	"strconv"
 	"sync"
-	"errors" // 添加这一行
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -45,12 +44,16 @@ func Login(c *gin.Context) {
 		})
 		return
 	}
+	if !checkLoginAllowed(c, username) {
+		return
+	}
 	user := model.User{
 		Username: username,
 		Password: password,
 	}
 	err = user.ValidateAndFill()
 	if err != nil {
+		recordLoginFailure(c, username, user.Id, "用户名或密码错误")
 		c.JSON(http.StatusOK, gin.H{
 			"message": err.Error(),
 			"success": false,
@@ -61,13 +64,35 @@ func Login(c *gin.Context) {
 }
 
 // setup session & cookies and then return user info
+// 启用了两步验证的用户先进入待验证状态，通过 LoginTwoFA 完成登录
 func setupLogin(user *model.User, c *gin.Context) {
+	if model.IsTwoFAEnabled(user.Id) {
+		setupPendingTwoFALogin(user, c)
+		return
+	}
+	setupSession(user, c, false)
+}
+
+func setupSession(user *model.User, c *gin.Context, twoFAVerified bool) {
+	userSession, err := model.CreateUserSession(user.Id, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无法保存会话信息，请重试",
+			"success": false,
+		})
+		return
+	}
 	session := sessions.Default(c)
+	session.Clear()
+	session.Set(common.SessionIdKey, userSession.Id)
 	session.Set("id", user.Id)
 	session.Set("username", user.Username)
 	session.Set("role", user.Role)
 	session.Set("status", user.Status)
-	err := session.Save()
+	if twoFAVerified {
+		session.Set(common.TwoFAVerifiedAtSessionKey, common.GetTimestamp())
+	}
+	err = session.Save()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "无法保存会话信息，请重试",
@@ -75,6 +100,7 @@ func setupLogin(user *model.User, c *gin.Context) {
 		})
 		return
 	}
+	recordLoginSuccess(c, user)
 	cleanUser := model.User{
 		Id:          user.Id,
 		Username:    user.Username,
@@ -90,8 +116,22 @@ func setupLogin(user *model.User, c *gin.Context) {
 	})
 }
 
+// getProvisionUsername 第三方登录自动创建用户时优先使用外部用户名，不合法或已被占用时以 prefix 加序号生成
+func getProvisionUsername(candidate string, prefix string) string {
+	if candidate != "" && len(candidate) <= 20 {
+		exist, err := model.CheckUserExistOrDeleted(candidate, "")
+		if err == nil && !exist {
+			return candidate
+		}
+	}
+	return prefix + strconv.Itoa(model.GetMaxUserId()+1)
+}
+
 func Logout(c *gin.Context) {
 	session := sessions.Default(c)
+	if sessionId, ok := session.Get(common.SessionIdKey).(string); ok {
+		_ = model.DeleteUserSession(sessionId)
+	}
 	session.Clear()
 	err := session.Save()
 	if err != nil {
@@ -138,6 +178,13 @@ func Register(c *gin.Context) {
 		})
 		return
 	}
+	if err := checkPasswordPolicy(0, user.Username, user.Password); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	if common.EmailVerificationEnabled {
 		if user.Email == "" || user.VerificationCode == "" {
 			c.JSON(http.StatusOK, gin.H{
@@ -188,7 +235,7 @@ func Register(c *gin.Context) {
 		return
 	}
 		// 生成访问令牌
-	accessToken, err := GenerateAccessTokenForUser(&cleanUser)
+	accessToken, err := rotateDefaultAccessToken(cleanUser.Id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -205,28 +252,18 @@ func Register(c *gin.Context) {
 	return
 }
 
-func GenerateAccessTokenForUser(user *model.User) (string, error) {
-	user.AccessToken = common.GetUUID()
-
-	// 检查生成的 UUID 是否重复
-	if model.DB.Where("access_token = ?", user.AccessToken).First(user).RowsAffected != 0 {
-		return "", errors.New("请重试，系统生成的 UUID 竟然重复了！")
-	}
-
-	// 更新用户信息
-	if err := user.Update(false); err != nil {
-		return "", err
-	}
-
-	return user.AccessToken, nil
-}
-
 func GetAllUsers(c *gin.Context) {
 	p, _ := strconv.Atoi(c.Query("p"))
 	if p < 0 {
 		p = 0
 	}
-	users, err := model.GetAllUsers(p*common.ItemsPerPage, common.ItemsPerPage)
+	var users []*model.User
+	var err error
+	if isResellerOperator(c) {
+		users, err = model.GetSubtreeUsers(c.GetInt("id"), p*common.ItemsPerPage, common.ItemsPerPage)
+	} else {
+		users, err = model.GetAllUsers(p*common.ItemsPerPage, common.ItemsPerPage)
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -245,7 +282,13 @@ func GetAllUsers(c *gin.Context) {
 func SearchUsers(c *gin.Context) {
 	keyword := c.Query("keyword")
 	group := c.Query("group")
-	users, err := model.SearchUsers(keyword, group)
+	var users []*model.User
+	var err error
+	if isResellerOperator(c) {
+		users, err = model.SearchSubtreeUsers(c.GetInt("id"), keyword)
+	} else {
+		users, err = model.SearchUsers(keyword, group)
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -279,7 +322,15 @@ func GetUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != common.RoleRootUser {
+	if isResellerOperator(c) {
+		if !resellerCanManage(c, user) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权获取非下级用户的信息",
+			})
+			return
+		}
+	} else if myRole <= user.Role && myRole != common.RoleRootUser {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权获取同级或更高等级用户的信息",
@@ -294,38 +345,28 @@ func GetUser(c *gin.Context) {
 	return
 }
 
+// GenerateAccessToken 重新生成默认管理令牌，兼容旧版的单一访问令牌
 func GenerateAccessToken(c *gin.Context) {
-	id := c.GetInt("id")
-	user, err := model.GetUserById(id, true)
-	if err != nil {
+	// 默认管理令牌拥有全部作用域，通过作用域受限的管理令牌调用时不能生成
+	if err := checkGrantableScopes(c, []string{common.PermissionAll}); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": err.Error(),
 		})
 		return
 	}
-	user.AccessToken = common.GetUUID()
-
-	if model.DB.Where("access_token = ?", user.AccessToken).First(user).RowsAffected != 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "请重试，系统生成的 UUID 竟然重复了！",
-		})
-		return
-	}
-
-	if err := user.Update(false); err != nil {
+	key, err := rotateDefaultAccessToken(c.GetInt("id"))
+	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": err.Error(),
 		})
 		return
 	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data":    user.AccessToken,
+		"data":    key,
 	})
 	return
 }
@@ -463,24 +504,54 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt("role")
-	if myRole <= originUser.Role && myRole != common.RoleRootUser {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "无权更新同权限等级或更高权限等级的用户信息",
-		})
-		return
-	}
-	if myRole <= updatedUser.Role && myRole != common.RoleRootUser {
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "无权将其他用户权限等级提升到大于等于自己的权限等级",
-		})
-		return
+	if isResellerOperator(c) {
+		if !resellerCanManage(c, originUser) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权更新非下级用户的信息",
+			})
+			return
+		}
+		// 代理不能直接修改下级的分组与额度，额度需从自己的余额中分配
+		updatedUser.Group = originUser.Group
+		updatedUser.Quota = originUser.Quota
+	} else {
+		if myRole <= originUser.Role && myRole != common.RoleRootUser {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权更新同权限等级或更高权限等级的用户信息",
+			})
+			return
+		}
+		if myRole <= updatedUser.Role && myRole != common.RoleRootUser {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权将其他用户权限等级提升到大于等于自己的权限等级",
+			})
+			return
+		}
+		if originUser.Quota != updatedUser.Quota && !hasPermission(c, common.PermissionUserQuotaAdjust) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，缺少权限 " + common.PermissionUserQuotaAdjust,
+			})
+			return
+		}
 	}
 	if updatedUser.Password == "$I_LOVE_U" {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
+	if updatePassword {
+		if err := checkPasswordPolicy(updatedUser.Id, originUser.Username, updatedUser.Password); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	before, _ := model.GetUserById(updatedUser.Id, true)
 	if err := updatedUser.Edit(updatePassword); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -488,9 +559,16 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if updatePassword {
+		// 修改密码后强制该用户的所有登录会话下线
+		_ = model.RevokeUserSessions(updatedUser.Id, "")
+		recordSecurityLog(c, updatedUser.Id, "管理员修改了登录密码")
+	}
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", common.LogQuota(originUser.Quota), common.LogQuota(updatedUser.Quota)))
 	}
+	after, _ := model.GetUserById(updatedUser.Id, true)
+	recordAudit(c, "user.update", model.AuditTargetUser, updatedUser.Id, before, after)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -530,6 +608,15 @@ func UpdateSelf(c *gin.Context) {
 		cleanUser.Password = ""
 	}
 	updatePassword := user.Password != ""
+	if updatePassword {
+		if err := checkPasswordPolicy(cleanUser.Id, c.GetString("username"), user.Password); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	if err := cleanUser.Update(updatePassword); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -537,6 +624,11 @@ func UpdateSelf(c *gin.Context) {
 		})
 		return
 	}
+	if updatePassword {
+		// 修改密码后保留当前会话，其余登录全部下线
+		_ = model.RevokeUserSessions(cleanUser.Id, currentSessionId(c))
+		recordSecurityLog(c, cleanUser.Id, "修改了登录密码")
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -563,13 +655,38 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt("role")
-	if myRole <= originUser.Role {
+	if isResellerOperator(c) {
+		if !resellerCanManage(c, originUser) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权删除非下级用户",
+			})
+			return
+		}
+	} else if myRole <= originUser.Role {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权删除同权限等级或更高权限等级的用户",
 		})
 		return
 	}
+	if children, _ := model.GetSubtreeUserIds(id); len(children) > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该用户仍有下级用户，无法删除",
+		})
+		return
+	}
+	if isResellerOperator(c) && originUser.Quota > 0 {
+		// 代理删除下级时收回其剩余额度
+		if err = model.AllocateQuotaToChild(c.GetInt("id"), id, -originUser.Quota); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	err = model.HardDeleteUserById(id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -578,6 +695,8 @@ func DeleteUser(c *gin.Context) {
 		})
 		return
 	}
+	_ = model.RevokeUserSessions(id, "")
+	recordAudit(c, "user.delete", model.AuditTargetUser, id, originUser, nil)
 }
 
 func DeleteSelf(c *gin.Context) {
@@ -600,6 +719,7 @@ func DeleteSelf(c *gin.Context) {
 		})
 		return
 	}
+	_ = model.RevokeUserSessions(id, "")
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -624,6 +744,13 @@ func CreateUser(c *gin.Context) {
 		})
 		return
 	}
+	if err := checkPasswordPolicy(0, user.Username, user.Password); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	if user.DisplayName == "" {
 		user.DisplayName = user.Username
 	}
@@ -641,6 +768,18 @@ func CreateUser(c *gin.Context) {
 		Password:    user.Password,
 		DisplayName: user.DisplayName,
 	}
+	if isResellerOperator(c) {
+		// 代理创建的用户挂在代理名下，沿用代理的分组
+		cleanUser.ParentId = c.GetInt("id")
+		cleanUser.Group, err = model.GetUserGroup(cleanUser.ParentId)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	if err := cleanUser.Insert(0); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -648,6 +787,7 @@ func CreateUser(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, "user.create", model.AuditTargetUser, cleanUser.Id, nil, cleanUser)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -686,13 +826,22 @@ func ManageUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != common.RoleRootUser {
+	if isResellerOperator(c) {
+		if !resellerCanManage(c, &user) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权更新非下级用户的信息",
+			})
+			return
+		}
+	} else if myRole <= user.Role && myRole != common.RoleRootUser {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权更新同权限等级或更高权限等级的用户信息",
 		})
 		return
 	}
+	before := user
 	switch req.Action {
 	case "disable":
 		user.Status = common.UserStatusDisabled
@@ -720,6 +869,7 @@ func ManageUser(c *gin.Context) {
 			})
 			return
 		}
+		recordAudit(c, "user.delete", model.AuditTargetUser, user.Id, before, nil)
 	case "promote":
 		if myRole != common.RoleRootUser {
 			c.JSON(http.StatusOK, gin.H{
@@ -736,6 +886,15 @@ func ManageUser(c *gin.Context) {
 			return
 		}
 		user.Role = common.RoleAdminUser
+	case "reseller":
+		if user.Role != common.RoleCommonUser {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "只有普通用户可以设为代理",
+			})
+			return
+		}
+		user.Role = common.RoleResellerUser
 	case "demote":
 		if user.Role == common.RoleRootUser {
 			c.JSON(http.StatusOK, gin.H{
@@ -752,6 +911,32 @@ func ManageUser(c *gin.Context) {
 			return
 		}
 		user.Role = common.RoleCommonUser
+	case "logout":
+		// 强制用户的所有登录会话下线，不影响管理令牌
+		if err := model.RevokeUserSessions(user.Id, ""); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "unlock":
+		if err := model.UnlockUserLogin(user.Username); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "reset_2fa":
+		// 用户丢失验证器与恢复码时由管理员重置，用户可重新绑定
+		if err := model.DisableUserTwoFA(user.Id); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	}
 
 	if err := user.Update(false); err != nil {
@@ -761,6 +946,13 @@ func ManageUser(c *gin.Context) {
 		})
 		return
 	}
+	if user.Status == common.UserStatusDisabled || req.Action == "delete" {
+		// 封禁或删除的用户立即下线
+		_ = model.RevokeUserSessions(user.Id, "")
+	}
+	if req.Action != "delete" {
+		recordAudit(c, "user."+req.Action, model.AuditTargetUser, user.Id, before, user)
+	}
 	clearUser := model.User{
 		Role:   user.Role,
 		Status: user.Status,
@@ -821,6 +1013,40 @@ type topUpRequest struct {
 
 var lock = sync.Mutex{}
 
+// GetSelfGroupGrant 返回当前用户生效中的限时分组，没有时 data 为空
+func GetSelfGroupGrant(c *gin.Context) {
+	grant, err := model.GetActiveUserGroupGrant(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    grant,
+	})
+}
+
+// GetSelfGroupPromotion 返回当前用户的累计充值金额与下一个自动升级的分组
+func GetSelfGroupPromotion(c *gin.Context) {
+	status, err := model.GetGroupPromotionStatus(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    status,
+	})
+}
+
 func TopUp(c *gin.Context) {
 	lock.Lock()
 	defer lock.Unlock()
@@ -0,0 +1,19 @@
+package dto
+
+type ModerationRequest struct {
+	Model string `json:"model,omitempty"`
+	Input any    `json:"input"`
+}
+
+type ModerationResponse struct {
+	Id      string                     `json:"id"`
+	Model   string                     `json:"model"`
+	Results []ModerationResponseResult `json:"results"`
+	Error   *OpenAIError               `json:"error,omitempty"`
+}
+
+type ModerationResponseResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
@@ -1,5 +1,7 @@
 package dto
 
+import "one-api/common"
+
 type OpenAIModelPermission struct {
 	Id                 string  `json:"id"`
 	Object             string  `json:"object"`
@@ -34,4 +36,6 @@ type ModelPricing struct {
 	OwnerBy         string   `json:"owner_by"`
 	CompletionRatio float64  `json:"completion_ratio"`
 	EnableGroup     []string `json:"enable_group,omitempty"`
+	// PricingSchema 多维度价格配置，包括上下文分档、缓存、音频与推理 token 倍率
+	PricingSchema *common.ModelPricingSchema `json:"pricing_schema,omitempty"`
 }
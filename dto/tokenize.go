@@ -0,0 +1,15 @@
+package dto
+
+// TokenizeRequest 兼容 OpenAI 格式（messages/prompt/input）与 Anthropic count_tokens 格式（system）
+type TokenizeRequest struct {
+	GeneralOpenAIRequest
+	System any `json:"system,omitempty"`
+}
+
+type TokenizeResponse struct {
+	Object         string  `json:"object"`
+	Model          string  `json:"model"`
+	InputTokens    int     `json:"input_tokens"`
+	EstimatedQuota int     `json:"estimated_quota"`
+	EstimatedCost  float64 `json:"estimated_cost"`
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"github.com/gin-contrib/sessions"
@@ -15,7 +16,10 @@ import (
 	"one-api/router"
 	"one-api/service"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	_ "net/http/pprof"
 )
@@ -78,12 +82,11 @@ func main() {
 	if err != nil {
 		common.FatalLog("failed to initialize database: " + err.Error())
 	}
-	defer func() {
-		err := model.CloseDB()
-		if err != nil {
-			common.FatalLog("failed to close database: " + err.Error())
-		}
-	}()
+	err = model.InitLogDB()
+	if err != nil {
+		common.FatalLog("failed to initialize log database: " + err.Error())
+	}
+	model.InitConsumeLogWriter()
 
 	// Initialize Redis
 	err = common.InitRedisClient()
@@ -112,6 +115,9 @@ func main() {
 
 	// 数据看板
 	go model.UpdateQuotaData()
+	if common.IsMasterNode {
+		go model.AutomaticallyDownsampleQuotaData()
+	}
 
 	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
@@ -130,6 +136,19 @@ func main() {
 	common.SafeGoroutine(func() {
 		controller.UpdateMidjourneyTaskBulk()
 	})
+	go model.UpdateChannelStats(common.ChannelStatUpdateFrequency)
+	if common.IsMasterNode && common.LedgerReconcileFrequency > 0 {
+		go model.AutomaticallyReconcileQuotaLedger(common.LedgerReconcileFrequency)
+	}
+	if common.IsMasterNode {
+		go controller.AutomaticallyGenerateStatements()
+		go controller.AutomaticallyArchiveLogs()
+		go model.AutomaticallyCleanExpiredUserSessions()
+		go model.AutomaticallyExpireUserGroupGrants()
+		go model.AutomaticallyProcessSubscriptions()
+		go controller.AutomaticallyCreateSubscriptionRenewalOrders()
+		go model.AutomaticallyDemoteInactiveUserGroups()
+	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
 		common.SysLog("batch update enabled with interval " + strconv.Itoa(common.BatchUpdateInterval) + "s")
@@ -171,8 +190,29 @@ func main() {
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
 	}
-	err = server.Run(":" + port)
-	if err != nil {
-		common.FatalLog("failed to start HTTP server: " + err.Error())
+	httpServer := &http.Server{
+		Addr:    ":" + port,
+		Handler: server,
+	}
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			common.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+
+	// 收到退出信号后停止接收新请求，等待进行中的请求结束，再写入缓冲中的消费日志、批量更新与渠道统计
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	common.SysLog("shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(common.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err = httpServer.Shutdown(ctx); err != nil {
+		common.SysError("failed to shutdown HTTP server: " + err.Error())
+	}
+	if err = model.CloseDB(); err != nil {
+		common.SysError("failed to close database: " + err.Error())
 	}
+	common.SysLog("server exited")
 }
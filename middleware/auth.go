@@ -9,13 +9,28 @@ import (
 	"strings"
 )
 
-func authHelper(c *gin.Context, minRole int) {
+// authUser 校验登录状态与权限等级，通过时写入用户信息并返回 true。
+// 登录会话须在服务端仍然有效；使用管理令牌时会写入令牌的作用域，供后续校验
+func authUser(c *gin.Context, minRole int) bool {
 	session := sessions.Default(c)
 	username := session.Get("username")
 	role := session.Get("role")
 	id := session.Get("id")
 	status := session.Get("status")
-	if username == nil {
+	if username != nil {
+		sessionId, _ := session.Get(common.SessionIdKey).(string)
+		userId, _ := id.(int)
+		if !model.ValidateUserSession(sessionId, userId) {
+			session.Clear()
+			_ = session.Save()
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "登录已失效，请重新登录",
+			})
+			c.Abort()
+			return false
+		}
+	} else {
 		// Check access token
 		accessToken := c.Request.Header.Get("Authorization")
 		if accessToken == "" {
@@ -24,22 +39,24 @@ func authHelper(c *gin.Context, minRole int) {
 				"message": "无权进行此操作，未登录且未提供 access token",
 			})
 			c.Abort()
-			return
+			return false
 		}
-		user := model.ValidateAccessToken(accessToken)
-		if user != nil && user.Username != "" {
+		token, user := model.ValidateAccessToken(accessToken)
+		if token != nil && user.Username != "" {
 			// Token is valid
 			username = user.Username
 			role = user.Role
 			id = user.Id
 			status = user.Status
+			c.Set("access_token_id", token.Id)
+			c.Set("access_token_scopes", token.GetScopes())
 		} else {
 			c.JSON(http.StatusOK, gin.H{
 				"success": false,
-				"message": "无权进行此操作，access token 无效",
+				"message": "无权进行此操作，access token 无效或已过期",
 			})
 			c.Abort()
-			return
+			return false
 		}
 	}
 	if status.(int) == common.UserStatusDisabled {
@@ -48,7 +65,7 @@ func authHelper(c *gin.Context, minRole int) {
 			"message": "用户已被封禁",
 		})
 		c.Abort()
-		return
+		return false
 	}
 	if role.(int) < minRole {
 		c.JSON(http.StatusOK, gin.H{
@@ -56,19 +73,120 @@ func authHelper(c *gin.Context, minRole int) {
 			"message": "无权进行此操作，权限不足",
 		})
 		c.Abort()
-		return
+		return false
+	}
+	if role.(int) < common.RoleAdminUser {
+		// 上级代理被禁用时，其下级用户一并不可用
+		chainEnabled, err := model.IsResellerChainEnabled(id.(int))
+		if err != nil || !chainEnabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "上级代理已被禁用",
+			})
+			c.Abort()
+			return false
+		}
 	}
 	c.Set("username", username)
 	c.Set("role", role)
 	c.Set("id", id)
+	return true
+}
+
+// scopeAllowed 判断管理令牌的作用域是否允许访问，通过登录会话访问时不受作用域限制
+func scopeAllowed(c *gin.Context, scope string) bool {
+	scopes, ok := c.Get("access_token_scopes")
+	if !ok {
+		return true
+	}
+	return common.HasPermission(scopes.([]string), scope)
+}
+
+func abortScopeDenied(c *gin.Context, scope string) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": "无权进行此操作，access token 缺少作用域 " + scope,
+	})
+	c.Abort()
+}
+
+func authHelper(c *gin.Context, minRole int) {
+	if !authUser(c, minRole) {
+		return
+	}
+	if !scopeAllowed(c, common.ScopeSelf) {
+		abortScopeDenied(c, common.ScopeSelf)
+		return
+	}
 	c.Next()
 }
 
+// PermissionAuth 要求当前用户的角色拥有指定权限
+func PermissionAuth(permission string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !authUser(c, common.RoleCommonUser) {
+			return
+		}
+		if common.TwoFAAdminEnforcementEnabled && c.GetInt("role") >= common.RoleAdminUser && !model.IsTwoFAEnabled(c.GetInt("id")) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "系统要求管理员启用两步验证，请先在个人设置中绑定验证器",
+			})
+			c.Abort()
+			return
+		}
+		permissions, err := model.GetUserPermissions(c.GetInt("id"), c.GetInt("role"))
+		if err != nil || !common.HasPermission(permissions, permission) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，缺少权限 " + permission,
+			})
+			c.Abort()
+			return
+		}
+		if !scopeAllowed(c, permission) {
+			abortScopeDenied(c, permission)
+			return
+		}
+		c.Set("permissions", permissions)
+		c.Next()
+	}
+}
+
+// RecentTwoFA 敏感操作要求近期完成过两步验证，需在 UserAuth 或 PermissionAuth 之后使用。
+// 使用 access token 调用时可以通过 X-TOTP-Code 请求头直接提供验证码
+func RecentTwoFA() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		userId := c.GetInt("id")
+		if !model.IsTwoFAEnabled(userId) {
+			c.Next()
+			return
+		}
+		session := sessions.Default(c)
+		verifiedAt, ok := session.Get(common.TwoFAVerifiedAtSessionKey).(int64)
+		if ok && common.GetTimestamp()-verifiedAt <= int64(common.TwoFARecentSeconds) {
+			c.Next()
+			return
+		}
+		if code := c.Request.Header.Get("X-TOTP-Code"); code != "" && model.VerifyUserTwoFA(userId, code) == nil {
+			c.Next()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":     false,
+			"message":     "该操作需要先完成两步验证",
+			"require_2fa": true,
+		})
+		c.Abort()
+	}
+}
+
 func TryUserAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		session := sessions.Default(c)
-		id := session.Get("id")
-		if id != nil {
+		id, ok := session.Get("id").(int)
+		sessionId, _ := session.Get(common.SessionIdKey).(string)
+		if ok && model.ValidateUserSession(sessionId, id) {
 			c.Set("id", id)
 		}
 		c.Next()
@@ -123,6 +241,26 @@ func TokenAuth() func(c *gin.Context) {
 			abortWithOpenAiMessage(c, http.StatusForbidden, "用户已被封禁")
 			return
 		}
+		chainEnabled, err := model.IsResellerChainEnabled(token.UserId)
+		if err != nil || !chainEnabled {
+			abortWithOpenAiMessage(c, http.StatusForbidden, "上级代理已被禁用")
+			return
+		}
+		markupRatio, err := model.CacheGetUserMarkupRatio(token.UserId)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Set("markup_ratio", markupRatio)
+		if token.OrgId != 0 {
+			orgGroup, err := model.ValidateOrganizationToken(token.OrgId, token.UserId)
+			if err != nil {
+				abortWithOpenAiMessage(c, http.StatusForbidden, err.Error())
+				return
+			}
+			c.Set("org_id", token.OrgId)
+			c.Set("org_group", orgGroup)
+		}
 		c.Set("id", token.UserId)
 		c.Set("token_id", token.Id)
 		c.Set("token_name", token.Name)
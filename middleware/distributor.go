@@ -26,6 +26,10 @@ func Distribute() func(c *gin.Context) {
 		channelId, ok := c.Get("specific_channel_id")
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
 		userGroup, _ := model.CacheGetUserGroup(userId)
+		// 组织令牌优先使用组织配置的分组
+		if orgGroup := c.GetString("org_group"); orgGroup != "" {
+			userGroup = orgGroup
+		}
 		c.Set("group", userGroup)
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
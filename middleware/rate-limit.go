@@ -101,3 +101,7 @@ func DownloadRateLimit() func(c *gin.Context) {
 func UploadRateLimit() func(c *gin.Context) {
 	return rateLimitFactory(common.UploadRateLimitNum, common.UploadRateLimitDuration, "UP")
 }
+
+func TokenizeRateLimit() func(c *gin.Context) {
+	return rateLimitFactory(common.TokenizeRateLimitNum, common.TokenizeRateLimitDuration, "TK")
+}
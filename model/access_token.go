@@ -0,0 +1,174 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strings"
+)
+
+const (
+	// maxAccessTokensPerUser 每个用户可创建的管理令牌数量上限
+	maxAccessTokensPerUser = 50
+	// accessTokenTouchSeconds 最近使用时间的更新间隔，避免每次请求都写库
+	accessTokenTouchSeconds = 60
+	// legacyAccessTokenName 由旧版 User.AccessToken 迁移而来的令牌名称
+	legacyAccessTokenName = "legacy"
+)
+
+// AccessToken 用户的管理令牌，用于以 Authorization 请求头调用管理接口。
+// 只保存令牌的摘要，明文仅在创建时返回一次
+type AccessToken struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Name         string `json:"name" gorm:"type:varchar(64)"`
+	KeyHash      string `json:"-" gorm:"type:char(64);uniqueIndex"`
+	KeyPrefix    string `json:"key_prefix" gorm:"type:varchar(8)"` // 便于用户辨认令牌
+	Scopes       string `json:"scopes" gorm:"type:text"`
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 表示永不过期
+	LastUsedTime int64  `json:"last_used_time" gorm:"bigint;default:0"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+}
+
+func hashAccessTokenKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// accessTokenPlaceholder 生成 User.AccessToken 列的占位值。该列带有唯一索引且已不再用于认证，
+// 以 x 开头与旧版的十六进制令牌区分，迁移时据此跳过
+func accessTokenPlaceholder() string {
+	return "x" + common.GetUUID()[1:]
+}
+
+func (token *AccessToken) GetScopes() []string {
+	var scopes []string
+	if token.Scopes != "" {
+		_ = json.Unmarshal([]byte(token.Scopes), &scopes)
+	}
+	return scopes
+}
+
+func (token *AccessToken) SetScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("请至少选择一个作用域")
+	}
+	for _, scope := range scopes {
+		if !common.IsValidScope(scope) {
+			return fmt.Errorf("未知的作用域：%s", scope)
+		}
+	}
+	data, err := json.Marshal(scopes)
+	if err != nil {
+		return err
+	}
+	token.Scopes = string(data)
+	return nil
+}
+
+// Insert 生成令牌并保存，返回仅此一次可见的明文令牌
+func (token *AccessToken) Insert() (string, error) {
+	var count int64
+	if err := DB.Model(&AccessToken{}).Where("user_id = ?", token.UserId).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count >= maxAccessTokensPerUser {
+		return "", fmt.Errorf("管理令牌数量已达上限 %d 个", maxAccessTokensPerUser)
+	}
+	if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
+		return "", errors.New("过期时间不能早于当前时间")
+	}
+	key := common.GetUUID()
+	token.KeyHash = hashAccessTokenKey(key)
+	token.KeyPrefix = key[:4]
+	token.LastUsedTime = 0
+	token.CreatedTime = common.GetTimestamp()
+	if err := DB.Create(token).Error; err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func GetUserAccessTokens(userId int) (tokens []*AccessToken, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Find(&tokens).Error
+	return tokens, err
+}
+
+func DeleteAccessTokenById(id int, userId int) error {
+	result := DB.Where("id = ? and user_id = ?", id, userId).Delete(&AccessToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("令牌不存在")
+	}
+	return nil
+}
+
+func DeleteAccessTokensByName(userId int, name string) error {
+	return DB.Where("user_id = ? and name = ?", userId, name).Delete(&AccessToken{}).Error
+}
+
+// ValidateAccessToken 校验管理令牌，返回令牌及其所属用户，并按间隔刷新最近使用时间
+func ValidateAccessToken(key string) (*AccessToken, *User) {
+	key = strings.TrimSpace(strings.TrimPrefix(key, "Bearer "))
+	if key == "" {
+		return nil, nil
+	}
+	token := &AccessToken{}
+	if err := DB.Where("key_hash = ?", hashAccessTokenKey(key)).First(token).Error; err != nil {
+		return nil, nil
+	}
+	now := common.GetTimestamp()
+	if token.ExpiredTime != -1 && token.ExpiredTime < now {
+		return nil, nil
+	}
+	user := &User{}
+	if err := DB.First(user, "id = ?", token.UserId).Error; err != nil {
+		return nil, nil
+	}
+	if now-token.LastUsedTime >= accessTokenTouchSeconds {
+		DB.Model(token).Update("last_used_time", now)
+	}
+	return token, user
+}
+
+// migrateLegacyAccessTokens 将旧版 User.AccessToken 迁移为拥有全部作用域的命名令牌，
+// 已有的调用方可继续使用原令牌，原列改写为占位值
+func migrateLegacyAccessTokens() error {
+	var users []*User
+	err := DB.Select("id", "access_token").
+		Where("access_token <> '' and access_token not like ?", "x%").Find(&users).Error
+	if err != nil {
+		return err
+	}
+	now := common.GetTimestamp()
+	for _, user := range users {
+		prefix := user.AccessToken
+		if len(prefix) > 4 {
+			prefix = prefix[:4]
+		}
+		token := &AccessToken{
+			UserId:      user.Id,
+			Name:        legacyAccessTokenName,
+			KeyHash:     hashAccessTokenKey(user.AccessToken),
+			KeyPrefix:   prefix,
+			Scopes:      `["*"]`,
+			ExpiredTime: -1,
+			CreatedTime: now,
+		}
+		if err = DB.Create(token).Error; err != nil {
+			return err
+		}
+		if err = DB.Model(&User{}).Where("id = ?", user.Id).Update("access_token", accessTokenPlaceholder()).Error; err != nil {
+			return err
+		}
+	}
+	if len(users) > 0 {
+		common.SysLog(fmt.Sprintf("migrated %d legacy access tokens", len(users)))
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 返佣来源
+const (
+	AffCommissionSourceEpay       = "epay"
+	AffCommissionSourceStripe     = "stripe"
+	AffCommissionSourceRedemption = "redemption"
+)
+
+// AffCommission 邀请返佣记录，被邀请人每次充值按层级向各级邀请人发放，返佣计入邀请人的 AffQuota
+type AffCommission struct {
+	Id          int     `json:"id"`
+	InviterId   int     `json:"inviter_id" gorm:"index;uniqueIndex:idx_aff_commission_source,priority:3"`
+	InviteeId   int     `json:"invitee_id" gorm:"index"`
+	Level       int     `json:"level"` // 1 表示直接邀请
+	Source      string  `json:"source" gorm:"type:varchar(32);uniqueIndex:idx_aff_commission_source,priority:1"`
+	Reference   string  `json:"reference" gorm:"type:varchar(64);uniqueIndex:idx_aff_commission_source,priority:2"` // 订单号或兑换码 ID，防止重复发放
+	TopUpQuota  int     `json:"topup_quota" gorm:"column:topup_quota"`
+	Rate        float64 `json:"rate"`
+	Quota       int     `json:"quota"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint;index"`
+}
+
+// AffCommissionSummary 邀请人从每个被邀请人处获得的返佣汇总
+type AffCommissionSummary struct {
+	InviteeId   int    `json:"invitee_id"`
+	Username    string `json:"username" gorm:"-"`
+	Level       int    `json:"level"`
+	Count       int    `json:"count"`
+	TopUpQuota  int    `json:"topup_quota" gorm:"column:topup_quota"`
+	Quota       int    `json:"quota"`
+	LastTopUpAt int64  `json:"last_topup_at" gorm:"column:last_topup_at"`
+}
+
+// GrantAffCommission 被邀请人充值成功后沿邀请链向各级邀请人发放返佣。
+// 返佣失败只记录系统日志，不影响充值本身
+func GrantAffCommission(inviteeId int, topUpQuota int, source string, reference string) {
+	if !common.AffCommissionEnabled || topUpQuota <= 0 || len(common.AffCommissionRates) == 0 {
+		return
+	}
+	invitee, err := GetUserById(inviteeId, false)
+	if err != nil {
+		return
+	}
+	// 时间限制以充值用户的注册时间为准，早期没有注册时间的用户不参与限时返佣
+	if common.AffCommissionDays > 0 &&
+		(invitee.CreatedTime == 0 || common.GetTimestamp()-invitee.CreatedTime > int64(common.AffCommissionDays)*86400) {
+		return
+	}
+	rates := common.AffCommissionRates
+	current := invitee
+	visited := map[int]bool{invitee.Id: true}
+	for i, rate := range rates {
+		inviterId := current.InviterId
+		if inviterId == 0 || visited[inviterId] {
+			break
+		}
+		visited[inviterId] = true
+		quota := int(float64(topUpQuota) * rate / 100)
+		if quota > 0 {
+			granted, err := grantAffCommission(&AffCommission{
+				InviterId:  inviterId,
+				InviteeId:  invitee.Id,
+				Level:      i + 1,
+				Source:     source,
+				Reference:  reference,
+				TopUpQuota: topUpQuota,
+				Rate:       rate,
+				Quota:      quota,
+			})
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to grant aff commission to user %d: %s", inviterId, err.Error()))
+			} else if granted > 0 {
+				RecordLog(inviterId, LogTypeSystem, fmt.Sprintf("邀请用户 %s 充值，获得 %d 级邀请返佣 %s", invitee.Username, i+1, common.LogQuota(granted)))
+			}
+		}
+		if current, err = GetUserById(inviterId, false); err != nil {
+			break
+		}
+	}
+}
+
+// grantAffCommission 在事务中按上限发放一笔返佣，返回实际发放的额度
+func grantAffCommission(commission *AffCommission) (granted int, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		// 锁定邀请人，保证并发充值时累计返佣不超过上限
+		var inviter User
+		if err := lockForUpdate(tx).Select("id").First(&inviter, "id = ?", commission.InviterId).Error; err != nil {
+			return err
+		}
+		if common.AffCommissionCap > 0 {
+			var total int64
+			err := tx.Model(&AffCommission{}).Where("inviter_id = ?", commission.InviterId).
+				Select("coalesce(sum(quota), 0)").Scan(&total).Error
+			if err != nil {
+				return err
+			}
+			remaining := int64(common.AffCommissionCap) - total
+			if remaining <= 0 {
+				return nil
+			}
+			if int64(commission.Quota) > remaining {
+				commission.Quota = int(remaining)
+			}
+		}
+		commission.CreatedTime = common.GetTimestamp()
+		if err := tx.Create(commission).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&User{}).Where("id = ?", commission.InviterId).Updates(map[string]interface{}{
+			"aff_quota":   gorm.Expr("aff_quota + ?", commission.Quota),
+			"aff_history": gorm.Expr("aff_history + ?", commission.Quota),
+		}).Error
+		if err != nil {
+			return err
+		}
+		granted = commission.Quota
+		return nil
+	})
+	return granted, err
+}
+
+func GetAffCommissions(inviterId int, inviteeId int, startIdx int, num int) (commissions []*AffCommission, total int64, err error) {
+	tx := DB.Model(&AffCommission{}).Where("inviter_id = ?", inviterId)
+	if inviteeId != 0 {
+		tx = tx.Where("invitee_id = ?", inviteeId)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&commissions).Error
+	return commissions, total, err
+}
+
+// GetAffCommissionSummaries 按被邀请人汇总邀请人获得的返佣
+func GetAffCommissionSummaries(inviterId int) (summaries []*AffCommissionSummary, err error) {
+	err = DB.Model(&AffCommission{}).
+		Select("invitee_id, min(level) as level, count(*) as count, sum(topup_quota) as topup_quota, sum(quota) as quota, max(created_time) as last_topup_at").
+		Where("inviter_id = ?", inviterId).Group("invitee_id").Order("quota desc").
+		Scan(&summaries).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range summaries {
+		summary.Username, _ = CacheGetUsername(summary.InviteeId)
+	}
+	return summaries, nil
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+)
+
+func TestGrantAffCommissionCap(t *testing.T) {
+	enabled, rates, days, limit := common.AffCommissionEnabled, common.AffCommissionRates, common.AffCommissionDays, common.AffCommissionCap
+	defer func() {
+		common.AffCommissionEnabled, common.AffCommissionRates, common.AffCommissionDays, common.AffCommissionCap = enabled, rates, days, limit
+	}()
+	common.AffCommissionEnabled = true
+	common.AffCommissionRates = []float64{10, 5}
+	common.AffCommissionDays = 0
+	common.AffCommissionCap = 150
+
+	grandInviter := createTestUser(t, "aff_grand_inviter", 0)
+	inviter := createTestUser(t, "aff_inviter", 0)
+	invitee := createTestUser(t, "aff_invitee", 0)
+	DB.Model(inviter).Update("inviter_id", grandInviter.Id)
+	DB.Model(invitee).Update("inviter_id", inviter.Id)
+
+	affQuota := func(userId int) int {
+		user, err := GetUserById(userId, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.AffQuota
+	}
+
+	GrantAffCommission(invitee.Id, 1000, AffCommissionSourceEpay, "order-1")
+	if affQuota(inviter.Id) != 100 || affQuota(grandInviter.Id) != 50 {
+		t.Fatalf("expected 100 and 50, got %d and %d", affQuota(inviter.Id), affQuota(grandInviter.Id))
+	}
+	// 同一订单重复回调不会重复返佣
+	GrantAffCommission(invitee.Id, 1000, AffCommissionSourceEpay, "order-1")
+	if affQuota(inviter.Id) != 100 {
+		t.Fatalf("expected the same order to be granted once, got %d", affQuota(inviter.Id))
+	}
+	GrantAffCommission(invitee.Id, 1000, AffCommissionSourceEpay, "order-2")
+	if affQuota(inviter.Id) != 150 || affQuota(grandInviter.Id) != 100 {
+		t.Fatalf("expected the first level to stop at the cap, got %d and %d", affQuota(inviter.Id), affQuota(grandInviter.Id))
+	}
+	GrantAffCommission(invitee.Id, 1000, AffCommissionSourceEpay, "order-3")
+	if affQuota(inviter.Id) != 150 || affQuota(grandInviter.Id) != 150 {
+		t.Fatalf("expected both levels to stop at the cap, got %d and %d", affQuota(inviter.Id), affQuota(grandInviter.Id))
+	}
+}
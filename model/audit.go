@@ -0,0 +1,194 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AuditLog 管理操作审计记录，只允许追加。Diff 为 JSON 对象，
+// 键为发生变化的字段，值为 {"before": ..., "after": ...}，敏感字段已脱敏
+type AuditLog struct {
+	Id         int    `json:"id"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint;index"`
+	ActorId    int    `json:"actor_id" gorm:"index"`
+	ActorName  string `json:"actor_name" gorm:"type:varchar(64);default:''"`
+	Ip         string `json:"ip" gorm:"type:varchar(64);default:''"`
+	Action     string `json:"action" gorm:"type:varchar(64);index"`
+	TargetType string `json:"target_type" gorm:"type:varchar(32);index:idx_audit_target,priority:1"`
+	TargetId   string `json:"target_id" gorm:"type:varchar(64);index:idx_audit_target,priority:2"`
+	Diff       string `json:"diff" gorm:"type:text"`
+}
+
+const (
+	AuditTargetChannel      = "channel"
+	AuditTargetOption       = "option"
+	AuditTargetUser         = "user"
+	AuditTargetRedemption   = "redemption"
+	AuditTargetToken        = "token"
+	AuditTargetGroup        = "group"
+	AuditTargetOrganization = "organization"
+	AuditTargetRole         = "role"
+	AuditTargetCampaign     = "redemption_campaign"
+	AuditTargetSubscription = "subscription"
+)
+
+const auditMaskedValue = "******"
+
+var errAuditImmutable = errors.New("audit log is append-only")
+
+func (audit *AuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return errAuditImmutable
+}
+
+func (audit *AuditLog) BeforeDelete(tx *gorm.DB) error {
+	return errAuditImmutable
+}
+
+// isSensitiveAuditField 判断字段是否包含密钥、密码等敏感信息
+func isSensitiveAuditField(field string) bool {
+	name := strings.ToLower(field)
+	return strings.HasSuffix(name, "key") ||
+		strings.HasSuffix(name, "token") ||
+		strings.Contains(name, "secret") ||
+		strings.Contains(name, "password")
+}
+
+func auditFields(value any) map[string]any {
+	fields := make(map[string]any)
+	if value == nil {
+		return fields
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		// 非对象类型的值统一记为 value 字段
+		var raw any
+		_ = json.Unmarshal(data, &raw)
+		return map[string]any{"value": raw}
+	}
+	return fields
+}
+
+func maskAuditValue(field string, value any) any {
+	if value == nil || !isSensitiveAuditField(field) {
+		return value
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return value
+	}
+	return auditMaskedValue
+}
+
+// BuildAuditDiff 比较操作前后的对象，返回发生变化的字段。before 为 nil 表示创建，after 为 nil 表示删除
+func BuildAuditDiff(before any, after any) string {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+	keys := make([]string, 0, len(beforeFields)+len(afterFields))
+	for key := range beforeFields {
+		keys = append(keys, key)
+	}
+	for key := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	diff := make(map[string]map[string]any)
+	for _, key := range keys {
+		oldValue, newValue := beforeFields[key], afterFields[key]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		diff[key] = map[string]any{
+			"before": maskAuditValue(key, oldValue),
+			"after":  maskAuditValue(key, newValue),
+		}
+	}
+	data, err := json.Marshal(diff)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+func RecordAuditLog(audit *AuditLog) error {
+	audit.Id = 0
+	audit.CreatedAt = common.GetTimestamp()
+	return DB.Create(audit).Error
+}
+
+// AuditLogFilter 审计记录的筛选条件
+type AuditLogFilter struct {
+	ActorId        int
+	ActorName      string
+	Action         string
+	TargetType     string
+	TargetId       string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+func (filter *AuditLogFilter) apply(tx *gorm.DB) *gorm.DB {
+	if filter.ActorId != 0 {
+		tx = tx.Where("actor_id = ?", filter.ActorId)
+	}
+	if filter.ActorName != "" {
+		tx = tx.Where("actor_name = ?", filter.ActorName)
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		tx = tx.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetId != "" {
+		tx = tx.Where("target_id = ?", filter.TargetId)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	return tx
+}
+
+func SearchAuditLogs(filter AuditLogFilter, startIdx int, num int) (audits []*AuditLog, total int64, err error) {
+	tx := filter.apply(DB.Model(&AuditLog{}))
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&audits).Error
+	return audits, total, err
+}
+
+// IterateAuditLogs 按 id 游标分批读取审计记录，fn 返回错误时停止
+func IterateAuditLogs(filter AuditLogFilter, batchSize int, fn func(audits []*AuditLog) error) error {
+	lastId := 0
+	for {
+		var audits []*AuditLog
+		err := filter.apply(DB.Model(&AuditLog{})).Where("id > ?", lastId).Order("id").Limit(batchSize).Find(&audits).Error
+		if err != nil {
+			return err
+		}
+		if len(audits) == 0 {
+			return nil
+		}
+		lastId = audits[len(audits)-1].Id
+		if err = fn(audits); err != nil {
+			return err
+		}
+		if len(audits) < batchSize {
+			return nil
+		}
+	}
+}
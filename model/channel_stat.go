@@ -0,0 +1,425 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelStat 渠道 + 模型按小时汇总的请求表现数据
+type ChannelStat struct {
+	Id        int    `json:"id"`
+	ChannelId int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_stat,priority:1"`
+	ModelName string `json:"model_name" gorm:"size:128;uniqueIndex:idx_channel_stat,priority:2;default:''"`
+	// CreatedAt 所在小时的起始时间
+	CreatedAt        int64 `json:"created_at" gorm:"bigint;uniqueIndex:idx_channel_stat,priority:3;index"`
+	RequestCount     int   `json:"request_count" gorm:"default:0"`
+	SuccessCount     int   `json:"success_count" gorm:"default:0"`
+	ErrorCount       int   `json:"error_count" gorm:"default:0"`
+	RetryCount       int   `json:"retry_count" gorm:"default:0"`
+	PromptTokens     int   `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int   `json:"completion_tokens" gorm:"default:0"`
+	LatencySum       int64 `json:"latency_sum" gorm:"bigint;default:0"`
+	FirstResponseSum int64 `json:"first_response_sum" gorm:"bigint;default:0"`
+	// FirstResponseCount 记录了首字时间的请求数（流式请求）
+	FirstResponseCount int `json:"first_response_count" gorm:"default:0"`
+	// 以下字段为 JSON 格式
+	LatencyHistogram       string `json:"latency_histogram"`
+	FirstResponseHistogram string `json:"first_response_histogram"`
+	ErrorStatusCodes       string `json:"error_status_codes"`
+	ErrorTypes             string `json:"error_types"`
+}
+
+// channelStatBuckets 延迟直方图各区间的上限（毫秒），最后一个区间没有上限
+var channelStatBuckets = []int64{50, 100, 200, 300, 500, 750, 1000, 1500, 2000, 3000, 5000, 7500, 10000, 15000, 20000, 30000, 45000, 60000, 90000, 120000, 180000, 300000}
+
+// ChannelRequestEvent 一次发往渠道的请求结果
+type ChannelRequestEvent struct {
+	ChannelId        int
+	ModelName        string
+	Success          bool
+	IsRetry          bool
+	StatusCode       int
+	ErrorType        string
+	PromptTokens     int
+	CompletionTokens int
+	// Latency 请求总耗时（毫秒）
+	Latency int64
+	// FirstResponse 首字时间（毫秒），为 0 表示未记录
+	FirstResponse int64
+}
+
+// channelStatCache 内存中尚未写入数据库的汇总数据
+type channelStatCache struct {
+	stat                   ChannelStat
+	latencyHistogram       []int
+	firstResponseHistogram []int
+	errorStatusCodes       map[string]int
+	errorTypes             map[string]int
+}
+
+var channelStatCaches = make(map[string]*channelStatCache)
+var channelStatCacheLock sync.Mutex
+
+func channelStatBucketIndex(value int64) int {
+	return sort.Search(len(channelStatBuckets), func(i int) bool {
+		return value <= channelStatBuckets[i]
+	})
+}
+
+func newChannelStatCache(channelId int, modelName string, createdAt int64) *channelStatCache {
+	return &channelStatCache{
+		stat: ChannelStat{
+			ChannelId: channelId,
+			ModelName: modelName,
+			CreatedAt: createdAt,
+		},
+		latencyHistogram:       make([]int, len(channelStatBuckets)+1),
+		firstResponseHistogram: make([]int, len(channelStatBuckets)+1),
+		errorStatusCodes:       make(map[string]int),
+		errorTypes:             make(map[string]int),
+	}
+}
+
+// RecordChannelRequest 记录渠道请求结果，定时汇总写入数据库
+func RecordChannelRequest(event ChannelRequestEvent) {
+	if event.ChannelId == 0 {
+		return
+	}
+	createdAt := common.GetTimestamp()
+	createdAt = createdAt - (createdAt % 3600)
+	key := fmt.Sprintf("%d-%s-%d", event.ChannelId, event.ModelName, createdAt)
+
+	channelStatCacheLock.Lock()
+	defer channelStatCacheLock.Unlock()
+	cache, ok := channelStatCaches[key]
+	if !ok {
+		cache = newChannelStatCache(event.ChannelId, event.ModelName, createdAt)
+		channelStatCaches[key] = cache
+	}
+	stat := &cache.stat
+	stat.RequestCount++
+	if event.IsRetry {
+		stat.RetryCount++
+	}
+	if event.Success {
+		stat.SuccessCount++
+		stat.PromptTokens += event.PromptTokens
+		stat.CompletionTokens += event.CompletionTokens
+		stat.LatencySum += event.Latency
+		cache.latencyHistogram[channelStatBucketIndex(event.Latency)]++
+		if event.FirstResponse > 0 {
+			stat.FirstResponseCount++
+			stat.FirstResponseSum += event.FirstResponse
+			cache.firstResponseHistogram[channelStatBucketIndex(event.FirstResponse)]++
+		}
+	} else {
+		stat.ErrorCount++
+		cache.errorStatusCodes[strconv.Itoa(event.StatusCode)]++
+		if event.ErrorType != "" {
+			cache.errorTypes[event.ErrorType]++
+		}
+	}
+}
+
+func decodeHistogram(value string) []int {
+	histogram := make([]int, len(channelStatBuckets)+1)
+	var stored []int
+	_ = json.Unmarshal([]byte(value), &stored)
+	for i := 0; i < len(stored) && i < len(histogram); i++ {
+		histogram[i] = stored[i]
+	}
+	return histogram
+}
+
+func decodeCountMap(value string) map[string]int {
+	counts := make(map[string]int)
+	_ = json.Unmarshal([]byte(value), &counts)
+	return counts
+}
+
+func channelStatJson(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func mergeHistogram(target []int, source []int) {
+	for i := range source {
+		target[i] += source[i]
+	}
+}
+
+func mergeCountMap(target map[string]int, source map[string]int) {
+	for k, v := range source {
+		target[k] += v
+	}
+}
+
+// mergeChannelStatCache 将 source 的计数累加到 target
+func mergeChannelStatCache(target *channelStatCache, source *channelStatCache) {
+	target.stat.RequestCount += source.stat.RequestCount
+	target.stat.SuccessCount += source.stat.SuccessCount
+	target.stat.ErrorCount += source.stat.ErrorCount
+	target.stat.RetryCount += source.stat.RetryCount
+	target.stat.PromptTokens += source.stat.PromptTokens
+	target.stat.CompletionTokens += source.stat.CompletionTokens
+	target.stat.LatencySum += source.stat.LatencySum
+	target.stat.FirstResponseSum += source.stat.FirstResponseSum
+	target.stat.FirstResponseCount += source.stat.FirstResponseCount
+	mergeHistogram(target.latencyHistogram, source.latencyHistogram)
+	mergeHistogram(target.firstResponseHistogram, source.firstResponseHistogram)
+	mergeCountMap(target.errorStatusCodes, source.errorStatusCodes)
+	mergeCountMap(target.errorTypes, source.errorTypes)
+}
+
+// saveChannelStat 将缓存合并到数据库中对应的小时记录
+func saveChannelStat(cache *channelStatCache) error {
+	return LOG_DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("channel_id = ? and model_name = ? and created_at = ?", cache.stat.ChannelId, cache.stat.ModelName, cache.stat.CreatedAt)
+		if !common.LogUsingSQLite {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		var existing ChannelStat
+		if err := query.Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		// 在副本上合并，写入失败时缓存保持不变，可以重试或放回
+		merged := newChannelStatCache(cache.stat.ChannelId, cache.stat.ModelName, cache.stat.CreatedAt)
+		mergeChannelStatCache(merged, cache)
+		if existing.Id != 0 {
+			mergeChannelStatCache(merged, &channelStatCache{
+				stat:                   existing,
+				latencyHistogram:       decodeHistogram(existing.LatencyHistogram),
+				firstResponseHistogram: decodeHistogram(existing.FirstResponseHistogram),
+				errorStatusCodes:       decodeCountMap(existing.ErrorStatusCodes),
+				errorTypes:             decodeCountMap(existing.ErrorTypes),
+			})
+		}
+		stat := merged.stat
+		stat.Id = existing.Id
+		stat.LatencyHistogram = channelStatJson(merged.latencyHistogram)
+		stat.FirstResponseHistogram = channelStatJson(merged.firstResponseHistogram)
+		stat.ErrorStatusCodes = channelStatJson(merged.errorStatusCodes)
+		stat.ErrorTypes = channelStatJson(merged.errorTypes)
+		return tx.Save(&stat).Error
+	})
+}
+
+// SaveChannelStatCache 将内存中的渠道统计写入数据库
+func SaveChannelStatCache() {
+	channelStatCacheLock.Lock()
+	caches := channelStatCaches
+	channelStatCaches = make(map[string]*channelStatCache)
+	channelStatCacheLock.Unlock()
+	for key, cache := range caches {
+		err := saveChannelStat(cache)
+		if err != nil {
+			// 多个节点同时插入同一小时的记录时唯一索引冲突，重试时记录已存在，按更新合并
+			err = saveChannelStat(cache)
+		}
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to save channel stat of channel #%d: %s", cache.stat.ChannelId, err.Error()))
+			restoreChannelStatCache(key, cache)
+		}
+	}
+}
+
+// restoreChannelStatCache 写入失败时将缓存放回，下次保存时一并写入
+func restoreChannelStatCache(key string, cache *channelStatCache) {
+	channelStatCacheLock.Lock()
+	defer channelStatCacheLock.Unlock()
+	if current, ok := channelStatCaches[key]; ok {
+		mergeChannelStatCache(current, cache)
+		return
+	}
+	channelStatCaches[key] = cache
+}
+
+func UpdateChannelStats(frequency int) {
+	if frequency <= 0 {
+		frequency = 60
+	}
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		SaveChannelStatCache()
+	}
+}
+
+// LatencySummary 延迟的平均值与分位数（毫秒）
+type LatencySummary struct {
+	Count int   `json:"count"`
+	Avg   int64 `json:"avg"`
+	P50   int64 `json:"p50"`
+	P95   int64 `json:"p95"`
+	P99   int64 `json:"p99"`
+}
+
+// histogramPercentile 在直方图所在区间内线性插值估算分位数
+func histogramPercentile(histogram []int, total int, percentile float64) int64 {
+	if total == 0 {
+		return 0
+	}
+	target := percentile * float64(total)
+	cumulative := 0
+	for i, count := range histogram {
+		if count == 0 {
+			continue
+		}
+		if float64(cumulative+count) >= target {
+			lower := int64(0)
+			if i > 0 {
+				lower = channelStatBuckets[i-1]
+			}
+			if i >= len(channelStatBuckets) {
+				return lower
+			}
+			upper := channelStatBuckets[i]
+			return lower + int64(float64(upper-lower)*(target-float64(cumulative))/float64(count))
+		}
+		cumulative += count
+	}
+	return channelStatBuckets[len(channelStatBuckets)-1]
+}
+
+func summarizeLatency(histogram []int, count int, sum int64) LatencySummary {
+	summary := LatencySummary{Count: count}
+	if count == 0 {
+		return summary
+	}
+	summary.Avg = sum / int64(count)
+	summary.P50 = histogramPercentile(histogram, count, 0.50)
+	summary.P95 = histogramPercentile(histogram, count, 0.95)
+	summary.P99 = histogramPercentile(histogram, count, 0.99)
+	return summary
+}
+
+// ChannelStatSummary 一段时间内的渠道表现汇总
+type ChannelStatSummary struct {
+	ChannelId        int            `json:"channel_id"`
+	ModelName        string         `json:"model_name,omitempty"`
+	CreatedAt        int64          `json:"created_at,omitempty"`
+	RequestCount     int            `json:"request_count"`
+	SuccessCount     int            `json:"success_count"`
+	ErrorCount       int            `json:"error_count"`
+	SuccessRate      float64        `json:"success_rate"`
+	RetryCount       int            `json:"retry_count"`
+	PromptTokens     int            `json:"prompt_tokens"`
+	CompletionTokens int            `json:"completion_tokens"`
+	Latency          LatencySummary `json:"latency"`
+	FirstResponse    LatencySummary `json:"first_response"`
+	ErrorStatusCodes map[string]int `json:"error_status_codes"`
+	ErrorTypes       map[string]int `json:"error_types"`
+
+	latencySum             int64
+	firstResponseSum       int64
+	firstResponseCount     int
+	latencyHistogram       []int
+	firstResponseHistogram []int
+}
+
+func (summary *ChannelStatSummary) add(stat *ChannelStat) {
+	if summary.latencyHistogram == nil {
+		summary.latencyHistogram = make([]int, len(channelStatBuckets)+1)
+		summary.firstResponseHistogram = make([]int, len(channelStatBuckets)+1)
+		summary.ErrorStatusCodes = make(map[string]int)
+		summary.ErrorTypes = make(map[string]int)
+	}
+	summary.RequestCount += stat.RequestCount
+	summary.SuccessCount += stat.SuccessCount
+	summary.ErrorCount += stat.ErrorCount
+	summary.RetryCount += stat.RetryCount
+	summary.PromptTokens += stat.PromptTokens
+	summary.CompletionTokens += stat.CompletionTokens
+	summary.latencySum += stat.LatencySum
+	summary.firstResponseSum += stat.FirstResponseSum
+	summary.firstResponseCount += stat.FirstResponseCount
+	mergeHistogram(summary.latencyHistogram, decodeHistogram(stat.LatencyHistogram))
+	mergeHistogram(summary.firstResponseHistogram, decodeHistogram(stat.FirstResponseHistogram))
+	mergeCountMap(summary.ErrorStatusCodes, decodeCountMap(stat.ErrorStatusCodes))
+	mergeCountMap(summary.ErrorTypes, decodeCountMap(stat.ErrorTypes))
+}
+
+func (summary *ChannelStatSummary) finish() {
+	if summary.RequestCount > 0 {
+		summary.SuccessRate = float64(summary.SuccessCount) / float64(summary.RequestCount)
+	}
+	summary.Latency = summarizeLatency(summary.latencyHistogram, summary.SuccessCount, summary.latencySum)
+	summary.FirstResponse = summarizeLatency(summary.firstResponseHistogram, summary.firstResponseCount, summary.firstResponseSum)
+}
+
+// ChannelStatQuery 渠道统计的查询条件，GroupBy 为 channel、model 或 hour
+type ChannelStatQuery struct {
+	ChannelId      int
+	ModelName      string
+	StartTimestamp int64
+	EndTimestamp   int64
+	GroupBy        string
+}
+
+// GetChannelStatSummaries 按渠道、渠道 + 模型或按小时汇总渠道表现
+func GetChannelStatSummaries(query ChannelStatQuery) ([]*ChannelStatSummary, error) {
+	tx := LOG_DB.Model(&ChannelStat{})
+	if query.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", query.ChannelId)
+	}
+	if query.ModelName != "" {
+		tx = tx.Where("model_name = ?", query.ModelName)
+	}
+	if query.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", query.StartTimestamp-(query.StartTimestamp%3600))
+	}
+	if query.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", query.EndTimestamp)
+	}
+	summaries := make([]*ChannelStatSummary, 0)
+	summaryMap := make(map[string]*ChannelStatSummary)
+	err := tx.FindInBatches(&[]*ChannelStat{}, 1000, func(batch *gorm.DB, _ int) error {
+		stats := *batch.Statement.Dest.(*[]*ChannelStat)
+		for _, stat := range stats {
+			var key string
+			summary := &ChannelStatSummary{ChannelId: stat.ChannelId}
+			switch query.GroupBy {
+			case "model":
+				key = fmt.Sprintf("%d-%s", stat.ChannelId, stat.ModelName)
+				summary.ModelName = stat.ModelName
+			case "hour":
+				key = strconv.FormatInt(stat.CreatedAt, 10)
+				summary.ChannelId = query.ChannelId
+				summary.CreatedAt = stat.CreatedAt
+			default:
+				key = strconv.Itoa(stat.ChannelId)
+			}
+			if existing, ok := summaryMap[key]; ok {
+				summary = existing
+			} else {
+				summaryMap[key] = summary
+				summaries = append(summaries, summary)
+			}
+			summary.add(stat)
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range summaries {
+		summary.finish()
+	}
+	if query.GroupBy == "hour" {
+		sort.Slice(summaries, func(i, j int) bool {
+			return summaries[i].CreatedAt < summaries[j].CreatedAt
+		})
+	} else {
+		sort.Slice(summaries, func(i, j int) bool {
+			return summaries[i].RequestCount > summaries[j].RequestCount
+		})
+	}
+	return summaries, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestSaveChannelStatCacheMergesExistingRow(t *testing.T) {
+	event := ChannelRequestEvent{ChannelId: 9001, ModelName: "stat-model", Success: true, PromptTokens: 10, Latency: 120}
+	RecordChannelRequest(event)
+	RecordChannelRequest(ChannelRequestEvent{ChannelId: 9001, ModelName: "stat-model", StatusCode: 500, ErrorType: "upstream_error"})
+	SaveChannelStatCache()
+
+	RecordChannelRequest(event)
+	channelStatCacheLock.Lock()
+	var cache *channelStatCache
+	for _, c := range channelStatCaches {
+		if c.stat.ChannelId == 9001 {
+			cache = c
+		}
+	}
+	channelStatCacheLock.Unlock()
+	if cache == nil {
+		t.Fatal("expected the new request to be cached")
+	}
+	// 保存失败后重试时使用同一份缓存，合并不能改动缓存本身
+	if err := saveChannelStat(cache); err != nil {
+		t.Fatal(err)
+	}
+	if cache.stat.RequestCount != 1 || cache.latencyHistogram[channelStatBucketIndex(120)] != 1 {
+		t.Fatalf("expected the cache to be left unchanged, got %+v", cache.stat)
+	}
+	channelStatCacheLock.Lock()
+	channelStatCaches = make(map[string]*channelStatCache)
+	channelStatCacheLock.Unlock()
+
+	var stats []*ChannelStat
+	if err := LOG_DB.Where("channel_id = ?", 9001).Find(&stats).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected one hourly row, got %d", len(stats))
+	}
+	stat := stats[0]
+	if stat.RequestCount != 3 || stat.SuccessCount != 2 || stat.ErrorCount != 1 || stat.PromptTokens != 20 {
+		t.Fatalf("unexpected merged counts %+v", stat)
+	}
+	if histogram := decodeHistogram(stat.LatencyHistogram); histogram[channelStatBucketIndex(120)] != 2 {
+		t.Fatalf("expected two latency samples, got %v", histogram)
+	}
+	if codes := decodeCountMap(stat.ErrorStatusCodes); codes["500"] != 1 {
+		t.Fatalf("expected one 500 error, got %v", codes)
+	}
+}
+
+func TestRestoreChannelStatCache(t *testing.T) {
+	failed := newChannelStatCache(9002, "stat-model", 3600)
+	failed.stat.RequestCount = 2
+	failed.errorTypes["timeout"] = 2
+	current := newChannelStatCache(9002, "stat-model", 3600)
+	current.stat.RequestCount = 1
+	current.errorTypes["timeout"] = 1
+	key := "9002-stat-model-3600"
+	channelStatCacheLock.Lock()
+	channelStatCaches[key] = current
+	channelStatCacheLock.Unlock()
+
+	restoreChannelStatCache(key, failed)
+	channelStatCacheLock.Lock()
+	restored := channelStatCaches[key]
+	delete(channelStatCaches, key)
+	channelStatCacheLock.Unlock()
+	if restored.stat.RequestCount != 3 || restored.errorTypes["timeout"] != 3 {
+		t.Fatalf("expected the failed cache to be merged back, got %+v %v", restored.stat, restored.errorTypes)
+	}
+}
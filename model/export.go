@@ -0,0 +1,134 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+// LogFilter 日志导出的筛选条件，与日志列表接口的参数一致
+type LogFilter struct {
+	UserId         int
+	UserIds        []int // 不为 nil 时只包含这些用户的日志，用于代理导出下级日志
+	LogType        int
+	StartTimestamp int64
+	EndTimestamp   int64
+	ModelName      string
+	Username       string
+	TokenName      string
+	Channel        int
+	userIdSet      map[int]bool
+}
+
+func (filter *LogFilter) apply(tx *gorm.DB) *gorm.DB {
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.UserIds != nil {
+		tx = tx.Where("user_id in ?", filter.UserIds)
+	}
+	if filter.LogType != LogTypeUnknown {
+		tx = tx.Where("type = ?", filter.LogType)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.Username != "" {
+		tx = tx.Where("username = ?", filter.Username)
+	}
+	if filter.TokenName != "" {
+		tx = tx.Where("token_name = ?", filter.TokenName)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	if filter.Channel != 0 {
+		tx = tx.Where("channel_id = ?", filter.Channel)
+	}
+	return tx
+}
+
+// IterateLogs 按 id 游标分批读取日志，避免一次性加载全部数据，fn 返回错误时停止
+func IterateLogs(filter LogFilter, batchSize int, fn func(logs []*Log) error) error {
+	lastId := 0
+	for {
+		var logs []*Log
+		err := filter.apply(LOG_DB.Model(&Log{})).Where("id > ?", lastId).Order("id").Limit(batchSize).Find(&logs).Error
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		lastId = logs[len(logs)-1].Id
+		if err = fn(logs); err != nil {
+			return err
+		}
+		if len(logs) < batchSize {
+			return nil
+		}
+	}
+}
+
+// QuotaDataFilter 数据看板导出的筛选条件
+type QuotaDataFilter struct {
+	UserId         int
+	UserIds        []int // 不为 nil 时只包含这些用户的数据
+	Username       string
+	ModelName      string
+	TokenName      string
+	ChannelId      int
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+func (filter *QuotaDataFilter) apply(tx *gorm.DB) *gorm.DB {
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.UserIds != nil {
+		tx = tx.Where("user_id in ?", filter.UserIds)
+	}
+	if filter.Username != "" {
+		tx = tx.Where("username = ?", filter.Username)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.TokenName != "" {
+		tx = tx.Where("token_name = ?", filter.TokenName)
+	}
+	if filter.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", filter.ChannelId)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	return tx
+}
+
+// IterateQuotaData 按 id 游标分批读取数据看板数据
+func IterateQuotaData(filter QuotaDataFilter, batchSize int, fn func(quotaData []*QuotaData) error) error {
+	lastId := 0
+	for {
+		var quotaData []*QuotaData
+		err := filter.apply(LOG_DB.Model(&QuotaData{})).Where("id > ?", lastId).Order("id").Limit(batchSize).Find(&quotaData).Error
+		if err != nil {
+			return err
+		}
+		if len(quotaData) == 0 {
+			return nil
+		}
+		lastId = quotaData[len(quotaData)-1].Id
+		if err = fn(quotaData); err != nil {
+			return err
+		}
+		if len(quotaData) < batchSize {
+			return nil
+		}
+	}
+}
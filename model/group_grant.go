@@ -0,0 +1,190 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	UserGroupGrantStatusActive   = 1
+	UserGroupGrantStatusExpired  = 2
+	UserGroupGrantStatusReplaced = 3 // 被新的授予覆盖或被永久分组替代
+)
+
+// 分组授予来源
+const (
+	UserGroupGrantSourceRedemption   = "redemption"
+	UserGroupGrantSourceSubscription = "subscription"
+)
+
+// UserGroupGrant 限时分组授予记录，到期后用户分组恢复为 PreviousGroup。
+// 每个用户同时最多只有一条生效中的记录
+type UserGroupGrant struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	Group         string `json:"group" gorm:"type:varchar(64)"`
+	PreviousGroup string `json:"previous_group" gorm:"type:varchar(64)"`
+	Source        string `json:"source" gorm:"type:varchar(32)"`
+	Reference     string `json:"reference" gorm:"type:varchar(64)"`
+	Status        int    `json:"status" gorm:"index;default:1"`
+	ExpiredTime   int64  `json:"expired_time" gorm:"bigint;index"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+}
+
+func userGroupColumn() string {
+	if common.UsingPostgreSQL {
+		return `"group"`
+	}
+	return "`group`"
+}
+
+func cacheSetUserGroup(userId int, group string) {
+	if common.RedisEnabled {
+		_ = common.RedisSet(fmt.Sprintf("user_group:%d", userId), group, time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	}
+}
+
+// grantUserGroupTx 在事务中将用户调整到 group，seconds 为有效期，不大于 0 表示永久调整。
+// 与生效中的授予分组相同时顺延有效期；不同时覆盖原授予，到期后仍恢复为最初的分组
+func grantUserGroupTx(tx *gorm.DB, userId int, group string, seconds int64, source string, reference string) (*UserGroupGrant, error) {
+	if group == "" {
+		return nil, errors.New("分组为空")
+	}
+	var user User
+	err := lockForUpdate(tx).Select("id, "+userGroupColumn()).First(&user, "id = ?", userId).Error
+	if err != nil {
+		return nil, err
+	}
+	var active UserGroupGrant
+	err = tx.Where("user_id = ? and status = ?", userId, UserGroupGrantStatusActive).Limit(1).Find(&active).Error
+	if err != nil {
+		return nil, err
+	}
+	now := common.GetTimestamp()
+	var grant *UserGroupGrant
+	if seconds > 0 && active.Id != 0 && active.Group == group {
+		if active.ExpiredTime < now {
+			active.ExpiredTime = now
+		}
+		active.ExpiredTime += seconds
+		if err = tx.Model(&active).Update("expired_time", active.ExpiredTime).Error; err != nil {
+			return nil, err
+		}
+		grant = &active
+	} else {
+		if active.Id != 0 {
+			err = tx.Model(&active).Update("status", UserGroupGrantStatusReplaced).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+		if seconds > 0 {
+			grant = &UserGroupGrant{
+				UserId:        userId,
+				Group:         group,
+				PreviousGroup: user.Group,
+				Source:        source,
+				Reference:     reference,
+				Status:        UserGroupGrantStatusActive,
+				ExpiredTime:   now + seconds,
+				CreatedTime:   now,
+			}
+			if active.Id != 0 {
+				grant.PreviousGroup = active.PreviousGroup
+			}
+			if err = tx.Create(grant).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+	if user.Group != group {
+		if err = tx.Model(&User{}).Where("id = ?", userId).Update("group", group).Error; err != nil {
+			return nil, err
+		}
+	}
+	return grant, nil
+}
+
+// GetActiveUserGroupGrant 返回用户生效中的限时分组，没有时返回 nil
+func GetActiveUserGroupGrant(userId int) (*UserGroupGrant, error) {
+	var grant UserGroupGrant
+	err := DB.Where("user_id = ? and status = ?", userId, UserGroupGrantStatusActive).Limit(1).Find(&grant).Error
+	if err != nil || grant.Id == 0 {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// ExpireUserGroupGrants 将到期的限时分组恢复为授予前的分组。
+// 用户分组已被管理员改为其他分组时只结束授予记录，不覆盖管理员的调整。
+// 订阅授予的分组由订阅续费与到期处理统一结束，避免自动续费前被提前降级
+func ExpireUserGroupGrants() error {
+	var grants []*UserGroupGrant
+	err := DB.Where("status = ? and expired_time <= ? and source <> ?", UserGroupGrantStatusActive, common.GetTimestamp(), UserGroupGrantSourceSubscription).
+		Find(&grants).Error
+	if err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		restored := false
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			restored, err = expireUserGroupGrantTx(tx, grant)
+			return err
+		})
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to expire group grant %d: %s", grant.Id, err.Error()))
+			continue
+		}
+		if restored {
+			cacheSetUserGroup(grant.UserId, grant.PreviousGroup)
+			RecordLog(grant.UserId, LogTypeSystem, fmt.Sprintf("分组 %s 已到期，恢复为 %s", grant.Group, grant.PreviousGroup))
+		}
+	}
+	return nil
+}
+
+// expireUserGroupGrantTx 结束一条生效中的授予，返回是否恢复了用户分组
+func expireUserGroupGrantTx(tx *gorm.DB, grant *UserGroupGrant) (bool, error) {
+	var user User
+	err := lockForUpdate(tx).Select("id, "+userGroupColumn()).First(&user, "id = ?", grant.UserId).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	result := tx.Model(&UserGroupGrant{}).Where("id = ? and status = ?", grant.Id, UserGroupGrantStatusActive).
+		Update("status", UserGroupGrantStatusExpired)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	if user.Id == 0 || user.Group != grant.Group {
+		return false, nil
+	}
+	return true, tx.Model(&User{}).Where("id = ?", user.Id).Update("group", grant.PreviousGroup).Error
+}
+
+// endUserGroupGrantTx 提前结束用户来自 source 的限时分组，返回恢复后的分组，未恢复时返回空字符串
+func endUserGroupGrantTx(tx *gorm.DB, userId int, source string) (string, error) {
+	var grant UserGroupGrant
+	err := tx.Where("user_id = ? and status = ? and source = ?", userId, UserGroupGrantStatusActive, source).Limit(1).Find(&grant).Error
+	if err != nil || grant.Id == 0 {
+		return "", err
+	}
+	restored, err := expireUserGroupGrantTx(tx, &grant)
+	if err != nil || !restored {
+		return "", err
+	}
+	return grant.PreviousGroup, nil
+}
+
+func AutomaticallyExpireUserGroupGrants() {
+	for {
+		if err := ExpireUserGroupGrants(); err != nil {
+			common.SysError("failed to expire user group grants: " + err.Error())
+		}
+		time.Sleep(time.Minute)
+	}
+}
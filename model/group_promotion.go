@@ -0,0 +1,227 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// groupPromotionBaseGroup 不满足任何自动升级规则时的分组
+const groupPromotionBaseGroup = "default"
+
+// GroupPromotionStatus 用户的累计充值与自动升级进度
+type GroupPromotionStatus struct {
+	Group        string  `json:"group"`
+	PaidAmount   float64 `json:"paid_amount"`
+	LastPaidTime int64   `json:"last_paid_time"`
+	NextGroup    string  `json:"next_group"`
+	NextAmount   float64 `json:"next_amount"`
+}
+
+// GetUserPaidAmount 返回用户累计充值金额与最近一次充值时间，兑换码充值的额度按充值价格折算为金额
+func GetUserPaidAmount(userId int) (amount float64, lastPaidTime int64, err error) {
+	var topUp struct {
+		Money    float64
+		LastTime int64
+	}
+	err = DB.Model(&TopUp{}).Where("user_id = ? and status = ?", userId, "success").
+		Select("coalesce(sum(money), 0) as money, coalesce(max(create_time), 0) as last_time").Scan(&topUp).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	var redemption struct {
+		Quota    int64
+		LastTime int64
+	}
+	err = DB.Model(&QuotaLedger{}).Where("user_id = ? and reason = ?", userId, LedgerReasonRedemption).
+		Select("coalesce(sum(amount), 0) as quota, coalesce(max(created_at), 0) as last_time").Scan(&redemption).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	amount = topUp.Money + float64(redemption.Quota)/common.QuotaPerUnit*constant.Price
+	lastPaidTime = topUp.LastTime
+	if redemption.LastTime > lastPaidTime {
+		lastPaidTime = redemption.LastTime
+	}
+	return amount, lastPaidTime, nil
+}
+
+// groupPromotionRank 返回分组在自动升级规则中的等级，default 为 0，不受规则管理的分组返回 -1
+func groupPromotionRank(group string) int {
+	if group == groupPromotionBaseGroup {
+		return 0
+	}
+	for i, rule := range common.GroupPromotionRules {
+		if rule.Group == group {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// groupPromotionTarget 按累计充值金额与最近充值时间计算应处的分组
+func groupPromotionTarget(amount float64, lastPaidTime int64, now int64) string {
+	rules := common.GroupPromotionRules
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		if amount < rule.MinAmount {
+			continue
+		}
+		if rule.InactiveDays > 0 && now-lastPaidTime > int64(rule.InactiveDays)*86400 {
+			continue
+		}
+		return rule.Group
+	}
+	return groupPromotionBaseGroup
+}
+
+// applyGroupPromotion 重新计算用户的分组，upgrade 为 true 时只升级，否则只降级。
+// 管理员设置的、不受规则管理的分组不会被调整；用户处于限时分组期间时调整的是到期后恢复的分组
+func applyGroupPromotion(userId int, upgrade bool) error {
+	amount, lastPaidTime, err := GetUserPaidAmount(userId)
+	if err != nil {
+		return err
+	}
+	target := groupPromotionTarget(amount, lastPaidTime, common.GetTimestamp())
+	var from string
+	var userGroupChanged bool
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := lockForUpdate(tx).Select("id, "+userGroupColumn()).First(&user, "id = ?", userId).Error
+		if err != nil {
+			return err
+		}
+		var grant UserGroupGrant
+		err = tx.Where("user_id = ? and status = ?", userId, UserGroupGrantStatusActive).Limit(1).Find(&grant).Error
+		if err != nil {
+			return err
+		}
+		from = user.Group
+		if grant.Id != 0 {
+			from = grant.PreviousGroup
+		}
+		currentRank := groupPromotionRank(from)
+		targetRank := groupPromotionRank(target)
+		if currentRank < 0 || targetRank == currentRank || (targetRank > currentRank) != upgrade {
+			from = ""
+			return nil
+		}
+		if grant.Id != 0 {
+			return tx.Model(&grant).Update("previous_group", target).Error
+		}
+		userGroupChanged = true
+		return tx.Model(&User{}).Where("id = ?", userId).Update("group", target).Error
+	})
+	if err != nil || from == "" {
+		return err
+	}
+	if userGroupChanged {
+		cacheSetUserGroup(userId, target)
+	}
+	var message string
+	if upgrade {
+		message = fmt.Sprintf("累计充值 %.2f，分组已从 %s 升级为 %s", amount, from, target)
+	} else {
+		message = fmt.Sprintf("长期未充值，分组已从 %s 调整为 %s", from, target)
+	}
+	if !userGroupChanged {
+		message += "（限时分组到期后生效）"
+	}
+	RecordLog(userId, LogTypeSystem, message)
+	go func() {
+		email, err := GetUserEmail(userId)
+		if err != nil || email == "" {
+			return
+		}
+		if err = common.SendEmail("用户分组变更通知", email, message+"。"); err != nil {
+			common.SysError("failed to send email: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+// PromoteUserGroup 用户充值或兑换成功后按累计充值金额升级分组
+func PromoteUserGroup(userId int) {
+	if !common.GroupPromotionEnabled || len(common.GroupPromotionRules) == 0 {
+		return
+	}
+	if err := applyGroupPromotion(userId, true); err != nil {
+		common.SysError(fmt.Sprintf("failed to promote user %d group: %s", userId, err.Error()))
+	}
+}
+
+// GetGroupPromotionStatus 返回用户当前的累计充值金额与下一个可升级的分组
+func GetGroupPromotionStatus(userId int) (*GroupPromotionStatus, error) {
+	group, err := GetUserGroup(userId)
+	if err != nil {
+		return nil, err
+	}
+	amount, lastPaidTime, err := GetUserPaidAmount(userId)
+	if err != nil {
+		return nil, err
+	}
+	status := &GroupPromotionStatus{
+		Group:        group,
+		PaidAmount:   amount,
+		LastPaidTime: lastPaidTime,
+	}
+	for _, rule := range common.GroupPromotionRules {
+		if rule.MinAmount > amount {
+			status.NextGroup = rule.Group
+			status.NextAmount = rule.MinAmount
+			break
+		}
+	}
+	return status, nil
+}
+
+// DemoteInactiveUserGroups 对设置了降级天数的分组，将长期未充值的用户调整到仍满足条件的分组
+func DemoteInactiveUserGroups() error {
+	if !common.GroupPromotionEnabled {
+		return nil
+	}
+	var groups []string
+	for _, rule := range common.GroupPromotionRules {
+		if rule.InactiveDays > 0 {
+			groups = append(groups, rule.Group)
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	var userIds []int
+	err := DB.Model(&User{}).Where(userGroupColumn()+" in ?", groups).Pluck("id", &userIds).Error
+	if err != nil {
+		return err
+	}
+	var grantUserIds []int
+	err = DB.Model(&UserGroupGrant{}).Where("status = ? and previous_group in ?", UserGroupGrantStatusActive, groups).
+		Pluck("user_id", &grantUserIds).Error
+	if err != nil {
+		return err
+	}
+	userIds = append(userIds, grantUserIds...)
+	processed := make(map[int]bool)
+	for _, userId := range userIds {
+		if processed[userId] {
+			continue
+		}
+		processed[userId] = true
+		if err = applyGroupPromotion(userId, false); err != nil {
+			common.SysError(fmt.Sprintf("failed to demote user %d group: %s", userId, err.Error()))
+		}
+	}
+	return nil
+}
+
+func AutomaticallyDemoteInactiveUserGroups() {
+	for {
+		if err := DemoteInactiveUserGroups(); err != nil {
+			common.SysError("failed to demote inactive user groups: " + err.Error())
+		}
+		time.Sleep(time.Hour)
+	}
+}
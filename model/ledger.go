@@ -0,0 +1,384 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// QuotaLedger 额度流水，只允许追加。每条记录同时包含借方与贷方账户，
+// Amount 为用户余额的变动量（正数为入账），BalanceAfter 为变动后的用户余额。
+// OrgId 不为 0 时为组织额度池的流水，Amount 与 BalanceAfter 对应组织额度，UserId 为发起变动的成员
+type QuotaLedger struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id" gorm:"index:idx_ledger_user_id,priority:1"`
+	OrgId         int    `json:"org_id" gorm:"index;default:0"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint;index"`
+	Reason        string `json:"reason" gorm:"type:varchar(32);index"`
+	Reference     string `json:"reference" gorm:"type:varchar(64);index;default:''"`
+	DebitAccount  string `json:"debit_account" gorm:"type:varchar(64)"`
+	CreditAccount string `json:"credit_account" gorm:"type:varchar(64)"`
+	Amount        int    `json:"amount"`
+	BalanceAfter  int    `json:"balance_after"`
+}
+
+const (
+	LedgerReasonOpening          = "opening"
+	LedgerReasonRegister         = "register"
+	LedgerReasonInvitee          = "invitee"
+	LedgerReasonTopUp            = "topup"
+	LedgerReasonRedemption       = "redemption"
+	LedgerReasonAffTransfer      = "aff_transfer"
+	LedgerReasonAdjust           = "admin_adjust"
+	LedgerReasonPreConsume       = "pre_consume"
+	LedgerReasonConsume          = "consume"
+	LedgerReasonRefund           = "refund"
+	LedgerReasonOrgTransfer      = "org_transfer"
+	LedgerReasonResellerAllocate = "reseller_allocate"
+	// 套餐额度的发放、周期重置与到期收回
+	LedgerReasonSubscription = "subscription"
+	// 使用余额购买或自动续费套餐
+	LedgerReasonSubscriptionPurchase = "subscription_purchase"
+)
+
+var errLedgerImmutable = errors.New("quota ledger is append-only")
+
+func (ledger *QuotaLedger) BeforeUpdate(tx *gorm.DB) error {
+	return errLedgerImmutable
+}
+
+func (ledger *QuotaLedger) BeforeDelete(tx *gorm.DB) error {
+	return errLedgerImmutable
+}
+
+func userLedgerAccount(userId int) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+func orgLedgerAccount(orgId int) string {
+	return fmt.Sprintf("org:%d", orgId)
+}
+
+func newQuotaLedger(userId int, amount int, reason string, reference string) *QuotaLedger {
+	ledger := &QuotaLedger{
+		UserId:    userId,
+		CreatedAt: common.GetTimestamp(),
+		Reason:    reason,
+		Reference: reference,
+		Amount:    amount,
+	}
+	// 入账时用户账户为借方，出账时为贷方，对方账户为按原因划分的系统账户
+	if amount >= 0 {
+		ledger.DebitAccount = userLedgerAccount(userId)
+		ledger.CreditAccount = "system:" + reason
+	} else {
+		ledger.DebitAccount = "system:" + reason
+		ledger.CreditAccount = userLedgerAccount(userId)
+	}
+	return ledger
+}
+
+// lockForUpdate 在事务中对查询到的行加写锁，SQLite 不支持 FOR UPDATE，写事务本身已串行
+func lockForUpdate(tx *gorm.DB) *gorm.DB {
+	if common.UsingSQLite {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// recordQuotaLedgerTx 在额度已变动的事务中追加流水，余额从同一事务中读取
+func recordQuotaLedgerTx(tx *gorm.DB, userId int, amount int, reason string, reference string) error {
+	ledger := newQuotaLedger(userId, amount, reason, reference)
+	err := tx.Model(&User{}).Where("id = ?", userId).Select("quota").Find(&ledger.BalanceAfter).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(ledger).Error
+}
+
+// recordOrganizationLedgerTx 在组织额度池已变动的事务中追加流水，余额为组织额度池的余额
+func recordOrganizationLedgerTx(tx *gorm.DB, orgId int, userId int, amount int, reason string, reference string) error {
+	ledger := newQuotaLedger(userId, amount, reason, reference)
+	ledger.OrgId = orgId
+	if amount >= 0 {
+		ledger.DebitAccount = orgLedgerAccount(orgId)
+	} else {
+		ledger.CreditAccount = orgLedgerAccount(orgId)
+	}
+	err := tx.Model(&Organization{}).Where("id = ?", orgId).Select("quota").Find(&ledger.BalanceAfter).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(ledger).Error
+}
+
+// changeUserQuota 变更用户额度并在同一事务中记录流水
+func changeUserQuota(userId int, amount int, reason string, reference string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", amount)).Error
+		if err != nil {
+			return err
+		}
+		if err = recordQuotaLedgerTx(tx, userId, amount, reason, reference); err != nil {
+			return err
+		}
+		if isConsumeLedgerReason(reason) {
+			return addSubscriptionUsageTx(tx, userId, -amount)
+		}
+		return nil
+	})
+}
+
+// changeUserQuotaTx 在事务中变更用户额度并记录流水
+func changeUserQuotaTx(tx *gorm.DB, userId int, amount int, reason string, reference string) error {
+	if amount == 0 {
+		return nil
+	}
+	err := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", amount)).Error
+	if err != nil {
+		return err
+	}
+	return recordQuotaLedgerTx(tx, userId, amount, reason, reference)
+}
+
+// 批量更新模式下暂存的流水，与额度在同一次批量更新中写入
+var batchLedgerStore = make(map[int][]*QuotaLedger)
+
+func addBatchQuotaRecord(userId int, amount int, reason string, reference string) {
+	batchUpdateLocks[BatchUpdateTypeUserQuota].Lock()
+	defer batchUpdateLocks[BatchUpdateTypeUserQuota].Unlock()
+	batchUpdateStores[BatchUpdateTypeUserQuota][userId] += amount
+	batchLedgerStore[userId] = append(batchLedgerStore[userId], newQuotaLedger(userId, amount, reason, reference))
+}
+
+// takeBatchLedgers 调用方需持有 BatchUpdateTypeUserQuota 的锁
+func takeBatchLedgers() map[int][]*QuotaLedger {
+	ledgers := batchLedgerStore
+	batchLedgerStore = make(map[int][]*QuotaLedger)
+	return ledgers
+}
+
+// recordBatchLedgers 根据批量更新后的余额倒推每条流水的变动后余额
+func recordBatchLedgers(userId int, ledgers []*QuotaLedger) {
+	if len(ledgers) == 0 {
+		return
+	}
+	balance, err := GetUserQuota(userId)
+	if err != nil {
+		common.SysError("failed to get user quota for ledger: " + err.Error())
+		return
+	}
+	for i := len(ledgers) - 1; i >= 0; i-- {
+		ledgers[i].BalanceAfter = balance
+		balance -= ledgers[i].Amount
+	}
+	if err = DB.Create(&ledgers).Error; err != nil {
+		common.SysError("failed to record batch quota ledger: " + err.Error())
+	}
+}
+
+// hasPendingBatchLedger 只能看到本节点暂存的流水，多节点开启批量更新时其他节点暂存的额度变动不可见
+func hasPendingBatchLedger(userId int) bool {
+	if !common.BatchUpdateEnabled {
+		return false
+	}
+	batchUpdateLocks[BatchUpdateTypeUserQuota].Lock()
+	defer batchUpdateLocks[BatchUpdateTypeUserQuota].Unlock()
+	return len(batchLedgerStore[userId]) > 0
+}
+
+func GetQuotaLedgers(userId int, reason string, reference string, startTimestamp int64, endTimestamp int64, startIdx int, num int) (ledgers []*QuotaLedger, total int64, err error) {
+	tx := DB.Model(&QuotaLedger{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ? and org_id = 0", userId)
+	}
+	if reason != "" {
+		tx = tx.Where("reason = ?", reason)
+	}
+	if reference != "" {
+		tx = tx.Where("reference = ?", reference)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&ledgers).Error
+	return ledgers, total, err
+}
+
+// LedgerDrift 流水余额与用户表余额不一致的记录
+type LedgerDrift struct {
+	UserId        int    `json:"user_id"`
+	Username      string `json:"username"`
+	Quota         int    `json:"quota"`
+	LedgerBalance int    `json:"ledger_balance"`
+	Drift         int    `json:"drift"`
+}
+
+// LedgerReconcileReport 对账结果，Inconclusive 为 true 时差异可能来自尚未写入的批量更新，不能作为对账结论
+type LedgerReconcileReport struct {
+	StartedAt    int64         `json:"started_at"`
+	FinishedAt   int64         `json:"finished_at"`
+	Checked      int           `json:"checked"`
+	Opened       int           `json:"opened"`
+	Drifts       []LedgerDrift `json:"drifts"`
+	Inconclusive bool          `json:"inconclusive"`
+	Note         string        `json:"note"`
+}
+
+var lastLedgerReconcileReport *LedgerReconcileReport
+var ledgerReconcileLock sync.Mutex
+
+func GetLastLedgerReconcileReport() *LedgerReconcileReport {
+	ledgerReconcileLock.Lock()
+	defer ledgerReconcileLock.Unlock()
+	return lastLedgerReconcileReport
+}
+
+type ledgerSum struct {
+	UserId int
+	Total  int
+	Count  int
+}
+
+// ReconcileQuotaLedger 对比每个用户的流水合计与 users.quota，
+// 没有任何流水的用户会补记一条期初余额。
+// 开启批量更新时，额度与流水在各节点内存中暂存后才写入，本节点无法看到其他节点暂存的变动，
+// 此时的差异不能区分是否为真实偏差，报告标记为不确定且不记录错误日志
+func ReconcileQuotaLedger() (*LedgerReconcileReport, error) {
+	ledgerReconcileLock.Lock()
+	defer ledgerReconcileLock.Unlock()
+	report := &LedgerReconcileReport{
+		StartedAt: common.GetTimestamp(),
+		Drifts:    make([]LedgerDrift, 0),
+	}
+	if common.BatchUpdateEnabled {
+		report.Inconclusive = true
+		report.Note = "已开启批量更新，其他节点暂存的额度变动尚未写入，差异仅供参考"
+	}
+	lastId := 0
+	for {
+		var users []*User
+		err := DB.Unscoped().Select("id", "username", "quota").Where("id > ?", lastId).Order("id").Limit(1000).Find(&users).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			break
+		}
+		ids := make([]int, 0, len(users))
+		for _, user := range users {
+			ids = append(ids, user.Id)
+		}
+		lastId = ids[len(ids)-1]
+		var sums []ledgerSum
+		err = DB.Model(&QuotaLedger{}).Select("user_id, sum(amount) as total, count(*) as count").
+			Where("user_id in ? and org_id = 0", ids).Group("user_id").Scan(&sums).Error
+		if err != nil {
+			return nil, err
+		}
+		sumMap := make(map[int]ledgerSum, len(sums))
+		for _, sum := range sums {
+			sumMap[sum.UserId] = sum
+		}
+		for _, user := range users {
+			if hasPendingBatchLedger(user.Id) {
+				continue
+			}
+			report.Checked++
+			sum, ok := sumMap[user.Id]
+			if !ok || sum.Count == 0 {
+				if err = recordOpeningLedger(user.Id); err != nil {
+					return nil, err
+				}
+				report.Opened++
+				continue
+			}
+			if sum.Total == user.Quota {
+				continue
+			}
+			// 读取用户与流水之间可能有新的扣费，重新读取一次确认
+			quota, ledgerBalance, err := getUserLedgerBalance(user.Id)
+			if err != nil {
+				return nil, err
+			}
+			if quota != ledgerBalance {
+				report.Drifts = append(report.Drifts, LedgerDrift{
+					UserId:        user.Id,
+					Username:      user.Username,
+					Quota:         quota,
+					LedgerBalance: ledgerBalance,
+					Drift:         quota - ledgerBalance,
+				})
+			}
+		}
+	}
+	report.FinishedAt = common.GetTimestamp()
+	lastLedgerReconcileReport = report
+	if report.Inconclusive {
+		return report, nil
+	}
+	for _, drift := range report.Drifts {
+		common.SysError(fmt.Sprintf("quota ledger drift: user %d quota %d, ledger %d", drift.UserId, drift.Quota, drift.LedgerBalance))
+	}
+	return report, nil
+}
+
+// recordOpeningLedger 以当前余额作为期初余额入账
+func recordOpeningLedger(userId int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&QuotaLedger{}).Where("user_id = ? and org_id = 0", userId).Count(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+		ledger := newQuotaLedger(userId, 0, LedgerReasonOpening, "")
+		err = tx.Model(&User{}).Unscoped().Where("id = ?", userId).Select("quota").Find(&ledger.BalanceAfter).Error
+		if err != nil {
+			return err
+		}
+		ledger.Amount = ledger.BalanceAfter
+		if ledger.Amount < 0 {
+			ledger.DebitAccount, ledger.CreditAccount = ledger.CreditAccount, ledger.DebitAccount
+		}
+		return tx.Create(ledger).Error
+	})
+}
+
+func getUserLedgerBalance(userId int) (quota int, ledgerBalance int, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Unscoped().Where("id = ?", userId).Select("quota").Find(&quota).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&QuotaLedger{}).Where("user_id = ? and org_id = 0", userId).Select("coalesce(sum(amount), 0)").Scan(&ledgerBalance).Error
+	})
+	return quota, ledgerBalance, err
+}
+
+func AutomaticallyReconcileQuotaLedger(frequency int) {
+	for {
+		report, err := ReconcileQuotaLedger()
+		if err != nil {
+			common.SysError("failed to reconcile quota ledger: " + err.Error())
+		} else {
+			message := fmt.Sprintf("quota ledger reconciled: %d users checked, %d opened, %d drifted", report.Checked, report.Opened, len(report.Drifts))
+			if report.Inconclusive {
+				message += " (inconclusive: batch update enabled)"
+			}
+			common.SysLog(message)
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
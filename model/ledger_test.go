@@ -0,0 +1,70 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+)
+
+func findLedgerDrift(report *LedgerReconcileReport, userId int) *LedgerDrift {
+	for i := range report.Drifts {
+		if report.Drifts[i].UserId == userId {
+			return &report.Drifts[i]
+		}
+	}
+	return nil
+}
+
+func TestReconcileQuotaLedger(t *testing.T) {
+	user := createTestUser(t, "ledger_user", 100)
+	report, err := ReconcileQuotaLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findLedgerDrift(report, user.Id) != nil {
+		t.Fatal("opening balance reported as drift")
+	}
+
+	if err = changeUserQuota(user.Id, -30, LedgerReasonConsume, "req-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = changeUserQuota(user.Id, 10, LedgerReasonRefund, "req-1"); err != nil {
+		t.Fatal(err)
+	}
+	ledgers, _, err := GetQuotaLedgers(user.Id, "", "req-1", 0, 0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledgers) != 2 || ledgers[0].BalanceAfter != 80 || ledgers[1].BalanceAfter != 70 {
+		t.Fatalf("unexpected ledger balances: %+v", ledgers)
+	}
+	report, err = ReconcileQuotaLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findLedgerDrift(report, user.Id) != nil {
+		t.Fatal("ledgered changes reported as drift")
+	}
+
+	// 绕过流水直接修改余额会被识别为偏差
+	DB.Model(&User{}).Where("id = ?", user.Id).Update("quota", 75)
+	report, err = ReconcileQuotaLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drift := findLedgerDrift(report, user.Id)
+	if drift == nil || drift.Drift != -5 || report.Inconclusive {
+		t.Fatalf("expected a conclusive drift of -5, got %+v", drift)
+	}
+
+	// 批量更新模式下其他节点的暂存变动不可见，结果标记为不确定
+	common.BatchUpdateEnabled = true
+	report, err = ReconcileQuotaLedger()
+	common.BatchUpdateEnabled = false
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Inconclusive {
+		t.Fatal("expected an inconclusive report with batch update enabled")
+	}
+	DB.Model(&User{}).Where("id = ?", user.Id).Update("quota", 80)
+}
@@ -24,6 +24,7 @@ type Log struct {
 	IsStream         bool   `json:"is_stream" gorm:"default:false"`
 	ChannelId        int    `json:"channel" gorm:"index"`
 	TokenId          int    `json:"token_id" gorm:"default:0;index"`
+	OrgId            int    `json:"org_id" gorm:"default:0;index"`
 	Other            string `json:"other"`
 }
 
@@ -33,10 +34,21 @@ const (
 	LogTypeConsume
 	LogTypeManage
 	LogTypeSystem
+	LogTypeSecurity // 登录、改密等账户安全事件，用户可在自己的日志中查看
 )
 
 func GetLogByKey(key string) (logs []*Log, err error) {
-	err = DB.Joins("left join tokens on tokens.id = logs.token_id").Where("tokens.key = ?", strings.TrimPrefix(key, "sk-")).Find(&logs).Error
+	// 日志可能位于单独的日志库中，不能与 tokens 表联表查询
+	keyCol := "`key`"
+	if common.UsingPostgreSQL {
+		keyCol = `"key"`
+	}
+	var tokenIds []int
+	err = DB.Model(&Token{}).Where(keyCol+" = ?", strings.TrimPrefix(key, "sk-")).Pluck("id", &tokenIds).Error
+	if err != nil || len(tokenIds) == 0 {
+		return logs, err
+	}
+	err = LOG_DB.Where("token_id in ?", tokenIds).Find(&logs).Error
 	return logs, err
 }
 
@@ -52,13 +64,33 @@ func RecordLog(userId int, logType int, content string) {
 		Type:      logType,
 		Content:   content,
 	}
-	err := DB.Create(log).Error
+	err := LOG_DB.Create(log).Error
 	if err != nil {
 		common.SysError("failed to record log: " + err.Error())
 	}
 }
 
-func RecordConsumeLog(ctx context.Context, userId int, channelId int, promptTokens int, completionTokens int, modelName string, tokenName string, quota int, content string, tokenId int, userQuota int, useTimeSeconds int, isStream bool, other map[string]interface{}) {
+// RecordSecurityLog 记录账户安全事件，附带来源 IP 与 User-Agent
+func RecordSecurityLog(userId int, ip string, userAgent string, content string) {
+	username, _ := CacheGetUsername(userId)
+	log := &Log{
+		UserId:    userId,
+		Username:  username,
+		CreatedAt: common.GetTimestamp(),
+		Type:      LogTypeSecurity,
+		Content:   content,
+		Other: common.MapToJsonStr(map[string]interface{}{
+			"ip":         ip,
+			"user_agent": userAgent,
+		}),
+	}
+	err := LOG_DB.Create(log).Error
+	if err != nil {
+		common.SysError("failed to record security log: " + err.Error())
+	}
+}
+
+func RecordConsumeLog(ctx context.Context, userId int, channelId int, promptTokens int, completionTokens int, modelName string, tokenName string, quota int, content string, tokenId int, orgId int, userQuota int, useTimeSeconds int, isStream bool, other map[string]interface{}) {
 	common.LogInfo(ctx, fmt.Sprintf("record consume log: userId=%d, 用户调用前余额=%d, channelId=%d, promptTokens=%d, completionTokens=%d, modelName=%s, tokenName=%s, quota=%d, content=%s", userId, userQuota, channelId, promptTokens, completionTokens, modelName, tokenName, quota, content))
 	if !common.LogConsumeEnabled {
 		return
@@ -78,27 +110,32 @@ func RecordConsumeLog(ctx context.Context, userId int, channelId int, promptToke
 		Quota:            quota,
 		ChannelId:        channelId,
 		TokenId:          tokenId,
+		OrgId:            orgId,
 		UseTime:          useTimeSeconds,
 		IsStream:         isStream,
 		Other:            otherStr,
 	}
-	err := DB.Create(log).Error
+	err := writeConsumeLog(log)
 	if err != nil {
 		common.LogError(ctx, "failed to record log: "+err.Error())
 	}
 	if common.DataExportEnabled {
 		common.SafeGoroutine(func() {
-			LogQuotaData(userId, username, modelName, quota, common.GetTimestamp(), promptTokens+completionTokens)
+			LogQuotaData(userId, username, modelName, tokenId, tokenName, channelId, quota, common.GetTimestamp(), promptTokens+completionTokens)
 		})
 	}
 }
 
-func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int) (logs []*Log, err error) {
+// GetAllLogs userIds 不为 nil 时只返回这些用户的日志，用于代理查看下级日志
+func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int, userIds []int) (logs []*Log, err error) {
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
-		tx = DB
+		tx = LOG_DB
 	} else {
-		tx = DB.Where("type = ?", logType)
+		tx = LOG_DB.Where("type = ?", logType)
+	}
+	if userIds != nil {
+		tx = tx.Where("user_id in ?", userIds)
 	}
 	if modelName != "" {
 		tx = tx.Where("model_name = ?", modelName)
@@ -125,9 +162,9 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int) (logs []*Log, err error) {
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
-		tx = DB.Where("user_id = ?", userId)
+		tx = LOG_DB.Where("user_id = ?", userId)
 	} else {
-		tx = DB.Where("user_id = ? and type = ?", userId, logType)
+		tx = LOG_DB.Where("user_id = ? and type = ?", userId, logType)
 	}
 	if modelName != "" {
 		tx = tx.Where("model_name = ?", modelName)
@@ -142,6 +179,12 @@ func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int
 		tx = tx.Where("created_at <= ?", endTimestamp)
 	}
 	err = tx.Order("id desc").Limit(num).Offset(startIdx).Omit("id").Find(&logs).Error
+	ClearLogAdminInfo(logs)
+	return logs, err
+}
+
+// ClearLogAdminInfo 清除日志中仅管理员可见的信息
+func ClearLogAdminInfo(logs []*Log) {
 	for i := range logs {
 		var otherMap map[string]interface{}
 		otherMap = common.StrToMap(logs[i].Other)
@@ -151,16 +194,19 @@ func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int
 		}
 		logs[i].Other = common.MapToJsonStr(otherMap)
 	}
-	return logs, err
 }
 
-func SearchAllLogs(keyword string) (logs []*Log, err error) {
-	err = DB.Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(common.MaxRecentItems).Find(&logs).Error
+func SearchAllLogs(keyword string, userIds []int) (logs []*Log, err error) {
+	tx := LOG_DB.Where("type = ? or content LIKE ?", keyword, keyword+"%")
+	if userIds != nil {
+		tx = tx.Where("user_id in ?", userIds)
+	}
+	err = tx.Order("id desc").Limit(common.MaxRecentItems).Find(&logs).Error
 	return logs, err
 }
 
 func SearchUserLogs(userId int, keyword string) (logs []*Log, err error) {
-	err = DB.Where("user_id = ? and type = ?", userId, keyword).Order("id desc").Limit(common.MaxRecentItems).Omit("id").Find(&logs).Error
+	err = LOG_DB.Where("user_id = ? and type = ?", userId, keyword).Order("id desc").Limit(common.MaxRecentItems).Omit("id").Find(&logs).Error
 	return logs, err
 }
 
@@ -170,8 +216,11 @@ type Stat struct {
 	Tpm   int `json:"tpm"`
 }
 
-func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) (stat Stat) {
-	tx := DB.Table("logs").Select("sum(quota) quota, count(*) rpm, sum(prompt_tokens) + sum(completion_tokens) tpm")
+func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int, userIds []int) (stat Stat) {
+	tx := LOG_DB.Table("logs").Select("sum(quota) quota, count(*) rpm, sum(prompt_tokens) + sum(completion_tokens) tpm")
+	if userIds != nil {
+		tx = tx.Where("user_id in ?", userIds)
+	}
 	if username != "" {
 		tx = tx.Where("username = ?", username)
 	}
@@ -195,7 +244,7 @@ func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelNa
 }
 
 func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string) (token int) {
-	tx := DB.Table("logs").Select("ifnull(sum(prompt_tokens),0) + ifnull(sum(completion_tokens),0)")
+	tx := LOG_DB.Table("logs").Select("coalesce(sum(prompt_tokens),0) + coalesce(sum(completion_tokens),0)")
 	if username != "" {
 		tx = tx.Where("username = ?", username)
 	}
@@ -216,6 +265,6 @@ func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelNa
 }
 
 func DeleteOldLog(targetTimestamp int64) (int64, error) {
-	result := DB.Where("created_at < ?", targetTimestamp).Delete(&Log{})
+	result := LOG_DB.Where("created_at < ?", targetTimestamp).Delete(&Log{})
 	return result.RowsAffected, result.Error
 }
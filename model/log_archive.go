@@ -0,0 +1,103 @@
+package model
+
+// LogArchive 已归档日志文件的索引，记录文件位置与覆盖的时间范围
+type LogArchive struct {
+	Id          int    `json:"id"`
+	StartTime   int64  `json:"start_time" gorm:"bigint;index"`
+	EndTime     int64  `json:"end_time" gorm:"bigint;index"`
+	Storage     string `json:"storage" gorm:"type:varchar(16)"`
+	Key         string `json:"key"`
+	RecordCount int    `json:"record_count"`
+	Size        int64  `json:"size" gorm:"bigint"`
+	MinId       int    `json:"min_id"`
+	MaxId       int    `json:"max_id"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+}
+
+func GetLogArchives(startIdx int, num int) (archives []*LogArchive, total int64, err error) {
+	err = LOG_DB.Model(&LogArchive{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = LOG_DB.Order("start_time desc").Limit(num).Offset(startIdx).Find(&archives).Error
+	return archives, total, err
+}
+
+// GetLogArchivesInRange 返回与时间范围有交集的归档，按时间升序，时间为 0 表示不限
+func GetLogArchivesInRange(start int64, end int64) (archives []*LogArchive, err error) {
+	tx := LOG_DB.Model(&LogArchive{})
+	if start != 0 {
+		tx = tx.Where("end_time > ?", start)
+	}
+	if end != 0 {
+		tx = tx.Where("start_time <= ?", end)
+	}
+	err = tx.Order("start_time").Find(&archives).Error
+	return archives, err
+}
+
+// GetOldestLogTimestamp 返回早于 before 的最早一条日志的时间，没有时返回 0
+func GetOldestLogTimestamp(before int64) (int64, error) {
+	var log Log
+	err := LOG_DB.Select("created_at").Where("created_at < ?", before).Order("created_at").Limit(1).Find(&log).Error
+	return log.CreatedAt, err
+}
+
+// DeleteLogsInRange 删除 [start, end) 范围内的日志，分区表上整月范围直接删除分区
+func DeleteLogsInRange(start int64, end int64) error {
+	if dropped, err := dropLogPartition(start, end); err != nil || dropped {
+		return err
+	}
+	return LOG_DB.Where("created_at >= ? and created_at < ?", start, end).Delete(&Log{}).Error
+}
+
+// Match 在内存中判断日志是否满足筛选条件，用于过滤归档文件中的记录
+func (filter *LogFilter) Match(log *Log) bool {
+	if filter.UserId != 0 && log.UserId != filter.UserId {
+		return false
+	}
+	if filter.UserIds != nil {
+		if filter.userIdSet == nil {
+			filter.userIdSet = make(map[int]bool, len(filter.UserIds))
+			for _, userId := range filter.UserIds {
+				filter.userIdSet[userId] = true
+			}
+		}
+		if !filter.userIdSet[log.UserId] {
+			return false
+		}
+	}
+	if filter.LogType != LogTypeUnknown && log.Type != filter.LogType {
+		return false
+	}
+	if filter.ModelName != "" && log.ModelName != filter.ModelName {
+		return false
+	}
+	if filter.Username != "" && log.Username != filter.Username {
+		return false
+	}
+	if filter.TokenName != "" && log.TokenName != filter.TokenName {
+		return false
+	}
+	if filter.StartTimestamp != 0 && log.CreatedAt < filter.StartTimestamp {
+		return false
+	}
+	if filter.EndTimestamp != 0 && log.CreatedAt > filter.EndTimestamp {
+		return false
+	}
+	if filter.Channel != 0 && log.ChannelId != filter.Channel {
+		return false
+	}
+	return true
+}
+
+// SaveLogArchive 保存归档索引，同一时间范围重复归档时覆盖原记录
+func SaveLogArchive(archive *LogArchive) error {
+	var existing LogArchive
+	err := LOG_DB.Where("start_time = ? and end_time = ?", archive.StartTime, archive.EndTime).Limit(1).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	archive.Id = existing.Id
+	return LOG_DB.Save(archive).Error
+}
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 日志表按月分区，分区名为 logs_pYYYYMM（PostgreSQL）或 pYYYYMM（MySQL）
+const logPartitionMonthsAhead = 2
+
+const postgresPartitionedLogTableSQL = `CREATE TABLE logs (
+	id bigserial,
+	user_id bigint,
+	created_at bigint NOT NULL,
+	type bigint,
+	content text,
+	username text DEFAULT '',
+	token_name text DEFAULT '',
+	model_name text DEFAULT '',
+	quota bigint DEFAULT 0,
+	prompt_tokens bigint DEFAULT 0,
+	completion_tokens bigint DEFAULT 0,
+	use_time bigint DEFAULT 0,
+	is_stream boolean DEFAULT false,
+	channel_id bigint,
+	token_id bigint DEFAULT 0,
+	other text,
+	PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at)`
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+}
+
+func logPartitionName(month time.Time) string {
+	if common.LogUsingPostgreSQL {
+		return "logs_p" + month.Format("200601")
+	}
+	return "p" + month.Format("200601")
+}
+
+// migrateLogTable 迁移日志表，开启分区时先建立分区表或转换为分区表
+func migrateLogTable(db *gorm.DB) error {
+	if common.LogPartitionEnabled && common.LogUsingPostgreSQL && !db.Migrator().HasTable(&Log{}) {
+		if err := db.Exec(postgresPartitionedLogTableSQL).Error; err != nil {
+			return err
+		}
+		if err := db.Exec("CREATE TABLE logs_default PARTITION OF logs DEFAULT").Error; err != nil {
+			return err
+		}
+	}
+	if err := db.AutoMigrate(&Log{}); err != nil {
+		return err
+	}
+	if !common.LogPartitionEnabled {
+		return nil
+	}
+	switch {
+	case common.LogUsingSQLite:
+		common.SysLog("LOG_PARTITION_ENABLED is ignored on SQLite")
+		return nil
+	case common.LogUsingPostgreSQL:
+		if !isLogTablePartitioned(db) {
+			common.SysLog("logs table already exists and is not partitioned, partitioning is only applied to new PostgreSQL installations")
+			return nil
+		}
+	case common.LogUsingMySQL:
+		if !isLogTablePartitioned(db) {
+			if err := convertMySQLLogTable(db); err != nil {
+				return err
+			}
+		}
+	}
+	return EnsureLogPartitions()
+}
+
+func isLogTablePartitioned(db *gorm.DB) bool {
+	var count int64
+	if common.LogUsingPostgreSQL {
+		db.Raw("SELECT count(*) FROM pg_partitioned_table pt JOIN pg_class c ON c.oid = pt.partrelid WHERE c.relname = 'logs' AND pg_table_is_visible(c.oid)").Scan(&count)
+	} else if common.LogUsingMySQL {
+		db.Raw("SELECT count(*) FROM information_schema.PARTITIONS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'logs' AND PARTITION_NAME IS NOT NULL").Scan(&count)
+	}
+	return count > 0
+}
+
+// IsLogPartitioned 日志表当前是否为分区表
+func IsLogPartitioned() bool {
+	return common.LogPartitionEnabled && !common.LogUsingSQLite && isLogTablePartitioned(LOG_DB)
+}
+
+// convertMySQLLogTable 将已有的 MySQL 日志表转换为分区表，历史数据保留在 p_history 分区中
+func convertMySQLLogTable(db *gorm.DB) error {
+	common.SysLog("converting logs table to partitioned table, this may take a while")
+	if err := db.Exec("ALTER TABLE logs DROP PRIMARY KEY, ADD PRIMARY KEY (id, created_at)").Error; err != nil {
+		return err
+	}
+	current := monthStart(time.Now())
+	partitions := []string{fmt.Sprintf("PARTITION p_history VALUES LESS THAN (%d)", current.Unix())}
+	for i := 0; i <= logPartitionMonthsAhead; i++ {
+		month := current.AddDate(0, i, 0)
+		partitions = append(partitions, fmt.Sprintf("PARTITION %s VALUES LESS THAN (%d)", logPartitionName(month), month.AddDate(0, 1, 0).Unix()))
+	}
+	partitions = append(partitions, "PARTITION p_max VALUES LESS THAN MAXVALUE")
+	return db.Exec("ALTER TABLE logs PARTITION BY RANGE (created_at) (" + strings.Join(partitions, ", ") + ")").Error
+}
+
+func getLogPartitionNames() (map[string]bool, error) {
+	var names []string
+	var err error
+	if common.LogUsingPostgreSQL {
+		err = LOG_DB.Raw("SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent WHERE p.relname = 'logs' AND pg_table_is_visible(p.oid)").Scan(&names).Error
+	} else {
+		err = LOG_DB.Raw("SELECT PARTITION_NAME FROM information_schema.PARTITIONS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'logs' AND PARTITION_NAME IS NOT NULL").Scan(&names).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(names))
+	for _, name := range names {
+		result[name] = true
+	}
+	return result, nil
+}
+
+// EnsureLogPartitions 创建当前月份及之后若干个月的分区
+func EnsureLogPartitions() error {
+	if !IsLogPartitioned() {
+		return nil
+	}
+	names, err := getLogPartitionNames()
+	if err != nil {
+		return err
+	}
+	current := monthStart(time.Now())
+	last := current.AddDate(0, logPartitionMonthsAhead, 0)
+	if common.LogUsingMySQL {
+		// MySQL 的范围分区只能从 p_max 向后拆分，从最后一个月份分区之后连续补齐，保证每个月份分区只包含当月数据
+		month := current.AddDate(0, -1, 0)
+		for name := range names {
+			if partitionMonth, err := time.ParseInLocation("200601", strings.TrimPrefix(name, "p"), time.Local); err == nil && partitionMonth.After(month) {
+				month = partitionMonth
+			}
+		}
+		for month = month.AddDate(0, 1, 0); !month.After(last); month = month.AddDate(0, 1, 0) {
+			sql := fmt.Sprintf("ALTER TABLE logs REORGANIZE PARTITION p_max INTO (PARTITION %s VALUES LESS THAN (%d), PARTITION p_max VALUES LESS THAN MAXVALUE)",
+				logPartitionName(month), month.AddDate(0, 1, 0).Unix())
+			if err = LOG_DB.Exec(sql).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for month := current; !month.After(last); month = month.AddDate(0, 1, 0) {
+		name := logPartitionName(month)
+		if names[name] {
+			continue
+		}
+		sql := fmt.Sprintf("CREATE TABLE %s PARTITION OF logs FOR VALUES FROM (%d) TO (%d)", name, month.Unix(), month.AddDate(0, 1, 0).Unix())
+		if err = LOG_DB.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropLogPartition 范围恰好是一个已存在的月份分区时删除该分区
+func dropLogPartition(start int64, end int64) (bool, error) {
+	if !IsLogPartitioned() {
+		return false, nil
+	}
+	month := time.Unix(start, 0).In(time.Local)
+	if monthStart(month).Unix() != start || month.AddDate(0, 1, 0).Unix() != end {
+		return false, nil
+	}
+	names, err := getLogPartitionNames()
+	if err != nil {
+		return false, err
+	}
+	name := logPartitionName(month)
+	if !names[name] {
+		return false, nil
+	}
+	if common.LogUsingPostgreSQL {
+		err = LOG_DB.Exec("DROP TABLE " + name).Error
+	} else {
+		err = LOG_DB.Exec("ALTER TABLE logs DROP PARTITION " + name).Error
+	}
+	return err == nil, err
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"sync"
+	"time"
+)
+
+var consumeLogChan chan *Log
+var consumeLogWriterDone chan struct{}
+var consumeLogChanLock sync.RWMutex
+
+// InitConsumeLogWriter 开启消费日志的异步批量写入
+func InitConsumeLogWriter() {
+	if !common.LogBatchWriteEnabled {
+		return
+	}
+	if common.LogBatchWriteSize <= 0 {
+		common.LogBatchWriteSize = 200
+	}
+	if common.LogBatchWriteInterval <= 0 {
+		common.LogBatchWriteInterval = 1000
+	}
+	consumeLogChan = make(chan *Log, common.LogBatchWriteSize*10)
+	consumeLogWriterDone = make(chan struct{})
+	common.SysLog(fmt.Sprintf("consume log batch write enabled with size %d and interval %dms", common.LogBatchWriteSize, common.LogBatchWriteInterval))
+	go runConsumeLogWriter(consumeLogChan, consumeLogWriterDone)
+}
+
+func runConsumeLogWriter(logs chan *Log, done chan struct{}) {
+	ticker := time.NewTicker(time.Duration(common.LogBatchWriteInterval) * time.Millisecond)
+	defer ticker.Stop()
+	batch := make([]*Log, 0, common.LogBatchWriteSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := LOG_DB.CreateInBatches(batch, common.LogBatchWriteSize).Error; err != nil {
+			common.SysError(fmt.Sprintf("failed to batch write %d consume logs: %s", len(batch), err.Error()))
+		}
+		batch = make([]*Log, 0, common.LogBatchWriteSize)
+	}
+	for {
+		select {
+		case log, ok := <-logs:
+			if !ok {
+				flush()
+				close(done)
+				return
+			}
+			batch = append(batch, log)
+			if len(batch) >= common.LogBatchWriteSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// writeConsumeLog 优先放入批量写入队列，未开启或队列已满时直接写入
+func writeConsumeLog(log *Log) error {
+	consumeLogChanLock.RLock()
+	if consumeLogChan != nil {
+		select {
+		case consumeLogChan <- log:
+			consumeLogChanLock.RUnlock()
+			return nil
+		default:
+		}
+	}
+	consumeLogChanLock.RUnlock()
+	return LOG_DB.Create(log).Error
+}
+
+// FlushConsumeLogWriter 停止批量写入并写入队列中剩余的日志
+func FlushConsumeLogWriter() {
+	consumeLogChanLock.Lock()
+	logs, done := consumeLogChan, consumeLogWriterDone
+	consumeLogChan = nil
+	consumeLogChanLock.Unlock()
+	if logs == nil {
+		return
+	}
+	close(logs)
+	<-done
+}
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"one-api/common"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	loginFailureUserPrefix = "user:"
+	loginFailureIPPrefix   = "ip:"
+	// loginMaxDelaySeconds 连续失败后每次重试的最长等待时间
+	loginMaxDelaySeconds = 32
+)
+
+// LoginFailure 按账户或 IP 统计的登录失败记录，Key 为 user:<用户名> 或 ip:<IP>
+type LoginFailure struct {
+	Key             string `json:"key" gorm:"column:failure_key;type:varchar(128);primaryKey"`
+	Failures        int    `json:"failures" gorm:"default:0"`
+	LastFailureTime int64  `json:"last_failure_time" gorm:"bigint"`
+	LockedUntil     int64  `json:"locked_until" gorm:"bigint;index;default:0"`
+}
+
+func loginFailureUserKey(username string) string {
+	return loginFailureUserPrefix + strings.ToLower(username)
+}
+
+func loginFailureIPKey(ip string) string {
+	return loginFailureIPPrefix + ip
+}
+
+func getLoginFailure(key string) *LoginFailure {
+	failure := &LoginFailure{}
+	if err := DB.Where("failure_key = ?", key).Limit(1).Find(failure).Error; err != nil || failure.Key == "" {
+		return nil
+	}
+	// 超出统计窗口的失败记录不再计数
+	if failure.LockedUntil <= common.GetTimestamp() && common.GetTimestamp()-failure.LastFailureTime > int64(common.LoginFailureWindowSeconds) {
+		return nil
+	}
+	return failure
+}
+
+// loginDelaySeconds 账户连续失败后的递增等待时间：第 2 次失败起依次为 1、2、4……秒
+func loginDelaySeconds(failures int) int64 {
+	if failures < 2 {
+		return 0
+	}
+	delay := int64(1) << uint(failures-2)
+	if delay > loginMaxDelaySeconds {
+		delay = loginMaxDelaySeconds
+	}
+	return delay
+}
+
+// CheckLoginAllowed 返回距离允许再次尝试登录还需等待的秒数，0 表示允许
+func CheckLoginAllowed(username string, ip string) int64 {
+	if !common.LoginLockoutEnabled {
+		return 0
+	}
+	now := common.GetTimestamp()
+	var wait int64
+	if failure := getLoginFailure(loginFailureUserKey(username)); failure != nil {
+		if failure.LockedUntil > now {
+			wait = failure.LockedUntil - now
+		} else if next := failure.LastFailureTime + loginDelaySeconds(failure.Failures); next > now {
+			wait = next - now
+		}
+	}
+	if failure := getLoginFailure(loginFailureIPKey(ip)); failure != nil && failure.LockedUntil-now > wait {
+		wait = failure.LockedUntil - now
+	}
+	return wait
+}
+
+// recordLoginFailure 原子地累加失败次数，并发的错误密码请求不会互相覆盖计数
+func recordLoginFailure(key string, maxFailures int) bool {
+	now := common.GetTimestamp()
+	locked := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&LoginFailure{Key: key, LastFailureTime: now}).Error
+		if err != nil {
+			return err
+		}
+		// 超出统计窗口且未锁定时重新计数
+		err = tx.Model(&LoginFailure{}).
+			Where("failure_key = ? and locked_until <= ? and last_failure_time < ?", key, now, now-int64(common.LoginFailureWindowSeconds)).
+			Update("failures", 0).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&LoginFailure{}).Where("failure_key = ?", key).Updates(map[string]interface{}{
+			"failures":          gorm.Expr("failures + 1"),
+			"last_failure_time": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		var failures int
+		if err = tx.Model(&LoginFailure{}).Where("failure_key = ?", key).Select("failures").Find(&failures).Error; err != nil {
+			return err
+		}
+		locked = maxFailures > 0 && failures >= maxFailures
+		if !locked {
+			return nil
+		}
+		return tx.Model(&LoginFailure{}).Where("failure_key = ?", key).Update("locked_until", now+int64(common.LoginLockoutSeconds)).Error
+	})
+	if err != nil {
+		common.SysError("failed to record login failure: " + err.Error())
+	}
+	return locked
+}
+
+// RecordLoginFailure 记录一次登录失败，返回账户是否因此被锁定
+func RecordLoginFailure(username string, ip string) bool {
+	if !common.LoginLockoutEnabled {
+		return false
+	}
+	recordLoginFailure(loginFailureIPKey(ip), common.LoginIPMaxFailures)
+	return recordLoginFailure(loginFailureUserKey(username), common.LoginMaxFailures)
+}
+
+// ClearLoginFailures 登录成功后清除账户的失败记录，IP 的失败记录保留至过期
+func ClearLoginFailures(username string) {
+	DB.Where("failure_key = ?", loginFailureUserKey(username)).Delete(&LoginFailure{})
+}
+
+// GetLockedLoginFailures 返回当前处于锁定状态的账户与 IP
+func GetLockedLoginFailures() (failures []*LoginFailure, err error) {
+	err = DB.Where("locked_until > ?", common.GetTimestamp()).Order("locked_until desc").Find(&failures).Error
+	return failures, err
+}
+
+// UnlockLogin 解除账户或 IP 的锁定并清空失败次数
+func UnlockLogin(key string) error {
+	return DB.Where("failure_key = ?", key).Delete(&LoginFailure{}).Error
+}
+
+// UnlockUserLogin 解除账户的锁定
+func UnlockUserLogin(username string) error {
+	return UnlockLogin(loginFailureUserKey(username))
+}
@@ -15,6 +15,9 @@ import (
 
 var DB *gorm.DB
 
+// LOG_DB 日志与数据看板使用的数据库，默认与 DB 相同
+var LOG_DB *gorm.DB
+
 func createRootAccountIfNeed() error {
 	var user User
 	//if user.Status != common.UserStatusEnabled {
@@ -30,7 +33,7 @@ func createRootAccountIfNeed() error {
 			Role:        common.RoleRootUser,
 			Status:      common.UserStatusEnabled,
 			DisplayName: "Root User",
-			AccessToken: common.GetUUID(),
+			AccessToken: accessTokenPlaceholder(),
 			Quota:       100000000,
 		}
 		DB.Create(&rootUser)
@@ -38,13 +41,20 @@ func createRootAccountIfNeed() error {
 	return nil
 }
 
-func chooseDB() (*gorm.DB, error) {
-	if os.Getenv("SQL_DSN") != "" {
-		dsn := os.Getenv("SQL_DSN")
+// chooseDB 根据环境变量中的 DSN 打开数据库，isLog 为 true 时设置日志库的数据库类型
+func chooseDB(envName string, isLog bool) (*gorm.DB, error) {
+	name := "database"
+	usingSQLite, usingPostgreSQL, usingMySQL := &common.UsingSQLite, &common.UsingPostgreSQL, &common.UsingMySQL
+	if isLog {
+		name = "log database"
+		usingSQLite, usingPostgreSQL, usingMySQL = &common.LogUsingSQLite, &common.LogUsingPostgreSQL, &common.LogUsingMySQL
+	}
+	dsn := os.Getenv(envName)
+	if dsn != "" && !strings.HasPrefix(dsn, "sqlite://") {
 		if strings.HasPrefix(dsn, "postgres://") {
 			// Use PostgreSQL
-			common.SysLog("using PostgreSQL as database")
-			common.UsingPostgreSQL = true
+			common.SysLog("using PostgreSQL as " + name)
+			*usingPostgreSQL = true
 			return gorm.Open(postgres.New(postgres.Config{
 				DSN:                  dsn,
 				PreferSimpleProtocol: true, // disables implicit prepared statement usage
@@ -53,7 +63,7 @@ func chooseDB() (*gorm.DB, error) {
 			})
 		}
 		// Use MySQL
-		common.SysLog("using MySQL as database")
+		common.SysLog("using MySQL as " + name)
 		// check parseTime
 		if !strings.Contains(dsn, "parseTime") {
 			if strings.Contains(dsn, "?") {
@@ -62,33 +72,46 @@ func chooseDB() (*gorm.DB, error) {
 				dsn += "?parseTime=true"
 			}
 		}
-		common.UsingMySQL = true
+		*usingMySQL = true
 		return gorm.Open(mysql.Open(dsn), &gorm.Config{
 			PrepareStmt: true, // precompile SQL
 		})
 	}
 	// Use SQLite
-	common.SysLog("SQL_DSN not set, using SQLite as database")
-	common.UsingSQLite = true
-	return gorm.Open(sqlite.Open(common.SQLitePath), &gorm.Config{
+	path := common.SQLitePath
+	if dsn != "" {
+		path = strings.TrimPrefix(dsn, "sqlite://")
+		common.SysLog("using SQLite as " + name)
+	} else {
+		common.SysLog(envName + " not set, using SQLite as " + name)
+	}
+	*usingSQLite = true
+	return gorm.Open(sqlite.Open(path), &gorm.Config{
 		PrepareStmt: true, // precompile SQL
 	})
 }
 
+func setDBConns(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	sqlDB.SetMaxIdleConns(common.GetOrDefault("SQL_MAX_IDLE_CONNS", 100))
+	sqlDB.SetMaxOpenConns(common.GetOrDefault("SQL_MAX_OPEN_CONNS", 1000))
+	sqlDB.SetConnMaxLifetime(time.Second * time.Duration(common.GetOrDefault("SQL_MAX_LIFETIME", 60)))
+	return nil
+}
+
 func InitDB() (err error) {
-	db, err := chooseDB()
+	db, err := chooseDB("SQL_DSN", false)
 	if err == nil {
 		if common.DebugEnabled {
 			db = db.Debug()
 		}
 		DB = db
-		sqlDB, err := DB.DB()
-		if err != nil {
+		if err = setDBConns(DB); err != nil {
 			return err
 		}
-		sqlDB.SetMaxIdleConns(common.GetOrDefault("SQL_MAX_IDLE_CONNS", 100))
-		sqlDB.SetMaxOpenConns(common.GetOrDefault("SQL_MAX_OPEN_CONNS", 1000))
-		sqlDB.SetConnMaxLifetime(time.Second * time.Duration(common.GetOrDefault("SQL_MAX_LIFETIME", 60)))
 
 		if !common.IsMasterNode {
 			return nil
@@ -124,22 +147,91 @@ func InitDB() (err error) {
 		if err != nil {
 			return err
 		}
-		err = db.AutoMigrate(&Log{})
+		err = db.AutoMigrate(&Midjourney{})
 		if err != nil {
 			return err
 		}
-		err = db.AutoMigrate(&Midjourney{})
+		err = db.AutoMigrate(&TopUp{})
 		if err != nil {
 			return err
 		}
-		err = db.AutoMigrate(&TopUp{})
+		err = db.AutoMigrate(&QuotaLedger{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Statement{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Organization{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&OrganizationMember{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Role{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&AuditLog{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserTwoFA{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserSession{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&AccessToken{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&LoginFailure{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&PasswordHistory{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&AffCommission{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&RedemptionCampaign{})
 		if err != nil {
 			return err
 		}
-		err = db.AutoMigrate(&QuotaData{})
+		err = db.AutoMigrate(&RedemptionUse{})
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&UserGroupGrant{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&SubscriptionPlan{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserSubscription{})
+		if err != nil {
+			return err
+		}
+		if err = migrateLegacyAccessTokens(); err != nil {
+			return err
+		}
+		if err = migrateLegacyRedemptions(); err != nil {
+			return err
+		}
+		if err = ensureBuiltInRoles(); err != nil {
+			return err
+		}
 		common.SysLog("database migrated")
 		err = createRootAccountIfNeed()
 		return err
@@ -149,8 +241,50 @@ func InitDB() (err error) {
 	return err
 }
 
-func CloseDB() error {
-	sqlDB, err := DB.DB()
+// InitLogDB 初始化日志库，未设置 LOG_SQL_DSN 时与主库共用连接，日志相关的表在日志库中单独迁移
+func InitLogDB() (err error) {
+	if os.Getenv("LOG_SQL_DSN") == "" {
+		LOG_DB = DB
+		common.LogUsingSQLite, common.LogUsingPostgreSQL, common.LogUsingMySQL = common.UsingSQLite, common.UsingPostgreSQL, common.UsingMySQL
+	} else {
+		db, err := chooseDB("LOG_SQL_DSN", true)
+		if err != nil {
+			return err
+		}
+		if common.DebugEnabled {
+			db = db.Debug()
+		}
+		LOG_DB = db
+		if err = setDBConns(LOG_DB); err != nil {
+			return err
+		}
+	}
+	if !common.IsMasterNode {
+		return nil
+	}
+	common.SysLog("log database migration started")
+	err = migrateLogTable(LOG_DB)
+	if err != nil {
+		return err
+	}
+	err = LOG_DB.AutoMigrate(&QuotaData{})
+	if err != nil {
+		return err
+	}
+	err = LOG_DB.AutoMigrate(&LogArchive{})
+	if err != nil {
+		return err
+	}
+	err = LOG_DB.AutoMigrate(&ChannelStat{})
+	if err != nil {
+		return err
+	}
+	common.SysLog("log database migrated")
+	return nil
+}
+
+func closeDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
 	if err != nil {
 		return err
 	}
@@ -158,6 +292,21 @@ func CloseDB() error {
 	return err
 }
 
+// CloseDB 写入内存中尚未落库的批量更新、消费日志与渠道统计后关闭数据库
+func CloseDB() error {
+	if common.BatchUpdateEnabled {
+		batchUpdate()
+	}
+	FlushConsumeLogWriter()
+	SaveChannelStatCache()
+	if LOG_DB != nil && LOG_DB != DB {
+		if err := closeDB(LOG_DB); err != nil {
+			return err
+		}
+	}
+	return closeDB(DB)
+}
+
 var (
 	lastPingTime time.Time
 	pingMutex    sync.Mutex
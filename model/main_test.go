@@ -0,0 +1,47 @@
+package model
+
+import (
+	"one-api/common"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "one-api-model-test")
+	if err != nil {
+		panic(err)
+	}
+	common.SQLitePath = filepath.Join(dir, "one-api.db") + "?_busy_timeout=5000"
+	common.RedisEnabled = false
+	common.IsMasterNode = true
+	if err = InitDB(); err != nil {
+		panic(err)
+	}
+	if err = InitLogDB(); err != nil {
+		panic(err)
+	}
+	InitOptionMap()
+	code := m.Run()
+	_ = CloseDB()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// createTestUser 创建一个额度为 quota 的普通用户
+func createTestUser(t *testing.T, username string, quota int) *User {
+	user := &User{
+		Username:    username,
+		Password:    "password123",
+		DisplayName: username,
+		Role:        common.RoleCommonUser,
+		Status:      common.UserStatusEnabled,
+		Quota:       quota,
+		AccessToken: common.GetUUID(),
+		AffCode:     common.GetRandomString(4) + username,
+	}
+	if err := DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
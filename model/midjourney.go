@@ -19,6 +19,7 @@ type Midjourney struct {
 	FailReason  string `json:"fail_reason"`
 	ChannelId   int    `json:"channel_id"`
 	Quota       int    `json:"quota"`
+	OrgId       int    `json:"org_id" gorm:"default:0"` // 组织令牌提交的任务由组织额度池付费，失败时退还组织
 	Buttons     string `json:"buttons"`
 	Properties  string `json:"properties"`
 }
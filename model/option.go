@@ -31,9 +31,22 @@ func InitOptionMap() {
 	common.OptionMap["PasswordRegisterEnabled"] = strconv.FormatBool(common.PasswordRegisterEnabled)
 	common.OptionMap["EmailVerificationEnabled"] = strconv.FormatBool(common.EmailVerificationEnabled)
 	common.OptionMap["GitHubOAuthEnabled"] = strconv.FormatBool(common.GitHubOAuthEnabled)
+	common.OptionMap["OIDCEnabled"] = strconv.FormatBool(common.OIDCEnabled)
+	common.OptionMap["LDAPLoginEnabled"] = strconv.FormatBool(common.LDAPLoginEnabled)
 	common.OptionMap["TelegramOAuthEnabled"] = strconv.FormatBool(common.TelegramOAuthEnabled)
 	common.OptionMap["WeChatAuthEnabled"] = strconv.FormatBool(common.WeChatAuthEnabled)
 	common.OptionMap["TurnstileCheckEnabled"] = strconv.FormatBool(common.TurnstileCheckEnabled)
+	common.OptionMap["TwoFAAdminEnforcementEnabled"] = strconv.FormatBool(common.TwoFAAdminEnforcementEnabled)
+	common.OptionMap["TwoFARecentSeconds"] = strconv.Itoa(common.TwoFARecentSeconds)
+	common.OptionMap["LoginLockoutEnabled"] = strconv.FormatBool(common.LoginLockoutEnabled)
+	common.OptionMap["LoginMaxFailures"] = strconv.Itoa(common.LoginMaxFailures)
+	common.OptionMap["LoginIPMaxFailures"] = strconv.Itoa(common.LoginIPMaxFailures)
+	common.OptionMap["LoginFailureWindowSeconds"] = strconv.Itoa(common.LoginFailureWindowSeconds)
+	common.OptionMap["LoginLockoutSeconds"] = strconv.Itoa(common.LoginLockoutSeconds)
+	common.OptionMap["PasswordMinLength"] = strconv.Itoa(common.PasswordMinLength)
+	common.OptionMap["PasswordRequiredCharClasses"] = strconv.Itoa(common.PasswordRequiredCharClasses)
+	common.OptionMap["PasswordBlockCommonEnabled"] = strconv.FormatBool(common.PasswordBlockCommonEnabled)
+	common.OptionMap["PasswordHistoryCount"] = strconv.Itoa(common.PasswordHistoryCount)
 	common.OptionMap["RegisterEnabled"] = strconv.FormatBool(common.RegisterEnabled)
 	common.OptionMap["AutomaticDisableChannelEnabled"] = strconv.FormatBool(common.AutomaticDisableChannelEnabled)
 	common.OptionMap["AutomaticEnableChannelEnabled"] = strconv.FormatBool(common.AutomaticEnableChannelEnabled)
@@ -70,6 +83,26 @@ func InitOptionMap() {
 	common.OptionMap["TopupGroupRatio"] = common.TopupGroupRatio2JSONString()
 	common.OptionMap["GitHubClientId"] = ""
 	common.OptionMap["GitHubClientSecret"] = ""
+	common.OptionMap["OIDCIssuer"] = ""
+	common.OptionMap["OIDCClientId"] = ""
+	common.OptionMap["OIDCClientSecret"] = ""
+	common.OptionMap["OIDCScopes"] = common.OIDCScopes
+	common.OptionMap["OIDCUsernameClaim"] = common.OIDCUsernameClaim
+	common.OptionMap["OIDCEmailClaim"] = common.OIDCEmailClaim
+	common.OptionMap["OIDCGroupClaim"] = common.OIDCGroupClaim
+	common.OptionMap["OIDCGroupMapping"] = common.OIDCGroupMapping2JSONString()
+	common.OptionMap["LDAPServerURL"] = ""
+	common.OptionMap["LDAPStartTLSEnabled"] = strconv.FormatBool(common.LDAPStartTLSEnabled)
+	common.OptionMap["LDAPSkipTLSVerifyEnabled"] = strconv.FormatBool(common.LDAPSkipTLSVerifyEnabled)
+	common.OptionMap["LDAPBindDN"] = ""
+	common.OptionMap["LDAPBindPassword"] = ""
+	common.OptionMap["LDAPBaseDN"] = ""
+	common.OptionMap["LDAPUserFilter"] = common.LDAPUserFilter
+	common.OptionMap["LDAPUsernameAttribute"] = common.LDAPUsernameAttribute
+	common.OptionMap["LDAPDisplayNameAttribute"] = common.LDAPDisplayNameAttribute
+	common.OptionMap["LDAPEmailAttribute"] = common.LDAPEmailAttribute
+	common.OptionMap["LDAPGroupAttribute"] = common.LDAPGroupAttribute
+	common.OptionMap["LDAPGroupMapping"] = common.LDAPGroupMapping2JSONString()
 	common.OptionMap["TelegramBotToken"] = ""
 	common.OptionMap["TelegramBotName"] = ""
 	common.OptionMap["WeChatServerAddress"] = ""
@@ -80,12 +113,20 @@ func InitOptionMap() {
 	common.OptionMap["QuotaForNewUser"] = strconv.Itoa(common.QuotaForNewUser)
 	common.OptionMap["QuotaForInviter"] = strconv.Itoa(common.QuotaForInviter)
 	common.OptionMap["QuotaForInvitee"] = strconv.Itoa(common.QuotaForInvitee)
+	common.OptionMap["AffCommissionEnabled"] = strconv.FormatBool(common.AffCommissionEnabled)
+	common.OptionMap["AffCommissionRedemptionEnabled"] = strconv.FormatBool(common.AffCommissionRedemptionEnabled)
+	common.OptionMap["AffCommissionRates"] = common.AffCommissionRates2JSONString()
+	common.OptionMap["AffCommissionDays"] = strconv.Itoa(common.AffCommissionDays)
+	common.OptionMap["AffCommissionCap"] = strconv.Itoa(common.AffCommissionCap)
+	common.OptionMap["GroupPromotionEnabled"] = strconv.FormatBool(common.GroupPromotionEnabled)
+	common.OptionMap["GroupPromotionRules"] = common.GroupPromotionRules2JSONString()
 	common.OptionMap["QuotaRemindThreshold"] = strconv.Itoa(common.QuotaRemindThreshold)
 	common.OptionMap["PreConsumedQuota"] = strconv.Itoa(common.PreConsumedQuota)
 	common.OptionMap["ModelRatio"] = common.ModelRatio2JSONString()
 	common.OptionMap["ModelPrice"] = common.ModelPrice2JSONString()
 	common.OptionMap["GroupRatio"] = common.GroupRatio2JSONString()
 	common.OptionMap["CompletionRatio"] = common.CompletionRatio2JSONString()
+	common.OptionMap["ModelPricing"] = common.ModelPricing2JSONString()
 	common.OptionMap["TopUpLink"] = common.TopUpLink
 	common.OptionMap["ChatLink"] = common.ChatLink
 	common.OptionMap["ChatLink2"] = common.ChatLink2
@@ -93,6 +134,9 @@ func InitOptionMap() {
 	common.OptionMap["RetryTimes"] = strconv.Itoa(common.RetryTimes)
 	common.OptionMap["DataExportInterval"] = strconv.Itoa(common.DataExportInterval)
 	common.OptionMap["DataExportDefaultTime"] = common.DataExportDefaultTime
+	common.OptionMap["DataExportHourRetentionDays"] = strconv.Itoa(common.DataExportHourRetentionDays)
+	common.OptionMap["DataExportDayRetentionDays"] = strconv.Itoa(common.DataExportDayRetentionDays)
+	common.OptionMap["DataExportMonthRetentionMonths"] = strconv.Itoa(common.DataExportMonthRetentionMonths)
 	common.OptionMap["DefaultCollapseSidebar"] = strconv.FormatBool(common.DefaultCollapseSidebar)
 	common.OptionMap["MjNotifyEnabled"] = strconv.FormatBool(constant.MjNotifyEnabled)
 	common.OptionMap["MjAccountFilterEnabled"] = strconv.FormatBool(constant.MjAccountFilterEnabled)
@@ -104,6 +148,41 @@ func InitOptionMap() {
 	common.OptionMap["StopOnSensitiveEnabled"] = strconv.FormatBool(constant.StopOnSensitiveEnabled)
 	common.OptionMap["SensitiveWords"] = constant.SensitiveWordsToString()
 	common.OptionMap["StreamCacheQueueLength"] = strconv.Itoa(constant.StreamCacheQueueLength)
+	common.OptionMap["ModerationEnabled"] = strconv.FormatBool(constant.ModerationEnabled)
+	common.OptionMap["ModerationModel"] = constant.ModerationModel
+	common.OptionMap["ModerationEndpoint"] = constant.ModerationEndpoint
+	common.OptionMap["ModerationKey"] = ""
+	common.OptionMap["ModerationAction"] = constant.ModerationAction
+	common.OptionMap["ModerationFailOpenEnabled"] = strconv.FormatBool(constant.ModerationFailOpenEnabled)
+	common.OptionMap["ModerationCacheSeconds"] = strconv.Itoa(constant.ModerationCacheSeconds)
+	common.OptionMap["ModerationThresholds"] = constant.ModerationThresholds2JSONString()
+	common.OptionMap["ModerationGroups"] = constant.ModerationGroups2JSONString()
+	common.OptionMap["PIIRedactionEnabled"] = strconv.FormatBool(constant.PIIRedactionEnabled)
+	common.OptionMap["PIIRedactionMode"] = constant.PIIRedactionMode
+	common.OptionMap["PIIRedactionDetectors"] = constant.PIIRedactionDetectorsToString()
+	common.OptionMap["PIICustomDetectors"] = constant.PIICustomDetectors2JSONString()
+	common.OptionMap["PIIRedactionGroups"] = constant.PIIRedactionGroups2JSONString()
+	common.OptionMap["PIIRedactionChannels"] = constant.PIIRedactionChannels2JSONString()
+	common.OptionMap["TokenizerMappings"] = constant.TokenizerMappings2JSONString()
+	common.OptionMap["StatementEnabled"] = strconv.FormatBool(constant.StatementEnabled)
+	common.OptionMap["StatementEmailEnabled"] = strconv.FormatBool(constant.StatementEmailEnabled)
+	common.OptionMap["StatementCompanyName"] = constant.StatementCompanyName
+	common.OptionMap["StatementCompanyAddress"] = constant.StatementCompanyAddress
+	common.OptionMap["StatementCompanyTaxId"] = constant.StatementCompanyTaxId
+	common.OptionMap["StatementCompanyEmail"] = constant.StatementCompanyEmail
+	common.OptionMap["StatementCompanyPhone"] = constant.StatementCompanyPhone
+	common.OptionMap["StatementNote"] = constant.StatementNote
+	common.OptionMap["LogArchiveEnabled"] = strconv.FormatBool(constant.LogArchiveEnabled)
+	common.OptionMap["LogArchiveDays"] = strconv.Itoa(constant.LogArchiveDays)
+	common.OptionMap["LogArchiveStorage"] = constant.LogArchiveStorage
+	common.OptionMap["LogArchiveDir"] = constant.LogArchiveDir
+	common.OptionMap["LogArchiveS3Endpoint"] = constant.LogArchiveS3Endpoint
+	common.OptionMap["LogArchiveS3Region"] = constant.LogArchiveS3Region
+	common.OptionMap["LogArchiveS3Bucket"] = constant.LogArchiveS3Bucket
+	common.OptionMap["LogArchiveS3Prefix"] = constant.LogArchiveS3Prefix
+	common.OptionMap["LogArchiveS3AccessKey"] = ""
+	common.OptionMap["LogArchiveS3SecretKey"] = ""
+	common.OptionMap["LogArchiveS3PathStyleEnabled"] = strconv.FormatBool(constant.LogArchiveS3PathStyleEnabled)
 
 	common.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
@@ -171,12 +250,26 @@ func updateOptionMap(key string, value string) (err error) {
 			common.EmailVerificationEnabled = boolValue
 		case "GitHubOAuthEnabled":
 			common.GitHubOAuthEnabled = boolValue
+		case "OIDCEnabled":
+			common.OIDCEnabled = boolValue
+		case "LDAPLoginEnabled":
+			common.LDAPLoginEnabled = boolValue
+		case "LDAPStartTLSEnabled":
+			common.LDAPStartTLSEnabled = boolValue
+		case "LDAPSkipTLSVerifyEnabled":
+			common.LDAPSkipTLSVerifyEnabled = boolValue
 		case "WeChatAuthEnabled":
 			common.WeChatAuthEnabled = boolValue
 		case "TelegramOAuthEnabled":
 			common.TelegramOAuthEnabled = boolValue
 		case "TurnstileCheckEnabled":
 			common.TurnstileCheckEnabled = boolValue
+		case "TwoFAAdminEnforcementEnabled":
+			common.TwoFAAdminEnforcementEnabled = boolValue
+		case "LoginLockoutEnabled":
+			common.LoginLockoutEnabled = boolValue
+		case "PasswordBlockCommonEnabled":
+			common.PasswordBlockCommonEnabled = boolValue
 		case "RegisterEnabled":
 			common.RegisterEnabled = boolValue
 		case "EmailDomainRestrictionEnabled":
@@ -191,6 +284,12 @@ func updateOptionMap(key string, value string) (err error) {
 			common.LogConsumeEnabled = boolValue
 		case "DisplayInCurrencyEnabled":
 			common.DisplayInCurrencyEnabled = boolValue
+		case "AffCommissionEnabled":
+			common.AffCommissionEnabled = boolValue
+		case "AffCommissionRedemptionEnabled":
+			common.AffCommissionRedemptionEnabled = boolValue
+		case "GroupPromotionEnabled":
+			common.GroupPromotionEnabled = boolValue
 		case "DisplayTokenStatEnabled":
 			common.DisplayTokenStatEnabled = boolValue
 		case "DrawingEnabled":
@@ -215,6 +314,20 @@ func updateOptionMap(key string, value string) (err error) {
 		//	constant.CheckSensitiveOnCompletionEnabled = boolValue
 		case "StopOnSensitiveEnabled":
 			constant.StopOnSensitiveEnabled = boolValue
+		case "ModerationEnabled":
+			constant.ModerationEnabled = boolValue
+		case "ModerationFailOpenEnabled":
+			constant.ModerationFailOpenEnabled = boolValue
+		case "PIIRedactionEnabled":
+			constant.PIIRedactionEnabled = boolValue
+		case "StatementEnabled":
+			constant.StatementEnabled = boolValue
+		case "StatementEmailEnabled":
+			constant.StatementEmailEnabled = boolValue
+		case "LogArchiveEnabled":
+			constant.LogArchiveEnabled = boolValue
+		case "LogArchiveS3PathStyleEnabled":
+			constant.LogArchiveS3PathStyleEnabled = boolValue
 		case "SMTPSSLEnabled":
 			common.SMTPSSLEnabled = boolValue
 		}
@@ -257,6 +370,42 @@ func updateOptionMap(key string, value string) (err error) {
 		common.GitHubClientId = value
 	case "GitHubClientSecret":
 		common.GitHubClientSecret = value
+	case "OIDCIssuer":
+		common.OIDCIssuer = strings.TrimSuffix(value, "/")
+	case "OIDCClientId":
+		common.OIDCClientId = value
+	case "OIDCClientSecret":
+		common.OIDCClientSecret = value
+	case "OIDCScopes":
+		common.OIDCScopes = value
+	case "OIDCUsernameClaim":
+		common.OIDCUsernameClaim = value
+	case "OIDCEmailClaim":
+		common.OIDCEmailClaim = value
+	case "OIDCGroupClaim":
+		common.OIDCGroupClaim = value
+	case "OIDCGroupMapping":
+		err = common.UpdateOIDCGroupMappingByJSONString(value)
+	case "LDAPServerURL":
+		common.LDAPServerURL = value
+	case "LDAPBindDN":
+		common.LDAPBindDN = value
+	case "LDAPBindPassword":
+		common.LDAPBindPassword = value
+	case "LDAPBaseDN":
+		common.LDAPBaseDN = value
+	case "LDAPUserFilter":
+		common.LDAPUserFilter = value
+	case "LDAPUsernameAttribute":
+		common.LDAPUsernameAttribute = value
+	case "LDAPDisplayNameAttribute":
+		common.LDAPDisplayNameAttribute = value
+	case "LDAPEmailAttribute":
+		common.LDAPEmailAttribute = value
+	case "LDAPGroupAttribute":
+		common.LDAPGroupAttribute = value
+	case "LDAPGroupMapping":
+		err = common.UpdateLDAPGroupMappingByJSONString(value)
 	case "Footer":
 		common.Footer = value
 	case "SystemName":
@@ -277,12 +426,36 @@ func updateOptionMap(key string, value string) (err error) {
 		common.TurnstileSiteKey = value
 	case "TurnstileSecretKey":
 		common.TurnstileSecretKey = value
+	case "TwoFARecentSeconds":
+		common.TwoFARecentSeconds, _ = strconv.Atoi(value)
+	case "LoginMaxFailures":
+		common.LoginMaxFailures, _ = strconv.Atoi(value)
+	case "LoginIPMaxFailures":
+		common.LoginIPMaxFailures, _ = strconv.Atoi(value)
+	case "LoginFailureWindowSeconds":
+		common.LoginFailureWindowSeconds, _ = strconv.Atoi(value)
+	case "LoginLockoutSeconds":
+		common.LoginLockoutSeconds, _ = strconv.Atoi(value)
+	case "PasswordMinLength":
+		common.PasswordMinLength, _ = strconv.Atoi(value)
+	case "PasswordRequiredCharClasses":
+		common.PasswordRequiredCharClasses, _ = strconv.Atoi(value)
+	case "PasswordHistoryCount":
+		common.PasswordHistoryCount, _ = strconv.Atoi(value)
 	case "QuotaForNewUser":
 		common.QuotaForNewUser, _ = strconv.Atoi(value)
 	case "QuotaForInviter":
 		common.QuotaForInviter, _ = strconv.Atoi(value)
 	case "QuotaForInvitee":
 		common.QuotaForInvitee, _ = strconv.Atoi(value)
+	case "AffCommissionRates":
+		err = common.UpdateAffCommissionRatesByJSONString(value)
+	case "AffCommissionDays":
+		common.AffCommissionDays, _ = strconv.Atoi(value)
+	case "AffCommissionCap":
+		common.AffCommissionCap, _ = strconv.Atoi(value)
+	case "GroupPromotionRules":
+		err = common.UpdateGroupPromotionRulesByJSONString(value)
 	case "QuotaRemindThreshold":
 		common.QuotaRemindThreshold, _ = strconv.Atoi(value)
 	case "PreConsumedQuota":
@@ -293,6 +466,12 @@ func updateOptionMap(key string, value string) (err error) {
 		common.DataExportInterval, _ = strconv.Atoi(value)
 	case "DataExportDefaultTime":
 		common.DataExportDefaultTime = value
+	case "DataExportHourRetentionDays":
+		common.DataExportHourRetentionDays, _ = strconv.Atoi(value)
+	case "DataExportDayRetentionDays":
+		common.DataExportDayRetentionDays, _ = strconv.Atoi(value)
+	case "DataExportMonthRetentionMonths":
+		common.DataExportMonthRetentionMonths, _ = strconv.Atoi(value)
 	case "ModelRatio":
 		err = common.UpdateModelRatioByJSONString(value)
 	case "GroupRatio":
@@ -301,6 +480,8 @@ func updateOptionMap(key string, value string) (err error) {
 		err = common.UpdateCompletionRatioByJSONString(value)
 	case "ModelPrice":
 		err = common.UpdateModelPriceByJSONString(value)
+	case "ModelPricing":
+		err = common.UpdateModelPricingByJSONString(value)
 	case "TopUpLink":
 		common.TopUpLink = value
 	case "ChatLink":
@@ -315,6 +496,62 @@ func updateOptionMap(key string, value string) (err error) {
 		constant.SensitiveWordsFromString(value)
 	case "StreamCacheQueueLength":
 		constant.StreamCacheQueueLength, _ = strconv.Atoi(value)
+	case "ModerationModel":
+		constant.ModerationModel = value
+	case "ModerationEndpoint":
+		constant.ModerationEndpoint = value
+	case "ModerationKey":
+		constant.ModerationKey = value
+	case "ModerationAction":
+		constant.ModerationAction = value
+	case "ModerationCacheSeconds":
+		constant.ModerationCacheSeconds, _ = strconv.Atoi(value)
+	case "ModerationThresholds":
+		err = constant.UpdateModerationThresholdsByJSONString(value)
+	case "ModerationGroups":
+		err = constant.UpdateModerationGroupsByJSONString(value)
+	case "PIIRedactionMode":
+		constant.PIIRedactionMode = value
+	case "PIIRedactionDetectors":
+		constant.PIIRedactionDetectorsFromString(value)
+	case "PIICustomDetectors":
+		err = constant.UpdatePIICustomDetectorsByJSONString(value)
+	case "PIIRedactionGroups":
+		err = constant.UpdatePIIRedactionGroupsByJSONString(value)
+	case "PIIRedactionChannels":
+		err = constant.UpdatePIIRedactionChannelsByJSONString(value)
+	case "TokenizerMappings":
+		err = constant.UpdateTokenizerMappingsByJSONString(value)
+	case "StatementCompanyName":
+		constant.StatementCompanyName = value
+	case "StatementCompanyAddress":
+		constant.StatementCompanyAddress = value
+	case "StatementCompanyTaxId":
+		constant.StatementCompanyTaxId = value
+	case "StatementCompanyEmail":
+		constant.StatementCompanyEmail = value
+	case "StatementCompanyPhone":
+		constant.StatementCompanyPhone = value
+	case "StatementNote":
+		constant.StatementNote = value
+	case "LogArchiveDays":
+		constant.LogArchiveDays, _ = strconv.Atoi(value)
+	case "LogArchiveStorage":
+		constant.LogArchiveStorage = value
+	case "LogArchiveDir":
+		constant.LogArchiveDir = value
+	case "LogArchiveS3Endpoint":
+		constant.LogArchiveS3Endpoint = value
+	case "LogArchiveS3Region":
+		constant.LogArchiveS3Region = value
+	case "LogArchiveS3Bucket":
+		constant.LogArchiveS3Bucket = value
+	case "LogArchiveS3Prefix":
+		constant.LogArchiveS3Prefix = value
+	case "LogArchiveS3AccessKey":
+		constant.LogArchiveS3AccessKey = value
+	case "LogArchiveS3SecretKey":
+		constant.LogArchiveS3SecretKey = value
 	}
 	return err
 }
@@ -0,0 +1,347 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OrgRoleMember = "member"
+	OrgRoleAdmin  = "admin"
+	OrgRoleOwner  = "owner"
+)
+
+const (
+	OrgStatusEnabled  = 1
+	OrgStatusDisabled = 2
+)
+
+// Organization 组织，成员通过组织令牌共享组织的额度池与分组
+type Organization struct {
+	Id          int            `json:"id"`
+	Name        string         `json:"name" gorm:"index"`
+	OwnerId     int            `json:"owner_id" gorm:"index"`
+	Quota       int            `json:"quota" gorm:"default:0"`
+	UsedQuota   int            `json:"used_quota" gorm:"default:0"`
+	Group       string         `json:"group" gorm:"type:varchar(64);default:''"`
+	Status      int            `json:"status" gorm:"default:1"`
+	CreatedTime int64          `json:"created_time" gorm:"bigint"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// OrganizationMember 组织成员，SpendLimit 为 0 表示不限制该成员的消费
+type OrganizationMember struct {
+	Id         int    `json:"id"`
+	OrgId      int    `json:"org_id" gorm:"uniqueIndex:idx_org_member,priority:1"`
+	UserId     int    `json:"user_id" gorm:"uniqueIndex:idx_org_member,priority:2;index"`
+	Username   string `json:"username" gorm:"-:all"`
+	Role       string `json:"role" gorm:"type:varchar(16);default:'member'"`
+	SpendLimit int    `json:"spend_limit" gorm:"default:0"`
+	UsedQuota  int    `json:"used_quota" gorm:"default:0"`
+	JoinedTime int64  `json:"joined_time" gorm:"bigint"`
+}
+
+// UserOrganization 用户所在的组织及其角色
+type UserOrganization struct {
+	Organization
+	Role       string `json:"role"`
+	SpendLimit int    `json:"spend_limit"`
+	MemberUsed int    `json:"member_used_quota"`
+}
+
+func IsValidOrgRole(role string) bool {
+	return role == OrgRoleMember || role == OrgRoleAdmin || role == OrgRoleOwner
+}
+
+func orgRoleLevel(role string) int {
+	switch role {
+	case OrgRoleOwner:
+		return 3
+	case OrgRoleAdmin:
+		return 2
+	case OrgRoleMember:
+		return 1
+	}
+	return 0
+}
+
+// HasRole 成员角色是否不低于 role
+func (member *OrganizationMember) HasRole(role string) bool {
+	return orgRoleLevel(member.Role) >= orgRoleLevel(role)
+}
+
+// CreateOrganization 创建组织并将创建者设为所有者
+func CreateOrganization(name string, ownerId int) (*Organization, error) {
+	org := &Organization{
+		Name:        name,
+		OwnerId:     ownerId,
+		Status:      OrgStatusEnabled,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrgId:      org.Id,
+			UserId:     ownerId,
+			Role:       OrgRoleOwner,
+			JoinedTime: org.CreatedTime,
+		}).Error
+	})
+	return org, err
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	org := &Organization{}
+	err := DB.First(org, "id = ?", id).Error
+	return org, err
+}
+
+func GetAllOrganizations(keyword string, startIdx int, num int) (orgs []*Organization, err error) {
+	tx := DB.Model(&Organization{})
+	if keyword != "" {
+		tx = tx.Where("name LIKE ?", "%"+keyword+"%")
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, err
+}
+
+func GetUserOrganizations(userId int) ([]*UserOrganization, error) {
+	var members []*OrganizationMember
+	if err := DB.Where("user_id = ?", userId).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*UserOrganization, 0, len(members))
+	for _, member := range members {
+		org, err := GetOrganizationById(member.OrgId)
+		if err != nil {
+			continue
+		}
+		result = append(result, &UserOrganization{
+			Organization: *org,
+			Role:         member.Role,
+			SpendLimit:   member.SpendLimit,
+			MemberUsed:   member.UsedQuota,
+		})
+	}
+	return result, nil
+}
+
+// Update 更新组织名称、分组与状态，额度通过专门的方法变更
+func (org *Organization) Update() error {
+	return DB.Model(org).Select("name", "group", "status").Updates(org).Error
+}
+
+// Delete 删除组织与成员关系，组织令牌随之失效
+func (org *Organization) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("org_id = ?", org.Id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(org).Error
+	})
+}
+
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	member := &OrganizationMember{}
+	err := DB.Where("org_id = ? and user_id = ?", orgId, userId).First(member).Error
+	return member, err
+}
+
+func GetOrganizationMembers(orgId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Where("org_id = ?", orgId).Order("id").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		member.Username, _ = CacheGetUsername(member.UserId)
+	}
+	return members, nil
+}
+
+func AddOrganizationMember(orgId int, userId int, role string, spendLimit int) (*OrganizationMember, error) {
+	if !IsValidOrgRole(role) || role == OrgRoleOwner {
+		return nil, errors.New("无效的成员角色")
+	}
+	member := &OrganizationMember{
+		OrgId:      orgId,
+		UserId:     userId,
+		Role:       role,
+		SpendLimit: spendLimit,
+		JoinedTime: common.GetTimestamp(),
+	}
+	var count int64
+	if err := DB.Model(&OrganizationMember{}).Where("org_id = ? and user_id = ?", orgId, userId).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("该用户已是组织成员")
+	}
+	return member, DB.Create(member).Error
+}
+
+func (member *OrganizationMember) Update() error {
+	return DB.Model(member).Select("role", "spend_limit").Updates(member).Error
+}
+
+func (member *OrganizationMember) Delete() error {
+	return DB.Delete(member).Error
+}
+
+// TransferQuotaToOrganization 将用户自己的额度转入组织额度池
+func TransferQuotaToOrganization(orgId int, userId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("转入额度必须大于 0")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? and quota >= ?", userId, quota).Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("用户额度不足")
+		}
+		if err := recordQuotaLedgerTx(tx, userId, -quota, LedgerReasonOrgTransfer, fmt.Sprintf("org:%d", orgId)); err != nil {
+			return err
+		}
+		err := tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		return recordOrganizationLedgerTx(tx, orgId, userId, quota, LedgerReasonOrgTransfer, userLedgerAccount(userId))
+	})
+}
+
+// AdjustOrganizationQuota 管理员直接调整组织额度
+func AdjustOrganizationQuota(orgId int, quota int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var org Organization
+		err := lockForUpdate(tx).Select("id", "quota").First(&org, "id = ?", orgId).Error
+		if err != nil {
+			return err
+		}
+		if org.Quota == quota {
+			return nil
+		}
+		if err = tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", quota).Error; err != nil {
+			return err
+		}
+		return recordOrganizationLedgerTx(tx, orgId, 0, quota-org.Quota, LedgerReasonAdjust, "")
+	})
+}
+
+// GetOrganizationPayerQuota 返回成员通过组织可用的额度，即组织剩余额度与成员剩余消费上限中的较小值
+func GetOrganizationPayerQuota(orgId int, userId int) (int, error) {
+	org, err := GetOrganizationById(orgId)
+	if err != nil {
+		return 0, err
+	}
+	if org.Status != OrgStatusEnabled {
+		return 0, errors.New("组织已被禁用")
+	}
+	member, err := GetOrganizationMember(orgId, userId)
+	if err != nil {
+		return 0, errors.New("用户不是该组织的成员")
+	}
+	quota := org.Quota
+	if member.SpendLimit > 0 && member.SpendLimit-member.UsedQuota < quota {
+		quota = member.SpendLimit - member.UsedQuota
+	}
+	return quota, nil
+}
+
+// changeOrganizationQuota 从组织额度池扣减（quota 为负时退还），同时累计成员的消费并记录流水。
+// checkBalance 为 true 时按条件扣减，组织额度不足或超出成员消费上限时返回错误，用于预扣费；
+// 结算与退还按实际发生额记账
+func changeOrganizationQuota(orgId int, userId int, quota int, checkBalance bool, reason string, reference string) error {
+	if quota == 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		orgTx := tx.Model(&Organization{}).Where("id = ?", orgId)
+		if checkBalance && quota > 0 {
+			orgTx = orgTx.Where("quota >= ?", quota)
+		}
+		result := orgTx.Updates(map[string]interface{}{
+			"quota":      gorm.Expr("quota - ?", quota),
+			"used_quota": gorm.Expr("used_quota + ?", quota),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("组织额度不足")
+		}
+		memberTx := tx.Model(&OrganizationMember{}).Where("org_id = ? and user_id = ?", orgId, userId)
+		if checkBalance && quota > 0 {
+			memberTx = memberTx.Where("(spend_limit <= 0 or spend_limit - used_quota >= ?)", quota)
+		}
+		result = memberTx.Update("used_quota", gorm.Expr("used_quota + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if checkBalance && result.RowsAffected == 0 {
+			return errors.New("超出成员消费上限")
+		}
+		return recordOrganizationLedgerTx(tx, orgId, userId, -quota, reason, reference)
+	})
+}
+
+// RefundOrganizationQuota 将已结算的额度退还组织额度池，用于异步任务失败后的补偿
+func RefundOrganizationQuota(orgId int, userId int, quota int, reference string) error {
+	return changeOrganizationQuota(orgId, userId, -quota, false, LedgerReasonRefund, reference)
+}
+
+// ValidateOrganizationToken 校验组织令牌的持有者仍是启用组织的成员，返回组织分组
+func ValidateOrganizationToken(orgId int, userId int) (string, error) {
+	org, err := GetOrganizationById(orgId)
+	if err != nil {
+		return "", errors.New("令牌所属组织不存在")
+	}
+	if org.Status != OrgStatusEnabled {
+		return "", errors.New("令牌所属组织已被禁用")
+	}
+	if _, err = GetOrganizationMember(orgId, userId); err != nil {
+		return "", errors.New("用户已不是令牌所属组织的成员")
+	}
+	return org.Group, nil
+}
+
+func GetOrganizationLogs(orgId int, userId int, logType int, startTimestamp int64, endTimestamp int64, startIdx int, num int) (logs []*Log, err error) {
+	tx := LOG_DB.Where("org_id = ?", orgId)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if logType != LogTypeUnknown {
+		tx = tx.Where("type = ?", logType)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	return logs, err
+}
+
+// CacheGetPayerQuota 返回本次请求的付费方剩余额度，orgId 非 0 时为组织额度
+func CacheGetPayerQuota(userId int, orgId int) (int, error) {
+	if orgId != 0 {
+		return GetOrganizationPayerQuota(orgId, userId)
+	}
+	return CacheGetUserQuota(userId)
+}
+
+// CacheDecreasePayerQuota 扣减付费方的缓存额度，组织额度不做缓存
+func CacheDecreasePayerQuota(userId int, orgId int, quota int) error {
+	if orgId != 0 {
+		return nil
+	}
+	return CacheDecreaseUserQuota(userId, quota)
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestChangeOrganizationQuotaRespectsPoolAndSpendLimit(t *testing.T) {
+	owner := createTestUser(t, "org_owner", 0)
+	member := createTestUser(t, "org_member", 0)
+	org, err := CreateOrganization("org", owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = AdjustOrganizationQuota(org.Id, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = AddOrganizationMember(org.Id, member.Id, OrgRoleMember, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if changeOrganizationQuota(org.Id, member.Id, 10, true, LedgerReasonPreConsume, "req") == nil {
+				lock.Lock()
+				succeeded++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded == 0 || succeeded > 5 {
+		t.Fatalf("expected 1 to 5 pre-consumes within the spend limit, got %d", succeeded)
+	}
+	got, _ := GetOrganizationById(org.Id)
+	memberRow, _ := GetOrganizationMember(org.Id, member.Id)
+	if got.Quota != 100-10*succeeded || memberRow.UsedQuota != 10*succeeded {
+		t.Fatalf("unexpected balances: pool %d, member used %d after %d debits", got.Quota, memberRow.UsedQuota, succeeded)
+	}
+
+	// 组织额度不足时预扣失败，不改变任何余额
+	if err = changeOrganizationQuota(org.Id, owner.Id, got.Quota+1, true, LedgerReasonPreConsume, "req"); err == nil {
+		t.Fatal("expected pre-consume beyond the pool to fail")
+	}
+	after, _ := GetOrganizationById(org.Id)
+	if after.Quota != got.Quota {
+		t.Fatalf("failed pre-consume changed the pool: %d -> %d", got.Quota, after.Quota)
+	}
+
+	// 每次变动都记入组织流水，流水合计等于组织额度
+	var ledgerBalance int
+	err = DB.Model(&QuotaLedger{}).Where("org_id = ?", org.Id).Select("coalesce(sum(amount), 0)").Scan(&ledgerBalance).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledgerBalance != after.Quota {
+		t.Fatalf("org ledger balance %d does not match pool %d", ledgerBalance, after.Quota)
+	}
+
+	// 退还计入组织额度池而不是成员的个人余额
+	if err = RefundOrganizationQuota(org.Id, member.Id, 10, "task"); err != nil {
+		t.Fatal(err)
+	}
+	refunded, _ := GetOrganizationById(org.Id)
+	memberUser, _ := GetUserById(member.Id, false)
+	if refunded.Quota != after.Quota+10 || memberUser.Quota != 0 {
+		t.Fatalf("refund went to the wrong account: pool %d, member %d", refunded.Quota, memberUser.Quota)
+	}
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// PasswordHistory 用户历史密码的哈希，用于禁止重复使用最近的密码
+type PasswordHistory struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Password    string `json:"-" gorm:"not null"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// recordPasswordHistory 保存新密码的哈希，并只保留最近 PasswordHistoryCount 条
+func recordPasswordHistory(tx *gorm.DB, userId int, hashedPassword string) error {
+	if common.PasswordHistoryCount <= 0 || userId == 0 || hashedPassword == "" {
+		return nil
+	}
+	err := tx.Create(&PasswordHistory{
+		UserId:      userId,
+		Password:    hashedPassword,
+		CreatedTime: common.GetTimestamp(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	var expiredIds []int
+	err = tx.Model(&PasswordHistory{}).Where("user_id = ?", userId).Order("id desc").
+		Offset(common.PasswordHistoryCount).Pluck("id", &expiredIds).Error
+	if err != nil || len(expiredIds) == 0 {
+		return err
+	}
+	return tx.Where("id in ?", expiredIds).Delete(&PasswordHistory{}).Error
+}
+
+// CheckPasswordReuse 新密码不能与当前密码或最近 PasswordHistoryCount 次使用过的密码相同
+func CheckPasswordReuse(userId int, password string) error {
+	if common.PasswordHistoryCount <= 0 || userId == 0 {
+		return nil
+	}
+	var hashes []string
+	err := DB.Model(&User{}).Where("id = ?", userId).Pluck("password", &hashes).Error
+	if err != nil {
+		return err
+	}
+	var histories []string
+	err = DB.Model(&PasswordHistory{}).Where("user_id = ?", userId).Order("id desc").
+		Limit(common.PasswordHistoryCount).Pluck("password", &histories).Error
+	if err != nil {
+		return err
+	}
+	hashes = append(hashes, histories...)
+	for _, hash := range hashes {
+		if common.ValidatePasswordAndHash(password, hash) {
+			return errors.New("不能使用最近用过的密码")
+		}
+	}
+	return nil
+}
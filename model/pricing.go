@@ -56,6 +56,15 @@ func updatePricing() {
 			pricing.ModelRatio = common.GetModelRatio(model)
 			pricing.CompletionRatio = common.GetCompletionRatio(model)
 			pricing.QuotaType = 0
+			if schema, ok := common.GetModelPricingSchema(model); ok {
+				pricing.PricingSchema = &schema
+				if schema.ModelRatio > 0 {
+					pricing.ModelRatio = schema.ModelRatio
+				}
+				if schema.CompletionRatio > 0 {
+					pricing.CompletionRatio = schema.CompletionRatio
+				}
+			}
 		}
 		pricingMap = append(pricingMap, pricing)
 	}
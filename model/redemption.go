@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"gorm.io/gorm"
 	"one-api/common"
+	"strconv"
+	"time"
 )
 
 type Redemption struct {
@@ -17,10 +19,38 @@ type Redemption struct {
 	CreatedTime  int64          `json:"created_time" gorm:"bigint"`
 	RedeemedTime int64          `json:"redeemed_time" gorm:"bigint"`
 	Count        int            `json:"count" gorm:"-:all"` // only for api request
-	UsedUserId   int            `json:"used_user_id"`
+	UsedUserId   int            `json:"used_user_id"`       // 多次使用的兑换码记录最后一次兑换的用户
+	CampaignId   int            `json:"campaign_id" gorm:"index;default:0"`
+	ExpiredTime  int64          `json:"expired_time" gorm:"bigint;default:0"` // 0 表示永不过期
+	MaxUses      int            `json:"max_uses" gorm:"default:1"`
+	UsedCount    int            `json:"used_count" gorm:"default:0"`
+	Group        string         `json:"group" gorm:"type:varchar(64);default:''"` // 兑换后调整到的分组
+	GroupDays    int            `json:"group_days" gorm:"default:0"`              // 分组有效天数，0 表示永久
+	Prefix       string         `json:"prefix" gorm:"-:all"`                      // only for api request
 	DeletedAt    gorm.DeletedAt `gorm:"index"`
 }
 
+// RedemptionUse 兑换记录，多次使用的兑换码每个用户只能兑换一次
+type RedemptionUse struct {
+	Id           int    `json:"id"`
+	RedemptionId int    `json:"redemption_id" gorm:"uniqueIndex:idx_redemption_use_user,priority:1"`
+	CampaignId   int    `json:"campaign_id" gorm:"index"`
+	UserId       int    `json:"user_id" gorm:"uniqueIndex:idx_redemption_use_user,priority:2;index"`
+	Quota        int    `json:"quota"`
+	Group        string `json:"group" gorm:"type:varchar(64)"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	// CampaignUserKey 活动限制每人一次时为 活动ID:用户ID，其余为空，由唯一索引保证并发兑换时不会重复参与
+	CampaignUserKey *string `json:"-" gorm:"type:varchar(64);uniqueIndex"`
+}
+
+// redemptionKeyLength 兑换码总长度，与 key 列的长度一致
+const redemptionKeyLength = 32
+
+// GenerateRedemptionKey 生成带前缀的兑换码，前缀之后以随机字符补足长度
+func GenerateRedemptionKey(prefix string) string {
+	return prefix + common.GetUUID()[:redemptionKeyLength-len(prefix)]
+}
+
 func GetAllRedemptions(startIdx int, num int) ([]*Redemption, error) {
 	var redemptions []*Redemption
 	var err error
@@ -51,6 +81,8 @@ func Redeem(key string, userId int) (quota int, err error) {
 		return 0, errors.New("无效的 user id")
 	}
 	redemption := &Redemption{}
+	var grant *UserGroupGrant
+	var use *RedemptionUse
 
 	keyCol := "`key`"
 	if common.UsingPostgreSQL {
@@ -58,33 +90,134 @@ func Redeem(key string, userId int) (quota int, err error) {
 	}
 	common.RandomSleep()
 	err = DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Set("gorm:query_option", "FOR UPDATE").Where(keyCol+" = ?", key).First(redemption).Error
+		err := lockForUpdate(tx).Where(keyCol+" = ?", key).First(redemption).Error
 		if err != nil {
 			return errors.New("无效的兑换码")
 		}
+		if redemption.Status == common.RedemptionCodeStatusUsed {
+			return errors.New("该兑换码已被使用")
+		}
 		if redemption.Status != common.RedemptionCodeStatusEnabled {
+			return errors.New("该兑换码已被禁用")
+		}
+		now := common.GetTimestamp()
+		if redemption.ExpiredTime != 0 && redemption.ExpiredTime < now {
+			return errors.New("该兑换码已过期")
+		}
+		use = &RedemptionUse{
+			RedemptionId: redemption.Id,
+			CampaignId:   redemption.CampaignId,
+			UserId:       userId,
+			Quota:        redemption.Quota,
+			Group:        redemption.Group,
+			CreatedTime:  now,
+		}
+		if redemption.CampaignId != 0 {
+			campaign := &RedemptionCampaign{}
+			if err = tx.First(campaign, "id = ?", redemption.CampaignId).Error; err != nil {
+				return errors.New("兑换活动不存在")
+			}
+			if campaign.Status != common.RedemptionCodeStatusEnabled {
+				return errors.New("兑换活动已结束")
+			}
+			if campaign.OnePerUser {
+				var count int64
+				err = tx.Model(&RedemptionUse{}).Where("campaign_id = ? and user_id = ?", campaign.Id, userId).Count(&count).Error
+				if err != nil {
+					return err
+				}
+				if count > 0 {
+					return errors.New("每个用户只能参与一次该兑换活动")
+				}
+				campaignUserKey := fmt.Sprintf("%d:%d", campaign.Id, userId)
+				use.CampaignUserKey = &campaignUserKey
+			}
+		}
+		var count int64
+		err = tx.Model(&RedemptionUse{}).Where("redemption_id = ? and user_id = ?", redemption.Id, userId).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("您已兑换过该兑换码")
+		}
+		// 按条件递增兑换次数占用一次使用机会，并发兑换时不会超出可兑换次数
+		result := tx.Model(&Redemption{}).
+			Where("id = ? and status = ? and used_count < max_uses", redemption.Id, common.RedemptionCodeStatusEnabled).
+			Updates(map[string]interface{}{
+				"used_count":    gorm.Expr("used_count + 1"),
+				"redeemed_time": now,
+				"used_user_id":  userId,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
 			return errors.New("该兑换码已被使用")
 		}
-		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+		err = tx.Model(&Redemption{}).Where("id = ? and used_count >= max_uses", redemption.Id).
+			Update("status", common.RedemptionCodeStatusUsed).Error
 		if err != nil {
 			return err
 		}
-		redemption.RedeemedTime = common.GetTimestamp()
-		redemption.Status = common.RedemptionCodeStatusUsed
-		redemption.UsedUserId = userId
-		err = tx.Save(redemption).Error
-		return err
+		if err = tx.Create(use).Error; err != nil {
+			return err
+		}
+		if redemption.Quota > 0 {
+			err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+			if err != nil {
+				return err
+			}
+			err = recordQuotaLedgerTx(tx, userId, redemption.Quota, LedgerReasonRedemption, strconv.Itoa(redemption.Id))
+			if err != nil {
+				return err
+			}
+		}
+		if redemption.Group != "" {
+			grant, err = grantUserGroupTx(tx, userId, redemption.Group, int64(redemption.GroupDays)*86400, UserGroupGrantSourceRedemption, strconv.Itoa(redemption.Id))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 	if err != nil {
 		return 0, errors.New("兑换失败，" + err.Error())
 	}
-	RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s", common.LogQuota(redemption.Quota)))
+	if redemption.Quota > 0 {
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s", common.LogQuota(redemption.Quota)))
+	}
+	if redemption.Group != "" {
+		cacheSetUserGroup(userId, redemption.Group)
+		if grant != nil {
+			RecordLog(userId, LogTypeSystem, fmt.Sprintf("通过兑换码升级至分组 %s，有效期至 %s", redemption.Group, time.Unix(grant.ExpiredTime, 0).Format("2006-01-02 15:04:05")))
+		} else {
+			RecordLog(userId, LogTypeSystem, fmt.Sprintf("通过兑换码升级至分组 %s", redemption.Group))
+		}
+	}
+	if common.AffCommissionRedemptionEnabled && redemption.Quota > 0 {
+		// 多次使用的兑换码按兑换记录去重，同一邀请人的多个下级兑换同一兑换码时都能获得返佣
+		GrantAffCommission(userId, redemption.Quota, AffCommissionSourceRedemption, strconv.Itoa(use.Id))
+	}
+	if redemption.Quota > 0 {
+		PromoteUserGroup(userId)
+	}
 	return redemption.Quota, nil
 }
 
+// migrateLegacyRedemptions 为旧版已使用的兑换码补齐兑换次数
+func migrateLegacyRedemptions() error {
+	return DB.Model(&Redemption{}).Where("status = ? and used_count = 0", common.RedemptionCodeStatusUsed).
+		Update("used_count", 1).Error
+}
+
 func (redemption *Redemption) Insert() error {
 	var err error
 	err = DB.Create(redemption).Error
+	if err == nil && redemption.Quota == 0 {
+		// quota 列带有默认值，创建时零值会被忽略，仅调整分组的兑换码需要显式写入 0
+		err = DB.Model(redemption).Update("quota", 0).Error
+	}
 	return err
 }
 
@@ -96,7 +229,7 @@ func (redemption *Redemption) SelectUpdate() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (redemption *Redemption) Update() error {
 	var err error
-	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time").Updates(redemption).Error
+	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time", "expired_time", "max_uses", "group", "group_days").Updates(redemption).Error
 	return err
 }
 
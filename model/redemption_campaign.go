@@ -0,0 +1,173 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// RedemptionCampaign 兑换活动，批量生成的兑换码在创建时复制活动的额度、分组、次数与有效期设置
+type RedemptionCampaign struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);index"`
+	Prefix      string `json:"prefix" gorm:"type:varchar(16)"`
+	Quota       int    `json:"quota"`
+	Group       string `json:"group" gorm:"type:varchar(64);default:''"`
+	GroupDays   int    `json:"group_days" gorm:"default:0"`
+	MaxUses     int    `json:"max_uses" gorm:"default:1"`         // 每个兑换码可被兑换的次数
+	OnePerUser  bool   `json:"one_per_user" gorm:"default:false"` // 每个用户在活动内只能兑换一次
+	ExpiredTime int64  `json:"expired_time" gorm:"bigint;default:0"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedBy   int    `json:"created_by"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Count       int    `json:"count" gorm:"-:all"` // only for api request
+}
+
+// RedemptionCampaignStats 兑换活动统计
+type RedemptionCampaignStats struct {
+	CodeCount     int64 `json:"code_count"`
+	RedeemedCount int64 `json:"redeemed_count"` // 至少被兑换过一次的兑换码数量
+	UsedUpCount   int64 `json:"used_up_count"`  // 兑换次数已用完的兑换码数量
+	UseCount      int64 `json:"use_count"`
+	UserCount     int64 `json:"user_count"`
+	Quota         int64 `json:"quota"`
+}
+
+func GetRedemptionCampaigns(startIdx int, num int) (campaigns []*RedemptionCampaign, total int64, err error) {
+	if err = DB.Model(&RedemptionCampaign{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&campaigns).Error
+	return campaigns, total, err
+}
+
+func GetRedemptionCampaignById(id int) (*RedemptionCampaign, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	campaign := &RedemptionCampaign{}
+	err := DB.First(campaign, "id = ?", id).Error
+	return campaign, err
+}
+
+// Insert 创建活动并生成 count 个兑换码，返回生成的兑换码
+func (campaign *RedemptionCampaign) Insert(count int) (keys []string, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(campaign).Error; err != nil {
+			return err
+		}
+		keys, err = campaign.generateCodes(tx, count)
+		return err
+	})
+	return keys, err
+}
+
+// GenerateCodes 为已有活动追加生成兑换码
+func (campaign *RedemptionCampaign) GenerateCodes(count int) (keys []string, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		keys, err = campaign.generateCodes(tx, count)
+		return err
+	})
+	return keys, err
+}
+
+func (campaign *RedemptionCampaign) generateCodes(tx *gorm.DB, count int) ([]string, error) {
+	now := common.GetTimestamp()
+	redemptions := make([]*Redemption, 0, count)
+	keys := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		key := GenerateRedemptionKey(campaign.Prefix)
+		redemptions = append(redemptions, &Redemption{
+			UserId:      campaign.CreatedBy,
+			Key:         key,
+			Status:      common.RedemptionCodeStatusEnabled,
+			Name:        campaign.Name,
+			Quota:       campaign.Quota,
+			CreatedTime: now,
+			CampaignId:  campaign.Id,
+			ExpiredTime: campaign.ExpiredTime,
+			MaxUses:     campaign.MaxUses,
+			Group:       campaign.Group,
+			GroupDays:   campaign.GroupDays,
+		})
+		keys = append(keys, key)
+	}
+	if err := tx.CreateInBatches(redemptions, 100).Error; err != nil {
+		return nil, err
+	}
+	if campaign.Quota == 0 {
+		// quota 列带有默认值，批量创建时零值会被忽略
+		return keys, tx.Model(&Redemption{}).Where("campaign_id = ?", campaign.Id).Update("quota", 0).Error
+	}
+	return keys, nil
+}
+
+// Update 更新活动名称、状态、限兑规则与有效期，有效期同步到活动内的兑换码
+func (campaign *RedemptionCampaign) Update() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(campaign).Select("name", "status", "one_per_user", "expired_time").Updates(campaign).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Redemption{}).Where("campaign_id = ?", campaign.Id).
+			Updates(map[string]interface{}{"name": campaign.Name, "expired_time": campaign.ExpiredTime}).Error
+	})
+}
+
+// DeleteRedemptionCampaignById 删除活动及其兑换码，兑换记录保留
+func DeleteRedemptionCampaignById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("campaign_id = ?", id).Delete(&Redemption{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&RedemptionCampaign{}, "id = ?", id).Error
+	})
+}
+
+func GetRedemptionCampaignStats(id int) (*RedemptionCampaignStats, error) {
+	stats := &RedemptionCampaignStats{}
+	if err := DB.Model(&Redemption{}).Where("campaign_id = ?", id).Count(&stats.CodeCount).Error; err != nil {
+		return nil, err
+	}
+	err := DB.Model(&Redemption{}).Where("campaign_id = ? and used_count > 0", id).Count(&stats.RedeemedCount).Error
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Model(&Redemption{}).Where("campaign_id = ? and status = ?", id, common.RedemptionCodeStatusUsed).Count(&stats.UsedUpCount).Error
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Model(&RedemptionUse{}).Where("campaign_id = ?", id).
+		Select("count(*) as use_count, count(distinct user_id) as user_count, coalesce(sum(quota), 0) as quota").
+		Scan(stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// IterateCampaignRedemptions 按 id 顺序分批读取活动内的兑换码，用于导出
+func IterateCampaignRedemptions(campaignId int, batchSize int, fn func(redemptions []*Redemption) error) error {
+	lastId := 0
+	for {
+		var redemptions []*Redemption
+		err := DB.Where("campaign_id = ? and id > ?", campaignId, lastId).Order("id").Limit(batchSize).Find(&redemptions).Error
+		if err != nil {
+			return err
+		}
+		if len(redemptions) == 0 {
+			return nil
+		}
+		lastId = redemptions[len(redemptions)-1].Id
+		if err = fn(redemptions); err != nil {
+			return err
+		}
+		if len(redemptions) < batchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"sync"
+	"testing"
+)
+
+// redeemConcurrently 同时以多个用户兑换同一兑换码，返回成功的次数
+func redeemConcurrently(keys []string, userIds []int) int {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	succeeded := 0
+	for i := range userIds {
+		wg.Add(1)
+		go func(key string, userId int) {
+			defer wg.Done()
+			if _, err := Redeem(key, userId); err == nil {
+				lock.Lock()
+				succeeded++
+				lock.Unlock()
+			}
+		}(keys[i%len(keys)], userIds[i])
+	}
+	wg.Wait()
+	return succeeded
+}
+
+func TestRedeemRespectsMaxUses(t *testing.T) {
+	redemption := &Redemption{
+		Key:         GenerateRedemptionKey("max"),
+		Status:      common.RedemptionCodeStatusEnabled,
+		Name:        "max uses",
+		Quota:       100,
+		MaxUses:     2,
+		CreatedTime: common.GetTimestamp(),
+	}
+	if err := redemption.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	userIds := make([]int, 0, 5)
+	for i := 0; i < 5; i++ {
+		userIds = append(userIds, createTestUser(t, fmt.Sprintf("redeem_max_%d", i), 0).Id)
+	}
+	succeeded := redeemConcurrently([]string{redemption.Key}, userIds)
+	if succeeded == 0 || succeeded > 2 {
+		t.Fatalf("expected at most 2 concurrent redemptions, got %d", succeeded)
+	}
+	// SQLite 下并发写可能因锁冲突失败，未用完的次数依次兑换后，下一次兑换必须失败
+	for _, userId := range userIds {
+		_, err := Redeem(redemption.Key, userId)
+		if err == nil {
+			succeeded++
+		}
+		if succeeded > 2 || (err != nil && succeeded == 2) {
+			break
+		}
+	}
+	if succeeded != 2 {
+		t.Fatalf("expected exactly 2 redemptions, got %d", succeeded)
+	}
+	got, _ := GetRedemptionById(redemption.Id)
+	if got.UsedCount != 2 || got.Status != common.RedemptionCodeStatusUsed {
+		t.Fatalf("expected the code to be used up, got %d uses and status %d", got.UsedCount, got.Status)
+	}
+	var totalQuota int64
+	DB.Model(&User{}).Where("id in ?", userIds).Select("coalesce(sum(quota), 0)").Scan(&totalQuota)
+	if totalQuota != 200 {
+		t.Fatalf("expected 200 quota granted in total, got %d", totalQuota)
+	}
+}
+
+func TestRedeemCampaignOnePerUser(t *testing.T) {
+	campaign := &RedemptionCampaign{
+		Name:        "one per user",
+		Prefix:      "once",
+		Quota:       50,
+		MaxUses:     10,
+		OnePerUser:  true,
+		Status:      common.RedemptionCodeStatusEnabled,
+		CreatedTime: common.GetTimestamp(),
+	}
+	keys, err := campaign.Insert(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, "redeem_once", 0)
+	succeeded := redeemConcurrently(keys, []int{user.Id, user.Id, user.Id})
+	if succeeded > 1 {
+		t.Fatalf("expected one redemption per user in a campaign, got %d", succeeded)
+	}
+	for _, key := range keys {
+		_, err = Redeem(key, user.Id)
+		if err == nil {
+			succeeded++
+		}
+		if succeeded > 1 || (err != nil && succeeded == 1) {
+			break
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one redemption, got %d", succeeded)
+	}
+	quota, _ := GetUserQuota(user.Id)
+	if quota != 50 {
+		t.Fatalf("expected 50 quota, got %d", quota)
+	}
+}
+
+func TestRedeemRejectsExpiredCode(t *testing.T) {
+	redemption := &Redemption{
+		Key:         GenerateRedemptionKey("expired"),
+		Status:      common.RedemptionCodeStatusEnabled,
+		Name:        "expired",
+		Quota:       100,
+		MaxUses:     1,
+		ExpiredTime: common.GetTimestamp() - 1,
+		CreatedTime: common.GetTimestamp(),
+	}
+	if err := redemption.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, "redeem_expired", 0)
+	if _, err := Redeem(redemption.Key, user.Id); err == nil {
+		t.Fatal("expected an expired code to be rejected")
+	}
+}
@@ -0,0 +1,209 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// resellerMaxDepth 代理层级的最大深度，防止数据异常时出现环
+const resellerMaxDepth = 16
+
+const (
+	MinMarkupRatio = 1.0
+	MaxMarkupRatio = 100.0
+)
+
+func GetUserParentId(id int) (parentId int, err error) {
+	err = DB.Model(&User{}).Where("id = ?", id).Select("parent_id").Find(&parentId).Error
+	return parentId, err
+}
+
+func CacheGetUserParentId(id int) (parentId int, err error) {
+	if !common.RedisEnabled {
+		return GetUserParentId(id)
+	}
+	parentString, err := common.RedisGet(fmt.Sprintf("user_parent:%d", id))
+	if err != nil {
+		parentId, err = GetUserParentId(id)
+		if err != nil {
+			return 0, err
+		}
+		err = common.RedisSet(fmt.Sprintf("user_parent:%d", id), strconv.Itoa(parentId), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+		if err != nil {
+			common.SysError("Redis set user parent error: " + err.Error())
+		}
+		return parentId, nil
+	}
+	return strconv.Atoi(parentString)
+}
+
+// IsResellerChainEnabled 检查用户的所有上级代理均未被禁用
+func IsResellerChainEnabled(userId int) (bool, error) {
+	id := userId
+	for i := 0; i < resellerMaxDepth; i++ {
+		parentId, err := CacheGetUserParentId(id)
+		if err != nil {
+			return false, err
+		}
+		if parentId == 0 {
+			return true, nil
+		}
+		enabled, err := CacheIsUserEnabled(parentId)
+		if err != nil || !enabled {
+			return false, err
+		}
+		id = parentId
+	}
+	return false, errors.New("代理层级过深")
+}
+
+// IsUserInSubtree 判断 userId 是否为 rootId 的下级（不含 rootId 自身）
+func IsUserInSubtree(rootId int, userId int) bool {
+	id := userId
+	for i := 0; i < resellerMaxDepth; i++ {
+		parentId, err := GetUserParentId(id)
+		if err != nil || parentId == 0 {
+			return false
+		}
+		if parentId == rootId {
+			return true
+		}
+		id = parentId
+	}
+	return false
+}
+
+// GetSubtreeUserIds 返回 rootId 下所有层级的下级用户 ID
+func GetSubtreeUserIds(rootId int) ([]int, error) {
+	result := make([]int, 0)
+	parents := []int{rootId}
+	for i := 0; i < resellerMaxDepth && len(parents) > 0; i++ {
+		var children []int
+		err := DB.Unscoped().Model(&User{}).Where("parent_id in ?", parents).Pluck("id", &children).Error
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, children...)
+		parents = children
+	}
+	return result, nil
+}
+
+func GetSubtreeUsers(rootId int, startIdx int, num int) (users []*User, err error) {
+	ids, err := GetSubtreeUserIds(rootId)
+	if err != nil || len(ids) == 0 {
+		return users, err
+	}
+	err = DB.Unscoped().Where("id in ?", ids).Order("id desc").Limit(num).Offset(startIdx).Omit("password").Find(&users).Error
+	return users, err
+}
+
+func SearchSubtreeUsers(rootId int, keyword string) (users []*User, err error) {
+	ids, err := GetSubtreeUserIds(rootId)
+	if err != nil || len(ids) == 0 {
+		return users, err
+	}
+	tx := DB.Unscoped().Omit("password").Where("id in ?", ids)
+	if keywordInt, err := strconv.Atoi(keyword); err == nil {
+		tx = tx.Where("id = ?", keywordInt)
+	} else {
+		tx = tx.Where("username LIKE ? OR email LIKE ? OR display_name LIKE ?", "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%")
+	}
+	err = tx.Find(&users).Error
+	return users, err
+}
+
+// GetUserMarkupRatio 返回用户的加价倍率，即其直属上级代理设置的倍率，没有上级时为 1
+func GetUserMarkupRatio(id int) (float64, error) {
+	parentId, err := GetUserParentId(id)
+	if err != nil || parentId == 0 {
+		return 1, err
+	}
+	return getResellerMarkupRatio(parentId)
+}
+
+func getResellerMarkupRatio(resellerId int) (float64, error) {
+	var ratio float64
+	err := DB.Model(&User{}).Where("id = ?", resellerId).Select("markup_ratio").Find(&ratio).Error
+	if err != nil || ratio < MinMarkupRatio {
+		return 1, err
+	}
+	return ratio, nil
+}
+
+// CacheGetUserMarkupRatio 加价倍率按上级代理缓存，代理修改倍率后对所有下级立即生效
+func CacheGetUserMarkupRatio(id int) (float64, error) {
+	if !common.RedisEnabled {
+		return GetUserMarkupRatio(id)
+	}
+	parentId, err := CacheGetUserParentId(id)
+	if err != nil || parentId == 0 {
+		return 1, err
+	}
+	ratioString, err := common.RedisGet(fmt.Sprintf("reseller_markup:%d", parentId))
+	if err != nil {
+		ratio, err := getResellerMarkupRatio(parentId)
+		if err != nil {
+			return 1, err
+		}
+		err = common.RedisSet(fmt.Sprintf("reseller_markup:%d", parentId), strconv.FormatFloat(ratio, 'f', -1, 64), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+		if err != nil {
+			common.SysError("Redis set reseller markup error: " + err.Error())
+		}
+		return ratio, nil
+	}
+	return strconv.ParseFloat(ratioString, 64)
+}
+
+func UpdateUserMarkupRatio(id int, ratio float64) error {
+	if ratio < MinMarkupRatio || ratio > MaxMarkupRatio {
+		return fmt.Errorf("加价倍率必须在 %.0f 到 %.0f 之间", MinMarkupRatio, MaxMarkupRatio)
+	}
+	err := DB.Model(&User{}).Where("id = ?", id).Update("markup_ratio", ratio).Error
+	if err == nil && common.RedisEnabled {
+		_ = common.RedisDel(fmt.Sprintf("reseller_markup:%d", id))
+	}
+	return err
+}
+
+// AllocateQuotaToChild 代理从自己的余额向下级分配额度，quota 为负时从下级收回额度
+func AllocateQuotaToChild(resellerId int, childId int, quota int) error {
+	if quota == 0 {
+		return errors.New("额度不能为 0")
+	}
+	from, to := resellerId, childId
+	amount := quota
+	if quota < 0 {
+		from, to = childId, resellerId
+		amount = -quota
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? and quota >= ?", from, amount).Update("quota", gorm.Expr("quota - ?", amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if quota > 0 {
+				return errors.New("代理余额不足")
+			}
+			return errors.New("下级用户额度不足")
+		}
+		if err := tx.Model(&User{}).Where("id = ?", to).Update("quota", gorm.Expr("quota + ?", amount)).Error; err != nil {
+			return err
+		}
+		if err := recordQuotaLedgerTx(tx, resellerId, -quota, LedgerReasonResellerAllocate, fmt.Sprintf("user:%d", childId)); err != nil {
+			return err
+		}
+		return recordQuotaLedgerTx(tx, childId, quota, LedgerReasonResellerAllocate, fmt.Sprintf("user:%d", resellerId))
+	})
+	if err == nil {
+		_ = CacheUpdateUserQuota(resellerId)
+		_ = CacheUpdateUserQuota(childId)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+)
+
+// Role 角色是一组命名权限的集合。内置角色与原有的权限等级一一对应，
+// 用户未指定角色（RoleId 为 0）时使用其权限等级对应的内置角色
+type Role struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Permissions string `json:"permissions" gorm:"type:text"`
+	BuiltIn     bool   `json:"built_in" gorm:"default:false"`
+	Level       int    `json:"level" gorm:"default:0"` // 内置角色对应的权限等级
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+var builtInRoles = []struct {
+	Level int
+	Name  string
+}{
+	{common.RoleCommonUser, "common"},
+	{common.RoleResellerUser, "reseller"},
+	{common.RoleAdminUser, "admin"},
+	{common.RoleRootUser, "root"},
+}
+
+func (role *Role) GetPermissions() []string {
+	var permissions []string
+	if role.Permissions != "" {
+		_ = json.Unmarshal([]byte(role.Permissions), &permissions)
+	}
+	return permissions
+}
+
+func (role *Role) SetPermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !common.IsValidPermission(permission) {
+			return fmt.Errorf("未知的权限：%s", permission)
+		}
+	}
+	if permissions == nil {
+		permissions = []string{}
+	}
+	data, err := json.Marshal(permissions)
+	if err != nil {
+		return err
+	}
+	role.Permissions = string(data)
+	return nil
+}
+
+// ensureBuiltInRoles 创建或同步内置角色，使原有的权限等级迁移为等价的内置角色
+func ensureBuiltInRoles() error {
+	for _, builtIn := range builtInRoles {
+		level := builtIn.Level
+		role := &Role{}
+		err := DB.Where("built_in = ? and level = ?", true, level).Limit(1).Find(role).Error
+		if err != nil {
+			return err
+		}
+		if err = role.SetPermissions(common.BuiltInRolePermissions[level]); err != nil {
+			return err
+		}
+		if role.Id == 0 {
+			role.Name = builtIn.Name
+			role.BuiltIn = true
+			role.Level = level
+			role.CreatedTime = common.GetTimestamp()
+			err = DB.Create(role).Error
+		} else {
+			err = DB.Model(role).Update("permissions", role.Permissions).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetAllRoles() (roles []*Role, err error) {
+	err = DB.Order("id").Find(&roles).Error
+	return roles, err
+}
+
+func GetRoleById(id int) (*Role, error) {
+	role := &Role{}
+	err := DB.First(role, "id = ?", id).Error
+	return role, err
+}
+
+func (role *Role) Insert() error {
+	role.BuiltIn = false
+	role.Level = 0
+	role.CreatedTime = common.GetTimestamp()
+	return DB.Create(role).Error
+}
+
+func (role *Role) Update() error {
+	if role.BuiltIn {
+		return errors.New("内置角色不可修改")
+	}
+	return DB.Model(role).Select("name", "description", "permissions").Updates(role).Error
+}
+
+func (role *Role) Delete() error {
+	if role.BuiltIn {
+		return errors.New("内置角色不可删除")
+	}
+	var count int64
+	if err := DB.Model(&User{}).Where("role_id = ?", role.Id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("仍有用户使用该角色，无法删除")
+	}
+	return DB.Delete(role).Error
+}
+
+// UpdateUserRoleId 为用户分配角色，roleId 为 0 时恢复为权限等级对应的内置角色
+func UpdateUserRoleId(userId int, roleId int) error {
+	if roleId != 0 {
+		if _, err := GetRoleById(roleId); err != nil {
+			return errors.New("角色不存在")
+		}
+	}
+	return DB.Model(&User{}).Where("id = ?", userId).Update("role_id", roleId).Error
+}
+
+// GetUserPermissions 返回用户拥有的权限，超级管理员始终拥有全部权限
+func GetUserPermissions(userId int, level int) ([]string, error) {
+	if level >= common.RoleRootUser {
+		return []string{common.PermissionAll}, nil
+	}
+	var roleId int
+	err := DB.Model(&User{}).Where("id = ?", userId).Select("role_id").Find(&roleId).Error
+	if err != nil {
+		return nil, err
+	}
+	if roleId == 0 {
+		return common.BuiltInRolePermissions[level], nil
+	}
+	role, err := GetRoleById(roleId)
+	if err != nil {
+		return common.BuiltInRolePermissions[level], nil
+	}
+	return role.GetPermissions(), nil
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"one-api/common"
+	"time"
+)
+
+const (
+	// userSessionSeconds 服务端会话的有效期，与 cookie 会话的默认有效期一致
+	userSessionSeconds = 30 * 24 * 3600
+	// userSessionTouchSeconds 最近活跃时间的更新间隔，避免每次请求都写库
+	userSessionTouchSeconds = 60
+)
+
+// UserSession 服务端会话记录，cookie 中只保存会话 ID，删除记录即可使对应登录失效
+type UserSession struct {
+	Id             string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	Ip             string `json:"ip" gorm:"type:varchar(64);default:''"`
+	UserAgent      string `json:"user_agent" gorm:"type:varchar(255);default:''"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+	LastActiveTime int64  `json:"last_active_time" gorm:"bigint"`
+	ExpiredTime    int64  `json:"expired_time" gorm:"bigint;index"`
+}
+
+func CreateUserSession(userId int, ip string, userAgent string) (*UserSession, error) {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	now := common.GetTimestamp()
+	session := &UserSession{
+		Id:             common.GetUUID() + common.GetUUID(),
+		UserId:         userId,
+		Ip:             ip,
+		UserAgent:      userAgent,
+		CreatedTime:    now,
+		LastActiveTime: now,
+		ExpiredTime:    now + userSessionSeconds,
+	}
+	err := DB.Create(session).Error
+	return session, err
+}
+
+// ValidateUserSession 校验会话是否仍然有效，并按间隔刷新最近活跃时间
+func ValidateUserSession(id string, userId int) bool {
+	if id == "" {
+		return false
+	}
+	session := &UserSession{}
+	if err := DB.Where("id = ? and user_id = ?", id, userId).First(session).Error; err != nil {
+		return false
+	}
+	now := common.GetTimestamp()
+	if session.ExpiredTime < now {
+		DB.Delete(session)
+		return false
+	}
+	if now-session.LastActiveTime >= userSessionTouchSeconds {
+		DB.Model(session).Update("last_active_time", now)
+	}
+	return true
+}
+
+func GetUserSessions(userId int) (sessions []*UserSession, err error) {
+	err = DB.Where("user_id = ? and expired_time >= ?", userId, common.GetTimestamp()).
+		Order("last_active_time desc").Find(&sessions).Error
+	return sessions, err
+}
+
+func DeleteUserSession(id string) error {
+	return DB.Where("id = ?", id).Delete(&UserSession{}).Error
+}
+
+// RevokeUserSession 撤销用户的指定会话，返回是否存在该会话
+func RevokeUserSession(userId int, id string) (bool, error) {
+	result := DB.Where("id = ? and user_id = ?", id, userId).Delete(&UserSession{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeUserSessions 撤销用户的全部会话，exceptId 不为空时保留该会话（通常为当前会话）
+func RevokeUserSessions(userId int, exceptId string) error {
+	tx := DB.Where("user_id = ?", userId)
+	if exceptId != "" {
+		tx = tx.Where("id <> ?", exceptId)
+	}
+	return tx.Delete(&UserSession{}).Error
+}
+
+// CleanExpiredUserSessions 清理已过期的会话记录
+func CleanExpiredUserSessions() error {
+	return DB.Where("expired_time < ?", common.GetTimestamp()).Delete(&UserSession{}).Error
+}
+
+func AutomaticallyCleanExpiredUserSessions() {
+	for {
+		if err := CleanExpiredUserSessions(); err != nil {
+			common.SysError("failed to clean expired user sessions: " + err.Error())
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,235 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"one-api/constant"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Statement 用户的月度账单，生成后保存以便重复下载
+type Statement struct {
+	Id              int    `json:"id"`
+	UserId          int    `json:"user_id" gorm:"uniqueIndex:idx_statement_user_period,priority:1"`
+	Username        string `json:"username" gorm:"default:''"`
+	Email           string `json:"email" gorm:"default:''"`
+	Period          string `json:"period" gorm:"type:varchar(16);uniqueIndex:idx_statement_user_period,priority:2;index"`
+	StartTime       int64  `json:"start_time" gorm:"bigint"`
+	EndTime         int64  `json:"end_time" gorm:"bigint"`
+	OpeningBalance  int    `json:"opening_balance"`
+	ClosingBalance  int    `json:"closing_balance"`
+	ConsumedQuota   int    `json:"consumed_quota"`
+	TopUpQuota      int    `json:"top_up_quota"`
+	RedemptionQuota int    `json:"redemption_quota"`
+	RequestCount    int    `json:"request_count"`
+	// Items 按模型与令牌汇总的消费明细，JSON 格式
+	Items string `json:"items"`
+	// ItemsIncomplete 明细来自消费日志，关闭消费日志或日志已归档时明细合计与流水中的消费不一致
+	ItemsIncomplete bool `json:"items_incomplete" gorm:"default:false"`
+	// Company 生成时的公司信息快照，JSON 格式
+	Company   string `json:"company"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+	EmailedAt int64  `json:"emailed_at" gorm:"bigint;default:0"`
+}
+
+type StatementItem struct {
+	ModelName        string `json:"model_name"`
+	TokenName        string `json:"token_name"`
+	RequestCount     int    `json:"request_count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	Quota            int    `json:"quota"`
+}
+
+const StatementPeriodLayout = "2006-01"
+
+// StatementPeriodRange 返回账单周期（自然月，服务器时区）的起止时间戳，结束时间不包含在内
+func StatementPeriodRange(period string) (int64, int64, error) {
+	start, err := time.ParseInLocation(StatementPeriodLayout, period, time.Local)
+	if err != nil {
+		return 0, 0, errors.New("账单周期格式应为 YYYY-MM")
+	}
+	return start.Unix(), start.AddDate(0, 1, 0).Unix(), nil
+}
+
+// LastStatementPeriod 返回上一个已结束的账单周期
+func LastStatementPeriod() string {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local).AddDate(0, -1, 0).Format(StatementPeriodLayout)
+}
+
+func (statement *Statement) GetItems() []StatementItem {
+	items := make([]StatementItem, 0)
+	_ = json.Unmarshal([]byte(statement.Items), &items)
+	return items
+}
+
+func (statement *Statement) GetCompany() constant.StatementCompany {
+	var company constant.StatementCompany
+	_ = json.Unmarshal([]byte(statement.Company), &company)
+	return company
+}
+
+// ledgerBalanceBefore 返回时间点之前最后一条流水的余额
+func ledgerBalanceBefore(userId int, timestamp int64) (int, error) {
+	var ledger QuotaLedger
+	err := DB.Where("user_id = ? and org_id = 0 and created_at < ?", userId, timestamp).Order("id desc").Limit(1).Find(&ledger).Error
+	return ledger.BalanceAfter, err
+}
+
+func sumLedgerAmount(userId int, start int64, end int64, reasons ...string) (total int, err error) {
+	err = DB.Model(&QuotaLedger{}).Where("user_id = ? and org_id = 0 and reason in ? and created_at >= ? and created_at < ?", userId, reasons, start, end).
+		Select("coalesce(sum(amount), 0)").Scan(&total).Error
+	return total, err
+}
+
+// BuildStatement 汇总用户在账单周期内的消费、充值与兑换，不保存。
+// 消费金额取自额度流水，按模型与令牌的明细取自消费日志，只包含个人额度支付的调用
+func BuildStatement(userId int, period string) (*Statement, error) {
+	start, end, err := StatementPeriodRange(period)
+	if err != nil {
+		return nil, err
+	}
+	user, err := GetUserById(userId, false)
+	if err != nil {
+		return nil, err
+	}
+	statement := &Statement{
+		UserId:    user.Id,
+		Username:  user.Username,
+		Email:     user.Email,
+		Period:    period,
+		StartTime: start,
+		EndTime:   end,
+		CreatedAt: common.GetTimestamp(),
+	}
+	var items []StatementItem
+	err = LOG_DB.Model(&Log{}).Select("model_name, token_name, count(*) as request_count, sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens, sum(quota) as quota").
+		Where("user_id = ? and type = ? and org_id = 0 and created_at >= ? and created_at < ?", userId, LogTypeConsume, start, end).
+		Group("model_name, token_name").Order("quota desc").Scan(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	itemsQuota := 0
+	for _, item := range items {
+		itemsQuota += item.Quota
+		statement.RequestCount += item.RequestCount
+	}
+	consumed, err := sumLedgerAmount(userId, start, end, LedgerReasonPreConsume, LedgerReasonConsume, LedgerReasonRefund)
+	if err != nil {
+		return nil, err
+	}
+	statement.ConsumedQuota = -consumed
+	statement.ItemsIncomplete = itemsQuota != statement.ConsumedQuota
+	itemsJson, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	statement.Items = string(itemsJson)
+	companyJson, err := json.Marshal(constant.GetStatementCompany())
+	if err != nil {
+		return nil, err
+	}
+	statement.Company = string(companyJson)
+
+	if statement.TopUpQuota, err = sumLedgerAmount(userId, start, end, LedgerReasonTopUp); err != nil {
+		return nil, err
+	}
+	if statement.RedemptionQuota, err = sumLedgerAmount(userId, start, end, LedgerReasonRedemption); err != nil {
+		return nil, err
+	}
+	if statement.OpeningBalance, err = ledgerBalanceBefore(userId, start); err != nil {
+		return nil, err
+	}
+	if statement.ClosingBalance, err = ledgerBalanceBefore(userId, end); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// GenerateStatement 生成并保存账单，已存在的同周期账单会被替换
+func GenerateStatement(userId int, period string) (*Statement, error) {
+	statement, err := BuildStatement(userId, period)
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var existing Statement
+		err := tx.Where("user_id = ? and period = ?", userId, period).Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing.Id != 0 {
+			statement.Id = existing.Id
+			statement.EmailedAt = existing.EmailedAt
+		}
+		return tx.Save(statement).Error
+	})
+	return statement, err
+}
+
+// GetStatementUserIds 返回账单周期内有消费或额度变动的用户
+func GetStatementUserIds(period string) ([]int, error) {
+	start, end, err := StatementPeriodRange(period)
+	if err != nil {
+		return nil, err
+	}
+	var logUserIds []int
+	err = LOG_DB.Model(&Log{}).Where("type = ? and created_at >= ? and created_at < ?", LogTypeConsume, start, end).
+		Distinct("user_id").Pluck("user_id", &logUserIds).Error
+	if err != nil {
+		return nil, err
+	}
+	var ledgerUserIds []int
+	err = DB.Model(&QuotaLedger{}).Where("org_id = 0 and created_at >= ? and created_at < ?", start, end).
+		Distinct("user_id").Pluck("user_id", &ledgerUserIds).Error
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int]bool)
+	userIds := make([]int, 0, len(logUserIds)+len(ledgerUserIds))
+	for _, id := range append(logUserIds, ledgerUserIds...) {
+		if !seen[id] {
+			seen[id] = true
+			userIds = append(userIds, id)
+		}
+	}
+	return userIds, nil
+}
+
+func IsStatementGenerated(userId int, period string) bool {
+	var count int64
+	DB.Model(&Statement{}).Where("user_id = ? and period = ?", userId, period).Count(&count)
+	return count > 0
+}
+
+func GetStatements(userId int, period string, startIdx int, num int) (statements []*Statement, err error) {
+	tx := DB.Omit("items")
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if period != "" {
+		tx = tx.Where("period = ?", period)
+	}
+	err = tx.Order("period desc, id desc").Limit(num).Offset(startIdx).Find(&statements).Error
+	return statements, err
+}
+
+// GetStatementById userId 不为 0 时只返回该用户的账单
+func GetStatementById(id int, userId int) (*Statement, error) {
+	statement := &Statement{}
+	tx := DB.Where("id = ?", id)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	err := tx.First(statement).Error
+	return statement, err
+}
+
+func (statement *Statement) MarkEmailed() error {
+	statement.EmailedAt = common.GetTimestamp()
+	return DB.Model(statement).Update("emailed_at", statement.EmailedAt).Error
+}
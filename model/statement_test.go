@@ -0,0 +1,47 @@
+package model
+
+import (
+	"context"
+	"one-api/common"
+	"testing"
+	"time"
+)
+
+func TestBuildStatementTakesConsumptionFromLedger(t *testing.T) {
+	user := createTestUser(t, "statement_user", 1000)
+	period := time.Now().Format(StatementPeriodLayout)
+
+	if err := changeUserQuota(user.Id, -100, LedgerReasonPreConsume, "req-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := changeUserQuota(user.Id, 40, LedgerReasonRefund, "req-1"); err != nil {
+		t.Fatal(err)
+	}
+	RecordConsumeLog(context.Background(), user.Id, 1, 10, 20, "gpt-4o", "default", 60, "", 0, 0, 1000, 1, false, nil)
+
+	statement, err := BuildStatement(user.Id, period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.ConsumedQuota != 60 || statement.RequestCount != 1 || statement.ItemsIncomplete {
+		t.Fatalf("unexpected statement: consumed %d, requests %d, incomplete %v", statement.ConsumedQuota, statement.RequestCount, statement.ItemsIncomplete)
+	}
+
+	// 关闭消费日志后消费金额仍来自流水，明细标记为不完整
+	common.LogConsumeEnabled = false
+	defer func() { common.LogConsumeEnabled = true }()
+	if err = changeUserQuota(user.Id, -25, LedgerReasonConsume, "req-2"); err != nil {
+		t.Fatal(err)
+	}
+	RecordConsumeLog(context.Background(), user.Id, 1, 5, 5, "gpt-4o", "default", 25, "", 0, 0, 1000, 1, false, nil)
+	statement, err = BuildStatement(user.Id, period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.ConsumedQuota != 85 || !statement.ItemsIncomplete {
+		t.Fatalf("expected ledger consumption 85 with incomplete items, got %d %v", statement.ConsumedQuota, statement.ItemsIncomplete)
+	}
+	if statement.OpeningBalance != 0 || statement.ClosingBalance != 1000-85 {
+		t.Fatalf("unexpected balances: opening %d, closing %d", statement.OpeningBalance, statement.ClosingBalance)
+	}
+}
@@ -0,0 +1,520 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionPlanStatusEnabled  = 1
+	SubscriptionPlanStatusDisabled = 2
+)
+
+const (
+	SubscriptionStatusActive    = 1
+	SubscriptionStatusExpired   = 2
+	SubscriptionStatusCancelled = 3
+)
+
+// SubscriptionPlan 订阅套餐，每个周期发放 Quota 额度，周期结束时未用完的套餐额度清零后重新发放
+type SubscriptionPlan struct {
+	Id           int     `json:"id"`
+	Name         string  `json:"name" gorm:"type:varchar(64)"`
+	Description  string  `json:"description" gorm:"type:varchar(255)"`
+	Price        float64 `json:"price"`         // 通过在线支付购买的价格
+	Quota        int     `json:"quota"`         // 每个周期发放的额度
+	DurationDays int     `json:"duration_days"` // 每次购买或续费的有效天数
+	ResetDays    int     `json:"reset_days"`    // 额度重置周期，0 表示与有效天数相同
+	Group        string  `json:"group" gorm:"type:varchar(64);default:''"`
+	Status       int     `json:"status" gorm:"default:1"`
+	CreatedTime  int64   `json:"created_time" gorm:"bigint"`
+}
+
+// UserSubscription 用户订阅，每个用户同时最多只有一个生效中的订阅。
+// 套餐额度直接计入用户额度，UsedQuota 记录当期调用的净消耗，
+// 当期剩余的套餐额度为 PeriodQuota - UsedQuota，消耗时优先计入套餐额度
+type UserSubscription struct {
+	Id            int   `json:"id"`
+	UserId        int   `json:"user_id" gorm:"index"`
+	PlanId        int   `json:"plan_id" gorm:"index"`
+	Status        int   `json:"status" gorm:"index;default:1"`
+	AutoRenew     bool  `json:"auto_renew" gorm:"default:false"`
+	StartTime     int64 `json:"start_time" gorm:"bigint"`
+	EndTime       int64 `json:"end_time" gorm:"bigint;index"`
+	PeriodQuota   int   `json:"period_quota"`
+	UsedQuota     int   `json:"used_quota"`
+	NextResetTime int64 `json:"next_reset_time" gorm:"bigint;index"`
+	CreatedTime   int64 `json:"created_time" gorm:"bigint"`
+	// RenewalTradeNo 当期已创建的在线支付续费订单号，续期后清空
+	RenewalTradeNo string `json:"renewal_trade_no" gorm:"type:varchar(64);default:''"`
+	// ActiveUserId 生效中的订阅为用户ID，结束后清空，由唯一索引保证并发开通时每个用户只有一个生效中的订阅
+	ActiveUserId *int `json:"-" gorm:"uniqueIndex"`
+}
+
+func (plan *SubscriptionPlan) periodSeconds() int64 {
+	if plan.ResetDays <= 0 || plan.ResetDays > plan.DurationDays {
+		return int64(plan.DurationDays) * 86400
+	}
+	return int64(plan.ResetDays) * 86400
+}
+
+// BalanceQuota 使用账户余额购买或自动续费时扣除的额度，按充值价格折算
+func (plan *SubscriptionPlan) BalanceQuota() int {
+	if constant.Price <= 0 {
+		return 0
+	}
+	return int(plan.Price / constant.Price * common.QuotaPerUnit)
+}
+
+// PlanRemainQuota 当期剩余的套餐额度
+func (subscription *UserSubscription) PlanRemainQuota() int {
+	remain := subscription.PeriodQuota - subscription.UsedQuota
+	if remain < 0 {
+		return 0
+	}
+	if remain > subscription.PeriodQuota {
+		return subscription.PeriodQuota
+	}
+	return remain
+}
+
+func GetSubscriptionPlans(enabledOnly bool) (plans []*SubscriptionPlan, err error) {
+	tx := DB.Order("price, id")
+	if enabledOnly {
+		tx = tx.Where("status = ?", SubscriptionPlanStatusEnabled)
+	}
+	err = tx.Find(&plans).Error
+	return plans, err
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	plan := &SubscriptionPlan{}
+	err := DB.First(plan, "id = ?", id).Error
+	return plan, err
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	return DB.Create(plan).Error
+}
+
+// Update 更新套餐，已生效的订阅在下个周期按新的额度发放
+func (plan *SubscriptionPlan) Update() error {
+	return DB.Model(plan).Select("name", "description", "price", "quota", "duration_days", "reset_days", "group", "status").Updates(plan).Error
+}
+
+// DeleteSubscriptionPlanById 删除没有生效中订阅的套餐
+func DeleteSubscriptionPlanById(id int) error {
+	var count int64
+	err := DB.Model(&UserSubscription{}).Where("plan_id = ? and status = ?", id, SubscriptionStatusActive).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该套餐仍有生效中的订阅，请先禁用套餐")
+	}
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+func GetActiveUserSubscription(userId int) (*UserSubscription, error) {
+	subscription := &UserSubscription{}
+	err := DB.Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).Limit(1).Find(subscription).Error
+	if err != nil || subscription.Id == 0 {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func GetUserSubscriptions(userId int, startIdx int, num int) (subscriptions []*UserSubscription, total int64, err error) {
+	tx := DB.Model(&UserSubscription{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&subscriptions).Error
+	return subscriptions, total, err
+}
+
+func SetSubscriptionAutoRenew(userId int, autoRenew bool) error {
+	result := DB.Model(&UserSubscription{}).Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).
+		Update("auto_renew", autoRenew)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("没有生效中的订阅")
+	}
+	return nil
+}
+
+// isConsumeLedgerReason 调用产生的扣费与退款，计入订阅当期的套餐用量
+func isConsumeLedgerReason(reason string) bool {
+	return reason == LedgerReasonPreConsume || reason == LedgerReasonConsume || reason == LedgerReasonRefund
+}
+
+// addSubscriptionUsageTx 累加用户生效中订阅的当期用量，quota 为负数表示退款
+func addSubscriptionUsageTx(tx *gorm.DB, userId int, quota int) error {
+	return tx.Model(&UserSubscription{}).Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).
+		Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+}
+
+// recordBatchSubscriptionUsage 批量更新模式下根据暂存的流水累加订阅用量
+func recordBatchSubscriptionUsage(userId int, ledgers []*QuotaLedger) {
+	used := 0
+	for _, ledger := range ledgers {
+		if isConsumeLedgerReason(ledger.Reason) {
+			used -= ledger.Amount
+		}
+	}
+	if used == 0 {
+		return
+	}
+	if err := addSubscriptionUsageTx(DB, userId, used); err != nil {
+		common.SysError("failed to update subscription usage: " + err.Error())
+	}
+}
+
+// lockSubscriptionTx 锁定订阅所属用户并重新读取订阅，返回用户当前额度。
+// 调用扣费时先更新用户再累加订阅用量，这里按相同顺序加锁，保证读取到的用量不会遗漏
+func lockSubscriptionTx(tx *gorm.DB, subscription *UserSubscription) (int, error) {
+	var user User
+	err := lockForUpdate(tx).Select("id", "quota").First(&user, "id = ?", subscription.UserId).Error
+	if err != nil {
+		return 0, err
+	}
+	return user.Quota, tx.First(subscription, "id = ?", subscription.Id).Error
+}
+
+// startSubscriptionPeriodTx 收回当期未用完的套餐额度并发放新周期的额度
+func startSubscriptionPeriodTx(tx *gorm.DB, subscription *UserSubscription, plan *SubscriptionPlan, userQuota int, periodEnd int64) error {
+	reclaim := subscription.PlanRemainQuota()
+	if reclaim > userQuota {
+		reclaim = userQuota
+	}
+	if reclaim < 0 {
+		reclaim = 0
+	}
+	err := changeUserQuotaTx(tx, subscription.UserId, plan.Quota-reclaim, LedgerReasonSubscription, strconv.Itoa(subscription.Id))
+	if err != nil {
+		return err
+	}
+	subscription.PeriodQuota = plan.Quota
+	subscription.UsedQuota = 0
+	subscription.NextResetTime = periodEnd
+	return nil
+}
+
+// grantSubscriptionGroupTx 将用户调整到套餐分组，分组有效期与订阅保持一致
+func grantSubscriptionGroupTx(tx *gorm.DB, subscription *UserSubscription, group string, now int64) error {
+	_, err := grantUserGroupTx(tx, subscription.UserId, group, subscription.EndTime-now, UserGroupGrantSourceSubscription, strconv.Itoa(subscription.Id))
+	if err != nil {
+		return err
+	}
+	return tx.Model(&UserGroupGrant{}).Where("user_id = ? and status = ?", subscription.UserId, UserGroupGrantStatusActive).
+		Update("expired_time", subscription.EndTime).Error
+}
+
+// Subscribe 为用户开通或续期套餐，chargeQuota 大于 0 时从用户的充值额度中扣除。
+// 已订阅同一套餐时顺延有效期，订阅其他套餐时需要先取消当前订阅
+func Subscribe(userId int, planId int, chargeQuota int, reference string) (*UserSubscription, error) {
+	plan, err := GetSubscriptionPlanById(planId)
+	if err != nil {
+		return nil, errors.New("套餐不存在")
+	}
+	if plan.Status != SubscriptionPlanStatusEnabled {
+		return nil, errors.New("套餐已下架")
+	}
+	subscription := &UserSubscription{}
+	renewed := false
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := lockForUpdate(tx).Select("id", "quota").First(&user, "id = ?", userId).Error
+		if err != nil {
+			return err
+		}
+		userQuota := user.Quota
+		err = tx.Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).Limit(1).Find(subscription).Error
+		if err != nil {
+			return err
+		}
+		if subscription.Id != 0 && subscription.PlanId != planId {
+			return errors.New("已有其他生效中的订阅，请先取消当前订阅")
+		}
+		if chargeQuota > 0 {
+			if userQuota-subscription.PlanRemainQuota() < chargeQuota {
+				return errors.New("余额不足")
+			}
+			err = changeUserQuotaTx(tx, userId, -chargeQuota, LedgerReasonSubscriptionPurchase, reference)
+			if err != nil {
+				return err
+			}
+			userQuota -= chargeQuota
+		}
+		now := common.GetTimestamp()
+		duration := int64(plan.DurationDays) * 86400
+		if subscription.Id != 0 {
+			renewed = true
+			if subscription.EndTime < now {
+				subscription.EndTime = now
+			}
+			subscription.EndTime += duration
+			subscription.ActiveUserId = &userId
+			subscription.RenewalTradeNo = ""
+		} else {
+			*subscription = UserSubscription{
+				UserId:       userId,
+				PlanId:       planId,
+				Status:       SubscriptionStatusActive,
+				StartTime:    now,
+				EndTime:      now + duration,
+				CreatedTime:  now,
+				ActiveUserId: &userId,
+			}
+			if err = tx.Create(subscription).Error; err != nil {
+				return err
+			}
+			err = startSubscriptionPeriodTx(tx, subscription, plan, userQuota, now+plan.periodSeconds())
+			if err != nil {
+				return err
+			}
+		}
+		if err = tx.Save(subscription).Error; err != nil {
+			return err
+		}
+		if plan.Group != "" {
+			return grantSubscriptionGroupTx(tx, subscription, plan.Group, now)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if plan.Group != "" {
+		cacheSetUserGroup(userId, plan.Group)
+	}
+	_ = CacheUpdateUserQuota(userId)
+	if renewed {
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("续订套餐 %s，有效期至 %s", plan.Name, time.Unix(subscription.EndTime, 0).Format("2006-01-02 15:04:05")))
+	} else {
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("订阅套餐 %s，获得套餐额度 %s，有效期至 %s", plan.Name, common.LogQuota(plan.Quota), time.Unix(subscription.EndTime, 0).Format("2006-01-02 15:04:05")))
+	}
+	return subscription, nil
+}
+
+// endSubscriptionTx 结束订阅，收回剩余的套餐额度并恢复订阅前的分组
+func endSubscriptionTx(tx *gorm.DB, subscription *UserSubscription, status int) (restoredGroup string, err error) {
+	userQuota, err := lockSubscriptionTx(tx, subscription)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+	reclaim := subscription.PlanRemainQuota()
+	if reclaim > userQuota {
+		reclaim = userQuota
+	}
+	if reclaim > 0 {
+		err = changeUserQuotaTx(tx, subscription.UserId, -reclaim, LedgerReasonSubscription, strconv.Itoa(subscription.Id))
+		if err != nil {
+			return "", err
+		}
+	}
+	result := tx.Model(&UserSubscription{}).Where("id = ? and status = ?", subscription.Id, SubscriptionStatusActive).
+		Updates(map[string]interface{}{"status": status, "end_time": common.GetTimestamp(), "active_user_id": nil})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", errors.New("订阅已结束")
+	}
+	return endUserGroupGrantTx(tx, subscription.UserId, UserGroupGrantSourceSubscription)
+}
+
+// CancelUserSubscription 立即终止订阅
+func CancelUserSubscription(id int) (*UserSubscription, error) {
+	subscription := &UserSubscription{}
+	if err := DB.First(subscription, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if subscription.Status != SubscriptionStatusActive {
+		return nil, errors.New("订阅已结束")
+	}
+	var restoredGroup string
+	err := DB.Transaction(func(tx *gorm.DB) (err error) {
+		restoredGroup, err = endSubscriptionTx(tx, subscription, SubscriptionStatusCancelled)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	afterSubscriptionEnded(subscription.UserId, restoredGroup, "订阅已被管理员终止")
+	return subscription, nil
+}
+
+func afterSubscriptionEnded(userId int, restoredGroup string, message string) {
+	if restoredGroup != "" {
+		cacheSetUserGroup(userId, restoredGroup)
+		message += "，分组恢复为 " + restoredGroup
+	}
+	_ = CacheUpdateUserQuota(userId)
+	RecordLog(userId, LogTypeSystem, message)
+	go func() {
+		email, err := GetUserEmail(userId)
+		if err != nil || email == "" {
+			return
+		}
+		if err = common.SendEmail("订阅已结束", email, message+"。"); err != nil {
+			common.SysError("failed to send email: " + err.Error())
+		}
+	}()
+}
+
+// GetSubscriptionsNeedRenewalOrder 返回将在 before 之前到期、开启了自动续费且当期尚未创建续费订单的订阅
+func GetSubscriptionsNeedRenewalOrder(before int64) (subscriptions []*UserSubscription, err error) {
+	err = DB.Where("status = ? and auto_renew = ? and end_time <= ? and renewal_trade_no = ?", SubscriptionStatusActive, true, before, "").
+		Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// NeedRenewalOrder 账户余额不足以在到期时自动续费时返回 true
+func (subscription *UserSubscription) NeedRenewalOrder(plan *SubscriptionPlan) (bool, error) {
+	if plan.BalanceQuota() <= 0 {
+		return true, nil
+	}
+	userQuota, err := GetUserQuota(subscription.UserId)
+	if err != nil {
+		return false, err
+	}
+	return userQuota-subscription.PlanRemainQuota() < plan.BalanceQuota(), nil
+}
+
+// SetSubscriptionRenewalTradeNo 记录当期的续费订单号，已有续费订单或订阅已结束时返回 false，避免重复创建
+func SetSubscriptionRenewalTradeNo(id int, tradeNo string) (bool, error) {
+	result := DB.Model(&UserSubscription{}).Where("id = ? and status = ? and renewal_trade_no = ?", id, SubscriptionStatusActive, "").
+		Update("renewal_trade_no", tradeNo)
+	return result.RowsAffected > 0, result.Error
+}
+
+// processSubscription 处理到期的周期重置、自动续费与订阅到期
+func processSubscription(subscription *UserSubscription, now int64) error {
+	plan := &SubscriptionPlan{}
+	if err := DB.Where("id = ?", subscription.PlanId).Limit(1).Find(plan).Error; err != nil {
+		return err
+	}
+	if subscription.EndTime > now {
+		if plan.Id == 0 || plan.periodSeconds() <= 0 || subscription.NextResetTime > now {
+			return nil
+		}
+		return DB.Transaction(func(tx *gorm.DB) error {
+			userQuota, err := lockSubscriptionTx(tx, subscription)
+			if err != nil || subscription.Status != SubscriptionStatusActive {
+				return err
+			}
+			nextReset := subscription.NextResetTime + plan.periodSeconds()
+			for nextReset <= now {
+				nextReset += plan.periodSeconds()
+			}
+			if err = startSubscriptionPeriodTx(tx, subscription, plan, userQuota, nextReset); err != nil {
+				return err
+			}
+			return tx.Model(subscription).Select("period_quota", "used_quota", "next_reset_time").Updates(subscription).Error
+		})
+	}
+	if subscription.AutoRenew && plan.Id != 0 && plan.Status == SubscriptionPlanStatusEnabled && plan.DurationDays > 0 && plan.BalanceQuota() > 0 {
+		renewed := false
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			userQuota, err := lockSubscriptionTx(tx, subscription)
+			if err != nil || subscription.Status != SubscriptionStatusActive {
+				return err
+			}
+			cost := plan.BalanceQuota()
+			if userQuota-subscription.PlanRemainQuota() < cost {
+				return nil
+			}
+			reference := strconv.Itoa(subscription.Id)
+			if err = changeUserQuotaTx(tx, subscription.UserId, -cost, LedgerReasonSubscriptionPurchase, reference); err != nil {
+				return err
+			}
+			duration := int64(plan.DurationDays) * 86400
+			subscription.EndTime += duration
+			for subscription.EndTime <= now {
+				subscription.EndTime += duration
+			}
+			subscription.RenewalTradeNo = ""
+			if err = startSubscriptionPeriodTx(tx, subscription, plan, userQuota-cost, now+plan.periodSeconds()); err != nil {
+				return err
+			}
+			if err = tx.Model(subscription).Select("end_time", "period_quota", "used_quota", "next_reset_time", "renewal_trade_no").Updates(subscription).Error; err != nil {
+				return err
+			}
+			if plan.Group != "" {
+				if err = grantSubscriptionGroupTx(tx, subscription, plan.Group, now); err != nil {
+					return err
+				}
+			}
+			renewed = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if renewed {
+			_ = CacheUpdateUserQuota(subscription.UserId)
+			RecordLog(subscription.UserId, LogTypeTopup, fmt.Sprintf("套餐 %s 已自动续费，扣除余额 %s，有效期至 %s", plan.Name, common.LogQuota(plan.BalanceQuota()), time.Unix(subscription.EndTime, 0).Format("2006-01-02 15:04:05")))
+			return nil
+		}
+	}
+	var restoredGroup string
+	err := DB.Transaction(func(tx *gorm.DB) (err error) {
+		restoredGroup, err = endSubscriptionTx(tx, subscription, SubscriptionStatusExpired)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	message := "订阅已到期"
+	if plan.Id != 0 {
+		message = fmt.Sprintf("套餐 %s 已到期", plan.Name)
+		if subscription.AutoRenew && subscription.RenewalTradeNo != "" {
+			message += "，余额不足且续费订单未支付，支付后将重新开通"
+		} else if subscription.AutoRenew {
+			message += "，余额不足无法自动续费"
+		}
+	}
+	afterSubscriptionEnded(subscription.UserId, restoredGroup, message)
+	return nil
+}
+
+// ProcessSubscriptions 重置到期周期的套餐额度，并处理到期订阅的自动续费或降级
+func ProcessSubscriptions() error {
+	now := common.GetTimestamp()
+	var subscriptions []*UserSubscription
+	err := DB.Where("status = ? and (next_reset_time <= ? or end_time <= ?)", SubscriptionStatusActive, now, now).
+		Find(&subscriptions).Error
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		if err = processSubscription(subscription, now); err != nil {
+			common.SysError(fmt.Sprintf("failed to process subscription %d: %s", subscription.Id, err.Error()))
+		}
+	}
+	return nil
+}
+
+func AutomaticallyProcessSubscriptions() {
+	for {
+		if err := ProcessSubscriptions(); err != nil {
+			common.SysError("failed to process subscriptions: " + err.Error())
+		}
+		time.Sleep(time.Minute)
+	}
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+)
+
+func createTestSubscriptionPlan(t *testing.T, name string) *SubscriptionPlan {
+	plan := &SubscriptionPlan{
+		Name:         name,
+		Price:        7.3,
+		Quota:        1000,
+		DurationDays: 30,
+		ResetDays:    10,
+		Status:       SubscriptionPlanStatusEnabled,
+		CreatedTime:  common.GetTimestamp(),
+	}
+	if err := plan.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func TestProcessSubscriptionResetsPeriodQuota(t *testing.T) {
+	user := createTestUser(t, "sub_reset", 0)
+	plan := createTestSubscriptionPlan(t, "reset")
+	subscription, err := Subscribe(user.Id, plan.Id, 0, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = DecreaseUserQuota(user.Id, 300, LedgerReasonConsume, "req"); err != nil {
+		t.Fatal(err)
+	}
+	if err = DB.First(subscription, subscription.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if subscription.PlanRemainQuota() != 700 {
+		t.Fatalf("expected 700 plan quota left, got %d", subscription.PlanRemainQuota())
+	}
+	if err = processSubscription(subscription, subscription.NextResetTime); err != nil {
+		t.Fatal(err)
+	}
+	quota, _ := GetUserQuota(user.Id)
+	if quota != 1000 {
+		t.Fatalf("expected the unused plan quota to be replaced by a fresh period of 1000, got %d", quota)
+	}
+	if err = DB.First(subscription, subscription.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if subscription.PeriodQuota != 1000 || subscription.UsedQuota != 0 {
+		t.Fatalf("expected a fresh period, got %d/%d", subscription.UsedQuota, subscription.PeriodQuota)
+	}
+}
+
+func TestProcessSubscriptionAutoRenew(t *testing.T) {
+	user := createTestUser(t, "sub_renew", 0)
+	plan := createTestSubscriptionPlan(t, "renew")
+	subscription, err := Subscribe(user.Id, plan.Id, 0, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SetSubscriptionAutoRenew(user.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = DB.First(subscription, subscription.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	need, err := subscription.NeedRenewalOrder(plan)
+	if err != nil || !need {
+		t.Fatalf("expected a renewal order without enough balance, got %v %v", need, err)
+	}
+	ok, err := SetSubscriptionRenewalTradeNo(subscription.Id, "renewal-1")
+	if err != nil || !ok {
+		t.Fatalf("expected the renewal order to be recorded, got %v %v", ok, err)
+	}
+	if ok, _ = SetSubscriptionRenewalTradeNo(subscription.Id, "renewal-2"); ok {
+		t.Fatal("expected only one renewal order per term")
+	}
+	pending, err := GetSubscriptionsNeedRenewalOrder(subscription.EndTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range pending {
+		if s.Id == subscription.Id {
+			t.Fatal("expected a subscription with a renewal order to be skipped")
+		}
+	}
+
+	if err = IncreaseUserQuota(user.Id, plan.BalanceQuota(), LedgerReasonTopUp, "topup"); err != nil {
+		t.Fatal(err)
+	}
+	if err = DB.First(subscription, subscription.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if need, _ = subscription.NeedRenewalOrder(plan); need {
+		t.Fatal("expected the balance to cover the renewal")
+	}
+	endTime := subscription.EndTime
+	if err = processSubscription(subscription, endTime); err != nil {
+		t.Fatal(err)
+	}
+	if err = DB.First(subscription, subscription.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if subscription.Status != SubscriptionStatusActive || subscription.EndTime != endTime+int64(plan.DurationDays)*86400 {
+		t.Fatalf("expected the subscription to be renewed, got status %d end %d", subscription.Status, subscription.EndTime)
+	}
+	if subscription.RenewalTradeNo != "" {
+		t.Fatal("expected the renewal order to be cleared after renewing")
+	}
+	quota, _ := GetUserQuota(user.Id)
+	if quota != plan.Quota {
+		t.Fatalf("expected the balance to pay for the renewal, got %d", quota)
+	}
+
+	if err = processSubscription(subscription, subscription.EndTime); err != nil {
+		t.Fatal(err)
+	}
+	if err = DB.First(subscription, subscription.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if subscription.Status != SubscriptionStatusExpired {
+		t.Fatalf("expected the subscription to expire without balance, got status %d", subscription.Status)
+	}
+}
@@ -23,7 +23,8 @@ type Token struct {
 	UnlimitedQuota     bool           `json:"unlimited_quota" gorm:"default:false"`
 	ModelLimitsEnabled bool           `json:"model_limits_enabled" gorm:"default:false"`
 	ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
-	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
+	UsedQuota          int            `json:"used_quota" gorm:"default:0"`   // used quota
+	OrgId              int            `json:"org_id" gorm:"index;default:0"` // 非 0 时从组织额度池扣费
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -232,7 +233,8 @@ func decreaseTokenQuota(id int, quota int) (err error) {
 	return err
 }
 
-func PreConsumeTokenQuota(tokenId int, quota int) (userQuota int, err error) {
+// PreConsumeTokenQuota 预扣令牌与用户额度，reference 为请求 ID，记入额度流水
+func PreConsumeTokenQuota(tokenId int, quota int, reference string) (userQuota int, err error) {
 	if quota < 0 {
 		return 0, errors.New("quota 不能为负数！")
 	}
@@ -243,30 +245,57 @@ func PreConsumeTokenQuota(tokenId int, quota int) (userQuota int, err error) {
 	if !token.UnlimitedQuota && token.RemainQuota < quota {
 		return 0, errors.New("令牌额度不足")
 	}
-	userQuota, err = GetUserQuota(token.UserId)
+	if token.OrgId != 0 {
+		userQuota, err = GetOrganizationPayerQuota(token.OrgId, token.UserId)
+	} else {
+		userQuota, err = GetUserQuota(token.UserId)
+	}
 	if err != nil {
 		return 0, err
 	}
 	if userQuota < quota {
+		if token.OrgId != 0 {
+			return 0, errors.New(fmt.Sprintf("组织额度不足或超出成员消费上限，剩余额度为 %d", userQuota))
+		}
 		return 0, errors.New(fmt.Sprintf("用户额度不足，剩余额度为 %d", userQuota))
 	}
+	if token.OrgId != 0 {
+		// 并发请求读取到的可用额度可能相同，按条件扣减保证组织额度与成员消费上限不被超出
+		err = changeOrganizationQuota(token.OrgId, token.UserId, quota, true, LedgerReasonPreConsume, reference)
+	} else {
+		err = DecreaseUserQuota(token.UserId, quota, LedgerReasonPreConsume, reference)
+	}
+	if err != nil {
+		return 0, err
+	}
 	if !token.UnlimitedQuota {
 		err = DecreaseTokenQuota(tokenId, quota)
 		if err != nil {
 			return 0, err
 		}
 	}
-	err = DecreaseUserQuota(token.UserId, quota)
-	return userQuota - quota, err
+	return userQuota - quota, nil
 }
 
-func PostConsumeTokenQuota(tokenId int, userQuota int, quota int, preConsumedQuota int, sendEmail bool) (err error) {
+// PostConsumeTokenQuota 按实际消耗补扣或退还额度，reference 为请求 ID，记入额度流水
+func PostConsumeTokenQuota(tokenId int, userQuota int, quota int, preConsumedQuota int, sendEmail bool, reference string) (err error) {
 	token, err := GetTokenById(tokenId)
+	if err != nil {
+		return err
+	}
 
-	if quota > 0 {
-		err = DecreaseUserQuota(token.UserId, quota)
+	if token.OrgId != 0 {
+		// 组织令牌从组织额度池结算，记入组织额度流水，不发送个人额度提醒
+		reason := LedgerReasonConsume
+		if quota < 0 {
+			reason = LedgerReasonRefund
+		}
+		err = changeOrganizationQuota(token.OrgId, token.UserId, quota, false, reason, reference)
+		sendEmail = false
+	} else if quota > 0 {
+		err = DecreaseUserQuota(token.UserId, quota, LedgerReasonConsume, reference)
 	} else {
-		err = IncreaseUserQuota(token.UserId, -quota)
+		err = IncreaseUserQuota(token.UserId, -quota, LedgerReasonRefund, reference)
 	}
 	if err != nil {
 		return err
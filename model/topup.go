@@ -8,6 +8,7 @@ type TopUp struct {
 	TradeNo    string  `json:"trade_no"`
 	CreateTime int64   `json:"create_time"`
 	Status     string  `json:"status"`
+	PlanId     int     `json:"plan_id" gorm:"default:0"` // 购买订阅套餐的订单，支付成功后开通套餐而不是充值额度
 }
 
 func (topUp *TopUp) Insert() error {
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"time"
+)
+
+// recoveryCodeCount 每次生成的一次性恢复码数量
+const recoveryCodeCount = 10
+
+// UserTwoFA 用户的 TOTP 两步验证配置。Enabled 为 false 时表示已生成密钥但尚未完成验证
+type UserTwoFA struct {
+	UserId        int    `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Secret        string `json:"-" gorm:"type:varchar(64)"`
+	Enabled       bool   `json:"enabled" gorm:"default:false"`
+	RecoveryCodes string `json:"-" gorm:"type:text"` // 恢复码摘要的 JSON 数组，使用后移除
+	LastUsedStep  int64  `json:"-" gorm:"bigint;default:0"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+}
+
+func (twoFA *UserTwoFA) getRecoveryCodes() []string {
+	var hashes []string
+	if twoFA.RecoveryCodes != "" {
+		_ = json.Unmarshal([]byte(twoFA.RecoveryCodes), &hashes)
+	}
+	return hashes
+}
+
+func GetUserTwoFA(userId int) (*UserTwoFA, error) {
+	twoFA := &UserTwoFA{}
+	err := DB.First(twoFA, "user_id = ?", userId).Error
+	return twoFA, err
+}
+
+func IsTwoFAEnabled(userId int) bool {
+	var count int64
+	err := DB.Model(&UserTwoFA{}).Where("user_id = ? and enabled = ?", userId, true).Count(&count).Error
+	return err == nil && count > 0
+}
+
+// GetRecoveryCodesRemaining 返回剩余可用的恢复码数量
+func GetRecoveryCodesRemaining(userId int) int {
+	twoFA, err := GetUserTwoFA(userId)
+	if err != nil {
+		return 0
+	}
+	return len(twoFA.getRecoveryCodes())
+}
+
+// SetupUserTwoFA 为用户生成新的 TOTP 密钥，需调用 EnableUserTwoFA 验证后才会生效
+func SetupUserTwoFA(userId int) (string, error) {
+	if IsTwoFAEnabled(userId) {
+		return "", errors.New("已启用两步验证，请先关闭后再重新绑定")
+	}
+	secret, err := common.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	if err = DB.Where("user_id = ?", userId).Delete(&UserTwoFA{}).Error; err != nil {
+		return "", err
+	}
+	err = DB.Create(&UserTwoFA{
+		UserId:      userId,
+		Secret:      secret,
+		CreatedTime: common.GetTimestamp(),
+	}).Error
+	return secret, err
+}
+
+func newRecoveryCodes() ([]string, string, error) {
+	codes, err := common.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, "", err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, common.HashRecoveryCode(code))
+	}
+	data, err := json.Marshal(hashes)
+	return codes, string(data), err
+}
+
+// EnableUserTwoFA 使用验证器应用生成的验证码确认绑定，成功后返回仅展示一次的恢复码
+func EnableUserTwoFA(userId int, code string) ([]string, error) {
+	twoFA, err := GetUserTwoFA(userId)
+	if err != nil {
+		return nil, errors.New("请先生成两步验证密钥")
+	}
+	if twoFA.Enabled {
+		return nil, errors.New("已启用两步验证")
+	}
+	step, ok := common.ValidateTOTP(twoFA.Secret, code, time.Now())
+	if !ok {
+		return nil, errors.New("验证码错误")
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Model(&UserTwoFA{}).Where("user_id = ?", userId).Updates(map[string]interface{}{
+		"enabled":        true,
+		"recovery_codes": hashes,
+		"last_used_step": step,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyUserTwoFA 校验 TOTP 验证码或一次性恢复码，验证码在同一时间窗口内只能使用一次
+func VerifyUserTwoFA(userId int, code string) error {
+	twoFA, err := GetUserTwoFA(userId)
+	if err != nil || !twoFA.Enabled {
+		return errors.New("未启用两步验证")
+	}
+	if step, ok := common.ValidateTOTP(twoFA.Secret, code, time.Now()); ok {
+		result := DB.Model(&UserTwoFA{}).Where("user_id = ? and last_used_step < ?", userId, step).Update("last_used_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("验证码已被使用，请等待下一个验证码")
+		}
+		return nil
+	}
+	hash := common.HashRecoveryCode(code)
+	hashes := twoFA.getRecoveryCodes()
+	for i, h := range hashes {
+		if h != hash {
+			continue
+		}
+		remaining := append(append([]string{}, hashes[:i]...), hashes[i+1:]...)
+		data, err := json.Marshal(remaining)
+		if err != nil {
+			return err
+		}
+		// 以原值为条件更新，防止同一恢复码被并发使用两次
+		result := DB.Model(&UserTwoFA{}).Where("user_id = ? and recovery_codes = ?", userId, twoFA.RecoveryCodes).Update("recovery_codes", string(data))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("恢复码已被使用")
+		}
+		return nil
+	}
+	return errors.New("验证码错误")
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，原有的恢复码全部失效
+func RegenerateRecoveryCodes(userId int) ([]string, error) {
+	if !IsTwoFAEnabled(userId) {
+		return nil, errors.New("未启用两步验证")
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Model(&UserTwoFA{}).Where("user_id = ?", userId).Update("recovery_codes", hashes).Error
+	return codes, err
+}
+
+func DisableUserTwoFA(userId int) error {
+	return DB.Where("user_id = ?", userId).Delete(&UserTwoFA{}).Error
+}
@@ -4,20 +4,33 @@ import (
 	"fmt"
 	"gorm.io/gorm"
 	"one-api/common"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	QuotaDataGranularityHour  = "hour"
+	QuotaDataGranularityDay   = "day"
+	QuotaDataGranularityMonth = "month"
+)
+
 // QuotaData 柱状图数据
 type QuotaData struct {
 	Id        int    `json:"id"`
 	UserID    int    `json:"user_id" gorm:"index"`
 	Username  string `json:"username" gorm:"index:idx_qdt_model_user_name,priority:2;size:64;default:''"`
 	ModelName string `json:"model_name" gorm:"index:idx_qdt_model_user_name,priority:1;size:64;default:''"`
-	CreatedAt int64  `json:"created_at" gorm:"bigint;index:idx_qdt_created_at,priority:2"`
-	TokenUsed int    `json:"token_used" gorm:"default:0"`
-	Count     int    `json:"count" gorm:"default:0"`
-	Quota     int    `json:"quota" gorm:"default:0"`
+	TokenId   int    `json:"token_id" gorm:"index;default:0"`
+	TokenName string `json:"token_name" gorm:"size:64;default:''"`
+	ChannelId int    `json:"channel_id" gorm:"index;default:0"`
+	// Granularity 数据粒度，过期的小时数据会合并为天数据，天数据合并为月数据
+	Granularity string `json:"granularity" gorm:"size:16;default:'hour';index:idx_qdt_granularity_created_at,priority:1"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index:idx_qdt_created_at,priority:2;index:idx_qdt_granularity_created_at,priority:2"`
+	TokenUsed   int    `json:"token_used" gorm:"default:0"`
+	Count       int    `json:"count" gorm:"default:0"`
+	Quota       int    `json:"quota" gorm:"default:0"`
 }
 
 func UpdateQuotaData() {
@@ -39,34 +52,46 @@ func UpdateQuotaData() {
 var CacheQuotaData = make(map[string]*QuotaData)
 var CacheQuotaDataLock = sync.Mutex{}
 
-func logQuotaDataCache(userId int, username string, modelName string, quota int, createdAt int64, tokenUsed int) {
-	key := fmt.Sprintf("%d-%s-%s-%d", userId, username, modelName, createdAt)
+func (quotaData *QuotaData) dimensionKey() string {
+	return fmt.Sprintf("%d-%s-%s-%d-%s-%d-%s-%d", quotaData.UserID, quotaData.Username, quotaData.ModelName, quotaData.TokenId, quotaData.TokenName, quotaData.ChannelId, quotaData.Granularity, quotaData.CreatedAt)
+}
+
+func (quotaData *QuotaData) add(other *QuotaData) {
+	quotaData.Count += other.Count
+	quotaData.Quota += other.Quota
+	quotaData.TokenUsed += other.TokenUsed
+}
+
+func logQuotaDataCache(data *QuotaData) {
+	key := data.dimensionKey()
 	quotaData, ok := CacheQuotaData[key]
 	if ok {
-		quotaData.Count += 1
-		quotaData.Quota += quota
-		quotaData.TokenUsed += tokenUsed
+		quotaData.add(data)
 	} else {
-		quotaData = &QuotaData{
-			UserID:    userId,
-			Username:  username,
-			ModelName: modelName,
-			CreatedAt: createdAt,
-			Count:     1,
-			Quota:     quota,
-			TokenUsed: tokenUsed,
-		}
+		quotaData = data
 	}
 	CacheQuotaData[key] = quotaData
 }
 
-func LogQuotaData(userId int, username string, modelName string, quota int, createdAt int64, tokenUsed int) {
+func LogQuotaData(userId int, username string, modelName string, tokenId int, tokenName string, channelId int, quota int, createdAt int64, tokenUsed int) {
 	// 只精确到小时
 	createdAt = createdAt - (createdAt % 3600)
 
 	CacheQuotaDataLock.Lock()
 	defer CacheQuotaDataLock.Unlock()
-	logQuotaDataCache(userId, username, modelName, quota, createdAt, tokenUsed)
+	logQuotaDataCache(&QuotaData{
+		UserID:      userId,
+		Username:    username,
+		ModelName:   modelName,
+		TokenId:     tokenId,
+		TokenName:   tokenName,
+		ChannelId:   channelId,
+		Granularity: QuotaDataGranularityHour,
+		CreatedAt:   createdAt,
+		Count:       1,
+		Quota:       quota,
+		TokenUsed:   tokenUsed,
+	})
 }
 
 func SaveQuotaDataCache() {
@@ -74,59 +99,243 @@ func SaveQuotaDataCache() {
 	defer CacheQuotaDataLock.Unlock()
 	size := len(CacheQuotaData)
 	// 如果缓存中有数据，就保存到数据库中
-	// 1. 先查询数据库中是否有数据
-	// 2. 如果有数据，就更新数据
-	// 3. 如果没有数据，就插入数据
 	for _, quotaData := range CacheQuotaData {
-		quotaDataDB := &QuotaData{}
-		DB.Table("quota_data").Where("user_id = ? and username = ? and model_name = ? and created_at = ?",
-			quotaData.UserID, quotaData.Username, quotaData.ModelName, quotaData.CreatedAt).First(quotaDataDB)
-		if quotaDataDB.Id > 0 {
-			//quotaDataDB.Count += quotaData.Count
-			//quotaDataDB.Quota += quotaData.Quota
-			//DB.Table("quota_data").Save(quotaDataDB)
-			increaseQuotaData(quotaData.UserID, quotaData.Username, quotaData.ModelName, quotaData.Count, quotaData.Quota, quotaData.CreatedAt)
-		} else {
-			DB.Table("quota_data").Create(quotaData)
+		if err := mergeQuotaData(LOG_DB, quotaData); err != nil {
+			common.SysLog(fmt.Sprintf("save quota data error: %s", err))
 		}
 	}
 	CacheQuotaData = make(map[string]*QuotaData)
 	common.SysLog(fmt.Sprintf("保存数据看板数据成功，共保存%d条数据", size))
 }
 
-func increaseQuotaData(userId int, username string, modelName string, count int, quota int, createdAt int64) {
-	err := DB.Table("quota_data").Where("user_id = ? and username = ? and model_name = ? and created_at = ?",
-		userId, username, modelName, createdAt).Updates(map[string]interface{}{
-		"count": gorm.Expr("count + ?", count),
-		"quota": gorm.Expr("quota + ?", quota),
+// mergeQuotaData 数据库中已有相同维度的数据时累加，否则插入
+func mergeQuotaData(tx *gorm.DB, quotaData *QuotaData) error {
+	result := tx.Model(&QuotaData{}).Where("user_id = ? and username = ? and model_name = ? and token_id = ? and token_name = ? and channel_id = ? and granularity = ? and created_at = ?",
+		quotaData.UserID, quotaData.Username, quotaData.ModelName, quotaData.TokenId, quotaData.TokenName, quotaData.ChannelId, quotaData.Granularity, quotaData.CreatedAt).
+		Updates(map[string]interface{}{
+			"count":      gorm.Expr("count + ?", quotaData.Count),
+			"quota":      gorm.Expr("quota + ?", quotaData.Quota),
+			"token_used": gorm.Expr("token_used + ?", quotaData.TokenUsed),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	quotaData.Id = 0
+	return tx.Create(quotaData).Error
+}
+
+// quotaDataBucket 将时间戳对齐到所在小时、天或月的起始时间（服务器时区）
+func quotaDataBucket(timestamp int64, granularity string) int64 {
+	t := time.Unix(timestamp, 0).In(time.Local)
+	switch granularity {
+	case QuotaDataGranularityDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).Unix()
+	case QuotaDataGranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local).Unix()
+	}
+	return timestamp - (timestamp % 3600)
+}
+
+func IsValidQuotaDataGranularity(granularity string) bool {
+	return granularity == QuotaDataGranularityHour || granularity == QuotaDataGranularityDay || granularity == QuotaDataGranularityMonth
+}
+
+// QuotaDataQuery 数据看板查询条件，GroupBy 可包含 user、model、token、channel，结果按 Granularity 对齐时间
+type QuotaDataQuery struct {
+	UserId      int
+	UserIds     []int // 不为 nil 时只统计这些用户的数据
+	Username    string
+	TokenId     int
+	ChannelId   int
+	StartTime   int64
+	EndTime     int64
+	GroupBy     []string
+	Granularity string
+}
+
+var QuotaDataGroupByFields = []string{"user", "model", "token", "channel"}
+
+func (query *QuotaDataQuery) groupBy(field string) bool {
+	for _, f := range query.GroupBy {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// GetQuotaData 按维度与粒度汇总数据看板数据，早于查询粒度保存的数据按其保存粒度返回
+func GetQuotaData(query QuotaDataQuery) ([]*QuotaData, error) {
+	tx := LOG_DB.Model(&QuotaData{}).Where("created_at >= ? and created_at <= ?", query.StartTime, query.EndTime)
+	if query.UserId != 0 {
+		tx = tx.Where("user_id = ?", query.UserId)
+	}
+	if query.UserIds != nil {
+		tx = tx.Where("user_id in ?", query.UserIds)
+	}
+	if query.Username != "" {
+		tx = tx.Where("username = ?", query.Username)
+	}
+	if query.TokenId != 0 {
+		tx = tx.Where("token_id = ?", query.TokenId)
+	}
+	if query.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", query.ChannelId)
+	}
+	result := make([]*QuotaData, 0)
+	resultMap := make(map[string]*QuotaData)
+	err := tx.FindInBatches(&[]*QuotaData{}, 1000, func(batch *gorm.DB, _ int) error {
+		rows := *batch.Statement.Dest.(*[]*QuotaData)
+		for _, row := range rows {
+			item := &QuotaData{
+				Granularity: query.Granularity,
+				CreatedAt:   quotaDataBucket(row.CreatedAt, query.Granularity),
+			}
+			if quotaDataGranularityRank(row.Granularity) > quotaDataGranularityRank(query.Granularity) {
+				item.Granularity = row.Granularity
+				item.CreatedAt = row.CreatedAt
+			}
+			if query.groupBy("user") {
+				item.UserID = row.UserID
+				item.Username = row.Username
+			}
+			if query.groupBy("model") {
+				item.ModelName = row.ModelName
+			}
+			if query.groupBy("token") {
+				item.TokenId = row.TokenId
+				item.TokenName = row.TokenName
+			}
+			if query.groupBy("channel") {
+				item.ChannelId = row.ChannelId
+			}
+			key := item.dimensionKey()
+			if existing, ok := resultMap[key]; ok {
+				existing.add(row)
+				continue
+			}
+			item.add(row)
+			resultMap[key] = item
+			result = append(result, item)
+		}
+		return nil
 	}).Error
 	if err != nil {
-		common.SysLog(fmt.Sprintf("increaseQuotaData error: %s", err))
+		return nil, err
 	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedAt < result[j].CreatedAt
+	})
+	return result, nil
 }
 
-func GetQuotaDataByUsername(username string, startTime int64, endTime int64) (quotaData []*QuotaData, err error) {
-	var quotaDatas []*QuotaData
-	// 从quota_data表中查询数据
-	err = DB.Table("quota_data").Where("username = ? and created_at >= ? and created_at <= ?", username, startTime, endTime).Find(&quotaDatas).Error
-	return quotaDatas, err
+func quotaDataGranularityRank(granularity string) int {
+	switch granularity {
+	case QuotaDataGranularityDay:
+		return 1
+	case QuotaDataGranularityMonth:
+		return 2
+	}
+	return 0
+}
+
+// ParseQuotaDataGroupBy 解析逗号分隔的 group_by 参数
+func ParseQuotaDataGroupBy(value string, allowed []string) ([]string, error) {
+	if value == "" {
+		return []string{"model"}, nil
+	}
+	fields := strings.Split(value, ",")
+	for _, field := range fields {
+		valid := false
+		for _, f := range allowed {
+			if field == f {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("不支持的 group_by：%s", field)
+		}
+	}
+	return fields, nil
+}
+
+// downsampleQuotaData 将 before 之前 from 粒度的数据按 to 粒度合并，每个目标周期在一个事务中完成
+func downsampleQuotaData(from string, to string, before int64) error {
+	before = quotaDataBucket(before, to)
+	for {
+		var oldest QuotaData
+		err := LOG_DB.Where("granularity = ? and created_at < ?", from, before).Order("created_at").Limit(1).Find(&oldest).Error
+		if err != nil || oldest.Id == 0 {
+			return err
+		}
+		start := quotaDataBucket(oldest.CreatedAt, to)
+		end := time.Unix(start, 0).AddDate(0, 0, 1).Unix()
+		if to == QuotaDataGranularityMonth {
+			end = time.Unix(start, 0).AddDate(0, 1, 0).Unix()
+		}
+		err = LOG_DB.Transaction(func(tx *gorm.DB) error {
+			var rows []*QuotaData
+			if err := tx.Where("granularity = ? and created_at >= ? and created_at < ?", from, start, end).Find(&rows).Error; err != nil {
+				return err
+			}
+			merged := make(map[string]*QuotaData)
+			for _, row := range rows {
+				row.Id = 0
+				row.Granularity = to
+				row.CreatedAt = start
+				key := row.dimensionKey()
+				if existing, ok := merged[key]; ok {
+					existing.add(row)
+				} else {
+					merged[key] = row
+				}
+			}
+			for _, quotaData := range merged {
+				if err := mergeQuotaData(tx, quotaData); err != nil {
+					return err
+				}
+			}
+			return tx.Where("granularity = ? and created_at >= ? and created_at < ?", from, start, end).Delete(&QuotaData{}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
 }
 
-func GetQuotaDataByUserId(userId int, startTime int64, endTime int64) (quotaData []*QuotaData, err error) {
-	var quotaDatas []*QuotaData
-	// 从quota_data表中查询数据
-	err = DB.Table("quota_data").Where("user_id = ? and created_at >= ? and created_at <= ?", userId, startTime, endTime).Find(&quotaDatas).Error
-	return quotaDatas, err
+// DownsampleQuotaData 按保留策略合并过期的小时与天数据，并删除过期的月数据
+func DownsampleQuotaData() error {
+	now := time.Now()
+	if common.DataExportHourRetentionDays > 0 {
+		if err := downsampleQuotaData(QuotaDataGranularityHour, QuotaDataGranularityDay, now.AddDate(0, 0, -common.DataExportHourRetentionDays).Unix()); err != nil {
+			return err
+		}
+	}
+	if common.DataExportDayRetentionDays > 0 {
+		if err := downsampleQuotaData(QuotaDataGranularityDay, QuotaDataGranularityMonth, now.AddDate(0, 0, -common.DataExportDayRetentionDays).Unix()); err != nil {
+			return err
+		}
+	}
+	if common.DataExportMonthRetentionMonths > 0 {
+		before := quotaDataBucket(now.AddDate(0, -common.DataExportMonthRetentionMonths, 0).Unix(), QuotaDataGranularityMonth)
+		if err := LOG_DB.Where("granularity = ? and created_at < ?", QuotaDataGranularityMonth, before).Delete(&QuotaData{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func GetAllQuotaDates(startTime int64, endTime int64, username string) (quotaData []*QuotaData, err error) {
-	if username != "" {
-		return GetQuotaDataByUsername(username, startTime, endTime)
+func AutomaticallyDownsampleQuotaData() {
+	for {
+		if common.DataExportEnabled {
+			if err := DownsampleQuotaData(); err != nil {
+				common.SysError("failed to downsample quota data: " + err.Error())
+			}
+		}
+		time.Sleep(time.Hour)
 	}
-	var quotaDatas []*QuotaData
-	// 从quota_data表中查询数据
-	// only select model_name, sum(count) as count, sum(quota) as quota, model_name, created_at from quota_data group by model_name, created_at;
-	//err = DB.Table("quota_data").Where("created_at >= ? and created_at <= ?", startTime, endTime).Find(&quotaDatas).Error
-	err = DB.Table("quota_data").Select("model_name, sum(count) as count, sum(quota) as quota, created_at").Where("created_at >= ? and created_at <= ?", startTime, endTime).Group("model_name, created_at").Find(&quotaDatas).Error
-	return quotaDatas, err
 }
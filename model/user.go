@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"one-api/common"
 	"strconv"
-	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -24,8 +23,10 @@ type User struct {
 	GitHubId         string         `json:"github_id" gorm:"column:github_id;index"`
 	WeChatId         string         `json:"wechat_id" gorm:"column:wechat_id;index"`
 	TelegramId       string         `json:"telegram_id" gorm:"column:telegram_id;index"`
+	OidcId           string         `json:"oidc_id" gorm:"column:oidc_id;index"`
+	LdapId           string         `json:"ldap_id" gorm:"column:ldap_id;index"`
 	VerificationCode string         `json:"verification_code" gorm:"-:all"`                                    // this field is only for Email verification, don't save it to database!
-	AccessToken      string         `json:"access_token" gorm:"type:char(32);column:access_token;uniqueIndex"` // this token is for system management
+	AccessToken      string         `json:"access_token" gorm:"type:char(32);column:access_token;uniqueIndex"` // 已迁移为 AccessToken 管理令牌，仅保留占位值
 	Quota            int            `json:"quota" gorm:"type:int;default:0"`
 	UsedQuota        int            `json:"used_quota" gorm:"type:int;default:0;column:used_quota"` // used quota
 	RequestCount     int            `json:"request_count" gorm:"type:int;default:0;"`               // request number
@@ -35,6 +36,10 @@ type User struct {
 	AffQuota         int            `json:"aff_quota" gorm:"type:int;default:0;column:aff_quota"`           // 邀请剩余额度
 	AffHistoryQuota  int            `json:"aff_history_quota" gorm:"type:int;default:0;column:aff_history"` // 邀请历史额度
 	InviterId        int            `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	ParentId         int            `json:"parent_id" gorm:"type:int;default:0;index"` // 上级代理
+	MarkupRatio      float64        `json:"markup_ratio" gorm:"default:1"`             // 代理对下级的加价倍率
+	RoleId           int            `json:"role_id" gorm:"type:int;default:0;index"`   // 0 表示使用权限等级对应的内置角色
+	CreatedTime      int64          `json:"created_time" gorm:"bigint;default:0"`      // 注册时间，早期用户为 0
 	DeletedAt        gorm.DeletedAt `gorm:"index"`
 }
 
@@ -187,6 +192,9 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 	if err := tx.Save(user).Error; err != nil {
 		return err
 	}
+	if err := recordQuotaLedgerTx(tx, user.Id, quota, LedgerReasonAffTransfer, ""); err != nil {
+		return err
+	}
 
 	// 提交事务
 	return tx.Commit().Error
@@ -201,18 +209,31 @@ func (user *User) Insert(inviterId int) error {
 		}
 	}
 	user.Quota = common.QuotaForNewUser
-	user.AccessToken = common.GetUUID()
+	if user.ParentId != 0 {
+		// 代理的下级用户额度由代理从自己的余额中分配
+		user.Quota = 0
+	}
+	user.AccessToken = accessTokenPlaceholder()
 	user.AffCode = common.GetRandomString(4)
-	result := DB.Create(user)
-	if result.Error != nil {
-		return result.Error
+	user.CreatedTime = common.GetTimestamp()
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if err := recordPasswordHistory(tx, user.Id, user.Password); err != nil {
+			return err
+		}
+		return recordQuotaLedgerTx(tx, user.Id, user.Quota, LedgerReasonRegister, "")
+	})
+	if err != nil {
+		return err
 	}
-	if common.QuotaForNewUser > 0 {
+	if user.Quota > 0 {
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(common.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee)
+			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, LedgerReasonInvitee, strconv.Itoa(inviterId))
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
@@ -235,10 +256,16 @@ func (user *User) Update(updatePassword bool) error {
 	newUser := *user
 	DB.First(&user, user.Id)
 	err = DB.Model(user).Updates(newUser).Error
+	if err == nil && updatePassword {
+		err = recordPasswordHistory(DB, user.Id, newUser.Password)
+	}
 	if err == nil {
 		if common.RedisEnabled {
 			_ = common.RedisSet(fmt.Sprintf("user_group:%d", user.Id), user.Group, time.Duration(UserId2GroupCacheSeconds)*time.Second)
 			_ = common.RedisSet(fmt.Sprintf("user_quota:%d", user.Id), strconv.Itoa(user.Quota), time.Duration(UserId2QuotaCacheSeconds)*time.Second)
+			// 代理被禁用或上级变更后，下级的转发请求立即按新的代理链校验
+			_ = common.RedisSet(fmt.Sprintf("user_parent:%d", user.Id), strconv.Itoa(user.ParentId), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+			_ = common.RedisDel(fmt.Sprintf("user_enabled:%d", user.Id))
 		}
 	}
 	return err
@@ -262,8 +289,25 @@ func (user *User) Edit(updatePassword bool) error {
 	if updatePassword {
 		updates["password"] = newUser.Password
 	}
-	DB.First(&user, user.Id)
-	err = DB.Model(user).Updates(updates).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err := lockForUpdate(tx).First(&user, user.Id).Error
+		if err != nil {
+			return err
+		}
+		delta := newUser.Quota - user.Quota
+		if err = tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if updatePassword {
+			if err = recordPasswordHistory(tx, user.Id, newUser.Password); err != nil {
+				return err
+			}
+		}
+		if delta != 0 {
+			return recordQuotaLedgerTx(tx, user.Id, delta, LedgerReasonAdjust, "")
+		}
+		return nil
+	})
 	if err == nil {
 		if common.RedisEnabled {
 			_ = common.RedisSet(fmt.Sprintf("user_group:%d", user.Id), user.Group, time.Duration(UserId2GroupCacheSeconds)*time.Second)
@@ -330,6 +374,22 @@ func (user *User) FillUserByGitHubId() error {
 	return nil
 }
 
+func (user *User) FillUserByOidcId() error {
+	if user.OidcId == "" {
+		return errors.New("OIDC id 为空！")
+	}
+	DB.Where(User{OidcId: user.OidcId}).First(user)
+	return nil
+}
+
+func (user *User) FillUserByLdapId() error {
+	if user.LdapId == "" {
+		return errors.New("LDAP id 为空！")
+	}
+	DB.Where(User{LdapId: user.LdapId}).First(user)
+	return nil
+}
+
 func (user *User) FillUserByWeChatId() error {
 	if user.WeChatId == "" {
 		return errors.New("WeChat id 为空！")
@@ -369,6 +429,14 @@ func IsGitHubIdAlreadyTaken(githubId string) bool {
 	return DB.Where("github_id = ?", githubId).Find(&User{}).RowsAffected == 1
 }
 
+func IsOidcIdAlreadyTaken(oidcId string) bool {
+	return DB.Where("oidc_id = ?", oidcId).Find(&User{}).RowsAffected == 1
+}
+
+func IsLdapIdAlreadyTaken(ldapId string) bool {
+	return DB.Where("ldap_id = ?", ldapId).Find(&User{}).RowsAffected == 1
+}
+
 func IsUsernameAlreadyTaken(username string) bool {
 	return DB.Where("username = ?", username).Find(&User{}).RowsAffected == 1
 }
@@ -385,8 +453,22 @@ func ResetUserPasswordByEmail(email string, password string) error {
 	if err != nil {
 		return err
 	}
+	var userIds []int
+	if err = DB.Model(&User{}).Where("email = ?", email).Pluck("id", &userIds).Error; err != nil {
+		return err
+	}
 	err = DB.Model(&User{}).Where("email = ?", email).Update("password", hashedPassword).Error
-	return err
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		if err = recordPasswordHistory(DB, userId, hashedPassword); err != nil {
+			return err
+		}
+	}
+	// 重置密码后强制该用户的所有登录会话下线
+	return DB.Where("user_id in (?)", DB.Model(&User{}).Select("id").Where("email = ?", email)).
+		Delete(&UserSession{}).Error
 }
 
 func IsAdmin(userId int) bool {
@@ -414,18 +496,6 @@ func IsUserEnabled(userId int) (bool, error) {
 	return user.Status == common.UserStatusEnabled, nil
 }
 
-func ValidateAccessToken(token string) (user *User) {
-	if token == "" {
-		return nil
-	}
-	token = strings.Replace(token, "Bearer ", "", 1)
-	user = &User{}
-	if DB.Where("access_token = ?", token).First(user).RowsAffected == 1 {
-		return user
-	}
-	return nil
-}
-
 func GetUserQuota(id int) (quota int, err error) {
 	err = DB.Model(&User{}).Where("id = ?", id).Select("quota").Find(&quota).Error
 	if err != nil {
@@ -456,15 +526,16 @@ func GetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
-func IncreaseUserQuota(id int, quota int) (err error) {
+// IncreaseUserQuota 增加用户额度，reason 与 reference 记入额度流水
+func IncreaseUserQuota(id int, quota int, reason string, reference string) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
+		addBatchQuotaRecord(id, quota, reason, reference)
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	return changeUserQuota(id, quota, reason, reference)
 }
 
 func increaseUserQuota(id int, quota int) (err error) {
@@ -472,20 +543,16 @@ func increaseUserQuota(id int, quota int) (err error) {
 	return err
 }
 
-func DecreaseUserQuota(id int, quota int) (err error) {
+// DecreaseUserQuota 扣减用户额度，reason 与 reference 记入额度流水
+func DecreaseUserQuota(id int, quota int, reason string, reference string) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
+		addBatchQuotaRecord(id, -quota, reason, reference)
 		return nil
 	}
-	return decreaseUserQuota(id, quota)
-}
-
-func decreaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
-	return err
+	return changeUserQuota(id, -quota, reason, reference)
 }
 
 func GetRootUserEmail() (email string) {
@@ -50,6 +50,10 @@ func batchUpdate() {
 		batchUpdateLocks[i].Lock()
 		store := batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int)
+		var ledgers map[int][]*QuotaLedger
+		if i == BatchUpdateTypeUserQuota {
+			ledgers = takeBatchLedgers()
+		}
 		batchUpdateLocks[i].Unlock()
 		// TODO: maybe we can combine updates with same key?
 		for key, value := range store {
@@ -58,6 +62,9 @@ func batchUpdate() {
 				err := increaseUserQuota(key, value)
 				if err != nil {
 					common.SysError("failed to batch update user quota: " + err.Error())
+				} else {
+					recordBatchLedgers(key, ledgers[key])
+					recordBatchSubscriptionUsage(key, ledgers[key])
 				}
 			case BatchUpdateTypeTokenQuota:
 				err := increaseTokenQuota(key, value)
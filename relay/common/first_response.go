@@ -0,0 +1,38 @@
+package common
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// firstResponseWriter 记录第一次向客户端写出响应的时间，用于统计首字时间
+type firstResponseWriter struct {
+	gin.ResponseWriter
+	info *RelayInfo
+}
+
+func (w *firstResponseWriter) markFirstResponse() {
+	if w.info.FirstResponseTime.IsZero() {
+		w.info.FirstResponseTime = time.Now()
+	}
+}
+
+func (w *firstResponseWriter) Write(data []byte) (int, error) {
+	w.markFirstResponse()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *firstResponseWriter) WriteString(s string) (int, error) {
+	w.markFirstResponse()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// TrackFirstResponse 替换 c.Writer 以记录首字时间，返回的函数用于恢复原来的 Writer
+func (info *RelayInfo) TrackFirstResponse(c *gin.Context) func() {
+	writer := c.Writer
+	c.Writer = &firstResponseWriter{ResponseWriter: writer, info: info}
+	return func() {
+		c.Writer = writer
+	}
+}
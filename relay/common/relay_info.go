@@ -13,9 +13,11 @@ type RelayInfo struct {
 	ChannelId         int
 	TokenId           int
 	UserId            int
+	OrgId             int
 	Group             string
 	TokenUnlimited    bool
 	StartTime         time.Time
+	FirstResponseTime time.Time
 	ApiType           int
 	IsStream          bool
 	RelayMode         int
@@ -48,6 +50,7 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		ChannelId:      channelId,
 		TokenId:        tokenId,
 		UserId:         userId,
+		OrgId:          c.GetInt("org_id"),
 		Group:          group,
 		TokenUnlimited: tokenUnlimited,
 		StartTime:      startTime,
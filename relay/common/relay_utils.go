@@ -66,3 +66,12 @@ func GetAPIVersion(c *gin.Context) string {
 	}
 	return apiVersion
 }
+
+// GetGroupRatio 返回分组倍率与上级代理加价倍率的乘积
+func GetGroupRatio(c *gin.Context, group string) float64 {
+	groupRatio := common.GetGroupRatio(group)
+	if markupRatio := c.GetFloat64("markup_ratio"); markupRatio > 1 {
+		groupRatio *= markupRatio
+	}
+	return groupRatio
+}
@@ -67,18 +67,18 @@ func AudioHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 		}
 		preConsumedTokens = promptTokens
 	}
-	modelRatio := common.GetModelRatio(audioRequest.Model)
-	groupRatio := common.GetGroupRatio(group)
+	modelRatio := common.GetTokenPrice(audioRequest.Model, preConsumedTokens).ModelRatio
+	groupRatio := relaycommon.GetGroupRatio(c, group)
 	ratio := modelRatio * groupRatio
 	preConsumedQuota := int(float64(preConsumedTokens) * ratio)
-	userQuota, err := model.CacheGetUserQuota(userId)
+	userQuota, err := model.CacheGetPayerQuota(userId, c.GetInt("org_id"))
 	if err != nil {
 		return service.OpenAIErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
 	if userQuota-preConsumedQuota < 0 {
 		return service.OpenAIErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(userId, preConsumedQuota)
+	err = model.CacheDecreasePayerQuota(userId, c.GetInt("org_id"), preConsumedQuota)
 	if err != nil {
 		return service.OpenAIErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -88,7 +88,7 @@ func AudioHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 		preConsumedQuota = 0
 	}
 	if preConsumedQuota > 0 {
-		userQuota, err = model.PreConsumeTokenQuota(tokenId, preConsumedQuota)
+		userQuota, err = model.PreConsumeTokenQuota(tokenId, preConsumedQuota, c.GetString(common.RequestIdKey))
 		if err != nil {
 			return service.OpenAIErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
@@ -101,12 +101,7 @@ func AudioHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 		}
 		if preConsumedQuota > 0 {
 			// we need to roll back the pre-consumed quota
-			defer func() {
-				go func() {
-					// negative means add quota back for token & user
-					returnPreConsumedQuota(c, tokenId, userQuota, preConsumedQuota)
-				}()
-			}()
+			returnPreConsumedQuota(c, tokenId, userQuota, preConsumedQuota)
 		}
 	}()
 
@@ -178,20 +173,25 @@ func AudioHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 	var audioResponse dto.AudioResponse
 
 	defer func(ctx context.Context) {
+		// 语音合成按输入字符计费，语音识别按转写文本计费并视为音频输入 token
+		var usage common.TokenUsage
+		if strings.HasPrefix(audioRequest.Model, "tts-1") {
+			usage.PromptTokens = promptTokens
+		} else {
+			usage.PromptTokens, _ = service.CountAudioToken(audioResponse.Text, audioRequest.Model)
+			usage.AudioInputTokens = usage.PromptTokens
+		}
+		service.RecordChannelSuccess(c, channelId, startTime, time.Time{}, usage.PromptTokens, 0)
+		// gin 会复用请求上下文，异步结算用到的请求信息需要在处理函数返回前读取
+		requestId := c.GetString(common.RequestIdKey)
+		tokenName := c.GetString("token_name")
+		orgId := c.GetInt("org_id")
 		go func() {
 			useTimeSeconds := time.Now().Unix() - startTime.Unix()
-			quota := 0
-			if strings.HasPrefix(audioRequest.Model, "tts-1") {
-				quota = promptTokens
-			} else {
-				quota, err = service.CountAudioToken(audioResponse.Text, audioRequest.Model)
-			}
-			quota = int(float64(quota) * ratio)
-			if ratio != 0 && quota <= 0 {
-				quota = 1
-			}
+			quota, tokenPrice := common.CalculateTokenQuota(audioRequest.Model, usage, groupRatio)
+			modelRatio := tokenPrice.ModelRatio
 			quotaDelta := quota - preConsumedQuota
-			err := model.PostConsumeTokenQuota(tokenId, userQuota, quotaDelta, preConsumedQuota, true)
+			err := model.PostConsumeTokenQuota(tokenId, userQuota, quotaDelta, preConsumedQuota, true, requestId)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
@@ -200,14 +200,15 @@ func AudioHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 				common.SysError("error update user quota cache: " + err.Error())
 			}
 			if quota != 0 {
-				tokenName := c.GetString("token_name")
 				logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
 				other := make(map[string]interface{})
 				other["model_ratio"] = modelRatio
 				other["group_ratio"] = groupRatio
-				model.RecordConsumeLog(ctx, userId, channelId, promptTokens, 0, audioRequest.Model, tokenName, quota, logContent, tokenId, userQuota, int(useTimeSeconds), false, other)
+				if usage.AudioInputTokens > 0 {
+					other["audio_input_ratio"] = tokenPrice.AudioInputRatio
+				}
+				model.RecordConsumeLog(ctx, userId, channelId, usage.PromptTokens, 0, audioRequest.Model, tokenName, quota, logContent, tokenId, orgId, userQuota, int(useTimeSeconds), false, other)
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
-				channelId := c.GetInt("channel_id")
 				model.UpdateChannelUsedQuota(channelId, quota)
 			}
 		}()
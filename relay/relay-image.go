@@ -121,28 +121,11 @@ func RelayImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusC
 		// per 1 modelRatio = $0.04 / 16
 		modelPrice = 0.0025 * modelRatio
 	}
-	groupRatio := common.GetGroupRatio(group)
-	userQuota, err := model.CacheGetUserQuota(userId)
-
-	sizeRatio := 1.0
-	// Size
-	if imageRequest.Size == "256x256" {
-		sizeRatio = 0.4
-	} else if imageRequest.Size == "512x512" {
-		sizeRatio = 0.45
-	} else if imageRequest.Size == "1024x1024" {
-		sizeRatio = 1
-	} else if imageRequest.Size == "1024x1792" || imageRequest.Size == "1792x1024" {
-		sizeRatio = 2
-	}
-
-	qualityRatio := 1.0
-	if imageRequest.Model == "dall-e-3" && imageRequest.Quality == "hd" {
-		qualityRatio = 2.0
-		if imageRequest.Size == "1024x1792" || imageRequest.Size == "1792x1024" {
-			qualityRatio = 1.5
-		}
-	}
+	groupRatio := relaycommon.GetGroupRatio(c, group)
+	userQuota, err := model.CacheGetPayerQuota(userId, c.GetInt("org_id"))
+
+	// 价格结构中配置了尺寸、品质倍率时优先使用
+	sizeRatio, qualityRatio := common.GetImagePriceRatio(imageRequest.Model, imageRequest.Size, imageRequest.Quality)
 
 	quota := int(modelPrice*groupRatio*common.QuotaPerUnit*sizeRatio*qualityRatio) * imageRequest.N
 
@@ -184,12 +167,13 @@ func RelayImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusC
 	}
 
 	var textResponse dto.ImageResponse
+	requestId := c.GetString(common.RequestIdKey)
 	defer func(ctx context.Context) {
 		useTimeSeconds := time.Now().Unix() - startTime.Unix()
 		if resp.StatusCode != http.StatusOK {
 			return
 		}
-		err := model.PostConsumeTokenQuota(tokenId, userQuota, quota, 0, true)
+		err := model.PostConsumeTokenQuota(tokenId, userQuota, quota, 0, true, requestId)
 		if err != nil {
 			common.SysError("error consuming token remain quota: " + err.Error())
 		}
@@ -207,11 +191,14 @@ func RelayImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusC
 			other := make(map[string]interface{})
 			other["model_price"] = modelPrice
 			other["group_ratio"] = groupRatio
-			model.RecordConsumeLog(ctx, userId, channelId, 0, 0, imageRequest.Model, tokenName, quota, logContent, tokenId, userQuota, int(useTimeSeconds), false, other)
+			other["size_ratio"] = sizeRatio
+			other["quality_ratio"] = qualityRatio
+			model.RecordConsumeLog(ctx, userId, channelId, 0, 0, imageRequest.Model, tokenName, quota, logContent, tokenId, c.GetInt("org_id"), userQuota, int(useTimeSeconds), false, other)
 			model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
 			channelId := c.GetInt("channel_id")
 			model.UpdateChannelUsedQuota(channelId, quota)
 		}
+		service.RecordChannelSuccess(c, channelId, startTime, time.Time{}, 0, 0)
 	}(c.Request.Context())
 
 	responseBody, err := io.ReadAll(resp.Body)
@@ -2,7 +2,6 @@ package relay
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -67,6 +66,7 @@ func getAndValidateTextRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo)
 func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 
 	relayInfo := relaycommon.GenRelayInfo(c)
+	defer relayInfo.TrackFirstResponse(c)()
 
 	// get & validate textRequest 获取并验证文本请求
 	textRequest, err := getAndValidateTextRequest(c, relayInfo)
@@ -92,7 +92,7 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	}
 	relayInfo.UpstreamModelName = textRequest.Model
 	modelPrice, success := common.GetModelPrice(textRequest.Model, false)
-	groupRatio := common.GetGroupRatio(relayInfo.Group)
+	groupRatio := relaycommon.GetGroupRatio(c, relayInfo.Group)
 
 	var preConsumedQuota int
 	var ratio float64
@@ -106,6 +106,30 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		}
 	}
 
+	if setting, ok := constant.GetModerationSetting(relayInfo.Group); ok && relayInfo.RelayMode != relayconstant.RelayModeModerations {
+		openaiErr := checkRequestModeration(c, textRequest, relayInfo, setting)
+		if openaiErr != nil {
+			return openaiErr
+		}
+	}
+
+	isRequestRedacted := false
+	if constant.ShouldRedactPII(relayInfo.Group, relayInfo.ChannelId) {
+		redactor := service.NewPIIRedactor(constant.PIIRedactionMode)
+		isRequestRedacted, err = redactor.RedactRequest(textRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "pii_detected", http.StatusBadRequest)
+		}
+		if redactor.HasPlaceholders() {
+			// 在响应中还原占位符
+			originWriter := c.Writer
+			c.Writer = service.NewPIIRestoreWriter(originWriter, redactor.NewRestorer())
+			defer func() {
+				c.Writer = originWriter
+			}()
+		}
+	}
+
 	promptTokens, err := getPromptTokens(textRequest, relayInfo)
 	// count messages token error 计算promptTokens错误
 	if err != nil {
@@ -117,7 +141,8 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		if textRequest.MaxTokens != 0 {
 			preConsumedTokens = promptTokens + int(textRequest.MaxTokens)
 		}
-		modelRatio = common.GetModelRatio(textRequest.Model)
+		// 按提示词长度命中的档位预扣费
+		modelRatio = common.GetTokenPrice(textRequest.Model, promptTokens).ModelRatio
 		ratio = modelRatio * groupRatio
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	} else {
@@ -137,7 +162,7 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	adaptor.Init(relayInfo, *textRequest)
 	var requestBody io.Reader
 	if relayInfo.ApiType == relayconstant.APITypeOpenAI {
-		if isModelMapped {
+		if isModelMapped || isRequestRedacted {
 			jsonStr, err := json.Marshal(textRequest)
 			if err != nil {
 				return service.OpenAIErrorWrapperLocal(err, "marshal_text_request_failed", http.StatusInternalServerError)
@@ -221,16 +246,120 @@ func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycom
 	return err
 }
 
+func checkRequestModeration(c *gin.Context, textRequest *dto.GeneralOpenAIRequest, info *relaycommon.RelayInfo, setting constant.ModerationGroupSetting) *dto.OpenAIErrorWithStatusCode {
+	var texts []string
+	switch info.RelayMode {
+	case relayconstant.RelayModeChatCompletions:
+		for _, message := range textRequest.Messages {
+			for _, m := range message.ParseContent() {
+				if m.Type == dto.ContentTypeText {
+					texts = append(texts, m.Text)
+				}
+			}
+		}
+	case relayconstant.RelayModeCompletions:
+		texts = append(texts, fmt.Sprintf("%v", textRequest.Prompt))
+	case relayconstant.RelayModeEmbeddings:
+		texts = append(texts, textRequest.ParseInput()...)
+	}
+	result, err := service.ModerateText(strings.Join(texts, "\n"), setting, channelModerationSender(c, info.Group))
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("moderation failed: %s", err.Error()))
+		if setting.ShouldFailOpen() {
+			return nil
+		}
+		return service.OpenAIErrorWrapperLocal(errors.New("moderation service unavailable"), "content_moderation_unavailable", http.StatusServiceUnavailable)
+	}
+	if !result.Flagged {
+		return nil
+	}
+	if setting.Action == constant.ModerationActionFlag {
+		common.LogWarn(c, fmt.Sprintf("moderation flagged, user %d, categories: %s", info.UserId, strings.Join(result.Categories, ",")))
+		c.Set("moderation_flagged", result.Categories)
+		return nil
+	}
+	return service.OpenAIErrorWrapperLocal(errors.New("content flagged by moderation: "+strings.Join(result.Categories, ",")), "content_moderation_flagged", http.StatusBadRequest)
+}
+
+// channelModerationSender 通过分组内支持审核模型的渠道发送审核请求，与正常转发一样由渠道适配器生成请求地址与请求头
+func channelModerationSender(c *gin.Context, group string) service.ModerationSender {
+	return func(request *dto.ModerationRequest) (*http.Response, error) {
+		channel, err := model.CacheGetRandomSatisfiedChannel(group, request.Model, 0)
+		if err != nil {
+			return nil, err
+		}
+		if channel == nil {
+			return nil, fmt.Errorf("no available channel for moderation model %s", request.Model)
+		}
+		if modelMapping := channel.GetModelMapping(); modelMapping != "" && modelMapping != "{}" {
+			modelMap := make(map[string]string)
+			if err = json.Unmarshal([]byte(modelMapping), &modelMap); err != nil {
+				return nil, err
+			}
+			if modelMap[request.Model] != "" {
+				request.Model = modelMap[request.Model]
+			}
+		}
+		apiType, _ := relayconstant.ChannelType2APIType(channel.Type)
+		adaptor := GetAdaptor(apiType)
+		if adaptor == nil {
+			return nil, fmt.Errorf("invalid api type: %d", apiType)
+		}
+		info := &relaycommon.RelayInfo{
+			ChannelType:       channel.Type,
+			ChannelId:         channel.Id,
+			Group:             group,
+			StartTime:         time.Now(),
+			ApiType:           apiType,
+			RelayMode:         relayconstant.RelayModeModerations,
+			UpstreamModelName: request.Model,
+			RequestURLPath:    "/v1/moderations",
+			ApiKey:            channel.Key,
+			BaseUrl:           channel.GetBaseURL(),
+		}
+		if info.BaseUrl == "" {
+			info.BaseUrl = common.ChannelBaseURLs[channel.Type]
+		}
+		if channel.OpenAIOrganization != nil {
+			info.Organization = *channel.OpenAIOrganization
+		}
+		switch channel.Type {
+		case common.ChannelTypeAzure, common.ChannelTypeXunfei, common.ChannelTypeGemini:
+			info.ApiVersion = channel.Other
+		}
+		adaptor.Init(info, dto.GeneralOpenAIRequest{Model: request.Model})
+		fullRequestURL, err := adaptor.GetRequestURL(info)
+		if err != nil {
+			return nil, err
+		}
+		jsonData, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPost, fullRequestURL, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		if err = adaptor.SetupRequestHeader(c, req, info); err != nil {
+			return nil, err
+		}
+		// 适配器会复制客户端请求的 Content-Type 与 Accept，审核请求始终为非流式 JSON
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		return service.GetHttpClient().Do(req)
+	}
+}
+
 // 预扣费并返回用户剩余配额
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *dto.OpenAIErrorWithStatusCode) {
-	userQuota, err := model.CacheGetUserQuota(relayInfo.UserId)
+	userQuota, err := model.CacheGetPayerQuota(relayInfo.UserId, relayInfo.OrgId)
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
 	if userQuota <= 0 || userQuota-preConsumedQuota < 0 {
 		return 0, 0, service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
+	err = model.CacheDecreasePayerQuota(relayInfo.UserId, relayInfo.OrgId, preConsumedQuota)
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -252,7 +381,7 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 		}
 	}
 	if preConsumedQuota > 0 {
-		userQuota, err = model.PreConsumeTokenQuota(relayInfo.TokenId, preConsumedQuota)
+		userQuota, err = model.PreConsumeTokenQuota(relayInfo.TokenId, preConsumedQuota, c.GetString(common.RequestIdKey))
 		if err != nil {
 			return 0, 0, service.OpenAIErrorWrapperLocal(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
@@ -262,13 +391,15 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 
 func returnPreConsumedQuota(c *gin.Context, tokenId int, userQuota int, preConsumedQuota int) {
 	if preConsumedQuota != 0 {
-		go func(ctx context.Context) {
+		// gin 会复用请求上下文，请求 ID 需要在启动协程前读取
+		requestId := c.GetString(common.RequestIdKey)
+		go func() {
 			// return pre-consumed quota
-			err := model.PostConsumeTokenQuota(tokenId, userQuota, -preConsumedQuota, 0, false)
+			err := model.PostConsumeTokenQuota(tokenId, userQuota, -preConsumedQuota, 0, false, requestId)
 			if err != nil {
 				common.SysError("error return pre-consumed quota: " + err.Error())
 			}
-		}(c)
+		}()
 	}
 }
 
@@ -284,12 +415,11 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, textRe
 	completionRatio := common.GetCompletionRatio(textRequest.Model)
 
 	quota := 0
+	var tokenPrice common.TokenPrice
 	if !usePrice {
-		quota = promptTokens + int(math.Round(float64(completionTokens)*completionRatio))
-		quota = int(math.Round(float64(quota) * ratio))
-		if ratio != 0 && quota <= 0 {
-			quota = 1
-		}
+		quota, tokenPrice = common.CalculateTokenQuota(textRequest.Model, usage.TokenUsage(), groupRatio)
+		modelRatio = tokenPrice.ModelRatio
+		completionRatio = tokenPrice.CompletionRatio
 	} else {
 		quota = int(modelPrice * common.QuotaPerUnit * groupRatio)
 	}
@@ -297,6 +427,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, textRe
 	var logContent string
 	if modelPrice == -1 {
 		logContent = fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f，补全倍率 %.2f", modelRatio, groupRatio, completionRatio)
+		logContent += pricingDetailLogContent(usage, tokenPrice)
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
@@ -314,7 +445,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, textRe
 		//}
 		quotaDelta := quota - preConsumedQuota
 		if quotaDelta != 0 {
-			err := model.PostConsumeTokenQuota(relayInfo.TokenId, userQuota, quotaDelta, preConsumedQuota, true)
+			err := model.PostConsumeTokenQuota(relayInfo.TokenId, userQuota, quotaDelta, preConsumedQuota, true, ctx.GetString(common.RequestIdKey))
 			if err != nil {
 				common.LogError(ctx, "error consuming token remain quota: "+err.Error())
 			}
@@ -337,12 +468,58 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, textRe
 	other["group_ratio"] = groupRatio
 	other["completion_ratio"] = completionRatio
 	other["model_price"] = modelPrice
+	if !usePrice {
+		if tokenPrice.Tier >= 0 {
+			other["price_tier"] = tokenPrice.Tier
+		}
+		if usage.PromptTokensDetails != nil {
+			other["cached_tokens"] = usage.PromptTokensDetails.CachedTokens
+			other["audio_input_tokens"] = usage.PromptTokensDetails.AudioTokens
+			other["cache_ratio"] = tokenPrice.CacheRatio
+			other["audio_input_ratio"] = tokenPrice.AudioInputRatio
+		}
+		if usage.CompletionTokensDetails != nil {
+			other["reasoning_tokens"] = usage.CompletionTokensDetails.ReasoningTokens
+			other["audio_output_tokens"] = usage.CompletionTokensDetails.AudioTokens
+			other["reasoning_ratio"] = tokenPrice.ReasoningRatio
+			other["audio_output_ratio"] = tokenPrice.AudioOutputRatio
+		}
+	}
+	if categories := ctx.GetStringSlice("moderation_flagged"); len(categories) > 0 {
+		other["moderation_flagged"] = categories
+	}
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	other["admin_info"] = adminInfo
-	model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, promptTokens, completionTokens, logModel, tokenName, quota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, other)
+	model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, promptTokens, completionTokens, logModel, tokenName, quota, logContent, relayInfo.TokenId, relayInfo.OrgId, userQuota, int(useTimeSeconds), relayInfo.IsStream, other)
+	service.RecordChannelSuccess(ctx, relayInfo.ChannelId, relayInfo.StartTime, relayInfo.FirstResponseTime, promptTokens, completionTokens)
 
 	//if quota != 0 {
 	//
 	//}
 }
+
+// pricingDetailLogContent 记录缓存、音频、推理等明细的计费倍率
+func pricingDetailLogContent(usage *dto.Usage, price common.TokenPrice) string {
+	content := ""
+	if price.Tier >= 0 {
+		content += fmt.Sprintf("，上下文档位 %d", price.Tier+1)
+	}
+	if details := usage.PromptTokensDetails; details != nil {
+		if details.CachedTokens > 0 {
+			content += fmt.Sprintf("，缓存 %d tokens 倍率 %.2f", details.CachedTokens, price.CacheRatio)
+		}
+		if details.AudioTokens > 0 {
+			content += fmt.Sprintf("，音频输入 %d tokens 倍率 %.2f", details.AudioTokens, price.AudioInputRatio)
+		}
+	}
+	if details := usage.CompletionTokensDetails; details != nil {
+		if details.ReasoningTokens > 0 {
+			content += fmt.Sprintf("，推理 %d tokens 倍率 %.2f", details.ReasoningTokens, price.ReasoningRatio)
+		}
+		if details.AudioTokens > 0 {
+			content += fmt.Sprintf("，音频输出 %d tokens 倍率 %.2f", details.AudioTokens, price.AudioOutputRatio)
+		}
+	}
+	return content
+}
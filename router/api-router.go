@@ -1,6 +1,7 @@
 package router
 
 import (
+	"one-api/common"
 	"one-api/controller"
 	"one-api/middleware"
 
@@ -15,7 +16,7 @@ func SetApiRouter(router *gin.Engine) {
 	{
 		apiRouter.GET("/status", controller.GetStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
-		apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
+		apiRouter.GET("/status/test", middleware.PermissionAuth(common.PermissionStatusTest), controller.TestStatus)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/about", controller.GetAbout)
 		//apiRouter.GET("/midjourney", controller.GetMidjourney)
@@ -26,6 +27,8 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.POST("/user/reset", middleware.CriticalRateLimit(), controller.ResetPassword)
 		apiRouter.GET("/oauth/github", middleware.CriticalRateLimit(), controller.GitHubOAuth)
 		apiRouter.GET("/oauth/state", middleware.CriticalRateLimit(), controller.GenerateOAuthCode)
+		apiRouter.GET("/oauth/oidc", middleware.CriticalRateLimit(), controller.OIDCOAuth)
+		apiRouter.GET("/oauth/oidc/authorize", middleware.CriticalRateLimit(), controller.OIDCAuthorize)
 		apiRouter.GET("/oauth/wechat", middleware.CriticalRateLimit(), controller.WeChatAuth)
 		apiRouter.GET("/oauth/wechat/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), controller.WeChatBind)
 		apiRouter.GET("/oauth/email/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), controller.EmailBind)
@@ -36,6 +39,8 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			userRoute.POST("/register", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Register)
 			userRoute.POST("/login", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Login)
+			userRoute.POST("/login/2fa", middleware.CriticalRateLimit(), controller.LoginTwoFA)
+			userRoute.POST("/login/ldap", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.LDAPLogin)
 			//userRoute.POST("/tokenlog", middleware.CriticalRateLimit(), controller.TokenLog)
 			userRoute.GET("/logout", controller.Logout)
 			userRoute.GET("/epay/notify", controller.EpayNotify)
@@ -47,54 +52,73 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/models", controller.GetUserModels)
 				selfRoute.PUT("/self", controller.UpdateSelf)
 				selfRoute.DELETE("/self", controller.DeleteSelf)
-				selfRoute.GET("/token", controller.GenerateAccessToken)
+				selfRoute.GET("/token", middleware.RecentTwoFA(), controller.GenerateAccessToken)
+				selfRoute.GET("/self/sessions", controller.GetSessions)
+				selfRoute.DELETE("/self/sessions", controller.RevokeOtherSessions)
+				selfRoute.DELETE("/self/sessions/:id", controller.RevokeSession)
+				selfRoute.GET("/self/access_tokens", controller.GetAccessTokens)
+				selfRoute.POST("/self/access_tokens", middleware.CriticalRateLimit(), middleware.RecentTwoFA(), controller.CreateAccessToken)
+				selfRoute.DELETE("/self/access_tokens/:id", controller.DeleteAccessToken)
+				selfRoute.GET("/2fa", controller.GetTwoFAStatus)
+				selfRoute.POST("/2fa/setup", middleware.CriticalRateLimit(), controller.SetupTwoFA)
+				selfRoute.POST("/2fa/enable", middleware.CriticalRateLimit(), controller.EnableTwoFA)
+				selfRoute.POST("/2fa/verify", middleware.CriticalRateLimit(), controller.VerifyTwoFA)
+				selfRoute.POST("/2fa/disable", middleware.CriticalRateLimit(), controller.DisableTwoFA)
+				selfRoute.POST("/2fa/recovery_codes", middleware.CriticalRateLimit(), controller.RegenerateRecoveryCodes)
 				selfRoute.GET("/aff", controller.GetAffCode)
+				selfRoute.GET("/aff/commissions", controller.GetAffCommissions)
+				selfRoute.GET("/aff/commissions/summary", controller.GetAffCommissionSummaries)
 				selfRoute.POST("/topup", controller.TopUp)
+				selfRoute.GET("/self/group_grant", controller.GetSelfGroupGrant)
+				selfRoute.GET("/self/group_promotion", controller.GetSelfGroupPromotion)
+				selfRoute.GET("/subscription/plans", controller.GetSubscriptionPlans)
+				selfRoute.GET("/self/subscription", controller.GetSelfSubscription)
+				selfRoute.PUT("/self/subscription", controller.UpdateSelfSubscription)
+				selfRoute.POST("/subscription/purchase", middleware.CriticalRateLimit(), controller.PurchaseSubscription)
 				selfRoute.POST("/pay", controller.RequestEpay)
 				selfRoute.POST("/amount", controller.RequestAmount)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 			}
 
-			adminRoute := userRoute.Group("/")
-			adminRoute.Use(middleware.AdminAuth())
-			{
-				adminRoute.GET("/", controller.GetAllUsers)
-				adminRoute.GET("/search", controller.SearchUsers)
-				adminRoute.GET("/:id", controller.GetUser)
-				adminRoute.POST("/", controller.CreateUser)
-				adminRoute.POST("/manage", controller.ManageUser)
-				adminRoute.PUT("/", controller.UpdateUser)
-				adminRoute.DELETE("/:id", controller.DeleteUser)
-				adminRoute.POST("/increase_quota", controller.IncreaseQuota)
-			}
+			// 代理也拥有用户管理权限，但只能管理自己子树中的用户
+			userRoute.GET("/", middleware.PermissionAuth(common.PermissionUserRead), controller.GetAllUsers)
+			userRoute.GET("/search", middleware.PermissionAuth(common.PermissionUserRead), controller.SearchUsers)
+			userRoute.GET("/:id", middleware.PermissionAuth(common.PermissionUserRead), controller.GetUser)
+			userRoute.POST("/", middleware.PermissionAuth(common.PermissionUserWrite), controller.CreateUser)
+			userRoute.POST("/manage", middleware.PermissionAuth(common.PermissionUserWrite), controller.ManageUser)
+			userRoute.GET("/login_lockouts", middleware.PermissionAuth(common.PermissionUserWrite), controller.GetLoginLockouts)
+			userRoute.DELETE("/login_lockouts", middleware.PermissionAuth(common.PermissionUserWrite), controller.UnlockLogin)
+			userRoute.PUT("/", middleware.PermissionAuth(common.PermissionUserWrite), controller.UpdateUser)
+			userRoute.DELETE("/:id", middleware.PermissionAuth(common.PermissionUserWrite), controller.DeleteUser)
+			userRoute.PUT("/role", middleware.PermissionAuth(common.PermissionRoleWrite), controller.UpdateUserRole)
+			userRoute.POST("/allocate_quota", middleware.PermissionAuth(common.PermissionUserQuotaAllocate), controller.AllocateQuota)
+			userRoute.PUT("/markup_ratio", middleware.PermissionAuth(common.PermissionResellerMarkup), controller.UpdateMarkupRatio)
+			userRoute.POST("/increase_quota", middleware.PermissionAuth(common.PermissionUserQuotaAdjust), controller.IncreaseQuota)
 		}
 		optionRoute := apiRouter.Group("/option")
-		optionRoute.Use(middleware.RootAuth())
-		{
-			optionRoute.GET("/", controller.GetOptions)
-			optionRoute.PUT("/", controller.UpdateOption)
-			optionRoute.POST("/rest_model_ratio", controller.ResetModelRatio)
-		}
+		optionRoute.GET("/", middleware.PermissionAuth(common.PermissionOptionRead), controller.GetOptions)
+		optionRoute.PUT("/", middleware.PermissionAuth(common.PermissionOptionWrite), controller.UpdateOption)
+		optionRoute.POST("/rest_model_ratio", middleware.PermissionAuth(common.PermissionOptionWrite), controller.ResetModelRatio)
+
 		channelRoute := apiRouter.Group("/channel")
-		channelRoute.Use(middleware.AdminAuth())
-		{
-			channelRoute.GET("/", controller.GetAllChannels)
-			channelRoute.GET("/search", controller.SearchChannels)
-			channelRoute.GET("/models", controller.ChannelListModels)
-			channelRoute.GET("/:id", controller.GetChannel)
-			channelRoute.GET("/test", controller.TestAllChannels)
-			channelRoute.GET("/test/:id", controller.TestChannel)
-			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
-			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
-			channelRoute.POST("/", controller.AddChannel)
-			channelRoute.PUT("/", controller.UpdateChannel)
-			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
-			channelRoute.DELETE("/:id", controller.DeleteChannel)
-			channelRoute.POST("/batch", controller.DeleteChannelBatch)
-			channelRoute.POST("/fix", controller.FixChannelsAbilities)
-			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
+		channelRoute.GET("/", middleware.PermissionAuth(common.PermissionChannelRead), controller.GetAllChannels)
+		channelRoute.GET("/search", middleware.PermissionAuth(common.PermissionChannelRead), controller.SearchChannels)
+		channelRoute.GET("/models", middleware.PermissionAuth(common.PermissionChannelRead), controller.ChannelListModels)
+		channelRoute.GET("/stats", middleware.PermissionAuth(common.PermissionChannelRead), controller.GetChannelStats)
+		channelRoute.GET("/:id", middleware.PermissionAuth(common.PermissionChannelRead), controller.GetChannel)
+		channelRoute.GET("/:id/key", middleware.PermissionAuth(common.PermissionChannelWrite), middleware.RecentTwoFA(), controller.GetChannelKey)
+		channelRoute.GET("/test", middleware.PermissionAuth(common.PermissionChannelWrite), controller.TestAllChannels)
+		channelRoute.GET("/test/:id", middleware.PermissionAuth(common.PermissionChannelWrite), controller.TestChannel)
+		channelRoute.GET("/update_balance", middleware.PermissionAuth(common.PermissionChannelWrite), controller.UpdateAllChannelsBalance)
+		channelRoute.GET("/update_balance/:id", middleware.PermissionAuth(common.PermissionChannelWrite), controller.UpdateChannelBalance)
+		channelRoute.POST("/", middleware.PermissionAuth(common.PermissionChannelWrite), controller.AddChannel)
+		channelRoute.PUT("/", middleware.PermissionAuth(common.PermissionChannelWrite), controller.UpdateChannel)
+		channelRoute.DELETE("/disabled", middleware.PermissionAuth(common.PermissionChannelWrite), controller.DeleteDisabledChannel)
+		channelRoute.DELETE("/:id", middleware.PermissionAuth(common.PermissionChannelWrite), controller.DeleteChannel)
+		channelRoute.POST("/batch", middleware.PermissionAuth(common.PermissionChannelWrite), controller.DeleteChannelBatch)
+		channelRoute.POST("/fix", middleware.PermissionAuth(common.PermissionChannelWrite), controller.FixChannelsAbilities)
+		channelRoute.GET("/fetch_models/:id", middleware.PermissionAuth(common.PermissionChannelWrite), controller.FetchUpstreamModels)
 
-		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
 		// tokenRoute.Use(middleware.CORS())
@@ -107,40 +131,101 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 		}
 		redemptionRoute := apiRouter.Group("/redemption")
-		redemptionRoute.Use(middleware.AdminAuth())
-		{
-			redemptionRoute.GET("/", controller.GetAllRedemptions)
-			redemptionRoute.GET("/search", controller.SearchRedemptions)
-			redemptionRoute.GET("/:id", controller.GetRedemption)
-			redemptionRoute.POST("/", controller.AddRedemption)
-			redemptionRoute.PUT("/", controller.UpdateRedemption)
-			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
-		}
+		redemptionRoute.GET("/", middleware.PermissionAuth(common.PermissionRedemptionRead), controller.GetAllRedemptions)
+		redemptionRoute.GET("/search", middleware.PermissionAuth(common.PermissionRedemptionRead), controller.SearchRedemptions)
+		redemptionRoute.GET("/campaign", middleware.PermissionAuth(common.PermissionRedemptionRead), controller.GetRedemptionCampaigns)
+		redemptionRoute.GET("/campaign/:id", middleware.PermissionAuth(common.PermissionRedemptionRead), controller.GetRedemptionCampaign)
+		redemptionRoute.GET("/campaign/:id/export", middleware.PermissionAuth(common.PermissionRedemptionRead), controller.ExportRedemptionCampaign)
+		redemptionRoute.POST("/campaign", middleware.PermissionAuth(common.PermissionRedemptionWrite), controller.AddRedemptionCampaign)
+		redemptionRoute.POST("/campaign/:id/codes", middleware.PermissionAuth(common.PermissionRedemptionWrite), controller.GenerateRedemptionCampaignCodes)
+		redemptionRoute.PUT("/campaign", middleware.PermissionAuth(common.PermissionRedemptionWrite), controller.UpdateRedemptionCampaign)
+		redemptionRoute.DELETE("/campaign/:id", middleware.PermissionAuth(common.PermissionRedemptionWrite), controller.DeleteRedemptionCampaign)
+		redemptionRoute.GET("/:id", middleware.PermissionAuth(common.PermissionRedemptionRead), controller.GetRedemption)
+		redemptionRoute.POST("/", middleware.PermissionAuth(common.PermissionRedemptionWrite), controller.AddRedemption)
+		redemptionRoute.PUT("/", middleware.PermissionAuth(common.PermissionRedemptionWrite), controller.UpdateRedemption)
+		redemptionRoute.DELETE("/:id", middleware.PermissionAuth(common.PermissionRedemptionWrite), controller.DeleteRedemption)
+
+		subscriptionRoute := apiRouter.Group("/subscription")
+		subscriptionRoute.GET("/plan", middleware.PermissionAuth(common.PermissionSubscriptionRead), controller.GetAllSubscriptionPlans)
+		subscriptionRoute.POST("/plan", middleware.PermissionAuth(common.PermissionSubscriptionWrite), controller.AddSubscriptionPlan)
+		subscriptionRoute.PUT("/plan", middleware.PermissionAuth(common.PermissionSubscriptionWrite), controller.UpdateSubscriptionPlan)
+		subscriptionRoute.DELETE("/plan/:id", middleware.PermissionAuth(common.PermissionSubscriptionWrite), controller.DeleteSubscriptionPlan)
+		subscriptionRoute.GET("/", middleware.PermissionAuth(common.PermissionSubscriptionRead), controller.GetUserSubscriptions)
+		subscriptionRoute.POST("/", middleware.PermissionAuth(common.PermissionSubscriptionWrite), controller.GrantUserSubscription)
+		subscriptionRoute.DELETE("/:id", middleware.PermissionAuth(common.PermissionSubscriptionWrite), controller.CancelUserSubscription)
+
 		logRoute := apiRouter.Group("/log")
-		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
-		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
-		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/", middleware.PermissionAuth(common.PermissionLogRead), controller.GetAllLogs)
+		logRoute.DELETE("/", middleware.PermissionAuth(common.PermissionLogDelete), controller.DeleteHistoryLogs)
+		logRoute.GET("/stat", middleware.PermissionAuth(common.PermissionLogRead), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
-		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/search", middleware.PermissionAuth(common.PermissionLogRead), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
+		logRoute.GET("/export", middleware.DownloadRateLimit(), middleware.PermissionAuth(common.PermissionLogExport), controller.ExportAllLogs)
+		logRoute.GET("/self/export", middleware.DownloadRateLimit(), middleware.UserAuth(), controller.ExportUserLogs)
+		logRoute.GET("/archive", middleware.PermissionAuth(common.PermissionLogArchiveRead), controller.GetLogArchives)
+		logRoute.POST("/archive", middleware.PermissionAuth(common.PermissionLogArchiveWrite), controller.ArchiveLogs)
 
 		dataRoute := apiRouter.Group("/data")
-		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
+		dataRoute.GET("/", middleware.PermissionAuth(common.PermissionDataRead), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
+		dataRoute.GET("/export", middleware.DownloadRateLimit(), middleware.PermissionAuth(common.PermissionDataExport), controller.ExportAllQuotaData)
+		dataRoute.GET("/self/export", middleware.DownloadRateLimit(), middleware.UserAuth(), controller.ExportUserQuotaData)
 
 		logRoute.Use(middleware.CORS())
 		{
 			logRoute.GET("/token", controller.GetLogByKey)
 
 		}
-		groupRoute := apiRouter.Group("/group")
-		groupRoute.Use(middleware.AdminAuth())
+		ledgerRoute := apiRouter.Group("/ledger")
+		ledgerRoute.GET("/self", middleware.UserAuth(), controller.GetUserLedgers)
+		ledgerRoute.GET("/", middleware.PermissionAuth(common.PermissionLedgerRead), controller.GetAllLedgers)
+		ledgerRoute.GET("/reconcile", middleware.PermissionAuth(common.PermissionLedgerRead), controller.GetLedgerReconcileReport)
+		ledgerRoute.POST("/reconcile", middleware.PermissionAuth(common.PermissionLedgerReconcile), controller.ReconcileLedger)
+
+		statementRoute := apiRouter.Group("/statement")
+		statementRoute.GET("/self", middleware.UserAuth(), controller.GetUserStatements)
+		statementRoute.GET("/self/preview", middleware.UserAuth(), controller.PreviewUserStatement)
+		statementRoute.GET("/self/:id", middleware.UserAuth(), controller.GetUserStatement)
+		statementRoute.GET("/", middleware.PermissionAuth(common.PermissionStatementRead), controller.GetAllStatements)
+		statementRoute.GET("/:id", middleware.PermissionAuth(common.PermissionStatementRead), controller.GetStatement)
+		statementRoute.POST("/generate", middleware.PermissionAuth(common.PermissionStatementWrite), controller.GenerateStatements)
+
+		orgRoute := apiRouter.Group("/org")
+		orgRoute.GET("/", middleware.PermissionAuth(common.PermissionOrgRead), controller.GetAllOrganizations)
+		orgRoute.PUT("/", middleware.PermissionAuth(common.PermissionOrgWrite), controller.AdminUpdateOrganization)
+		orgRoute.Use(middleware.UserAuth())
 		{
-			groupRoute.GET("/", controller.GetGroups)
+			orgRoute.POST("/", controller.CreateOrganization)
+			orgRoute.GET("/self", controller.GetSelfOrganizations)
+			orgRoute.GET("/:id", controller.GetOrganization)
+			orgRoute.PUT("/:id", controller.UpdateOrganization)
+			orgRoute.DELETE("/:id", controller.DeleteOrganization)
+			orgRoute.GET("/:id/member", controller.GetOrganizationMembers)
+			orgRoute.POST("/:id/member", controller.AddOrganizationMember)
+			orgRoute.PUT("/:id/member/:user_id", controller.UpdateOrganizationMember)
+			orgRoute.DELETE("/:id/member/:user_id", controller.RemoveOrganizationMember)
+			orgRoute.POST("/:id/quota", controller.TransferQuotaToOrganization)
+			orgRoute.GET("/:id/log", controller.GetOrganizationLogs)
 		}
+
+		groupRoute := apiRouter.Group("/group")
+		groupRoute.GET("/", middleware.PermissionAuth(common.PermissionGroupRead), controller.GetGroups)
+
+		roleRoute := apiRouter.Group("/role")
+		roleRoute.GET("/", middleware.PermissionAuth(common.PermissionRoleRead), controller.GetAllRoles)
+		roleRoute.GET("/permissions", middleware.PermissionAuth(common.PermissionRoleRead), controller.GetAllPermissions)
+		roleRoute.POST("/", middleware.PermissionAuth(common.PermissionRoleWrite), controller.AddRole)
+		roleRoute.PUT("/", middleware.PermissionAuth(common.PermissionRoleWrite), controller.UpdateRole)
+		roleRoute.DELETE("/:id", middleware.PermissionAuth(common.PermissionRoleWrite), controller.DeleteRole)
+
+		auditRoute := apiRouter.Group("/audit")
+		auditRoute.GET("/", middleware.PermissionAuth(common.PermissionAuditRead), controller.GetAuditLogs)
+		auditRoute.GET("/export", middleware.DownloadRateLimit(), middleware.PermissionAuth(common.PermissionAuditRead), controller.ExportAuditLogs)
+
 		mjRoute := apiRouter.Group("/mj")
 		mjRoute.GET("/self", middleware.UserAuth(), controller.GetUserMidjourney)
-		mjRoute.GET("/", middleware.AdminAuth(), controller.GetAllMidjourney)
+		mjRoute.GET("/", middleware.PermissionAuth(common.PermissionMidjourneyRead), controller.GetAllMidjourney)
 	}
 }
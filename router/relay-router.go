@@ -16,6 +16,12 @@ func SetRelayRouter(router *gin.Engine) {
 		modelsRouter.GET("", controller.ListModels)
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
+	tokenizeRouter := router.Group("/v1")
+	tokenizeRouter.Use(middleware.TokenAuth(), middleware.TokenizeRateLimit())
+	{
+		tokenizeRouter.POST("/tokenize", controller.Tokenize)
+		tokenizeRouter.POST("/messages/count_tokens", controller.Tokenize)
+	}
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.TokenAuth(), middleware.Distribute())
 	{
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"one-api/dto"
+	"one-api/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isRetryRequest 本次请求是否为重试，relay_attempt 在每次重试前写入
+func isRetryRequest(c *gin.Context) bool {
+	return c.GetInt("relay_attempt") > 0
+}
+
+// RecordChannelSuccess 记录渠道的成功请求，firstResponseTime 为零值时不统计首字时间
+func RecordChannelSuccess(c *gin.Context, channelId int, startTime time.Time, firstResponseTime time.Time, promptTokens int, completionTokens int) {
+	event := model.ChannelRequestEvent{
+		ChannelId:        channelId,
+		ModelName:        c.GetString("original_model"),
+		Success:          true,
+		IsRetry:          isRetryRequest(c),
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Latency:          time.Since(startTime).Milliseconds(),
+	}
+	if !firstResponseTime.IsZero() {
+		event.FirstResponse = firstResponseTime.Sub(startTime).Milliseconds()
+	}
+	model.RecordChannelRequest(event)
+}
+
+// RecordChannelError 记录渠道的失败请求，本地错误不计入渠道统计
+func RecordChannelError(c *gin.Context, channelId int, startTime time.Time, err *dto.OpenAIErrorWithStatusCode) {
+	if err == nil || err.LocalError {
+		return
+	}
+	model.RecordChannelRequest(model.ChannelRequestEvent{
+		ChannelId:  channelId,
+		ModelName:  c.GetString("original_model"),
+		IsRetry:    isRetryRequest(c),
+		StatusCode: err.StatusCode,
+		ErrorType:  err.Error.Type,
+		Latency:    time.Since(startTime).Milliseconds(),
+	})
+}
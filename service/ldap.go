@@ -0,0 +1,150 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"one-api/common"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const ldapTimeout = 10 * time.Second
+
+// LDAPUser 目录认证通过后读取到的用户信息
+type LDAPUser struct {
+	Id          string
+	DisplayName string
+	Email       string
+	Groups      []string
+}
+
+var ErrLDAPInvalidCredentials = errors.New("用户名或密码错误")
+
+func dialLDAP() (*ldap.Conn, error) {
+	serverURL, err := url.Parse(common.LDAPServerURL)
+	if err != nil || serverURL.Host == "" {
+		return nil, errors.New("LDAP 服务器地址无效")
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         serverURL.Hostname(),
+		InsecureSkipVerify: common.LDAPSkipTLSVerifyEnabled,
+	}
+	conn, err := ldap.DialURL(common.LDAPServerURL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	if common.LDAPStartTLSEnabled && serverURL.Scheme != "ldaps" {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// AuthenticateLDAPUser 使用服务账号查找用户条目，再以用户自己的 DN 与密码绑定完成认证
+func AuthenticateLDAPUser(username string, password string) (*LDAPUser, error) {
+	// 空密码会被服务器视为匿名绑定而返回成功，必须提前拒绝
+	if username == "" || password == "" {
+		return nil, ErrLDAPInvalidCredentials
+	}
+	conn, err := dialLDAP()
+	if err != nil {
+		common.SysError("failed to connect ldap server: " + err.Error())
+		return nil, errors.New("无法连接至 LDAP 服务器，请稍后重试！")
+	}
+	defer conn.Close()
+	if common.LDAPBindDN != "" {
+		if err = conn.Bind(common.LDAPBindDN, common.LDAPBindPassword); err != nil {
+			common.SysError("failed to bind ldap service account: " + err.Error())
+			return nil, errors.New("LDAP 服务账号绑定失败，请联系管理员")
+		}
+	}
+	attributes := []string{"dn"}
+	for _, attribute := range []string{common.LDAPUsernameAttribute, common.LDAPDisplayNameAttribute, common.LDAPEmailAttribute, common.LDAPGroupAttribute} {
+		if attribute != "" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	request := ldap.NewSearchRequest(
+		common.LDAPBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout/time.Second), false,
+		strings.ReplaceAll(common.LDAPUserFilter, "%s", ldap.EscapeFilter(username)),
+		attributes,
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		common.SysError("failed to search ldap user: " + err.Error())
+		return nil, errors.New("LDAP 查询失败，请联系管理员")
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrLDAPInvalidCredentials
+	}
+	entry := result.Entries[0]
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPInvalidCredentials
+		}
+		common.SysError("failed to bind ldap user: " + err.Error())
+		return nil, errors.New("LDAP 认证失败，请稍后重试！")
+	}
+	user := &LDAPUser{
+		Id:          entry.GetAttributeValue(common.LDAPUsernameAttribute),
+		DisplayName: entry.GetAttributeValue(common.LDAPDisplayNameAttribute),
+		Email:       entry.GetAttributeValue(common.LDAPEmailAttribute),
+		Groups:      entry.GetAttributeValues(common.LDAPGroupAttribute),
+	}
+	if user.Id == "" {
+		user.Id = username
+	}
+	return user, nil
+}
+
+// ResolveLDAPGroupRule 根据用户所属的目录组确定分组与权限等级：
+// 分组取第一个配置了分组的目录组，权限等级取所有匹配目录组中最高的一个。matched 为 false 表示没有任何目录组匹配
+func ResolveLDAPGroupRule(groups []string) (rule common.LDAPGroupRule, matched bool) {
+	if len(common.LDAPGroupMapping) == 0 {
+		return rule, false
+	}
+	mapping := make(map[string]common.LDAPGroupRule, len(common.LDAPGroupMapping))
+	for name, r := range common.LDAPGroupMapping {
+		mapping[strings.ToLower(name)] = r
+	}
+	for _, group := range groups {
+		names := []string{strings.ToLower(group)}
+		// memberOf 通常为完整 DN，同时支持按 CN 匹配
+		if dn, err := ldap.ParseDN(group); err == nil && len(dn.RDNs) > 0 {
+			for _, attr := range dn.RDNs[0].Attributes {
+				if strings.EqualFold(attr.Type, "cn") {
+					names = append(names, strings.ToLower(attr.Value))
+				}
+			}
+		}
+		for _, name := range names {
+			r, ok := mapping[name]
+			if !ok {
+				continue
+			}
+			matched = true
+			if rule.Group == "" && r.Group != "" {
+				if _, exists := common.GroupRatio[r.Group]; exists {
+					rule.Group = r.Group
+				}
+			}
+			if r.Role > rule.Role {
+				rule.Role = r.Role
+			}
+			break
+		}
+	}
+	return rule, matched
+}
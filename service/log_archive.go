@@ -0,0 +1,176 @@
+package service
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const logArchiveBatchSize = 1000
+
+var logArchiveLock sync.Mutex
+
+// ArchiveLogs 将超过保留天数的日志按天（分区表按月）归档到存储中，并从数据库删除，返回生成的归档数量
+func ArchiveLogs() (int, error) {
+	if !logArchiveLock.TryLock() {
+		return 0, errors.New("日志归档正在进行中")
+	}
+	defer logArchiveLock.Unlock()
+
+	if constant.LogArchiveDays <= 0 {
+		return 0, errors.New("日志保留天数必须大于 0")
+	}
+	storage, err := GetLogArchiveStorage(constant.LogArchiveStorage)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -constant.LogArchiveDays)
+	oldest, err := model.GetOldestLogTimestamp(cutoff.Unix())
+	if err != nil || oldest == 0 {
+		return 0, err
+	}
+	monthly := model.IsLogPartitioned()
+	start := time.Unix(oldest, 0).In(time.Local)
+	if monthly {
+		start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.Local)
+	} else {
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local)
+	}
+	count := 0
+	for {
+		var end time.Time
+		if monthly {
+			end = start.AddDate(0, 1, 0)
+		} else {
+			end = start.AddDate(0, 0, 1)
+		}
+		if end.After(cutoff) {
+			break
+		}
+		archived, err := archiveLogRange(storage, start, end, monthly)
+		if err != nil {
+			return count, err
+		}
+		if archived {
+			count++
+		}
+		start = end
+	}
+	return count, nil
+}
+
+func logArchiveKey(start time.Time, monthly bool) string {
+	name := "logs-" + start.Format("20060102") + ".jsonl.gz"
+	if monthly {
+		name = "logs-" + start.Format("200601") + ".jsonl.gz"
+	}
+	key := path.Join(start.Format("2006"), start.Format("01"), name)
+	if constant.LogArchiveStorage == constant.LogArchiveStorageS3 && constant.LogArchiveS3Prefix != "" {
+		key = path.Join(constant.LogArchiveS3Prefix, key)
+	}
+	return key
+}
+
+// archiveLogRange 归档 [start, end) 范围内的日志，先写入临时文件再上传，上传成功后才删除数据库中的记录
+func archiveLogRange(storage LogArchiveStorage, start time.Time, end time.Time, monthly bool) (bool, error) {
+	file, err := os.CreateTemp("", "log-archive-*.jsonl.gz")
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	archive := &model.LogArchive{
+		StartTime: start.Unix(),
+		EndTime:   end.Unix(),
+		Storage:   constant.LogArchiveStorage,
+		Key:       logArchiveKey(start, monthly),
+	}
+	gzipWriter := gzip.NewWriter(file)
+	encoder := json.NewEncoder(gzipWriter)
+	filter := model.LogFilter{StartTimestamp: archive.StartTime, EndTimestamp: archive.EndTime - 1}
+	err = model.IterateLogs(filter, logArchiveBatchSize, func(logs []*model.Log) error {
+		for _, log := range logs {
+			if err := encoder.Encode(log); err != nil {
+				return err
+			}
+			if archive.MinId == 0 || log.Id < archive.MinId {
+				archive.MinId = log.Id
+			}
+			if log.Id > archive.MaxId {
+				archive.MaxId = log.Id
+			}
+			archive.RecordCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return false, err
+	}
+	if archive.RecordCount == 0 {
+		return false, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	archive.Size = info.Size()
+	if err = storage.Put(archive.Key, file); err != nil {
+		return false, err
+	}
+	archive.CreatedAt = common.GetTimestamp()
+	if err = model.SaveLogArchive(archive); err != nil {
+		return false, err
+	}
+	if err = model.DeleteLogsInRange(archive.StartTime, archive.EndTime); err != nil {
+		return false, err
+	}
+	common.SysLog(fmt.Sprintf("archived %d logs to %s", archive.RecordCount, archive.Key))
+	return true, nil
+}
+
+// ReadLogArchive 逐条读取归档文件中的日志，fn 返回错误时停止
+func ReadLogArchive(archive *model.LogArchive, fn func(log *model.Log) error) error {
+	storage, err := GetLogArchiveStorage(archive.Storage)
+	if err != nil {
+		return err
+	}
+	reader, err := storage.Open(archive.Key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	decoder := json.NewDecoder(gzipReader)
+	for {
+		var log model.Log
+		if err = decoder.Decode(&log); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err = fn(&log); err != nil {
+			return err
+		}
+	}
+}
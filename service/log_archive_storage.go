@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"one-api/constant"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// LogArchiveStorage 归档文件的存储后端
+type LogArchiveStorage interface {
+	// Put 将本地文件上传到 key 对应的位置
+	Put(key string, file *os.File) error
+	Open(key string) (io.ReadCloser, error)
+}
+
+func GetLogArchiveStorage(storage string) (LogArchiveStorage, error) {
+	switch storage {
+	case constant.LogArchiveStorageLocal:
+		return &localLogArchiveStorage{dir: constant.LogArchiveDir}, nil
+	case constant.LogArchiveStorageS3:
+		if constant.LogArchiveS3Bucket == "" || constant.LogArchiveS3AccessKey == "" || constant.LogArchiveS3SecretKey == "" {
+			return nil, errors.New("S3 归档存储未配置")
+		}
+		return &s3LogArchiveStorage{
+			endpoint:  constant.LogArchiveS3Endpoint,
+			region:    constant.LogArchiveS3Region,
+			bucket:    constant.LogArchiveS3Bucket,
+			accessKey: constant.LogArchiveS3AccessKey,
+			secretKey: constant.LogArchiveS3SecretKey,
+			pathStyle: constant.LogArchiveS3PathStyleEnabled,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown log archive storage: %s", storage)
+}
+
+type localLogArchiveStorage struct {
+	dir string
+}
+
+func (s *localLogArchiveStorage) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(s.dir)+string(filepath.Separator)) {
+		return "", errors.New("invalid archive key")
+	}
+	return path, nil
+}
+
+func (s *localLogArchiveStorage) Put(key string, file *os.File) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	target, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(target, file); err != nil {
+		_ = target.Close()
+		return err
+	}
+	return target.Close()
+}
+
+func (s *localLogArchiveStorage) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// s3LogArchiveStorage 通过 SigV4 签名的 HTTP 请求访问 S3 兼容的对象存储
+type s3LogArchiveStorage struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+}
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (s *s3LogArchiveStorage) objectUrl(key string) (string, error) {
+	endpoint := s.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return "", err
+	}
+	escapedKey := (&url.URL{Path: key}).EscapedPath()
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+		u.RawPath = "/" + s.bucket + "/" + escapedKey
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+		u.RawPath = "/" + escapedKey
+	}
+	return u.String(), nil
+}
+
+func (s *s3LogArchiveStorage) do(method string, key string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	objectUrl, err := s.objectUrl(key)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, method, objectUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	credentials := aws.Credentials{AccessKeyID: s.accessKey, SecretAccessKey: s.secretKey}
+	if err = v4.NewSigner().SignHTTP(ctx, credentials, req, payloadHash, "s3", s.region, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s failed: status %d, %s", method, key, resp.StatusCode, string(message))
+	}
+	return resp, nil
+}
+
+func (s *s3LogArchiveStorage) Put(key string, file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodPut, key, file, size, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *s3LogArchiveStorage) Open(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
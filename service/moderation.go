@@ -0,0 +1,199 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ModerationResult struct {
+	Flagged    bool     `json:"flagged"`
+	Categories []string `json:"categories"`
+}
+
+type moderationCacheItem struct {
+	result    dto.ModerationResponseResult
+	expiresAt time.Time
+}
+
+var moderationCache = make(map[string]moderationCacheItem)
+var moderationCacheLock sync.RWMutex
+
+// ModerationSender 通过网关渠道发送审核请求，请求地址与鉴权由渠道适配器生成
+type ModerationSender func(request *dto.ModerationRequest) (*http.Response, error)
+
+// ModerateText 调用审核模型检查文本，并根据分组设置的阈值判断是否命中；
+// 配置了自定义审核接口时直接请求该接口，否则使用 send 通过渠道发送
+func ModerateText(text string, setting constant.ModerationGroupSetting, send ModerationSender) (*ModerationResult, error) {
+	if text == "" {
+		return &ModerationResult{}, nil
+	}
+	hash := sha256.Sum256([]byte(text))
+	key := hex.EncodeToString(hash[:])
+	upstreamResult, ok := getModerationCache(key)
+	if !ok {
+		if constant.ModerationEndpoint != "" {
+			send = sendEndpointModeration
+		}
+		var err error
+		upstreamResult, err = requestModeration(text, send)
+		if err != nil {
+			return nil, err
+		}
+		setModerationCache(key, upstreamResult)
+	}
+	return evaluateModeration(upstreamResult, setting.Thresholds), nil
+}
+
+func evaluateModeration(upstreamResult *dto.ModerationResponseResult, thresholds map[string]float64) *ModerationResult {
+	result := &ModerationResult{Categories: make([]string, 0)}
+	if len(thresholds) == 0 {
+		result.Flagged = upstreamResult.Flagged
+		for category, hit := range upstreamResult.Categories {
+			if hit {
+				result.Categories = append(result.Categories, category)
+			}
+		}
+	} else {
+		for category, threshold := range thresholds {
+			if score, ok := upstreamResult.CategoryScores[category]; ok && score >= threshold {
+				result.Flagged = true
+				result.Categories = append(result.Categories, category)
+			}
+		}
+	}
+	sort.Strings(result.Categories)
+	return result
+}
+
+// sendEndpointModeration 请求自定义审核接口
+func sendEndpointModeration(request *dto.ModerationRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, constant.ModerationEndpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if constant.ModerationKey != "" {
+		req.Header.Set("Authorization", "Bearer "+constant.ModerationKey)
+	}
+	return GetHttpClient().Do(req)
+}
+
+func requestModeration(text string, send ModerationSender) (*dto.ModerationResponseResult, error) {
+	resp, err := send(&dto.ModerationRequest{
+		Model: constant.ModerationModel,
+		Input: text,
+	})
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var moderationResponse dto.ModerationResponse
+	err = json.Unmarshal(responseBody, &moderationResponse)
+	if err != nil {
+		return nil, err
+	}
+	if moderationResponse.Error != nil && moderationResponse.Error.Message != "" {
+		return nil, errors.New(moderationResponse.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad moderation response status code %d", resp.StatusCode)
+	}
+	if len(moderationResponse.Results) == 0 {
+		return nil, errors.New("empty moderation result")
+	}
+	// 多段输入时合并结果，取各类别的最高分
+	merged := &dto.ModerationResponseResult{
+		Categories:     make(map[string]bool),
+		CategoryScores: make(map[string]float64),
+	}
+	for _, result := range moderationResponse.Results {
+		merged.Flagged = merged.Flagged || result.Flagged
+		for category, hit := range result.Categories {
+			merged.Categories[category] = merged.Categories[category] || hit
+		}
+		for category, score := range result.CategoryScores {
+			if score > merged.CategoryScores[category] {
+				merged.CategoryScores[category] = score
+			}
+		}
+	}
+	return merged, nil
+}
+
+func getModerationCache(key string) (*dto.ModerationResponseResult, bool) {
+	if constant.ModerationCacheSeconds <= 0 {
+		return nil, false
+	}
+	if common.RedisEnabled {
+		value, err := common.RedisGet("moderation:" + key)
+		if err != nil {
+			return nil, false
+		}
+		var result dto.ModerationResponseResult
+		if err = json.Unmarshal([]byte(value), &result); err != nil {
+			return nil, false
+		}
+		return &result, true
+	}
+	moderationCacheLock.RLock()
+	item, ok := moderationCache[key]
+	moderationCacheLock.RUnlock()
+	if !ok || time.Now().After(item.expiresAt) {
+		return nil, false
+	}
+	return &item.result, true
+}
+
+func setModerationCache(key string, result *dto.ModerationResponseResult) {
+	if constant.ModerationCacheSeconds <= 0 {
+		return
+	}
+	expiration := time.Duration(constant.ModerationCacheSeconds) * time.Second
+	if common.RedisEnabled {
+		jsonBytes, err := json.Marshal(result)
+		if err != nil {
+			return
+		}
+		err = common.RedisSet("moderation:"+key, string(jsonBytes), expiration)
+		if err != nil {
+			common.SysError("failed to set moderation cache: " + err.Error())
+		}
+		return
+	}
+	moderationCacheLock.Lock()
+	defer moderationCacheLock.Unlock()
+	now := time.Now()
+	// 顺便清理过期的缓存，避免内存无限增长
+	for k, item := range moderationCache {
+		if now.After(item.expiresAt) {
+			delete(moderationCache, k)
+		}
+	}
+	moderationCache[key] = moderationCacheItem{
+		result:    *result,
+		expiresAt: now.Add(expiration),
+	}
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/constant"
+	"one-api/dto"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type piiDetector struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// 内置检测器，顺序有意义：身份证号需要在手机号之前匹配
+var builtinPIIDetectors = []piiDetector{
+	{Name: "api_key", Pattern: regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_\-]{20,}\b|\bAKIA[0-9A-Z]{16}\b|\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{Name: "email", Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	{Name: "id_card", Pattern: regexp.MustCompile(`\b[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`)},
+	{Name: "phone", Pattern: regexp.MustCompile(`(?:\+86[\-\s]?|\b86[\-\s]?|\b)1[3-9]\d{9}\b`)},
+}
+
+var customPIIPatterns = make(map[string]*regexp.Regexp)
+var customPIIPatternsLock sync.Mutex
+
+var placeholderPrefixPattern = regexp.MustCompile(`\[(?:P(?:I(?:I(?:_[A-Z0-9_]*)?)?)?)?$`)
+
+func getPIIDetectors() []piiDetector {
+	detectors := make([]piiDetector, 0)
+	for _, detector := range builtinPIIDetectors {
+		for _, name := range constant.PIIRedactionDetectors {
+			if detector.Name == name {
+				detectors = append(detectors, detector)
+				break
+			}
+		}
+	}
+	names := make([]string, 0, len(constant.PIICustomDetectors))
+	for name := range constant.PIICustomDetectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	customPIIPatternsLock.Lock()
+	defer customPIIPatternsLock.Unlock()
+	for _, name := range names {
+		pattern := constant.PIICustomDetectors[name]
+		re, ok := customPIIPatterns[pattern]
+		if !ok {
+			var err error
+			re, err = regexp.Compile(pattern)
+			if err != nil {
+				continue
+			}
+			customPIIPatterns[pattern] = re
+		}
+		detectors = append(detectors, piiDetector{Name: name, Pattern: re})
+	}
+	return detectors
+}
+
+// PIIRedactor 对请求中的敏感信息进行脱敏，placeholder 模式下记录占位符与原文的对应关系
+type PIIRedactor struct {
+	mode      string
+	detectors []piiDetector
+	// placeholder -> original value
+	values map[string]string
+	// original value -> placeholder
+	placeholders map[string]string
+	counter      map[string]int
+	hits         map[string]bool
+}
+
+func NewPIIRedactor(mode string) *PIIRedactor {
+	return &PIIRedactor{
+		mode:         mode,
+		detectors:    getPIIDetectors(),
+		values:       make(map[string]string),
+		placeholders: make(map[string]string),
+		counter:      make(map[string]int),
+		hits:         make(map[string]bool),
+	}
+}
+
+// Detected 返回命中的检测器名称
+func (r *PIIRedactor) Detected() []string {
+	names := make([]string, 0, len(r.hits))
+	for name := range r.hits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *PIIRedactor) HasPlaceholders() bool {
+	return len(r.values) > 0
+}
+
+func (r *PIIRedactor) RedactText(text string) string {
+	for _, detector := range r.detectors {
+		text = detector.Pattern.ReplaceAllStringFunc(text, func(value string) string {
+			r.hits[detector.Name] = true
+			label := strings.ToUpper(detector.Name)
+			if r.mode != constant.PIIRedactionModePlaceholder {
+				return fmt.Sprintf("[%s_REDACTED]", label)
+			}
+			if placeholder, ok := r.placeholders[value]; ok {
+				return placeholder
+			}
+			r.counter[label]++
+			placeholder := fmt.Sprintf("[PII_%s_%d]", label, r.counter[label])
+			r.placeholders[value] = placeholder
+			r.values[placeholder] = value
+			return placeholder
+		})
+	}
+	return text
+}
+
+// RedactRequest 对请求中的文本内容脱敏，返回请求是否被修改
+func (r *PIIRedactor) RedactRequest(request *dto.GeneralOpenAIRequest) (bool, error) {
+	modified := false
+	for i, message := range request.Messages {
+		if message.IsStringContent() {
+			content := message.StringContent()
+			redacted := r.RedactText(content)
+			if redacted != content {
+				jsonContent, err := json.Marshal(redacted)
+				if err != nil {
+					return false, err
+				}
+				request.Messages[i].Content = jsonContent
+				modified = true
+			}
+			continue
+		}
+		var parts []map[string]any
+		if err := json.Unmarshal(message.Content, &parts); err != nil {
+			continue
+		}
+		partModified := false
+		for _, part := range parts {
+			if text, ok := part["text"].(string); ok && part["type"] == dto.ContentTypeText {
+				redacted := r.RedactText(text)
+				if redacted != text {
+					part["text"] = redacted
+					partModified = true
+				}
+			}
+		}
+		if partModified {
+			jsonContent, err := json.Marshal(parts)
+			if err != nil {
+				return false, err
+			}
+			request.Messages[i].Content = jsonContent
+			modified = true
+		}
+	}
+	if prompt, ok := request.Prompt.(string); ok {
+		redacted := r.RedactText(prompt)
+		if redacted != prompt {
+			request.Prompt = redacted
+			modified = true
+		}
+	}
+	switch input := request.Input.(type) {
+	case string:
+		redacted := r.RedactText(input)
+		if redacted != input {
+			request.Input = redacted
+			modified = true
+		}
+	case []any:
+		for i, item := range input {
+			if text, ok := item.(string); ok {
+				redacted := r.RedactText(text)
+				if redacted != text {
+					input[i] = redacted
+					modified = true
+				}
+			}
+		}
+	}
+	if modified && r.mode == constant.PIIRedactionModeReject {
+		return false, errors.New("personal information detected: " + strings.Join(r.Detected(), ","))
+	}
+	return modified, nil
+}
+
+// PIIRestorer 在响应中还原占位符，流式响应中被拆分的占位符会暂存到下一个分片
+type PIIRestorer struct {
+	textReplacer *strings.Replacer
+	jsonReplacer *strings.Replacer
+	pending      map[int]string
+	lock         sync.Mutex
+}
+
+func (r *PIIRedactor) NewRestorer() *PIIRestorer {
+	textPairs := make([]string, 0, len(r.values)*2)
+	jsonPairs := make([]string, 0, len(r.values)*2)
+	for placeholder, value := range r.values {
+		textPairs = append(textPairs, placeholder, value)
+		jsonValue, _ := json.Marshal(value)
+		jsonPairs = append(jsonPairs, placeholder, string(jsonValue[1:len(jsonValue)-1]))
+	}
+	return &PIIRestorer{
+		textReplacer: strings.NewReplacer(textPairs...),
+		jsonReplacer: strings.NewReplacer(jsonPairs...),
+		pending:      make(map[int]string),
+	}
+}
+
+// RestoreText 将文本中的占位符还原为原文
+func (r *PIIRestorer) RestoreText(text string) string {
+	return r.textReplacer.Replace(text)
+}
+
+func (r *PIIRestorer) restoreStreamContent(index int, content string, finished bool) string {
+	content = r.pending[index] + content
+	delete(r.pending, index)
+	if !finished {
+		if loc := placeholderPrefixPattern.FindStringIndex(content); loc != nil {
+			r.pending[index] = content[loc[0]:]
+			content = content[:loc[0]]
+		}
+	}
+	return r.RestoreText(content)
+}
+
+func (r *PIIRestorer) restoreStreamEvent(data string) string {
+	var event map[string]any
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return r.jsonReplacer.Replace(data)
+	}
+	choices, ok := event["choices"].([]any)
+	if !ok {
+		return r.jsonReplacer.Replace(data)
+	}
+	for i, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := i
+		if idx, ok := choice["index"].(float64); ok {
+			index = int(idx)
+		}
+		finished := choice["finish_reason"] != nil
+		if delta, ok := choice["delta"].(map[string]any); ok {
+			if content, ok := delta["content"].(string); ok || finished {
+				delta["content"] = r.restoreStreamContent(index, content, finished)
+			}
+		} else if text, ok := choice["text"].(string); ok || finished {
+			choice["text"] = r.restoreStreamContent(index, text, finished)
+		}
+	}
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		return r.jsonReplacer.Replace(data)
+	}
+	return string(jsonData)
+}
+
+func (r *PIIRestorer) Restore(data []byte) []byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	str := string(data)
+	if strings.HasPrefix(str, "data: {") {
+		return []byte("data: " + r.restoreStreamEvent(strings.TrimPrefix(str, "data: ")))
+	}
+	return []byte(r.jsonReplacer.Replace(str))
+}
+
+type piiRestoreWriter struct {
+	gin.ResponseWriter
+	restorer *PIIRestorer
+}
+
+// NewPIIRestoreWriter 包装响应，写出前还原占位符
+func NewPIIRestoreWriter(writer gin.ResponseWriter, restorer *PIIRestorer) gin.ResponseWriter {
+	return &piiRestoreWriter{ResponseWriter: writer, restorer: restorer}
+}
+
+func (w *piiRestoreWriter) WriteHeader(code int) {
+	// 还原后长度会变化
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *piiRestoreWriter) Write(data []byte) (int, error) {
+	if !w.Written() {
+		w.Header().Del("Content-Length")
+	}
+	_, err := w.ResponseWriter.Write(w.restorer.Restore(data))
+	return len(data), err
+}
+
+func (w *piiRestoreWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"one-api/constant"
+	"strings"
+	"testing"
+)
+
+// streamChunk 构造一个只包含 delta 内容的 SSE 分片
+func streamChunk(content string, finishReason any) string {
+	data, _ := json.Marshal(map[string]any{
+		"choices": []any{
+			map[string]any{
+				"index":         0,
+				"delta":         map[string]any{"content": content},
+				"finish_reason": finishReason,
+			},
+		},
+	})
+	return "data: " + string(data)
+}
+
+func streamContent(t *testing.T, chunk []byte) string {
+	var event struct {
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(string(chunk), "data: ")), &event); err != nil {
+		t.Fatalf("invalid chunk %q: %v", chunk, err)
+	}
+	return event.Choices[0].Delta.Content
+}
+
+func TestPIIPlaceholderRoundTrip(t *testing.T) {
+	redactor := NewPIIRedactor(constant.PIIRedactionModePlaceholder)
+	redacted := redactor.RedactText("mail alice@example.com or alice@example.com, call 13800138000")
+	expected := "mail [PII_EMAIL_1] or [PII_EMAIL_1], call [PII_PHONE_1]"
+	if redacted != expected {
+		t.Fatalf("expected %q, got %q", expected, redacted)
+	}
+	restorer := redactor.NewRestorer()
+	if restored := restorer.RestoreText(redacted); restored != "mail alice@example.com or alice@example.com, call 13800138000" {
+		t.Fatalf("unexpected restored text %q", restored)
+	}
+	body := restorer.Restore([]byte(`{"choices":[{"message":{"content":"hi [PII_EMAIL_1]"}}]}`))
+	if !strings.Contains(string(body), "hi alice@example.com") {
+		t.Fatalf("expected the non-stream body to be restored, got %s", body)
+	}
+}
+
+func TestPIIRestoreAcrossSplitStreamChunks(t *testing.T) {
+	redactor := NewPIIRedactor(constant.PIIRedactionModePlaceholder)
+	redactor.RedactText("alice@example.com")
+	restorer := redactor.NewRestorer()
+
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{name: "split inside label", chunks: []string{"Hello [PII_EM", "AIL_1]!"}},
+		{name: "split after bracket", chunks: []string{"Hello [", "PII_EMAIL_1]!"}},
+		{name: "one character per chunk", chunks: strings.Split("Hello [PII_EMAIL_1]!", "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output strings.Builder
+			for _, chunk := range tt.chunks {
+				output.WriteString(streamContent(t, restorer.Restore([]byte(streamChunk(chunk, nil)))))
+			}
+			output.WriteString(streamContent(t, restorer.Restore([]byte(streamChunk("", "stop")))))
+			if output.String() != "Hello alice@example.com!" {
+				t.Fatalf("expected the placeholder to be restored, got %q", output.String())
+			}
+		})
+	}
+}
+
+func TestPIIRestoreFlushesUnmatchedPrefixOnFinish(t *testing.T) {
+	redactor := NewPIIRedactor(constant.PIIRedactionModePlaceholder)
+	redactor.RedactText("alice@example.com")
+	restorer := redactor.NewRestorer()
+
+	first := streamContent(t, restorer.Restore([]byte(streamChunk("see [PI", nil))))
+	last := streamContent(t, restorer.Restore([]byte(streamChunk("", "stop"))))
+	if first+last != "see [PI" {
+		t.Fatalf("expected the held back text to be flushed at the end, got %q", first+last)
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"strconv"
+	"time"
+)
+
+func statementAmount(quota int) string {
+	return fmt.Sprintf("%.6f", float64(quota)/common.QuotaPerUnit)
+}
+
+func statementTime(timestamp int64) string {
+	return time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
+}
+
+// WriteStatementCSV 输出账单 CSV，金额单位为美元，同时保留原始额度
+func WriteStatementCSV(w io.Writer, statement *model.Statement) error {
+	writer := csv.NewWriter(w)
+	company := statement.GetCompany()
+	rows := [][]string{
+		{"company", company.Name},
+		{"user_id", strconv.Itoa(statement.UserId)},
+		{"username", statement.Username},
+		{"period", statement.Period},
+		{"start_time", statementTime(statement.StartTime)},
+		{"end_time", statementTime(statement.EndTime)},
+		{"opening_balance", statementAmount(statement.OpeningBalance)},
+		{"top_up", statementAmount(statement.TopUpQuota)},
+		{"redemption", statementAmount(statement.RedemptionQuota)},
+		{"consumed", statementAmount(statement.ConsumedQuota)},
+		{"closing_balance", statementAmount(statement.ClosingBalance)},
+		{"items_incomplete", strconv.FormatBool(statement.ItemsIncomplete)},
+		{},
+		{"model_name", "token_name", "request_count", "prompt_tokens", "completion_tokens", "quota", "amount"},
+	}
+	for _, item := range statement.GetItems() {
+		rows = append(rows, []string{
+			item.ModelName,
+			item.TokenName,
+			strconv.Itoa(item.RequestCount),
+			strconv.Itoa(item.PromptTokens),
+			strconv.Itoa(item.CompletionTokens),
+			strconv.Itoa(item.Quota),
+			statementAmount(item.Quota),
+		})
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"amount": statementAmount,
+	"time":   statementTime,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>{{.SystemName}} 账单 {{.Statement.Period}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", "Microsoft YaHei", sans-serif; color: #222; max-width: 900px; margin: 24px auto; padding: 0 16px; }
+table { width: 100%; border-collapse: collapse; margin-top: 16px; }
+th, td { border: 1px solid #ddd; padding: 6px 8px; text-align: left; font-size: 13px; }
+th { background: #f5f5f5; }
+td.num { text-align: right; }
+.header { display: flex; justify-content: space-between; }
+.muted { color: #666; font-size: 13px; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<div class="header">
+  <div>
+    <h2>{{if .Company.Name}}{{.Company.Name}}{{else}}{{.SystemName}}{{end}}</h2>
+    <div class="muted">{{.Company.Address}}</div>
+    {{if .Company.TaxId}}<div class="muted">税号：{{.Company.TaxId}}</div>{{end}}
+    {{if .Company.Email}}<div class="muted">{{.Company.Email}}</div>{{end}}
+    {{if .Company.Phone}}<div class="muted">{{.Company.Phone}}</div>{{end}}
+  </div>
+  <div>
+    <h2>账单 #{{.Statement.Id}}</h2>
+    <div class="muted">周期：{{.Statement.Period}}</div>
+    <div class="muted">{{time .Statement.StartTime}} - {{time .Statement.EndTime}}</div>
+    <div class="muted">生成时间：{{time .Statement.CreatedAt}}</div>
+  </div>
+</div>
+<p>用户：{{.Statement.Username}}（ID {{.Statement.UserId}}）{{if .Statement.Email}} {{.Statement.Email}}{{end}}</p>
+<table>
+  <tr><th>期初余额</th><th>充值</th><th>兑换码</th><th>消费</th><th>期末余额</th></tr>
+  <tr>
+    <td class="num">${{amount .Statement.OpeningBalance}}</td>
+    <td class="num">${{amount .Statement.TopUpQuota}}</td>
+    <td class="num">${{amount .Statement.RedemptionQuota}}</td>
+    <td class="num">${{amount .Statement.ConsumedQuota}}</td>
+    <td class="num">${{amount .Statement.ClosingBalance}}</td>
+  </tr>
+</table>
+<table>
+  <tr><th>模型</th><th>令牌</th><th>请求数</th><th>提示 tokens</th><th>补全 tokens</th><th>金额</th></tr>
+  {{range .Items}}
+  <tr>
+    <td>{{.ModelName}}</td>
+    <td>{{.TokenName}}</td>
+    <td class="num">{{.RequestCount}}</td>
+    <td class="num">{{.PromptTokens}}</td>
+    <td class="num">{{.CompletionTokens}}</td>
+    <td class="num">${{amount .Quota}}</td>
+  </tr>
+  {{else}}
+  <tr><td colspan="6">本周期无消费记录</td></tr>
+  {{end}}
+</table>
+{{if .Statement.ItemsIncomplete}}<p class="muted">消费明细来自调用日志，部分日志未记录或已归档，明细合计与消费金额可能不一致。</p>{{end}}
+{{if .Company.Note}}<p class="muted">{{.Company.Note}}</p>{{end}}
+</body>
+</html>`))
+
+// WriteStatementHTML 输出可打印的 HTML 账单
+func WriteStatementHTML(w io.Writer, statement *model.Statement) error {
+	return statementTemplate.Execute(w, struct {
+		SystemName string
+		Statement  *model.Statement
+		Company    constant.StatementCompany
+		Items      []model.StatementItem
+	}{
+		SystemName: common.SystemName,
+		Statement:  statement,
+		Company:    statement.GetCompany(),
+		Items:      statement.GetItems(),
+	})
+}
+
+// SendStatementEmail 将 HTML 账单作为邮件正文发送给用户
+func SendStatementEmail(statement *model.Statement) error {
+	if statement.Email == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := WriteStatementHTML(&buf, statement); err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("%s %s 账单", common.SystemName, statement.Period)
+	if err := common.SendEmail(subject, statement.Email, buf.String()); err != nil {
+		return err
+	}
+	return statement.MarkEmailed()
+}
@@ -11,13 +11,15 @@ import (
 	"one-api/common"
 	"one-api/dto"
 	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
-// tokenEncoderMap won't grow after initialization
-var tokenEncoderMap = map[string]*tiktoken.Tiktoken{}
-var defaultTokenEncoder *tiktoken.Tiktoken
-var cl200kTokenEncoder *tiktoken.Tiktoken
+// tokenEncoderMap 仅包含 OpenAI 模型，自定义模型的编码器在首次使用时写入
+var tokenEncoderMap = map[string]Tokenizer{}
+var tokenEncoderMapLock sync.RWMutex
+var defaultTokenEncoder Tokenizer
+var cl200kTokenEncoder Tokenizer
 
 func InitTokenEncoders() {
 	common.SysLog("initializing token encoders")
@@ -25,59 +27,73 @@ func InitTokenEncoders() {
 	if err != nil {
 		common.FatalLog(fmt.Sprintf("failed to get gpt-3.5-turbo token encoder: %s", err.Error()))
 	}
-	defaultTokenEncoder = gpt35TokenEncoder
+	defaultTokenEncoder = &tiktokenTokenizer{encoder: gpt35TokenEncoder}
 	gpt4TokenEncoder, err := tiktoken.EncodingForModel("gpt-4")
 	if err != nil {
 		common.FatalLog(fmt.Sprintf("failed to get gpt-4 token encoder: %s", err.Error()))
 	}
-	cl200kTokenEncoder, err = tiktoken.EncodingForModel("gpt-4o")
+	gpt4oTokenEncoder, err := tiktoken.EncodingForModel("gpt-4o")
 	if err != nil {
 		common.FatalLog(fmt.Sprintf("failed to get gpt-4o token encoder: %s", err.Error()))
 	}
+	cl200kTokenEncoder = &tiktokenTokenizer{encoder: gpt4oTokenEncoder}
+	gpt4Tokenizer := &tiktokenTokenizer{encoder: gpt4TokenEncoder}
+	tokenEncoderMapLock.Lock()
 	for model, _ := range common.GetDefaultModelRatioMap() {
 		if strings.HasPrefix(model, "gpt-3.5") {
-			tokenEncoderMap[model] = gpt35TokenEncoder
+			tokenEncoderMap[model] = defaultTokenEncoder
 		} else if strings.HasPrefix(model, "gpt-4") {
 			if strings.HasPrefix(model, "gpt-4o") {
 				tokenEncoderMap[model] = cl200kTokenEncoder
 			} else {
-				tokenEncoderMap[model] = gpt4TokenEncoder
+				tokenEncoderMap[model] = gpt4Tokenizer
 			}
 		} else {
 			tokenEncoderMap[model] = nil
 		}
 	}
+	tokenEncoderMapLock.Unlock()
 	common.SysLog("token encoders initialized")
 }
 
-func getModelDefaultTokenEncoder(model string) *tiktoken.Tiktoken {
+func getModelDefaultTokenEncoder(model string) Tokenizer {
 	if strings.HasPrefix(model, "gpt-4o") {
 		return cl200kTokenEncoder
 	}
 	return defaultTokenEncoder
 }
 
-func getTokenEncoder(model string) *tiktoken.Tiktoken {
+func getTokenEncoder(model string) Tokenizer {
+	// 优先使用分词器注册表中的映射，如 Claude、Qwen、GLM 等非 OpenAI 模型
+	if tokenizer := defaultTokenizerRegistry.Lookup(model); tokenizer != nil {
+		return tokenizer
+	}
+	tokenEncoderMapLock.RLock()
 	tokenEncoder, ok := tokenEncoderMap[model]
+	tokenEncoderMapLock.RUnlock()
 	if ok && tokenEncoder != nil {
 		return tokenEncoder
 	}
 	// 如果ok（即model在tokenEncoderMap中），但是tokenEncoder为nil，说明可能是自定义模型
 	if ok {
-		tokenEncoder, err := tiktoken.EncodingForModel(model)
+		encoder, err := tiktoken.EncodingForModel(model)
 		if err != nil {
 			common.SysError(fmt.Sprintf("failed to get token encoder for model %s: %s, using encoder for gpt-3.5-turbo", model, err.Error()))
 			tokenEncoder = getModelDefaultTokenEncoder(model)
+		} else {
+			tokenEncoder = &tiktokenTokenizer{encoder: encoder}
 		}
+		tokenEncoderMapLock.Lock()
 		tokenEncoderMap[model] = tokenEncoder
+		tokenEncoderMapLock.Unlock()
 		return tokenEncoder
 	}
 	// 如果model不在tokenEncoderMap中，直接返回默认的tokenEncoder
 	return getModelDefaultTokenEncoder(model)
 }
 
-func getTokenNum(tokenEncoder *tiktoken.Tiktoken, text string) int {
-	return len(tokenEncoder.Encode(text, nil, nil))
+func getTokenNum(tokenEncoder Tokenizer, text string) int {
+	return tokenEncoder.CountTokens(text)
 }
 
 func getImageToken(imageUrl *dto.MessageImageUrl, model string, stream bool) (int, error) {
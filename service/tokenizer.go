@@ -0,0 +1,308 @@
+package service
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+	"one-api/common"
+	"one-api/constant"
+)
+
+// Tokenizer 统计文本的 token 数量，实现需要支持并发调用
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+type tiktokenTokenizer struct {
+	encoder *tiktoken.Tiktoken
+}
+
+func (t *tiktokenTokenizer) CountTokens(text string) int {
+	return len(t.encoder.Encode(text, nil, nil))
+}
+
+var TokenizerDir = common.GetOrDefaultString("TOKENIZER_DIR", "./tokenizers")
+
+const tokenizerRetryInterval = 5 * time.Minute
+
+const defaultSplitPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+
+// tokenizerRegistry 缓存已加载的分词器和编译后的模型匹配规则
+type tokenizerRegistry struct {
+	lock       sync.RWMutex
+	tokenizers map[string]Tokenizer
+	// 加载失败的时间，一段时间内不再重复读取文件
+	failed   map[string]time.Time
+	patterns map[string]*regexp.Regexp
+	// 正在加载的分词器，文件在锁外读取，同一分词器的并发调用等待同一次加载
+	loading map[string]*tokenizerLoading
+}
+
+type tokenizerLoading struct {
+	done      chan struct{}
+	tokenizer Tokenizer
+}
+
+var defaultTokenizerRegistry = &tokenizerRegistry{
+	tokenizers: make(map[string]Tokenizer),
+	failed:     make(map[string]time.Time),
+	patterns:   make(map[string]*regexp.Regexp),
+	loading:    make(map[string]*tokenizerLoading),
+}
+
+func tokenizerKey(mapping constant.TokenizerMapping) string {
+	return strings.Join([]string{mapping.Type, mapping.Encoding, mapping.File, mapping.SplitPattern}, "|")
+}
+
+func (r *tokenizerRegistry) match(pattern string, model string) bool {
+	r.lock.RLock()
+	re, ok := r.patterns[pattern]
+	r.lock.RUnlock()
+	if !ok {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return false
+		}
+		r.lock.Lock()
+		r.patterns[pattern] = re
+		r.lock.Unlock()
+	}
+	return re.MatchString(model)
+}
+
+func (r *tokenizerRegistry) get(mapping constant.TokenizerMapping) Tokenizer {
+	key := tokenizerKey(mapping)
+	r.lock.RLock()
+	tokenizer, ok := r.tokenizers[key]
+	failedAt, failed := r.failed[key]
+	r.lock.RUnlock()
+	if ok || (failed && time.Since(failedAt) < tokenizerRetryInterval) {
+		return tokenizer
+	}
+
+	r.lock.Lock()
+	// double check, another goroutine may have loaded it
+	if tokenizer, ok = r.tokenizers[key]; ok {
+		r.lock.Unlock()
+		return tokenizer
+	}
+	if failedAt, failed = r.failed[key]; failed && time.Since(failedAt) < tokenizerRetryInterval {
+		r.lock.Unlock()
+		return nil
+	}
+	if loading, ok := r.loading[key]; ok {
+		r.lock.Unlock()
+		<-loading.done
+		return loading.tokenizer
+	}
+	loading := &tokenizerLoading{done: make(chan struct{})}
+	r.loading[key] = loading
+	r.lock.Unlock()
+
+	tokenizer, err := loadTokenizer(mapping)
+	r.lock.Lock()
+	delete(r.loading, key)
+	if err != nil {
+		r.failed[key] = time.Now()
+	} else {
+		delete(r.failed, key)
+		r.tokenizers[key] = tokenizer
+	}
+	loading.tokenizer = tokenizer
+	r.lock.Unlock()
+	close(loading.done)
+
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to load tokenizer %s%s: %s", mapping.Encoding, mapping.File, err.Error()))
+		return nil
+	}
+	common.SysLog(fmt.Sprintf("tokenizer %s%s loaded for pattern %s", mapping.Encoding, mapping.File, mapping.Pattern))
+	return tokenizer
+}
+
+// Lookup 按注册顺序查找模型对应的分词器，管理员配置优先于内置映射
+func (r *tokenizerRegistry) Lookup(model string) Tokenizer {
+	for _, mappings := range [][]constant.TokenizerMapping{constant.TokenizerMappings, constant.DefaultTokenizerMappings} {
+		for _, mapping := range mappings {
+			if !r.match(mapping.Pattern, model) {
+				continue
+			}
+			if tokenizer := r.get(mapping); tokenizer != nil {
+				return tokenizer
+			}
+		}
+	}
+	return nil
+}
+
+func loadTokenizer(mapping constant.TokenizerMapping) (Tokenizer, error) {
+	if mapping.Type == constant.TokenizerTypeEncoding {
+		encoder, err := tiktoken.GetEncoding(mapping.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		return &tiktokenTokenizer{encoder: encoder}, nil
+	}
+	path := mapping.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(TokenizerDir, path)
+	}
+	var ranks map[string]int
+	var specialTokens map[string]int
+	var err error
+	switch mapping.Type {
+	case constant.TokenizerTypeTiktoken:
+		ranks, err = loadTiktokenRanks(path)
+		specialTokens = map[string]int{}
+	case constant.TokenizerTypeHuggingFace:
+		ranks, specialTokens, err = loadHuggingFaceRanks(path)
+	default:
+		err = fmt.Errorf("unknown tokenizer type: %s", mapping.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(specialTokens) == 0 {
+		// tiktoken-go 在没有特殊 token 时会因空的匹配规则陷入死循环
+		specialTokens[tiktoken.ENDOFTEXT] = len(ranks)
+	}
+	splitPattern := mapping.SplitPattern
+	if splitPattern == "" {
+		splitPattern = defaultSplitPattern
+	}
+	bpe, err := tiktoken.NewCoreBPE(ranks, specialTokens, splitPattern)
+	if err != nil {
+		return nil, err
+	}
+	encoding := &tiktoken.Encoding{
+		Name:           mapping.File,
+		PatStr:         splitPattern,
+		MergeableRanks: ranks,
+		SpecialTokens:  specialTokens,
+	}
+	specialTokensSet := make(map[string]any)
+	for token := range specialTokens {
+		specialTokensSet[token] = true
+	}
+	return &tiktokenTokenizer{encoder: tiktoken.NewTiktoken(bpe, encoding, specialTokensSet)}, nil
+}
+
+// loadTiktokenRanks 读取 tiktoken 格式的词表，每行为 base64 编码的 token 与 rank
+func loadTiktokenRanks(path string) (map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid tiktoken line: %s", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		rank, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(token)] = rank
+	}
+	return ranks, scanner.Err()
+}
+
+type huggingFaceTokenizerFile struct {
+	Model struct {
+		Type  string         `json:"type"`
+		Vocab map[string]int `json:"vocab"`
+	} `json:"model"`
+	AddedTokens []struct {
+		Id      int    `json:"id"`
+		Content string `json:"content"`
+		Special bool   `json:"special"`
+	} `json:"added_tokens"`
+}
+
+// loadHuggingFaceRanks 将 HuggingFace BPE 词表转换为 tiktoken 的 rank 格式，token id 即合并顺序
+func loadHuggingFaceRanks(path string) (map[string]int, map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tokenizerFile huggingFaceTokenizerFile
+	if err = json.Unmarshal(data, &tokenizerFile); err != nil {
+		return nil, nil, err
+	}
+	if tokenizerFile.Model.Type != "" && tokenizerFile.Model.Type != "BPE" {
+		return nil, nil, errors.New("only BPE tokenizer is supported, got " + tokenizerFile.Model.Type)
+	}
+	byteDecoder := byteLevelDecoder()
+	specialTokens := make(map[string]int)
+	for _, token := range tokenizerFile.AddedTokens {
+		if token.Special {
+			specialTokens[token.Content] = token.Id
+		}
+	}
+	ranks := make(map[string]int, len(tokenizerFile.Model.Vocab))
+	for token, id := range tokenizerFile.Model.Vocab {
+		if _, ok := specialTokens[token]; ok {
+			continue
+		}
+		tokenBytes, ok := decodeByteLevelToken(token, byteDecoder)
+		if !ok {
+			// sentencepiece 风格的词表，▁ 表示空格
+			tokenBytes = []byte(strings.ReplaceAll(token, "▁", " "))
+		}
+		if rank, exists := ranks[string(tokenBytes)]; !exists || id < rank {
+			ranks[string(tokenBytes)] = id
+		}
+	}
+	return ranks, specialTokens, nil
+}
+
+// byteLevelDecoder GPT-2 byte-level BPE 中可见字符到原始字节的映射
+func byteLevelDecoder() map[rune]byte {
+	decoder := make(map[rune]byte, 256)
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			decoder[rune(b)] = byte(b)
+		} else {
+			decoder[rune(256+n)] = byte(b)
+			n++
+		}
+	}
+	return decoder
+}
+
+func decodeByteLevelToken(token string, decoder map[rune]byte) ([]byte, bool) {
+	tokenBytes := make([]byte, 0, len(token))
+	for _, r := range token {
+		b, ok := decoder[r]
+		if !ok {
+			return nil, false
+		}
+		tokenBytes = append(tokenBytes, b)
+	}
+	return tokenBytes, true
+}